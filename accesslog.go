@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxAccessLogLine caps the length of a single access log line
+const maxAccessLogLine = 1 << 20
+
+// accessLogLine matches the common and combined log formats used by nginx and
+// Apache; the referer and user agent fields are only present in combined
+var accessLogLine = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]*\] "(\S+) (\S+)[^"]*" (\d{3}) \S+(?: "[^"]*" "([^"]*)")?`)
+
+// logRequest is a request read from an access log line
+type logRequest struct {
+	method string
+	path   string
+	status string
+	agent  string
+}
+
+// pathCount is a request path and how often it was requested
+type pathCount struct {
+	path  string
+	count int
+}
+
+// topAccessLogURLs reads the configured access logs and returns the most
+// requested paths, most popular first, joined to the base URL. Requests from
+// the warmer itself (matched by user agent) are not counted.
+func topAccessLogURLs(config *AccessLogConfig, userAgent string) ([]string, error) {
+	var files []string
+	for _, pattern := range config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log pattern %q: %v", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no access logs match %s", strings.Join(config.Files, ", "))
+	}
+
+	counts := make(map[string]int)
+	for _, file := range files {
+		if err := countAccessLog(file, userAgent, counts); err != nil {
+			return nil, err
+		}
+	}
+	return rankPaths(counts, config.BaseURL, config.Top), nil
+}
+
+// countAccessLog adds the requests of one combined-format log file to counts
+func countAccessLog(file, userAgent string, counts map[string]int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %v", err)
+	}
+	defer f.Close()
+
+	if err := countLogRequests(f, parseCombinedLine, userAgent, counts); err != nil {
+		return fmt.Errorf("failed to read access log %s: %v", file, err)
+	}
+	return nil
+}
+
+// parseCombinedLine parses a combined or common format log line
+func parseCombinedLine(line string) (logRequest, bool) {
+	match := accessLogLine.FindStringSubmatch(line)
+	if match == nil {
+		return logRequest{}, false
+	}
+	return logRequest{method: match[1], path: match[2], status: match[3], agent: match[4]}, true
+}
+
+// countLogRequests adds the successful GET requests read from r to counts,
+// transparently decompressing gzipped (rotated) logs
+func countLogRequests(r io.Reader, parse func(string) (logRequest, bool), userAgent string, counts map[string]int) error {
+	// Sniff the gzip magic number rather than trusting the file extension
+	buffered := bufio.NewReader(r)
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return fmt.Errorf("failed to decompress: %v", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxAccessLogLine)
+	for scanner.Scan() {
+		req, ok := parse(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		// Only pages that were served successfully are worth warming
+		if req.method != "GET" || !strings.HasPrefix(req.path, "/") || (req.status[0] != '2' && req.status != "304") {
+			continue
+		}
+		if userAgent != "" && req.agent == userAgent {
+			continue
+		}
+		counts[req.path]++
+	}
+	return scanner.Err()
+}
+
+// rankPaths returns the top most requested paths, most popular first,
+// joined to the base URL
+func rankPaths(counts map[string]int, baseURL string, top int) []string {
+	ranked := make([]pathCount, 0, len(counts))
+	for path, count := range counts {
+		ranked = append(ranked, pathCount{path, count})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].count != ranked[j].count {
+			return ranked[i].count > ranked[j].count
+		}
+		return ranked[i].path < ranked[j].path
+	})
+	if len(ranked) > top {
+		ranked = ranked[:top]
+	}
+
+	base := strings.TrimSuffix(baseURL, "/")
+	urls := make([]string, len(ranked))
+	for i, pc := range ranked {
+		urls[i] = base + pc.path
+	}
+	return urls
+}
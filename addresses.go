@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// jobAddresses returns the addresses a URL is warmed at in a region: every
+// A/AAAA record of its host when all_addresses applies, or a single empty
+// address to connect as usual. Lookups are cached in resolved for the run.
+func (cw *CacheWarmer) jobAddresses(ctx context.Context, rawURL string, r *region, resolved map[string][]string) []string {
+	direct := []string{""}
+	if !cw.config.AllAddresses.Enabled || r.transport == nil {
+		return direct
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return direct
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	if net.ParseIP(host) != nil || r.overrides[host] {
+		return direct
+	}
+	if hosts := cw.config.AllAddresses.Hosts; len(hosts) > 0 && !containsFold(hosts, host) {
+		return direct
+	}
+
+	addrs, ok := resolved[host]
+	if !ok {
+		ips, err := cw.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			// Let the request itself fail with the DNS error
+			cw.logger.Debug("Failed to resolve %s for all_addresses: %v", host, err)
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, ip.IP.String())
+		}
+		resolved[host] = addrs
+		if len(addrs) > 1 {
+			cw.logger.Debug("Warming %s at %d addresses: %s", host, len(addrs), strings.Join(addrs, ", "))
+		}
+	}
+
+	addrs = familyAddresses(addrs, r.family)
+	if len(addrs) == 0 {
+		return direct
+	}
+	return addrs
+}
+
+// familyAddresses returns the addresses of an ip_family
+func familyAddresses(addrs []string, family string) []string {
+	if family != IPFamilyIPv4 && family != IPFamilyIPv6 {
+		return addrs
+	}
+	var matching []string
+	for _, addr := range addrs {
+		if isIPv4 := net.ParseIP(addr).To4() != nil; isIPv4 == (family == IPFamilyIPv4) {
+			matching = append(matching, addr)
+		}
+	}
+	return matching
+}
+
+// addressClient returns a client for the region that connects to addr
+// instead of resolving the URL's host, keeping Host and SNI unchanged. Each
+// address gets its own connection pool so requests stay pinned to it.
+func (r *region) addressClient(config *Config, addr string) *http.Client {
+	r.pinnedMutex.Lock()
+	defer r.pinnedMutex.Unlock()
+
+	if client, ok := r.pinned[addr]; ok {
+		return client
+	}
+
+	transport := r.transport.Clone()
+	transport.Proxy = nil
+	transport.DialContext = pinnedDialer(addr)
+	client := newHTTPClient(config, transport)
+	client.Jar = r.client.Jar
+
+	if r.pinned == nil {
+		r.pinned = make(map[string]*http.Client)
+	}
+	r.pinned[addr] = client
+	return client
+}
+
+// pinnedDialer returns a DialContext that connects to ip on the requested port
+func pinnedDialer(ip string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+	}
+}
+
+// pinnableTransport returns the transport per-address clients are cloned
+// from, or nil if rt is a custom RoundTripper that cannot be pinned
+func pinnableTransport(rt http.RoundTripper) *http.Transport {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	transport, _ := rt.(*http.Transport)
+	return transport
+}
+
+// label returns a log suffix identifying the job's region, address and
+// device
+func (j warmJob) label() string {
+	label := j.region.label()
+	if j.addr != "" {
+		label += " at " + j.addr
+	}
+	if j.device != "" {
+		label += " as " + j.device
+	}
+	if j.byteRange != "" {
+		label += " bytes " + j.byteRange
+	}
+	return label
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminPath prefixes the admin API's endpoints on the metrics server
+const AdminPath = "/admin"
+
+// maxAdminBody limits the size of accepted admin request bodies
+const maxAdminBody = 1 << 20
+
+// AdminProgress is the state of the warmer reported by the admin API
+type AdminProgress struct {
+	RunID       string     `json:"run_id"`
+	StartedAt   time.Time  `json:"started_at"`
+	ActiveRuns  int64      `json:"active_runs"`
+	Leader      bool       `json:"leader"`
+	Paused      bool       `json:"paused"`
+	PausedSince *time.Time `json:"paused_since,omitempty"`
+
+	// Requests of the current or last run; Completed counts the URLs with
+	// a result so far
+	Completed int64 `json:"completed_requests"`
+	Succeeded int64 `json:"success_requests"`
+	Failed    int64 `json:"failed_requests"`
+
+	// Remaining counts the requests still queued, and Percent how much of
+	// the running work is done: Completed of Completed, Remaining and
+	// InFlight
+	Remaining   int64   `json:"remaining_requests"`
+	InFlight    int64   `json:"in_flight_requests"`
+	BusyWorkers int64   `json:"busy_workers"`
+	Percent     float64 `json:"percent_complete"`
+
+	// URLs added and removed at runtime
+	AddedURLs   []string `json:"added_urls"`
+	RemovedURLs []string `json:"removed_urls"`
+}
+
+// adminURLsRequest is the body of the URL endpoints
+type adminURLsRequest struct {
+	URLs []string `json:"urls"`
+}
+
+// registerAdminHandlers serves the admin API on the metrics server
+func (cw *CacheWarmer) registerAdminHandlers(metrics *Metrics) {
+	metrics.HandleFunc("POST "+AdminPath+"/cycle", cw.adminHandler(cw.adminStartCycle))
+	metrics.HandleFunc("DELETE "+AdminPath+"/cycle", cw.adminHandler(cw.adminStopCycles))
+	metrics.HandleFunc("POST "+AdminPath+"/pause", cw.adminHandler(cw.adminPause))
+	metrics.HandleFunc("POST "+AdminPath+"/resume", cw.adminHandler(cw.adminResume))
+	metrics.HandleFunc("GET "+AdminPath+"/urls", cw.adminHandler(cw.adminURLs))
+	metrics.HandleFunc("POST "+AdminPath+"/urls", cw.adminHandler(cw.adminAddURLs))
+	metrics.HandleFunc("DELETE "+AdminPath+"/urls", cw.adminHandler(cw.adminRemoveURLs))
+	metrics.HandleFunc("GET "+AdminPath+"/progress", cw.adminHandler(cw.adminProgress))
+}
+
+// adminHandler checks the admin token before calling handler
+func (cw *CacheWarmer) adminHandler(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearerAuthorized(r, cw.config.Admin.Token) {
+			cw.logger.Warn("Rejected admin request from %s: invalid token", r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// adminStartCycle starts a cycle now
+func (cw *CacheWarmer) adminStartCycle(w http.ResponseWriter, r *http.Request) {
+	status, err := cw.StartCycle()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": status})
+}
+
+// adminStopCycles stops the running cycles
+func (cw *CacheWarmer) adminStopCycles(w http.ResponseWriter, r *http.Request) {
+	stopped, err := cw.StopCycles()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"stopped": stopped})
+}
+
+// adminPause pauses warming
+func (cw *CacheWarmer) adminPause(w http.ResponseWriter, r *http.Request) {
+	changed := cw.Pause()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"paused": true, "changed": changed})
+}
+
+// adminResume resumes warming
+func (cw *CacheWarmer) adminResume(w http.ResponseWriter, r *http.Request) {
+	changed := cw.Resume()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"paused": false, "changed": changed})
+}
+
+// adminURLs lists the URLs added and removed at runtime
+func (cw *CacheWarmer) adminURLs(w http.ResponseWriter, r *http.Request) {
+	cw.writeAdminURLs(w, 0)
+}
+
+// adminAddURLs adds URLs to the following cycles
+func (cw *CacheWarmer) adminAddURLs(w http.ResponseWriter, r *http.Request) {
+	urls, err := readAdminURLs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	changed, err := cw.AddURLs(urls)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cw.writeAdminURLs(w, changed)
+}
+
+// adminRemoveURLs leaves URLs out of the following cycles
+func (cw *CacheWarmer) adminRemoveURLs(w http.ResponseWriter, r *http.Request) {
+	urls, err := readAdminURLs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cw.writeAdminURLs(w, cw.RemoveURLs(urls))
+}
+
+// writeAdminURLs answers with the runtime URL changes
+func (cw *CacheWarmer) writeAdminURLs(w http.ResponseWriter, changed int) {
+	added, removed := cw.RuntimeURLs()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"changed": changed,
+		"added":   nonNilStrings(added),
+		"removed": nonNilStrings(removed),
+	})
+}
+
+// adminProgress reports the progress of the running work
+func (cw *CacheWarmer) adminProgress(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cw.Progress())
+}
+
+// Progress returns the state of the warmer and its running work
+func (cw *CacheWarmer) Progress() AdminProgress {
+	run := cw.lastRun.Load()
+	stats := run.Statistics()
+	scheduler := cw.SchedulerStats()
+	added, removed := cw.RuntimeURLs()
+
+	progress := AdminProgress{
+		RunID:       stats.RunID,
+		StartedAt:   stats.StartTime,
+		ActiveRuns:  cw.ActiveRuns(),
+		Leader:      cw.IsLeader(),
+		Completed:   int64(run.resultCount()),
+		Succeeded:   stats.SuccessRequests,
+		Failed:      stats.FailedRequests,
+		Remaining:   scheduler.QueueDepth,
+		InFlight:    scheduler.InFlightRequests,
+		BusyWorkers: scheduler.BusyWorkers,
+		AddedURLs:   nonNilStrings(added),
+		RemovedURLs: nonNilStrings(removed),
+	}
+	if paused, since := cw.Paused(); paused {
+		progress.Paused = true
+		progress.PausedSince = &since
+	}
+
+	// Idle, everything the last run set out to do is done
+	progress.Percent = 100
+	if total := progress.Completed + progress.Remaining + progress.InFlight; progress.ActiveRuns > 0 && total > 0 {
+		progress.Percent = float64(progress.Completed) / float64(total) * 100
+	}
+	return progress
+}
+
+// readAdminURLs parses the URLs of a request body
+func readAdminURLs(r *http.Request) ([]string, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAdminBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %v", err)
+	}
+	var request adminURLsRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("invalid body: %v", err)
+	}
+	if len(request.URLs) == 0 {
+		return nil, fmt.Errorf(`body must list "urls"`)
+	}
+	return request.URLs, nil
+}
+
+// bearerAuthorized checks the request's bearer token, if one is configured
+func bearerAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+	provided, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// nonNilStrings keeps an empty list from encoding as null
+func nonNilStrings(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
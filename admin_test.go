@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressCountsInFlightRequestsOnce(t *testing.T) {
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow/") {
+			<-release
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+	released := false
+	defer func() {
+		if !released {
+			close(release)
+		}
+	}()
+
+	// The fast URLs finish first, then the slow ones take every worker
+	cw := newTestWarmer(t, origin, nil)
+	urls := append(testURLs(origin, "fast", 6), testURLs(origin, "slow", cw.config.Workers)...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cw.warm(context.Background(), urls)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	progress := cw.Progress()
+	for progress.Completed != 6 || progress.InFlight != int64(cw.config.Workers) {
+		if time.Now().After(deadline) {
+			t.Fatalf("run never had 6 URLs done and %d in flight: %+v", cw.config.Workers, progress)
+		}
+		time.Sleep(time.Millisecond)
+		progress = cw.Progress()
+	}
+	if progress.Percent != 60 {
+		t.Errorf("%.1f%% complete with 6 of 10 requests done, want 60%%", progress.Percent)
+	}
+
+	close(release)
+	released = true
+	<-done
+	if progress = cw.Progress(); progress.Completed != int64(len(urls)) || progress.Percent != 100 {
+		t.Errorf("after the run: %d completed, %.1f%%, want %d and 100%%", progress.Completed, progress.Percent, len(urls))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"runtime/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// heapPollInterval is how often a paused warmer re-checks the heap
+const heapPollInterval = 250 * time.Millisecond
+
+// heapObjectsMetric is the runtime metric for bytes held by heap objects
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// admission holds back new requests while too many are in flight or the heap
+// is above its watermark. Its zero configuration admits everything.
+type admission struct {
+	logger  *Logger
+	slots   chan struct{}
+	maxHeap uint64
+
+	// gate lets a single paused request run the heap checks at a time
+	gate   chan struct{}
+	paused int32
+}
+
+// newAdmission creates admission control for the configured limits
+func newAdmission(config *AdmissionConfig, logger *Logger) *admission {
+	a := &admission{
+		logger:  logger,
+		maxHeap: uint64(config.MaxHeapMB) << 20,
+		gate:    make(chan struct{}, 1),
+	}
+	if config.MaxInFlight > 0 {
+		a.slots = make(chan struct{}, config.MaxInFlight)
+	}
+	return a
+}
+
+// Acquire waits until a new request may start. Every successful Acquire must
+// be paired with a Release.
+func (a *admission) Acquire(ctx context.Context) error {
+	if err := a.waitForHeap(ctx); err != nil {
+		return err
+	}
+	if a.slots == nil {
+		return nil
+	}
+	select {
+	case a.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot taken by Acquire
+func (a *admission) Release() {
+	if a.slots != nil {
+		<-a.slots
+	}
+}
+
+// Paused reports whether new requests are held back by the heap watermark
+func (a *admission) Paused() bool {
+	return atomic.LoadInt32(&a.paused) == 1
+}
+
+// waitForHeap blocks while the heap is above the watermark
+func (a *admission) waitForHeap(ctx context.Context) error {
+	if a.maxHeap == 0 || heapInUse() <= a.maxHeap {
+		return nil
+	}
+
+	select {
+	case a.gate <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-a.gate }()
+
+	for {
+		// Collect first: with new requests held back, allocation may not
+		// grow enough to trigger a collection on its own
+		runtime.GC()
+		heap := heapInUse()
+		if heap <= a.maxHeap {
+			if atomic.CompareAndSwapInt32(&a.paused, 1, 0) {
+				a.logger.Info("Heap back to %dMB, resuming requests", heap>>20)
+			}
+			return nil
+		}
+		if atomic.CompareAndSwapInt32(&a.paused, 0, 1) {
+			a.logger.Warn("Heap at %dMB exceeds max_heap_mb %d, pausing new requests", heap>>20, a.maxHeap>>20)
+		}
+
+		select {
+		case <-time.After(heapPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// heapInUse returns the bytes currently held by heap objects, including
+// garbage not yet collected
+func heapInUse() uint64 {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
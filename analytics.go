@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// analyticsScope is the OAuth2 scope for reading GA4 reports
+const analyticsScope = "https://www.googleapis.com/auth/analytics.readonly"
+
+// analyticsReportURL is the GA4 Data API runReport endpoint
+const analyticsReportURL = "https://analyticsdata.googleapis.com/v1beta/properties/%s:runReport"
+
+// analyticsReport is the subset of a runReport response we need
+type analyticsReport struct {
+	Rows []struct {
+		DimensionValues []struct {
+			Value string `json:"value"`
+		} `json:"dimensionValues"`
+	} `json:"rows"`
+}
+
+// topAnalyticsURLs asks the GA4 Data API for the pages with the most views
+// over the configured number of days and returns them joined to the base
+// URL, most viewed first
+func topAnalyticsURLs(ctx context.Context, config *GoogleAnalyticsConfig, tokens *googleTokenSource) ([]string, error) {
+	token, err := tokens.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dimension := "pagePath"
+	if config.IncludeQuery {
+		dimension = "pagePathPlusQueryString"
+	}
+	request := map[string]interface{}{
+		"dateRanges": []map[string]string{{"startDate": fmt.Sprintf("%ddaysAgo", config.Days), "endDate": "today"}},
+		"dimensions": []map[string]string{{"name": dimension}},
+		"metrics":    []map[string]string{{"name": "screenPageViews"}},
+		"orderBys": []map[string]interface{}{{
+			"metric": map[string]string{"metricName": "screenPageViews"},
+			"desc":   true,
+		}},
+		"limit": config.Top,
+	}
+	if config.Hostname != "" {
+		request["dimensionFilter"] = map[string]interface{}{
+			"filter": map[string]interface{}{
+				"fieldName":    "hostName",
+				"stringFilter": map[string]string{"value": config.Hostname},
+			},
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf(analyticsReportURL, url.PathEscape(config.PropertyID)), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GA4 report request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GA4 report request failed: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var report analyticsReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse GA4 report: %v", err)
+	}
+
+	base := strings.TrimSuffix(config.BaseURL, "/")
+	urls := make([]string, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		if len(row.DimensionValues) == 0 {
+			continue
+		}
+		// Skip placeholders such as "(not set)"
+		if path := row.DimensionValues[0].Value; strings.HasPrefix(path, "/") {
+			urls = append(urls, base+path)
+		}
+	}
+	return urls, nil
+}
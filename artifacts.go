@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// artifactUploadTimeout bounds how long uploading a run's artifacts may take
+const artifactUploadTimeout = 2 * time.Minute
+
+// ResultRecord is the serialized form of a Result used in run artifacts
+type ResultRecord struct {
+	URL         string            `json:"url"`
+	Region      string            `json:"region,omitempty"`
+	Address     string            `json:"address,omitempty"`
+	Family      string            `json:"family,omitempty"`
+	Device      string            `json:"device,omitempty"`
+	Range       string            `json:"range,omitempty"`
+	StatusCode  int               `json:"status_code,omitempty"`
+	CacheStatus string            `json:"cache_status,omitempty"`
+	POP         string            `json:"pop,omitempty"`
+	Attempts    int               `json:"attempts"`
+	DurationMs  float64           `json:"duration_ms"`
+	TTFBMs      float64           `json:"ttfb_ms"`
+	Bytes       int64             `json:"bytes"`
+	Success     bool              `json:"success"`
+	Error       string            `json:"error,omitempty"`
+	ErrorClass  string            `json:"error_class,omitempty"`
+	Coalesced   bool              `json:"coalesced,omitempty"`
+	Verified    bool              `json:"shield_verified,omitempty"`
+	Critical    bool              `json:"critical,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	TTLSeconds  *int64            `json:"ttl_seconds,omitempty"`
+	Shadow      *ShadowResult     `json:"shadow,omitempty"`
+}
+
+// Record converts a result to its serialized form
+func (r Result) Record() ResultRecord {
+	record := ResultRecord{
+		URL:         r.URL,
+		Region:      r.Region,
+		Address:     r.Address,
+		Family:      r.Family,
+		Device:      r.Device,
+		Range:       r.Range,
+		StatusCode:  r.StatusCode,
+		CacheStatus: r.CacheStatus,
+		POP:         r.POP,
+		Attempts:    r.Attempts,
+		DurationMs:  float64(r.Duration) / float64(time.Millisecond),
+		TTFBMs:      float64(r.TTFB) / float64(time.Millisecond),
+		Bytes:       r.Bytes,
+		Success:     r.Success,
+		Coalesced:   r.Coalesced,
+		Verified:    r.Verified,
+		Critical:    r.Critical,
+		Priority:    r.Priority,
+		Headers:     r.Headers,
+		Shadow:      r.Shadow,
+	}
+	if r.TTLKnown {
+		ttl := int64(r.TTL / time.Second)
+		record.TTLSeconds = &ttl
+	}
+	if r.Err != nil {
+		record.Error = r.Err.Error()
+		record.ErrorClass = ErrorClass(r.Err)
+	}
+	return record
+}
+
+// captureHeaders returns the named response headers that are present, with
+// repeated values joined, or nil if none are
+func captureHeaders(header http.Header, names []string) map[string]string {
+	var captured map[string]string
+	for _, name := range names {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		if captured == nil {
+			captured = make(map[string]string, len(names))
+		}
+		captured[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+	}
+	return captured
+}
+
+// RunReport is the JSON report written at the end of each cycle
+type RunReport struct {
+	RunID       string             `json:"run_id"`
+	StartedAt   time.Time          `json:"started_at"`
+	FinishedAt  time.Time          `json:"finished_at"`
+	DurationMs  float64            `json:"duration_ms"`
+	Total       int64              `json:"total_requests"`
+	Successful  int64              `json:"successful"`
+	Failed      int64              `json:"failed"`
+	SuccessRate float64            `json:"success_rate"`
+	Duplicates  int64              `json:"duplicates_skipped"`
+	Coalesced   int64              `json:"coalesced_requests"`
+	Skipped     int64              `json:"skipped_urls"`
+	Crawled     int64              `json:"crawled_urls"`
+	Assets      int64              `json:"asset_urls"`
+	Retries     int64              `json:"retries"`
+	Denied      int64              `json:"retries_denied,omitempty"`
+	Latency     LatencyPercentiles `json:"latency"`
+	TTFB        LatencyPercentiles `json:"ttfb"`
+	Bytes       int64              `json:"bytes_transferred"`
+	Failures    map[string]int     `json:"failure_classes,omitempty"`
+	SkipList    []SkipRecord       `json:"skip_list,omitempty"`
+	Regions     []RegionSummary    `json:"regions,omitempty"`
+	Devices     []RegionSummary    `json:"devices,omitempty"`
+	POPs        []RegionSummary    `json:"pops,omitempty"`
+	Shield      *ShieldSummary     `json:"shield,omitempty"`
+	Shadow      *ShadowSummary     `json:"shadow,omitempty"`
+	Critical    *CriticalSummary   `json:"critical,omitempty"`
+	Slow        *SlowSummary       `json:"slow,omitempty"`
+	TTL         *TTLInventory      `json:"ttl_inventory,omitempty"`
+	Results     []ResultRecord     `json:"results"`
+}
+
+// artifact is a named file produced by a cycle
+type artifact struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// newRunID returns a sortable, unique identifier for a warming cycle
+func newRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+// buildRunReport summarizes run r with the given copy of its results
+func (cw *CacheWarmer) buildRunReport(r *warmRun, results []Result) RunReport {
+	stats := r.Statistics()
+	finished := time.Now()
+
+	report := RunReport{
+		RunID:      stats.RunID,
+		StartedAt:  stats.StartTime,
+		FinishedAt: finished,
+		DurationMs: float64(finished.Sub(stats.StartTime)) / float64(time.Millisecond),
+		Total:      stats.TotalRequests,
+		Successful: stats.SuccessRequests,
+		Failed:     stats.FailedRequests,
+		Duplicates: stats.DuplicatesSkipped,
+		Coalesced:  stats.CoalescedRequests,
+		Skipped:    stats.SkippedURLs,
+		Crawled:    stats.CrawledURLs,
+		Assets:     stats.AssetURLs,
+		Retries:    stats.Retries,
+		Denied:     stats.RetriesDenied,
+		Latency:    stats.Latency,
+		TTFB:       stats.TTFB,
+		Bytes:      stats.BytesTransferred,
+		Results:    make([]ResultRecord, 0, len(results)),
+	}
+	if stats.TotalRequests > 0 {
+		report.SuccessRate = float64(stats.SuccessRequests) / float64(stats.TotalRequests) * 100
+	}
+	if cw.skipList != nil {
+		report.SkipList = cw.skipList.Skipped()
+	}
+	if len(cw.config.Regions) > 0 || cw.shield != nil {
+		report.Regions = cw.GetRegionSummaries(results)
+		report.POPs = cw.GetPOPSummaries(results)
+	}
+	if len(cw.config.Devices) > 0 {
+		report.Devices = cw.GetDeviceSummaries(results)
+	}
+	if cw.shield != nil {
+		shield := cw.GetShieldSummary(results)
+		report.Shield = &shield
+	}
+	if cw.config.Shadow.BaseURL != "" {
+		shadow := cw.GetShadowSummary(results)
+		report.Shadow = &shadow
+	}
+	if r.settings.critical != nil {
+		critical := cw.GetCriticalSummary(results)
+		report.Critical = &critical
+	}
+	if cw.config.SlowThreshold > 0 {
+		slow := cw.GetSlowSummary(results)
+		report.Slow = &slow
+	}
+	if cw.config.TTLReport.Enabled {
+		inventory := cw.GetTTLInventory(results)
+		report.TTL = &inventory
+	}
+
+	for _, result := range results {
+		record := result.Record()
+		report.Results = append(report.Results, record)
+		if record.ErrorClass != "" {
+			if report.Failures == nil {
+				report.Failures = make(map[string]int)
+			}
+			report.Failures[record.ErrorClass]++
+		}
+	}
+	return report
+}
+
+// buildArtifacts renders the report of run r, the events file (one result
+// per line in completion order), the results as CSV and the failure list
+// (one URL per line)
+func (cw *CacheWarmer) buildArtifacts(r *warmRun) ([]artifact, error) {
+	results := r.Results(0)
+	report := cw.buildRunReport(r, results)
+
+	var events bytes.Buffer
+	var failures bytes.Buffer
+	failed := make(map[string]bool)
+	encoder := json.NewEncoder(&events)
+	for i, result := range results {
+		if err := encoder.Encode(report.Results[i]); err != nil {
+			return nil, fmt.Errorf("failed to encode event: %v", err)
+		}
+		if !result.Success && !failed[result.URL] {
+			failed[result.URL] = true
+			fmt.Fprintln(&failures, result.URL)
+		}
+	}
+
+	reportData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report: %v", err)
+	}
+	resultsData, err := formatResultsCSV(report.Results)
+	if err != nil {
+		return nil, err
+	}
+
+	return []artifact{
+		{name: "report.json", contentType: "application/json", data: reportData},
+		{name: "events.jsonl", contentType: "application/x-ndjson", data: events.Bytes()},
+		{name: "results.csv", contentType: "text/csv", data: resultsData},
+		{name: "failures.txt", contentType: "text/plain", data: failures.Bytes()},
+	}, nil
+}
+
+// publishArtifacts writes the cycle's artifacts to the local artifacts
+// directory and uploads them to object storage, as configured
+func (cw *CacheWarmer) publishArtifacts(r *warmRun) {
+	artifacts, err := cw.buildArtifacts(r)
+	if err != nil {
+		cw.logger.Error("Failed to build run artifacts: %v", err)
+		return
+	}
+
+	runID := r.stats.RunID
+
+	if dir := cw.config.Artifacts.Dir; dir != "" {
+		runDir := filepath.Join(dir, runID)
+		if err := os.MkdirAll(runDir, 0755); err != nil {
+			cw.logger.Error("Failed to create artifacts directory: %v", err)
+		} else {
+			for _, a := range artifacts {
+				if err := ioutil.WriteFile(filepath.Join(runDir, a.name), a.data, 0644); err != nil {
+					cw.logger.Error("Failed to write artifact %s: %v", a.name, err)
+				}
+			}
+			cw.logger.Info("Wrote run artifacts to %s", runDir)
+		}
+	}
+
+	if cw.objectStore != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), artifactUploadTimeout)
+		defer cancel()
+
+		for _, a := range artifacts {
+			if err := cw.objectStore.Put(ctx, runID+"/"+a.name, a.contentType, a.data); err != nil {
+				cw.logger.Error("Failed to upload artifact %s: %v", a.name, err)
+				return
+			}
+		}
+		cw.logger.Info("Uploaded run artifacts to %s/%s", cw.config.Artifacts.Upload, runID)
+	}
+}
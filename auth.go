@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// authRetryInterval is how long a failed authentication is reported to every
+// request before it is tried again, so warming doesn't hammer the login
+const authRetryInterval = 30 * time.Second
+
+// authSession holds the credentials obtained by the configured
+// authentication flow and renews them when they expire
+type authSession struct {
+	config *AuthConfig
+	client *http.Client
+	logger *Logger
+
+	mutex sync.Mutex
+
+	// jar holds the login session cookies
+	jar http.CookieJar
+
+	// token is the OAuth2 access token, valid until expires
+	token   string
+	expires time.Time
+
+	// renew is when to authenticate again regardless (zero = never)
+	renew time.Time
+
+	// valid is false until authenticated, and after a 401 response
+	valid bool
+
+	// Last failure, reported until authRetryInterval has passed
+	failed    time.Time
+	failedErr error
+}
+
+// newAuthSession creates the session for config's authentication flow; it
+// authenticates on first use
+func newAuthSession(config *Config, logger *Logger) *authSession {
+	// Options with a public suffix list never make New fail
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	client := newHTTPClient(config, newBaseTransport(config))
+	client.Jar = jar
+	return &authSession{config: &config.Auth, client: client, logger: logger, jar: jar}
+}
+
+// Apply attaches the session cookies or access token to req, authenticating
+// first if there are no current credentials
+func (a *authSession) Apply(req *http.Request, userAgent string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.expired() {
+		if time.Since(a.failed) < authRetryInterval {
+			return a.failedErr
+		}
+		if err := a.authenticate(req.Context(), userAgent); err != nil {
+			err = fmt.Errorf("authentication failed: %v", err)
+			if req.Context().Err() == nil {
+				a.failed, a.failedErr = time.Now(), err
+				a.logger.Error("%v", err)
+			}
+			return err
+		}
+		a.failed, a.failedErr = time.Time{}, nil
+	}
+
+	switch a.config.Type {
+	case AuthLogin:
+		for _, cookie := range a.jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	case AuthOAuth2:
+		if len(a.config.Hosts) == 0 || containsFold(a.config.Hosts, req.URL.Hostname()) {
+			req.Header.Set("Authorization", "Bearer "+a.token)
+		}
+	}
+	return nil
+}
+
+// Invalidate makes the next request authenticate again, after the origin
+// rejected the credentials
+func (a *authSession) Invalidate() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.valid = false
+}
+
+// expired reports whether the credentials must be renewed. The mutex must be
+// held.
+func (a *authSession) expired() bool {
+	switch {
+	case !a.valid:
+		return true
+	case !a.renew.IsZero() && time.Now().After(a.renew):
+		return true
+	case a.config.Type == AuthOAuth2:
+		return !a.expires.IsZero() && time.Until(a.expires) < time.Minute
+	}
+
+	// The session is over once the login cookies have expired
+	loginURL, err := url.Parse(a.config.URL)
+	return err != nil || len(a.jar.Cookies(loginURL)) == 0
+}
+
+// authenticate runs the configured flow. The mutex must be held.
+func (a *authSession) authenticate(ctx context.Context, userAgent string) error {
+	var err error
+	if a.config.Type == AuthLogin {
+		err = a.login(ctx, userAgent)
+	} else {
+		err = a.requestToken(ctx, userAgent)
+	}
+	if err != nil {
+		return err
+	}
+
+	a.valid = true
+	a.renew = time.Time{}
+	if a.config.Refresh > 0 {
+		a.renew = time.Now().Add(a.config.Refresh)
+	}
+	return nil
+}
+
+// login posts the login form, keeping the cookies set along the way
+func (a *authSession) login(ctx context.Context, userAgent string) error {
+	form := url.Values{}
+	for name, value := range a.config.Form {
+		form.Set(name, value)
+	}
+	resp, err := a.post(ctx, form, userAgent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("login returned status %d", resp.StatusCode)
+	}
+	loginURL, _ := url.Parse(a.config.URL)
+	if len(a.jar.Cookies(loginURL)) == 0 {
+		return fmt.Errorf("login set no session cookies")
+	}
+	a.logger.Info("Logged in at %s", a.config.URL)
+	return nil
+}
+
+// requestToken obtains an OAuth2 access token with the configured grant
+func (a *authSession) requestToken(ctx context.Context, userAgent string) error {
+	form := url.Values{"grant_type": {a.config.Grant}}
+	if a.config.ClientID != "" {
+		form.Set("client_id", a.config.ClientID)
+	}
+	if a.config.ClientSecret != "" {
+		form.Set("client_secret", a.config.ClientSecret)
+	}
+	if a.config.Grant == OAuth2Password {
+		form.Set("username", a.config.Username)
+		form.Set("password", a.config.Password)
+	}
+	if a.config.Scope != "" {
+		form.Set("scope", a.config.Scope)
+	}
+
+	resp, err := a.post(ctx, form, userAgent)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("failed to parse token response: %v", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token response has no access_token")
+	}
+
+	a.token = token.AccessToken
+	a.expires = time.Time{}
+	if token.ExpiresIn > 0 {
+		a.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	a.logger.Info("Obtained an access token from %s", a.config.URL)
+	return nil
+}
+
+// post sends form to the authentication URL
+func (a *authSession) post(ctx context.Context, form url.Values, userAgent string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", a.config.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %v", a.config.URL, err)
+	}
+	return resp, nil
+}
+
+// validate checks that the credentials are complete
+func (b *BasicAuthConfig) validate() error {
+	switch {
+	case b.Username == "":
+		return fmt.Errorf("username is required")
+	case b.Password != "" && b.PasswordEnv != "":
+		return fmt.Errorf("set password or password_env, not both")
+	case b.PasswordEnv != "" && os.Getenv(b.PasswordEnv) == "":
+		return fmt.Errorf("environment variable %s is not set", b.PasswordEnv)
+	}
+	return nil
+}
+
+// password returns the configured password, or the one in PasswordEnv
+func (b *BasicAuthConfig) password() string {
+	if b.PasswordEnv != "" {
+		return os.Getenv(b.PasswordEnv)
+	}
+	return b.Password
+}
+
+// basicAuthSet returns the URLs with their own basic auth credentials
+func basicAuthSet(config *Config) map[string]*BasicAuthConfig {
+	var set map[string]*BasicAuthConfig
+	entries := config.URLs
+	for _, group := range config.Groups {
+		entries = append(entries[:len(entries):len(entries)], group.URLs...)
+	}
+	for _, entry := range entries {
+		if entry.BasicAuth == nil {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]*BasicAuthConfig)
+		}
+		set[normalizeURL(&config.Normalize, entry.URL)] = entry.BasicAuth
+	}
+	return set
+}
+
+// applyBasicAuth sets the basic auth credentials for url on req, the URL's
+// own if it has them, otherwise the global ones
+func (cw *CacheWarmer) applyBasicAuth(req *http.Request, url string) {
+	creds := cw.settingsOf(req.Context()).basicAuth[url]
+	if creds == nil {
+		creds = cw.config.BasicAuth
+	}
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.password())
+	}
+}
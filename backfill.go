@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runState is what the state file persists between processes
+type runState struct {
+	// LastCycle is when the last full warming cycle finished
+	LastCycle time.Time `json:"last_cycle"`
+
+	// Cycle counts the completed cycles, for tiers warmed every Nth cycle
+	Cycle int `json:"cycle,omitempty"`
+
+	// Tiers records when each interval tier was last warmed
+	Tiers map[string]time.Time `json:"tiers,omitempty"`
+
+	// Expires records when the cached copy of each URL expires, for
+	// ttl_schedule
+	Expires map[string]time.Time `json:"expires,omitempty"`
+}
+
+// loadRunState reads the state file; a missing file is an empty state
+func loadRunState(path string) (runState, error) {
+	var state runState
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("failed to parse state file: %v", err)
+	}
+	return state, nil
+}
+
+// saveRunState writes the state file atomically
+func saveRunState(path string, state runState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create state directory: %v", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace state file: %v", err)
+	}
+	return nil
+}
+
+// recordCycle persists the end of a full warming cycle
+func (cw *CacheWarmer) recordCycle() {
+	if cw.config.StateFile == "" {
+		return
+	}
+	state := runState{LastCycle: time.Now()}
+	if cw.tiers != nil {
+		state.Cycle = cw.tiers.cycle
+		state.Tiers = cw.tiers.lastWarmed
+	}
+	if cw.ttlSchedule != nil {
+		state.Expires = cw.ttlSchedule.snapshot()
+	}
+	if err := saveRunState(cw.config.StateFile, state); err != nil {
+		cw.logger.Error("Failed to save state: %v", err)
+	}
+}
+
+// missedCycles returns how many scheduled cycles passed without a run since
+// the last full cycle recorded in the state file
+func (cw *CacheWarmer) missedCycles() int {
+	if cw.config.StateFile == "" || cw.config.Interval <= 0 {
+		return 0
+	}
+
+	state, err := loadRunState(cw.config.StateFile)
+	if err != nil {
+		cw.logger.Warn("No backfill check: %v", err)
+		return 0
+	}
+	if state.LastCycle.IsZero() {
+		return 0
+	}
+
+	down := time.Since(state.LastCycle)
+	missed := int(down / cw.config.Interval)
+	if missed > 0 {
+		cw.logger.Info("Last cycle finished %v ago, %d scheduled cycles were missed",
+			down.Round(time.Second), missed)
+	}
+	return missed
+}
+
+// startScope returns what to warm at start: warm_on_start, widened to
+// backfill if cycles were missed while the process was down
+func (cw *CacheWarmer) startScope() string {
+	scope := cw.config.WarmOnStart
+	if scope == WarmOnStartAll || cw.missedCycles() == 0 {
+		return scope
+	}
+
+	rank := map[string]int{WarmOnStartNone: 0, WarmOnStartCritical: 1, WarmOnStartAll: 2}
+	if rank[cw.config.Backfill] > rank[scope] {
+		cw.logger.Info("Running a catch-up cycle (backfill: %s)", cw.config.Backfill)
+		return cw.config.Backfill
+	}
+	return scope
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProbePath is where the metrics server answers blackbox probes
+const ProbePath = "/probe"
+
+// probeMaxTimeout keeps a probe within the metrics server's write timeout
+const probeMaxTimeout = 9 * time.Second
+
+// probeHosts returns the hosts probe targets may be on
+func probeHosts(config *Config) map[string]bool {
+	hosts := make(map[string]bool)
+	if len(config.ProbeEndpoint.Hosts) > 0 {
+		for _, host := range config.ProbeEndpoint.Hosts {
+			hosts[strings.ToLower(host)] = true
+		}
+		return hosts
+	}
+	return configuredHosts(config)
+}
+
+// configuredHosts returns the hosts of the configured URLs, templates and
+// group URLs
+func configuredHosts(config *Config) map[string]bool {
+	hosts := make(map[string]bool)
+	urls := append(config.URLList(), config.TemplateURLs()...)
+	for _, g := range config.Groups {
+		for _, entry := range g.URLs {
+			urls = append(urls, entry.URL)
+		}
+	}
+	for _, raw := range urls {
+		if u, err := url.Parse(raw); err == nil {
+			hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+	return hosts
+}
+
+// probeHandler warms or checks ?target=URL once with the settings of the URL
+// group named by ?module=, or through the region of that name, and reports
+// the outcome as Prometheus metrics, like a blackbox exporter
+func (cw *CacheWarmer) probeHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if err := ValidateURL(target); err != nil {
+		http.Error(w, fmt.Sprintf("invalid target: %v", err), http.StatusBadRequest)
+		return
+	}
+	if u, _ := url.Parse(target); !cw.probeHosts[strings.ToLower(u.Hostname())] {
+		http.Error(w, fmt.Sprintf("target host %s is not allowed", u.Hostname()), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout(r, cw.config.Timeout))
+	defer cancel()
+
+	// Modules are the URL groups, whose headers and success codes apply, or
+	// else the configured regions; the default is the main settings through
+	// the first region
+	module := r.URL.Query().Get("module")
+	var region string
+	if g := cw.groupByName(module); g != nil {
+		ctx = withGroup(ctx, g)
+	} else if module != "" {
+		if !cw.hasRegion(module) {
+			http.Error(w, fmt.Sprintf("unknown module %q", module), http.StatusBadRequest)
+			return
+		}
+		region = module
+	}
+
+	report := cw.Probe(ctx, target, region, "")
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeProbeMetrics(w, report)
+}
+
+// groupByName returns the URL group of the given name, or nil
+func (cw *CacheWarmer) groupByName(name string) *urlGroup {
+	for _, g := range cw.groups {
+		if g.config.Name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// probeTimeout bounds a probe by the request timeout, the scraper's own
+// timeout less a margin, and the metrics server's write timeout
+func probeTimeout(r *http.Request, timeout time.Duration) time.Duration {
+	if timeout > probeMaxTimeout {
+		timeout = probeMaxTimeout
+	}
+	if s, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil {
+		if scrape := time.Duration(s*float64(time.Second)) - 500*time.Millisecond; scrape > 0 && scrape < timeout {
+			timeout = scrape
+		}
+	}
+	return timeout
+}
+
+// writeProbeMetrics writes the outcome of a probe in the Prometheus text
+// format
+func writeProbeMetrics(w io.Writer, report *ProbeReport) {
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %g\n", name, help, name, name, value)
+	}
+
+	gauge("probe_success", "Whether the warm request succeeded, with all checks", boolGauge(report.Success))
+	gauge("probe_duration_seconds", "How long the probe took", report.Total.Seconds())
+	gauge("probe_http_status_code", "Response status code (0 = no response)", float64(report.StatusCode))
+	if report.StatusCode > 0 {
+		gauge("probe_cache_hit", "Whether the response was served from cache", boolGauge(report.CacheStatus == CacheStatusHit))
+		fmt.Fprintf(w, "# HELP probe_cache_status Cache status reported by the response headers\n# TYPE probe_cache_status gauge\n")
+		fmt.Fprintf(w, "probe_cache_status{status=%q} 1\n", report.CacheStatus)
+	}
+	if report.TTLKnown {
+		gauge("probe_cache_ttl_seconds", "Remaining cache lifetime of the response", report.TTL.Seconds())
+	}
+	if !report.Success && report.Err != nil {
+		fmt.Fprintf(w, "# HELP probe_failure Failure class of an unsuccessful probe\n# TYPE probe_failure gauge\n")
+		fmt.Fprintf(w, "probe_failure{class=%q} 1\n", ErrorClass(report.Err))
+	}
+}
+
+// boolGauge converts b to a 0 or 1 gauge value
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
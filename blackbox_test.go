@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandlerModules(t *testing.T) {
+	// The checkout group's URLs answer 202, which only it counts as success
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer origin.Close()
+
+	cw := newTestWarmer(t, origin, func(config *Config) {
+		config.SuccessCodes = []int{200}
+		config.Groups = []GroupConfig{{
+			Name:         "checkout",
+			URLs:         []URLEntry{{URL: origin.URL + "/checkout"}},
+			SuccessCodes: []int{202},
+		}}
+	})
+	cw.probeHosts = probeHosts(cw.config)
+
+	for _, tc := range []struct {
+		module string
+		code   int
+		want   string
+	}{
+		{"", http.StatusOK, "probe_success 0"},
+		{"checkout", http.StatusOK, "probe_success 1"},
+		{"nowhere", http.StatusBadRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/probe?target="+origin.URL+"/checkout&module="+tc.module, nil)
+		rec := httptest.NewRecorder()
+		cw.probeHandler(rec, req)
+
+		if rec.Code != tc.code {
+			t.Errorf("module %q: status %d, want %d", tc.module, rec.Code, tc.code)
+		}
+		if tc.want != "" && !strings.Contains(rec.Body.String(), tc.want+"\n") {
+			t.Errorf("module %q: metrics lack %q:\n%s", tc.module, tc.want, rec.Body.String())
+		}
+	}
+
+	// Probes leave the statistics of the last run alone
+	if stats := cw.GetStatistics(); stats.TotalRequests != 0 {
+		t.Errorf("probes counted %d requests", stats.TotalRequests)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// byteRangeRule is the byte ranges a group of large files is warmed by
+type byteRangeRule struct {
+	match  *urlPattern
+	ranges []string
+}
+
+// validate checks the ranges and chunk settings of a byte range group
+func (b *ByteRangeConfig) validate() error {
+	if len(b.Ranges) == 0 && b.Chunks == 0 {
+		return fmt.Errorf("ranges or chunks is required")
+	}
+	for _, r := range b.Ranges {
+		if err := validateByteRange(r); err != nil {
+			return err
+		}
+	}
+	if b.Chunks < 0 {
+		return fmt.Errorf("chunks must be non-negative, got %d", b.Chunks)
+	}
+	if b.Chunks > 0 && b.ChunkSize <= 0 {
+		return fmt.Errorf("chunks require a positive chunk_size, got %d", b.ChunkSize)
+	}
+	return nil
+}
+
+// validateByteRange checks r is a single range of a Range header: "0-1023",
+// "1024-" (to the end) or "-1024" (the last 1024 bytes)
+func validateByteRange(r string) error {
+	first, last, ok := strings.Cut(r, "-")
+	if !ok || first == "" && last == "" {
+		return fmt.Errorf("invalid byte range %q, expected e.g. 0-1048575", r)
+	}
+	start, err := parseRangeOffset(first)
+	if err != nil {
+		return fmt.Errorf("invalid byte range %q: %v", r, err)
+	}
+	end, err := parseRangeOffset(last)
+	if err != nil {
+		return fmt.Errorf("invalid byte range %q: %v", r, err)
+	}
+	if first != "" && last != "" && end < start {
+		return fmt.Errorf("invalid byte range %q: end is before start", r)
+	}
+	return nil
+}
+
+// parseRangeOffset parses one side of a byte range, where empty means open
+func parseRangeOffset(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a byte offset", s)
+	}
+	return n, nil
+}
+
+// byteRanges returns the listed ranges followed by the chunks
+func (b *ByteRangeConfig) byteRanges() []string {
+	ranges := append([]string(nil), b.Ranges...)
+	for i := 0; i < b.Chunks; i++ {
+		start := int64(i) * b.ChunkSize
+		ranges = append(ranges, fmt.Sprintf("%d-%d", start, start+b.ChunkSize-1))
+	}
+	return ranges
+}
+
+// compileByteRanges compiles the configured byte range groups in order
+func compileByteRanges(configs []ByteRangeConfig) []byteRangeRule {
+	rules := make([]byteRangeRule, 0, len(configs))
+	for _, bc := range configs {
+		rule := byteRangeRule{ranges: bc.byteRanges()}
+		if bc.Match != "" {
+			pattern := compileURLPattern(bc.Match)
+			rule.match = &pattern
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// jobRanges returns the byte ranges of the first group matching rawURL, or
+// a single empty range to warm the whole response
+func (cw *CacheWarmer) jobRanges(rawURL string) []string {
+	if cw.graphQL[rawURL] == nil {
+		for _, rule := range cw.byteRanges {
+			if rule.match == nil || rule.match.Match(rawURL) {
+				return rule.ranges
+			}
+		}
+	}
+	return []string{""}
+}
+
+// applyRange asks for byteRange of the response, if set
+func applyRange(req *http.Request, byteRange string) {
+	if byteRange != "" {
+		req.Header.Set("Range", "bytes="+byteRange)
+	}
+}
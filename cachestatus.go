@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Cache status values reported for warmed responses
+const (
+	CacheStatusHit     = "HIT"
+	CacheStatusMiss    = "MISS"
+	CacheStatusStale   = "STALE"
+	CacheStatusBypass  = "BYPASS"
+	CacheStatusUnknown = ""
+)
+
+// cacheStatusHeaders are checked in order; the first one present wins
+var cacheStatusHeaders = []string{
+	"CF-Cache-Status",   // Cloudflare
+	"X-Cache-Status",    // nginx proxy_cache
+	"X-Cache",           // CloudFront, Fastly, Varnish, Akamai
+	"X-Proxy-Cache",     // generic reverse proxies
+	"X-Varnish-Cache",   // Varnish configurations
+	"X-Drupal-Cache",    // Drupal page cache
+	"X-Vercel-Cache",    // Vercel edge
+	"X-Nextjs-Cache",    // Next.js ISR
+	"Cdn-Cache",         // BunnyCDN
+	"X-Litespeed-Cache", // LiteSpeed
+	"X-Cache-Lookup",    // Squid
+	"X-Rack-Cache",      // Rack::Cache
+	"X-Magento-Cache-Debug",
+}
+
+// DetectCacheStatus classifies a response as HIT, MISS, STALE, BYPASS or
+// unknown from common CDN and proxy cache headers
+func DetectCacheStatus(header http.Header) string {
+	for _, name := range cacheStatusHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		if status := classifyCacheValue(value); status != CacheStatusUnknown {
+			return status
+		}
+	}
+
+	// An Age greater than zero means the response came from a cache
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		return CacheStatusHit
+	}
+
+	return CacheStatusUnknown
+}
+
+// classifyCacheValue maps a raw cache header value to a cache status. Layered
+// CDNs report one entry per tier ("MISS, HIT"); the last entry is the tier
+// closest to the client.
+func classifyCacheValue(value string) string {
+	parts := strings.Split(value, ",")
+	v := strings.ToUpper(strings.TrimSpace(parts[len(parts)-1]))
+
+	switch {
+	case strings.Contains(v, "STALE"), strings.Contains(v, "UPDATING"), strings.Contains(v, "REVALIDATED"):
+		return CacheStatusStale
+	case strings.Contains(v, "BYPASS"), strings.Contains(v, "PASS"), strings.Contains(v, "DYNAMIC"), strings.Contains(v, "UNCACHEABLE"):
+		return CacheStatusBypass
+	case strings.Contains(v, "MISS"), strings.Contains(v, "EXPIRED"):
+		return CacheStatusMiss
+	case strings.Contains(v, "HIT"):
+		return CacheStatusHit
+	}
+	return CacheStatusUnknown
+}
+
+// DetectPOP returns the CDN point of presence that served a response, from
+// the headers CloudFront, Cloudflare, Fastly and Vercel add, or "" if unknown
+func DetectPOP(header http.Header) string {
+	// CloudFront: X-Amz-Cf-Pop: FRA56-P1
+	if pop := header.Get("X-Amz-Cf-Pop"); pop != "" {
+		code, _, _ := strings.Cut(pop, "-")
+		return strings.ToUpper(code)
+	}
+	// Cloudflare: CF-Ray: 8a1b2c3d4e5f6789-FRA
+	if ray := header.Get("CF-Ray"); strings.Contains(ray, "-") {
+		return strings.ToUpper(ray[strings.LastIndex(ray, "-")+1:])
+	}
+	// Fastly lists one node per tier, the edge last:
+	// X-Served-By: cache-iad-kiad7000025-IAD, cache-fra-etou8220071-FRA
+	if servedBy := header.Get("X-Served-By"); strings.Contains(servedBy, "-") {
+		parts := strings.Split(servedBy, ",")
+		edge := strings.TrimSpace(parts[len(parts)-1])
+		return strings.ToUpper(edge[strings.LastIndex(edge, "-")+1:])
+	}
+	// Vercel: X-Vercel-Id: fra1::iad1::abc123, the edge first
+	if id := header.Get("X-Vercel-Id"); strings.Contains(id, "::") {
+		code, _, _ := strings.Cut(id, "::")
+		return strings.ToUpper(code)
+	}
+	return ""
+}
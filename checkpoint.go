@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// checkpoint is the persisted progress of a cycle: the jobs already warmed,
+// by the hash of their key, and the URL list they belong to
+type checkpoint struct {
+	path  string
+	mutex sync.Mutex
+	dirty bool
+
+	// List fingerprints the cycle's URLs, so the progress of one list is
+	// never applied to another
+	List      string          `json:"list"`
+	StartedAt time.Time       `json:"started_at"`
+	Done      map[string]bool `json:"done"`
+}
+
+// listFingerprint identifies a URL list regardless of its order, which can
+// change between cycles (e.g. with order: slowest_first)
+func listFingerprint(urls []string) string {
+	var sum uint64
+	for _, url := range urls {
+		h := fnv.New64a()
+		h.Write([]byte(url))
+		sum += h.Sum64()
+	}
+	return fmt.Sprintf("%d-%016x", len(urls), sum)
+}
+
+// jobHash shortens a job key for the checkpoint file
+func jobHash(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// loadCheckpoint reads the checkpoint at path for the given URLs. It returns
+// an empty checkpoint if there is none or it belongs to a different list.
+func loadCheckpoint(path string, urls []string) (*checkpoint, bool, error) {
+	c := &checkpoint{
+		path:      path,
+		List:      listFingerprint(urls),
+		StartedAt: time.Now(),
+		Done:      make(map[string]bool),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, false, nil
+	}
+	if err != nil {
+		return c, false, fmt.Errorf("failed to read checkpoint file: %v", err)
+	}
+
+	var saved checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return c, false, fmt.Errorf("failed to parse checkpoint file: %v", err)
+	}
+	if saved.List != c.List || len(saved.Done) == 0 {
+		return c, false, nil
+	}
+	c.StartedAt = saved.StartedAt
+	c.Done = saved.Done
+	return c, true, nil
+}
+
+// Completed reports whether the job with the given key was already warmed
+func (c *checkpoint) Completed(key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.Done[jobHash(key)]
+}
+
+// Complete records that the job with the given key was warmed
+func (c *checkpoint) Complete(key string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Done[jobHash(key)] = true
+	c.dirty = true
+}
+
+// Save writes the checkpoint if jobs were completed since it was last saved
+func (c *checkpoint) Save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %v", err)
+	}
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Remove deletes the checkpoint once its cycle has completed
+func (c *checkpoint) Remove() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.dirty = false
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove checkpoint file: %v", err)
+	}
+	return nil
+}
+
+// startCheckpoint loads or creates the checkpoint of run r over urls and
+// saves it every interval until the returned function is called
+func (cw *CacheWarmer) startCheckpoint(r *warmRun, urls []string) func(completed bool) {
+	c, resumed, err := loadCheckpoint(cw.config.Checkpoint.File, urls)
+	if err != nil {
+		cw.logger.Warn("Starting the cycle from the beginning: %v", err)
+	} else if resumed {
+		cw.logger.Info("Resuming the cycle interrupted since %s: %d jobs already warmed",
+			c.StartedAt.Format(time.RFC3339), len(c.Done))
+	}
+
+	r.checkpoint = c
+
+	done := make(chan struct{})
+	saved := make(chan struct{})
+	go func() {
+		defer close(saved)
+		ticker := time.NewTicker(cw.config.Checkpoint.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.Save(); err != nil {
+					cw.logger.Error("Failed to save checkpoint: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func(completed bool) {
+		close(done)
+		<-saved
+
+		// Keep the progress of an interrupted cycle for the next one
+		if !completed {
+			err = c.Save()
+		} else {
+			err = c.Remove()
+		}
+		if err != nil {
+			cw.logger.Error("Failed to update checkpoint: %v", err)
+		}
+	}
+}
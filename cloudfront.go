@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cloudFrontEndpoint is the global CloudFront API endpoint
+const cloudFrontEndpoint = "https://cloudfront.amazonaws.com"
+
+// cloudFrontAPIVersion is the CloudFront API version requests are sent to
+const cloudFrontAPIVersion = "2020-05-31"
+
+// validate checks the CloudFront settings, if a distribution is configured
+func (c *CloudFrontConfig) validate() error {
+	if c.DistributionID == "" {
+		if len(c.Paths) > 0 {
+			return fmt.Errorf("paths require a distribution_id")
+		}
+		return nil
+	}
+
+	for _, path := range c.Paths {
+		if err := validateInvalidationPath(path); err != nil {
+			return err
+		}
+	}
+	if c.BaseURL != "" {
+		if err := ValidateURL(c.BaseURL); err != nil {
+			return fmt.Errorf("base_url: %v", err)
+		}
+	}
+	if c.PollInterval <= 0 {
+		return fmt.Errorf("poll_interval must be positive, got %v", c.PollInterval)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %v", c.Timeout)
+	}
+	return nil
+}
+
+// validateInvalidationPath checks path is absolute, with * only at its end
+func validateInvalidationPath(path string) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("invalidation path must start with '/', got %q", path)
+	}
+	if i := strings.Index(path, "*"); i >= 0 && i != len(path)-1 {
+		return fmt.Errorf("invalidation path may only end in *, got %q", path)
+	}
+	return nil
+}
+
+// cloudFrontInvalidation is the part of a CloudFront invalidation response
+// the warmer needs
+type cloudFrontInvalidation struct {
+	ID     string `xml:"Id"`
+	Status string `xml:"Status"`
+}
+
+// invalidationURL returns the API URL of the distribution's invalidations,
+// or of one of them if id is set
+func (c *CloudFrontConfig) invalidationURL(id string) string {
+	endpoint := cloudFrontEndpoint
+	if c.Endpoint != "" {
+		endpoint = strings.TrimSuffix(c.Endpoint, "/")
+	}
+	u := fmt.Sprintf("%s/%s/distribution/%s/invalidation", endpoint, cloudFrontAPIVersion, url.PathEscape(c.DistributionID))
+	if id != "" {
+		u += "/" + url.PathEscape(id)
+	}
+	return u
+}
+
+// createInvalidation invalidates paths on the distribution
+func createInvalidation(ctx context.Context, config *CloudFrontConfig, paths []string) (*cloudFrontInvalidation, error) {
+	type batch struct {
+		XMLName         xml.Name `xml:"InvalidationBatch"`
+		Xmlns           string   `xml:"xmlns,attr"`
+		CallerReference string   `xml:"CallerReference"`
+		Quantity        int      `xml:"Paths>Quantity"`
+		Items           []string `xml:"Paths>Items>Path"`
+	}
+	body, err := xml.Marshal(batch{
+		Xmlns:           "http://cloudfront.amazonaws.com/doc/" + cloudFrontAPIVersion + "/",
+		CallerReference: "cache-warmer-" + newRunID(),
+		Quantity:        len(paths),
+		Items:           paths,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode invalidation: %v", err)
+	}
+
+	header := http.Header{"Content-Type": {"application/xml"}}
+	data, err := doAWSRequest(ctx, http.MethodPost, config.invalidationURL(""), append([]byte(xml.Header), body...), header, "us-east-1", "cloudfront")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invalidation: %v", err)
+	}
+	return parseInvalidation(data)
+}
+
+// getInvalidation returns the current state of invalidation id
+func getInvalidation(ctx context.Context, config *CloudFrontConfig, id string) (*cloudFrontInvalidation, error) {
+	data, err := doAWSRequest(ctx, http.MethodGet, config.invalidationURL(id), nil, nil, "us-east-1", "cloudfront")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invalidation %s: %v", id, err)
+	}
+	return parseInvalidation(data)
+}
+
+// parseInvalidation parses an Invalidation response document
+func parseInvalidation(data []byte) (*cloudFrontInvalidation, error) {
+	var inv cloudFrontInvalidation
+	if err := xml.Unmarshal(data, &inv); err != nil {
+		return nil, fmt.Errorf("failed to parse invalidation response: %v", err)
+	}
+	if inv.ID == "" {
+		return nil, fmt.Errorf("invalidation response has no Id")
+	}
+	return &inv, nil
+}
+
+// waitForInvalidation polls invalidation id until CloudFront reports it
+// completed, or ctx ends
+func waitForInvalidation(ctx context.Context, config *CloudFrontConfig, id string, logger *Logger) error {
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("invalidation %s not completed: %v", id, ctx.Err())
+		case <-ticker.C:
+		}
+
+		inv, err := getInvalidation(ctx, config, id)
+		if err != nil {
+			// Keep polling through transient API errors until the timeout
+			logger.Warn("%v", err)
+			continue
+		}
+		logger.Debug("Invalidation %s is %s", id, inv.Status)
+		if inv.Status == "Completed" {
+			return nil
+		}
+	}
+}
+
+// invalidatedURLs returns the URLs among urls that the invalidation paths
+// cover, plus the exact paths on base if they aren't listed
+func invalidatedURLs(urls, paths []string, base string) []string {
+	base = strings.TrimSuffix(base, "/")
+	patterns := make([]urlPattern, len(paths))
+	for i, path := range paths {
+		patterns[i] = compileURLPattern(base + path)
+	}
+
+	seen := make(map[string]bool)
+	var matched []string
+	for _, u := range urls {
+		if !seen[u] && matchesAny(patterns, u) {
+			seen[u] = true
+			matched = append(matched, u)
+		}
+	}
+	if base != "" {
+		for _, path := range paths {
+			if u := base + path; !strings.HasSuffix(path, "*") && !seen[u] {
+				seen[u] = true
+				matched = append(matched, u)
+			}
+		}
+	}
+	return matched
+}
+
+// runInvalidate implements the "invalidate" subcommand and returns the exit
+// code
+func runInvalidate(args []string) int {
+	fs := flag.NewFlagSet("invalidate", flag.ExitOnError)
+	configFile := fs.String("config", "config.yaml", "Path to configuration file")
+	workers := fs.Int("workers", 0, "Number of concurrent workers (overrides config file)")
+	timeout := fs.Duration("timeout", 0, "HTTP request timeout (overrides config file)")
+	verbose := fs.Bool("verbose", false, "Enable verbose logging")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cache-warmer invalidate [OPTIONS] [PATH...]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	logger := NewLogger(*verbose)
+
+	config, err := LoadConfig(*configFile, "", *workers, *timeout)
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return 1
+	}
+	if err := config.ValidateSettings(); err != nil {
+		logger.Error("Invalid configuration: %v", err)
+		return 1
+	}
+
+	cf := &config.CloudFront
+	if cf.DistributionID == "" {
+		logger.Error("cloudfront distribution_id is required")
+		return 1
+	}
+	paths := cf.Paths
+	if fs.NArg() > 0 {
+		paths = fs.Args()
+	}
+	if len(paths) == 0 {
+		logger.Error("No paths to invalidate: pass them as arguments or set cloudfront paths")
+		return 1
+	}
+	for _, path := range paths {
+		if err := validateInvalidationPath(path); err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+	}
+
+	// A one-off run never needs the background servers
+	config.DisableServices()
+
+	warmer := NewCacheWarmer(config, logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cf.Timeout)
+	inv, err := createInvalidation(ctx, cf, paths)
+	if err != nil {
+		cancel()
+		logger.Error("%v", err)
+		return 2
+	}
+	logger.Info("Created invalidation %s for %d paths on distribution %s, waiting for it to complete",
+		inv.ID, len(paths), cf.DistributionID)
+
+	if inv.Status != "Completed" {
+		err = waitForInvalidation(ctx, cf, inv.ID, logger)
+	}
+	cancel()
+	if err != nil {
+		logger.Error("%v", err)
+		return 2
+	}
+
+	urls := invalidatedURLs(warmer.collectURLs(context.Background()), paths, cf.BaseURL)
+	if len(urls) == 0 {
+		logger.Warn("Invalidation %s completed, but no configured URLs match its paths", inv.ID)
+		return 0
+	}
+	logger.Info("Invalidation %s completed, warming %d URLs", inv.ID, len(urls))
+
+	summary, err := warmer.WarmURLs(context.Background(), urls)
+	if err != nil || summary.FailedRequests > 0 {
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coalescer serializes warm requests for URL variants that collapse to the
+// same origin resource behind a collapsing proxy, so warming many variants
+// at once doesn't itself cause an origin stampede
+type coalescer struct {
+	config *CoalescingConfig
+
+	mutex sync.Mutex
+	keys  map[string]*coalesceKey
+}
+
+// coalesceKey is the lock shared by requests with the same coalescing key
+type coalesceKey struct {
+	lock  chan struct{}
+	last  time.Time
+	users int
+}
+
+// newCoalescer creates a coalescer, or returns nil if coalescing is disabled
+func newCoalescer(config *CoalescingConfig) *coalescer {
+	if !config.Enabled {
+		return nil
+	}
+	return &coalescer{
+		config: config,
+		keys:   make(map[string]*coalesceKey),
+	}
+}
+
+// Key returns the coalescing key of rawURL: the URL with its host lowercased,
+// the fragment and ignored query parameters dropped and the rest sorted
+func (c *coalescer) Key(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+
+	query := u.Query()
+	for name := range query {
+		for _, pattern := range c.config.IgnoreQuery {
+			if ok, _ := path.Match(pattern, name); ok {
+				query.Del(name)
+				break
+			}
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// Acquire waits until no other request with the same key is in flight and
+// the stagger delay has passed since the last one finished. The returned
+// function must be called when the request is done.
+func (c *coalescer) Acquire(ctx context.Context, key string) (func(), error) {
+	if c == nil {
+		return func() {}, nil
+	}
+
+	c.mutex.Lock()
+	k, ok := c.keys[key]
+	if !ok {
+		k = &coalesceKey{lock: make(chan struct{}, 1)}
+		c.keys[key] = k
+	}
+	k.users++
+	c.mutex.Unlock()
+
+	release := func() {
+		c.mutex.Lock()
+		k.users--
+		if k.users == 0 {
+			delete(c.keys, key)
+		}
+		c.mutex.Unlock()
+	}
+
+	select {
+	case k.lock <- struct{}{}:
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+
+	if delay := time.Until(k.last.Add(c.config.Stagger)); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			<-k.lock
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return func() {
+		k.last = time.Now()
+		<-k.lock
+		release()
+	}, nil
+}
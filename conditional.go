@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Validators are the cache validators of the last full response for a URL
+type Validators struct {
+	// ETag is sent back as If-None-Match
+	ETag string `json:"etag,omitempty"`
+
+	// LastModified is sent back as If-Modified-Since
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// ValidatorStore keeps the validators of each URL (and device) so later
+// cycles can ask for a 304 Not Modified instead of the whole body
+type ValidatorStore struct {
+	path  string
+	mutex sync.Mutex
+	URLs  map[string]*Validators `json:"urls"`
+}
+
+// newValidatorStore creates an empty store persisted at path, or kept in
+// memory only if path is empty
+func newValidatorStore(path string) *ValidatorStore {
+	return &ValidatorStore{
+		path: path,
+		URLs: make(map[string]*Validators),
+	}
+}
+
+// LoadValidatorStore reads the validators file at path; a missing file
+// yields an empty store
+func LoadValidatorStore(path string) (*ValidatorStore, error) {
+	s := newValidatorStore(path)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read validators file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse validators file: %v", err)
+	}
+	if s.URLs == nil {
+		s.URLs = make(map[string]*Validators)
+	}
+	return s, nil
+}
+
+// validatorKey identifies the representation of url warmed as device
+func validatorKey(url, device string) string {
+	if device == "" {
+		return url
+	}
+	return device + " " + url
+}
+
+// Apply sets the conditional headers for the stored validators of key on
+// req, reporting whether any were set
+func (s *ValidatorStore) Apply(req *http.Request, key string) bool {
+	s.mutex.Lock()
+	v := s.URLs[key]
+	s.mutex.Unlock()
+	if v == nil {
+		return false
+	}
+
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	return true
+}
+
+// Observe stores the validators of a successful response for key. A 200
+// without validators forgets the old ones; a 304 only updates those it
+// carries.
+func (s *ValidatorStore) Observe(key string, resp *http.Response) {
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if etag == "" && lastModified == "" {
+			delete(s.URLs, key)
+			return
+		}
+		s.URLs[key] = &Validators{ETag: etag, LastModified: lastModified}
+	case http.StatusNotModified:
+		v := s.URLs[key]
+		if v == nil {
+			return
+		}
+		if etag != "" {
+			v.ETag = etag
+		}
+		if lastModified != "" {
+			v.LastModified = lastModified
+		}
+	}
+}
+
+// Save writes the validators file atomically, if the store is persisted
+func (s *ValidatorStore) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mutex.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode validators: %v", err)
+	}
+	return writeFileAtomic(s.path, data)
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,7 +20,46 @@ import (
 // Config represents the configuration for the cache warming tool
 type Config struct {
 	// URLs is the list of URLs to warm
-	URLs []string `yaml:"urls"`
+	URLs []URLEntry `yaml:"urls"`
+
+	// Templates expand into every combination of their parameter values
+	Templates []URLTemplate `yaml:"url_templates"`
+
+	// RemoteList is an HTTP endpoint serving the URL list itself, re-fetched
+	// every cycle
+	RemoteList RemoteListConfig `yaml:"url_list"`
+
+	// Sitemap is an XML sitemap (or sitemap index) whose <loc> entries are
+	// warmed in addition to URLs
+	Sitemap string `yaml:"sitemap"`
+
+	// OpenAPI warms the GET operations of an OpenAPI or Swagger document
+	OpenAPI OpenAPIConfig `yaml:"openapi"`
+
+	// GraphQL warms queries against a GraphQL endpoint
+	GraphQL GraphQLConfig `yaml:"graphql"`
+
+	// AccessLog warms the most requested paths found in web server access logs
+	AccessLog AccessLogConfig `yaml:"access_log"`
+
+	// S3Logs warms the most requested paths found in ALB or CloudFront logs
+	// delivered to S3
+	S3Logs S3LogsConfig `yaml:"s3_logs"`
+
+	// GoogleAnalytics warms the most viewed pages reported by GA4
+	GoogleAnalytics GoogleAnalyticsConfig `yaml:"google_analytics"`
+
+	// RedisQueue warms URLs other services push onto a Redis list or stream
+	RedisQueue RedisQueueConfig `yaml:"redis_queue"`
+
+	// Crawl discovers and warms same-domain pages linked from the URLs
+	Crawl CrawlConfig `yaml:"crawl"`
+
+	// Robots reads robots.txt for sitemaps and, when crawling, its rules
+	Robots RobotsConfig `yaml:"robots"`
+
+	// Assets warms the stylesheets, scripts, images and fonts of HTML pages
+	Assets AssetsConfig `yaml:"assets"`
 
 	// Workers is the number of concurrent workers
 	Workers int `yaml:"workers"`
@@ -21,252 +67,3171 @@ type Config struct {
 	// Timeout is the HTTP request timeout
 	Timeout time.Duration `yaml:"timeout"`
 
-	// RetryCount is the number of retries for failed requests
-	RetryCount int `yaml:"retry_count"`
+	// RequestTimeout bounds each attempt at a warm URL (0 = timeout alone)
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+
+	// URLBudget bounds the total time spent on one URL across all attempts,
+	// including retry delays and politeness waits (0 = no budget)
+	URLBudget time.Duration `yaml:"url_budget"`
+
+	// CycleTimeout bounds how long a whole warming cycle may take (0 = none)
+	CycleTimeout time.Duration `yaml:"cycle_timeout"`
+
+	// RetryCount is the number of retries for failed requests
+	RetryCount int `yaml:"retry_count"`
+
+	// RetryDelay is the delay between retries
+	RetryDelay time.Duration `yaml:"retry_delay"`
+
+	// RetryPolicy overrides RetryCount per failure class (timeout, dns, tls,
+	// connection, proxy, status, status_4xx, status_5xx, assertion, other)
+	RetryPolicy map[string]int `yaml:"retry_policy"`
+
+	// RetryBudget caps the retries of a whole cycle, so an outage doesn't
+	// turn into a retry storm
+	RetryBudget RetryBudgetConfig `yaml:"retry_budget"`
+
+	// UserAgent is the User-Agent header to use for requests
+	UserAgent string `yaml:"user_agent"`
+
+	// Headers contains custom headers to include in requests
+	Headers map[string]string `yaml:"headers"`
+
+	// Method is GET, or HEAD for origins that fill their cache on HEAD, so
+	// large bodies aren't transferred just to warm them
+	Method string `yaml:"method"`
+
+	// FollowRedirects determines if redirects should be followed
+	FollowRedirects bool `yaml:"follow_redirects"`
+
+	// MaxRedirects is the maximum number of redirects to follow
+	MaxRedirects int `yaml:"max_redirects"`
+
+	// SuccessCodes defines which HTTP status codes are considered successful
+	SuccessCodes []int `yaml:"success_codes"`
+
+	// SuccessRules decide success with an expression over status, headers
+	// and latency for the URLs they match, in place of SuccessCodes
+	SuccessRules []SuccessRuleConfig `yaml:"success_rules"`
+
+	// LocaleChecks verify the Content-Language and charset of locale
+	// variant URLs
+	LocaleChecks []LocaleCheckConfig `yaml:"locale_checks"`
+
+	// UnixSocket sends every request through a Unix domain socket, such as
+	// a service-mesh sidecar, instead of connecting to the URL's host
+	UnixSocket string `yaml:"unix_socket"`
+
+	// ConnectTo connects to a fixed address for a host while keeping the
+	// URL's host in Host and SNI, like curl's --connect-to. Keys are host or
+	// host:port, values IP or IP:port.
+	ConnectTo map[string]string `yaml:"connect_to"`
+
+	// DNS resolves hosts through other name servers and a static hosts map,
+	// e.g. to warm not-yet-live addresses before a cutover
+	DNS DNSConfig `yaml:"dns"`
+
+	// TLS trusts a private CA or skips certificate verification, for
+	// internal environments
+	TLS TLSConfig `yaml:"tls"`
+
+	// HTTP2 selects the protocol warm requests use: auto, force, disable or
+	// h2c
+	HTTP2 string `yaml:"http2"`
+
+	// IPFamily restricts connections to ipv4 or ipv6; dual connects over
+	// whichever family answers first
+	IPFamily string `yaml:"ip_family"`
+
+	// ConnectionPool tunes how many connections to origins are kept open and
+	// reused
+	ConnectionPool ConnectionPoolConfig `yaml:"connection_pool"`
+
+	// ProxyURL sends warm requests through this forward proxy, in place of
+	// HTTP_PROXY and HTTPS_PROXY; regions with their own proxy use that
+	ProxyURL string `yaml:"proxy_url"`
+
+	// ProxyPool rotates warm requests over several forward proxies, in place
+	// of ProxyURL
+	ProxyPool ProxyPoolConfig `yaml:"proxy_pool"`
+
+	// Transport, if set by embedding code, replaces the built-in transport
+	// for direct warming; the timeout and redirect policy still apply on top
+	Transport http.RoundTripper `yaml:"-"`
+
+	// Only restricts each cycle to URLs matching one of these patterns, for
+	// ad-hoc runs (set by -only)
+	Only []string `yaml:"-"`
+
+	// Limit caps how many distinct URLs each cycle warms (set by -limit)
+	Limit int `yaml:"-"`
+
+	// MaxURLs caps how many distinct URLs each cycle warms, chosen by the
+	// sampling mode (0 = all)
+	MaxURLs int `yaml:"max_urls"`
+
+	// Sampling chooses which URLs each cycle warms when it can't warm all
+	// of them
+	Sampling SamplingConfig `yaml:"sampling"`
+
+	// Shard splits the URLs between several instances, each warming a
+	// disjoint subset (overridden by -shard-index and -shard-count)
+	Shard ShardConfig `yaml:"shard"`
+
+	// Interval is the time between cycles in continuous mode, 0 to run once
+	// (overridden by -interval)
+	Interval time.Duration `yaml:"interval"`
+
+	// Jitter delays each scheduled cycle by a random time of up to this
+	// duration, so replicas and restarts don't line up on the same tick
+	Jitter time.Duration `yaml:"jitter"`
+
+	// Stagger spreads each scheduled cycle's requests evenly over the
+	// interval instead of sending them all at the tick
+	Stagger bool `yaml:"stagger"`
+
+	// Trace dumps the full exchange of URLs matching one of these patterns
+	// (set by -trace-url)
+	Trace []string `yaml:"-"`
+
+	// TraceFor stops tracing this long after start, 0 for never (set by
+	// -trace-for)
+	TraceFor time.Duration `yaml:"-"`
+
+	// Order controls the order URLs are dispatched in each cycle
+	Order string `yaml:"order"`
+
+	// WarmOnStart controls what continuous mode warms at process start
+	WarmOnStart string `yaml:"warm_on_start"`
+
+	// OverlapPolicy controls what continuous mode does when a cycle is
+	// still running at the next tick
+	OverlapPolicy string `yaml:"overlap_policy"`
+
+	// MaxConcurrentCycles caps the cycles running at once with overlap
+	// policy concurrent
+	MaxConcurrentCycles int `yaml:"max_concurrent_cycles"`
+
+	// LeaderElection lets only one of several continuous-mode replicas run
+	// the scheduled cycles, with the others standing by
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+
+	// Tiers warm groups of URLs every Nth cycle or once per interval instead
+	// of every cycle
+	Tiers []TierConfig `yaml:"tiers"`
+
+	// Groups are URL sets warmed on their own schedule and with their own
+	// settings, independently of the main URLs
+	Groups []GroupConfig `yaml:"groups"`
+
+	// StateFile persists the time of the last cycle, so continuous mode can
+	// catch up on cycles missed while the process was down, and when each
+	// tier was last warmed
+	StateFile string `yaml:"state_file"`
+
+	// Backfill is what the catch-up cycle warms, with the same values as
+	// WarmOnStart
+	Backfill string `yaml:"backfill"`
+
+	// HistoryFile persists per-URL warming history across runs
+	HistoryFile string `yaml:"history_file"`
+
+	// FailuresFile receives the URLs that failed each cycle, in the format
+	// read by -urls-file
+	FailuresFile string `yaml:"failures_file"`
+
+	// ResultsFile receives every per-URL result of each cycle, as CSV if it
+	// ends in .csv and as JSON otherwise
+	ResultsFile string `yaml:"results_file"`
+
+	// SlowThreshold logs warmed requests taking longer than this with a
+	// timing breakdown and lists the slowest in the summary (0 = disabled)
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+
+	// FailThreshold fails a single run, exiting non-zero, if more than this
+	// percentage of its requests fail (0 = disabled)
+	FailThreshold float64 `yaml:"fail_threshold"`
+
+	// FailPriority fails a single run if a URL with at least this priority
+	// fails to warm (0 = disabled)
+	FailPriority int `yaml:"fail_priority"`
+
+	// SkipList stops warming URLs that keep failing, re-checking them later
+	SkipList SkipListConfig `yaml:"skip_list"`
+
+	// Checkpoint persists the progress of a cycle, so an interrupted cycle
+	// resumes where it left off
+	Checkpoint CheckpointConfig `yaml:"checkpoint"`
+
+	// Conditional revalidates URLs with the validators of their last
+	// response, so unchanged URLs are answered 304 Not Modified
+	Conditional ConditionalConfig `yaml:"conditional"`
+
+	// Regions lists egress paths every URL is warmed through
+	Regions []RegionConfig `yaml:"regions"`
+
+	// Devices warms every URL once per device profile, for caches split by
+	// User-Agent or a device-detection header
+	Devices []DeviceConfig `yaml:"devices"`
+
+	// ByteRanges warms large files such as video segments or installers by
+	// byte range instead of downloading them whole
+	ByteRanges []ByteRangeConfig `yaml:"byte_ranges"`
+
+	// Shield is an origin shield URLs are warmed through before the regions
+	Shield ShieldConfig `yaml:"shield"`
+
+	// Shadow mirrors every warm request to a second stack and compares the
+	// responses
+	Shadow ShadowConfig `yaml:"shadow"`
+
+	// Waves splits each cycle into waves, each started only if the one
+	// before it passed its checks
+	Waves WavesConfig `yaml:"waves"`
+
+	// TTLReport configures the per-cycle inventory of response TTLs
+	TTLReport TTLReportConfig `yaml:"ttl_report"`
+
+	// TTLSchedule warms each URL shortly before its cached copy expires
+	// instead of every cycle
+	TTLSchedule TTLScheduleConfig `yaml:"ttl_schedule"`
+
+	// CaptureHeaders lists response headers recorded per URL in run reports,
+	// e.g. X-Cache, Age or Server-Timing
+	CaptureHeaders []string `yaml:"capture_headers"`
+
+	// Artifacts configures where run reports are written and uploaded
+	Artifacts ArtifactsConfig `yaml:"artifacts"`
+
+	// Metrics configuration
+	Metrics MetricsConfig `yaml:"metrics"`
+
+	// StatsD pushes metrics to a StatsD or DogStatsD agent
+	StatsD StatsDConfig `yaml:"statsd"`
+
+	// Webhook configuration for event-driven warming
+	Webhook WebhookConfig `yaml:"webhook"`
+
+	// GRPC configures the gRPC control and status API
+	GRPC GRPCConfig `yaml:"grpc"`
+
+	// CloudFront configures the invalidate subcommand
+	CloudFront CloudFrontConfig `yaml:"cloudfront"`
+
+	// Fleet shares cycle summaries between warmer instances
+	Fleet FleetConfig `yaml:"fleet"`
+
+	// ProbeEndpoint answers blackbox probes of single URLs on the metrics
+	// server
+	ProbeEndpoint ProbeEndpointConfig `yaml:"probe_endpoint"`
+
+	// Admin serves the admin API on the metrics server
+	Admin AdminConfig `yaml:"admin"`
+
+	// RateLimitBudget configures pacing based on API rate-limit headers
+	RateLimitBudget RateLimitBudgetConfig `yaml:"rate_limit_budget"`
+
+	// Politeness spaces each worker's requests to the same host
+	Politeness PolitenessConfig `yaml:"politeness"`
+
+	// Admission protects the warmer itself from running out of memory
+	Admission AdmissionConfig `yaml:"admission"`
+
+	// RateLimit caps the rate requests are sent at across all workers
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// AllAddresses warms every resolved address of a host, so each node of
+	// a DNS round-robin pool is warmed
+	AllAddresses AllAddressesConfig `yaml:"all_addresses"`
+
+	// Normalize rewrites URLs to a canonical form before they are
+	// deduplicated, so near-duplicates from several sources are warmed once
+	Normalize NormalizeConfig `yaml:"normalize"`
+
+	// Coalescing keeps URL variants of the same origin resource from being
+	// warmed concurrently
+	Coalescing CoalescingConfig `yaml:"coalescing"`
+
+	// Variants also warms each URL under its other schemes and its www or
+	// bare host, since each is a separate cache key
+	Variants VariantsConfig `yaml:"variants"`
+
+	// Cookies replays cookies set by responses on later requests to the host
+	Cookies CookiesConfig `yaml:"cookies"`
+
+	// Auth logs in before warming and attaches the session cookies or
+	// access token to warm requests
+	Auth AuthConfig `yaml:"auth"`
+
+	// BasicAuth sends HTTP basic auth credentials with every warm request;
+	// a URL's own basic_auth takes precedence
+	BasicAuth *BasicAuthConfig `yaml:"basic_auth"`
+}
+
+// PolitenessConfig contains configuration for per-host politeness delays
+type PolitenessConfig struct {
+	// Delay is the minimum time between a worker's consecutive requests to
+	// the same host
+	Delay time.Duration `yaml:"delay"`
+
+	// Jitter is a random extra delay of up to this duration added each time
+	Jitter time.Duration `yaml:"jitter"`
+
+	// Hosts limits politeness to these hostnames (empty = all hosts)
+	Hosts []string `yaml:"hosts"`
+}
+
+// AllAddressesConfig contains configuration for warming every A/AAAA record
+type AllAddressesConfig struct {
+	// Enabled warms each URL once per resolved address of its host
+	Enabled bool `yaml:"enabled"`
+
+	// Hosts limits this to these hostnames (empty = all hosts)
+	Hosts []string `yaml:"hosts"`
+}
+
+// NormalizeConfig contains configuration for normalizing URLs before they
+// are deduplicated
+type NormalizeConfig struct {
+	// Enabled lowercases the scheme and host, drops default ports and
+	// resolves dot-segments
+	Enabled bool `yaml:"enabled"`
+
+	// StripFragment drops the #fragment, which is never sent to the server
+	StripFragment bool `yaml:"strip_fragment"`
+
+	// StripQuery lists query parameters (glob patterns such as "utm_*")
+	// dropped from URLs, such as tracking parameters
+	StripQuery []string `yaml:"strip_query"`
+}
+
+// CoalescingConfig contains configuration for stampede-safe warming of URL
+// variants that collapse to the same origin resource
+type CoalescingConfig struct {
+	// Enabled serializes requests sharing a coalescing key
+	Enabled bool `yaml:"enabled"`
+
+	// IgnoreQuery lists query parameters (glob patterns such as "utm_*")
+	// that don't change the origin resource
+	IgnoreQuery []string `yaml:"ignore_query"`
+
+	// Header, if set, sends the coalescing key with each request for
+	// proxies that can lock or collapse on it
+	Header string `yaml:"header"`
+
+	// Stagger is the pause after a request before the next one with the
+	// same key is sent
+	Stagger time.Duration `yaml:"stagger"`
+}
+
+// VariantsConfig contains configuration for expanding URLs into their
+// protocol and host variants
+type VariantsConfig struct {
+	// Schemes warms each URL with each of these schemes too (http, https)
+	Schemes []string `yaml:"schemes"`
+
+	// WWW warms each URL on the www host of a bare domain, or the bare
+	// domain of a www host, too
+	WWW bool `yaml:"www"`
+
+	// Hosts limits expansion to these hostnames (empty = all hosts)
+	Hosts []string `yaml:"hosts"`
+}
+
+// CookiesConfig contains configuration for the cookie jar
+type CookiesConfig struct {
+	// Enabled keeps Set-Cookie responses, such as session or A/B bucket
+	// cookies, and sends them with later requests to the host
+	Enabled bool `yaml:"enabled"`
+
+	// Preload puts these cookies in the jar before the first request
+	Preload []CookieConfig `yaml:"preload"`
+}
+
+// Authentication flows run before warming
+const (
+	// AuthLogin posts a login form and keeps the session cookies it sets
+	AuthLogin = "login"
+
+	// AuthOAuth2 requests an OAuth2 access token sent as a Bearer token
+	AuthOAuth2 = "oauth2"
+)
+
+// OAuth2 grants supported by the oauth2 authentication flow
+const (
+	OAuth2ClientCredentials = "client_credentials"
+	OAuth2Password          = "password"
+)
+
+// AuthConfig contains configuration for authenticating before warming
+type AuthConfig struct {
+	// Type is login or oauth2 (empty = no authentication)
+	Type string `yaml:"type"`
+
+	// URL is the login form's action, or the OAuth2 token endpoint
+	URL string `yaml:"url"`
+
+	// Form holds the login form fields, e.g. username and password
+	Form map[string]string `yaml:"form"`
+
+	// Grant is the OAuth2 grant: client_credentials or password
+	Grant string `yaml:"grant"`
+
+	// ClientID and ClientSecret identify the OAuth2 client
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+
+	// Username and Password are the resource owner's, for the password grant
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// Scope is the space-separated OAuth2 scope requested
+	Scope string `yaml:"scope"`
+
+	// Hosts limits the access token to these hostnames (empty = all hosts);
+	// login cookies follow their own domain
+	Hosts []string `yaml:"hosts"`
+
+	// Refresh authenticates again this long after the last time, for
+	// sessions that expire without telling (0 = on cookie or token expiry)
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+// BasicAuthConfig contains HTTP basic auth credentials
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// PasswordEnv reads the password from this environment variable
+	// instead, keeping it out of the config file
+	PasswordEnv string `yaml:"password_env"`
+}
+
+// CookieConfig is a cookie preloaded into the jar
+type CookieConfig struct {
+	// URL is where the cookie is sent: its host and paths under its path
+	URL string `yaml:"url"`
+
+	// Name and Value are the cookie itself
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// AdmissionConfig contains configuration for admission control
+type AdmissionConfig struct {
+	// MaxInFlight caps concurrent requests across all runs and regions
+	// (0 = no cap beyond the worker count)
+	MaxInFlight int `yaml:"max_in_flight"`
+
+	// MaxHeapMB pauses new requests while the heap exceeds this many MiB
+	// (0 = no limit)
+	MaxHeapMB int `yaml:"max_heap_mb"`
+}
+
+// RateLimitConfig contains configuration for the global request rate limit
+type RateLimitConfig struct {
+	// RequestsPerSecond caps how many requests start per second across all
+	// workers, regions and runs (0 = no limit)
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+
+	// Burst is how many requests may start at once after a quiet spell
+	// (default: 1)
+	Burst int `yaml:"burst"`
+}
+
+// TLSConfig contains TLS options for connecting to origins
+type TLSConfig struct {
+	// CAFile is a PEM bundle of root CAs trusted in addition to the system
+	// roots
+	CAFile string `yaml:"ca_file"`
+
+	// InsecureSkipVerify accepts any certificate, for self-signed hosts
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// URLEntry is a URL to warm, written in config either as a plain string or
+// as a mapping with per-URL options
+type URLEntry struct {
+	// URL is the address to warm
+	URL string `yaml:"url"`
+
+	// Critical marks URLs warmed before the process reports ready
+	Critical bool `yaml:"critical"`
+
+	// BasicAuth overrides the global basic auth credentials for this URL
+	BasicAuth *BasicAuthConfig `yaml:"basic_auth"`
+
+	// Tags name sets of URLs the warm webhook can be asked to warm
+	Tags []string `yaml:"tags"`
+
+	// Priority orders the URL's dispatch: higher priorities are warmed
+	// first and left out last (default: 0, or the group's)
+	Priority int `yaml:"priority"`
+}
+
+// UnmarshalYAML accepts either "https://..." or {url: "https://...", ...}
+func (e *URLEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var plain string
+	if err := unmarshal(&plain); err == nil {
+		*e = URLEntry{URL: plain}
+		return nil
+	}
+
+	type rawEntry URLEntry
+	var raw rawEntry
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*e = URLEntry(raw)
+	return nil
+}
+
+// SuccessRuleConfig is a success expression for a group of URLs
+type SuccessRuleConfig struct {
+	// Match selects the URLs the rule applies to, with the same patterns as
+	// -only; empty matches every URL
+	Match string `yaml:"match"`
+
+	// When is the expression a response must satisfy, e.g.
+	// status in 200..299 and header["X-Cache"] != "BYPASS" and duration < 2s
+	When string `yaml:"when"`
+}
+
+// LocaleCheckConfig is the language and charset expected for a group of
+// locale variant URLs
+type LocaleCheckConfig struct {
+	// Match selects the URLs, with the same patterns as -only; empty
+	// matches every URL
+	Match string `yaml:"match"`
+
+	// Language is the expected Content-Language tag; de also accepts de-DE
+	Language string `yaml:"language"`
+
+	// Charset is the expected charset parameter of Content-Type
+	Charset string `yaml:"charset"`
+}
+
+// TierConfig is a frequency tier for a group of URLs
+type TierConfig struct {
+	// Name identifies the tier in logs and the state file (default: Match)
+	Name string `yaml:"name"`
+
+	// Match selects the URLs, with the same patterns as -only; the first
+	// matching tier applies
+	Match string `yaml:"match"`
+
+	// Every warms the tier's URLs every Nth cycle
+	Every int `yaml:"every"`
+
+	// Interval warms the tier's URLs once this long after they were last
+	// warmed, e.g. 24h for daily
+	Interval time.Duration `yaml:"interval"`
+}
+
+// GroupConfig is a set of URLs warmed on its own schedule and with its own
+// settings, independently of the main URLs
+type GroupConfig struct {
+	// Name identifies the group in logs
+	Name string `yaml:"name"`
+
+	// URLs, Sitemap and RemoteList are where the group's URLs come from, as
+	// for the main URLs
+	URLs       []URLEntry       `yaml:"urls"`
+	Sitemap    string           `yaml:"sitemap"`
+	RemoteList RemoteListConfig `yaml:"url_list"`
+
+	// Workers is the number of concurrent workers of the group's cycles
+	// (default: workers)
+	Workers int `yaml:"workers"`
+
+	// Interval is the time between the group's cycles in continuous mode
+	// (default: interval)
+	Interval time.Duration `yaml:"interval"`
+
+	// Headers are sent in addition to the global headers, replacing those
+	// of the same name
+	Headers map[string]string `yaml:"headers"`
+
+	// SuccessCodes replace the global success codes for the group
+	SuccessCodes []int `yaml:"success_codes"`
+
+	// Priority is the priority of the group's URLs without their own, and
+	// orders the groups of a single run
+	Priority int `yaml:"priority"`
+}
+
+// validate checks a URL group's settings
+func (g *GroupConfig) validate() error {
+	if len(g.URLs) == 0 && g.Sitemap == "" && g.RemoteList.URL == "" {
+		return fmt.Errorf("no urls, sitemap or url_list")
+	}
+	for _, entry := range g.URLs {
+		if err := ValidateURL(entry.URL); err != nil {
+			return err
+		}
+		if entry.BasicAuth != nil {
+			if err := entry.BasicAuth.validate(); err != nil {
+				return fmt.Errorf("invalid basic_auth for %s: %v", entry.URL, err)
+			}
+		}
+	}
+	if g.Sitemap != "" {
+		if err := ValidateURL(g.Sitemap); err != nil {
+			return fmt.Errorf("invalid sitemap: %v", err)
+		}
+	}
+	if g.RemoteList.URL != "" {
+		if err := ValidateURL(g.RemoteList.URL); err != nil {
+			return fmt.Errorf("invalid url_list: %v", err)
+		}
+	}
+	if g.Workers < 0 || g.Workers > 1000 {
+		return fmt.Errorf("workers must be between 1 and 1000, got %d", g.Workers)
+	}
+	if g.Interval < 0 {
+		return fmt.Errorf("interval must be non-negative, got %v", g.Interval)
+	}
+	for _, code := range g.SuccessCodes {
+		if code < 100 || code >= 600 {
+			return fmt.Errorf("invalid HTTP status code: %d", code)
+		}
+	}
+	return nil
+}
+
+// URLTemplate is a URL with {name} placeholders warmed with every
+// combination of its parameter values
+type URLTemplate struct {
+	// URL is the address with placeholders, e.g. https://shop.example/products/{id}?lang={lang}
+	URL string `yaml:"url"`
+
+	// Params lists the values of each placeholder
+	Params map[string]TemplateValues `yaml:"params"`
+
+	// Query lists the values of query parameters appended to the URL, in
+	// name order; an empty value leaves the parameter out
+	Query map[string]TemplateValues `yaml:"query"`
+
+	// MaxURLs caps the expansion to its first URLs (0 = every combination)
+	MaxURLs int `yaml:"max_urls"`
+}
+
+// OverrideURLs replaces the configured URLs and every other URL source with
+// urls, as given on the command line
+func (c *Config) OverrideURLs(urls []string) {
+	// Trim whitespace from each URL
+	c.URLs = make([]URLEntry, len(urls))
+	for i, u := range urls {
+		c.URLs[i] = URLEntry{URL: strings.TrimSpace(u)}
+	}
+	c.Templates = nil
+	c.RemoteList.URL = ""
+	c.Sitemap = ""
+	c.OpenAPI.Spec = ""
+	c.GraphQL.Endpoint = ""
+	c.AccessLog.Files = nil
+	c.S3Logs.Source = ""
+	c.GoogleAnalytics.PropertyID = ""
+	c.RedisQueue.URL = ""
+}
+
+// DisableServices turns off everything a one-off command such as probe must
+// not start: the servers, the fleet, the StatsD push, the Redis queue
+// consumer and leader election
+func (c *Config) DisableServices() {
+	c.Metrics.Enabled = false
+	c.ProbeEndpoint.Enabled = false
+	c.Admin.Enabled = false
+	c.Webhook.Enabled = false
+	c.GRPC.Enabled = false
+	c.Fleet.Aggregate = false
+	c.Fleet.Push = ""
+	c.StatsD.Address = ""
+	c.RedisQueue.URL = ""
+	c.LeaderElection.Redis = ""
+}
+
+// URLList returns the addresses of all configured URLs
+func (c *Config) URLList() []string {
+	urls := make([]string, len(c.URLs))
+	for i, entry := range c.URLs {
+		urls[i] = entry.URL
+	}
+	return urls
+}
+
+// TaggedURLList returns the addresses of URLs with the given tag
+func (c *Config) TaggedURLList(tag string) []string {
+	var urls []string
+	for _, entry := range c.URLs {
+		if containsString(entry.Tags, tag) {
+			urls = append(urls, entry.URL)
+		}
+	}
+	return urls
+}
+
+// CriticalURLList returns the addresses of URLs marked critical
+func (c *Config) CriticalURLList() []string {
+	var urls []string
+	for _, entry := range c.URLs {
+		if entry.Critical {
+			urls = append(urls, entry.URL)
+		}
+	}
+	return urls
+}
+
+// RateLimitBudgetConfig contains configuration for rate-limit budget awareness
+type RateLimitBudgetConfig struct {
+	// Enabled determines if rate-limit response headers are honored
+	Enabled bool `yaml:"enabled"`
+
+	// Reserve is the remaining budget left untouched for real clients;
+	// warming of a host pauses until its window resets at or below it
+	Reserve int64 `yaml:"reserve"`
+
+	// SlowdownBelow is the remaining budget below which requests to a host
+	// are spread evenly over the rest of the rate-limit window
+	SlowdownBelow int64 `yaml:"slowdown_below"`
+
+	// MaxPause caps how long a single request waits for a window to reset
+	MaxPause time.Duration `yaml:"max_pause"`
+}
+
+// AccessLogConfig contains configuration for warming by traffic popularity
+type AccessLogConfig struct {
+	// Files are nginx/Apache access logs in combined or common format; glob
+	// patterns are expanded and gzipped rotated logs are read too
+	Files []string `yaml:"files"`
+
+	// BaseURL is the scheme and host the logged paths are warmed against
+	BaseURL string `yaml:"base_url"`
+
+	// Top is how many of the most requested paths are warmed
+	Top int `yaml:"top"`
+}
+
+// RemoteListConfig contains configuration for fetching URLs from an HTTP
+// endpoint serving JSON or newline-separated text
+type RemoteListConfig struct {
+	// URL is the endpoint serving the list
+	URL string `yaml:"url"`
+
+	// Headers are sent with the list request, e.g. for authorization
+	Headers map[string]string `yaml:"headers"`
+}
+
+// OpenAPIConfig contains configuration for generating URLs from the GET
+// operations of an OpenAPI 3 or Swagger 2 document
+type OpenAPIConfig struct {
+	// Spec is the http(s) URL or file path of the JSON or YAML document
+	Spec string `yaml:"spec"`
+
+	// Server overrides the server URL declared in the document
+	Server string `yaml:"server"`
+
+	// Parameters are values for path and query parameters by name, used
+	// instead of the document's examples
+	Parameters map[string]string `yaml:"parameters"`
+
+	// Tags restricts warming to operations with one of these tags
+	Tags []string `yaml:"tags"`
+}
+
+// GraphQLConfig contains configuration for warming GraphQL queries
+type GraphQLConfig struct {
+	// Endpoint is the GraphQL endpoint URL
+	Endpoint string `yaml:"endpoint"`
+
+	// Method is POST (JSON body) or GET (URL parameters, cacheable by CDNs)
+	Method string `yaml:"method"`
+
+	// Persisted sends automatic persisted query hashes instead of the query
+	// text, registering queries the server does not know yet
+	Persisted bool `yaml:"persisted"`
+
+	// Queries are the queries to warm
+	Queries []GraphQLQueryConfig `yaml:"queries"`
+}
+
+// GraphQLQueryConfig is one query to warm
+type GraphQLQueryConfig struct {
+	// File is the .graphql file holding the query
+	File string `yaml:"file"`
+
+	// Name identifies the query in results (default: its operation name, or
+	// the file name)
+	Name string `yaml:"name"`
+
+	// Variables are sent with the query
+	Variables map[string]interface{} `yaml:"variables"`
+}
+
+// S3LogsConfig contains configuration for warming by traffic popularity
+// from AWS load balancer or CDN logs
+type S3LogsConfig struct {
+	// Source is the s3://bucket/prefix the logs are delivered to
+	Source string `yaml:"source"`
+
+	// Format is the log format, alb or cloudfront
+	Format string `yaml:"format"`
+
+	// Window is how far back logs are read, by their last-modified time
+	Window time.Duration `yaml:"window"`
+
+	// BaseURL is the scheme and host the logged paths are warmed against
+	BaseURL string `yaml:"base_url"`
+
+	// Top is how many of the most requested paths are warmed
+	Top int `yaml:"top"`
+
+	// Endpoint overrides the S3 endpoint for S3-compatible stores
+	Endpoint string `yaml:"endpoint"`
+
+	// Region is the log bucket region (default: AWS_REGION)
+	Region string `yaml:"region"`
+}
+
+// GoogleAnalyticsConfig contains configuration for warming the top pages
+// from the GA4 Data API
+type GoogleAnalyticsConfig struct {
+	// PropertyID is the numeric GA4 property ID
+	PropertyID string `yaml:"property_id"`
+
+	// Days is how many days of page views are ranked, ending today
+	Days int `yaml:"days"`
+
+	// Top is how many of the most viewed pages are warmed
+	Top int `yaml:"top"`
+
+	// BaseURL is the scheme and host the reported paths are warmed against
+	BaseURL string `yaml:"base_url"`
+
+	// Hostname limits the report to page views on this host
+	Hostname string `yaml:"hostname"`
+
+	// IncludeQuery ranks paths with their query strings
+	IncludeQuery bool `yaml:"include_query"`
+}
+
+// LeaderElectionConfig contains configuration for electing the replica that
+// runs the scheduled cycles
+type LeaderElectionConfig struct {
+	// Redis is the redis:// or rediss:// URL of the server holding the
+	// leader lock (empty = disabled)
+	Redis string `yaml:"redis"`
+
+	// Key is the lock key, shared by all replicas of one deployment
+	Key string `yaml:"key"`
+
+	// Identity names this replica in the lock
+	Identity string `yaml:"identity"`
+
+	// TTL is how long the lock outlives a leader that stopped renewing it
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// RedisQueueConfig contains configuration for consuming URLs from Redis
+type RedisQueueConfig struct {
+	// URL is the redis:// or rediss:// server URL, with optional password
+	// and database number
+	URL string `yaml:"url"`
+
+	// List is a list URLs are pushed onto (RPUSH) and popped from
+	List string `yaml:"list"`
+
+	// Stream is a stream URLs are added to (XADD), read as a consumer group
+	Stream string `yaml:"stream"`
+
+	// Group is the stream consumer group shared by all warmer instances
+	Group string `yaml:"group"`
+
+	// Consumer names this instance within the group
+	Consumer string `yaml:"consumer"`
+
+	// Field is the stream entry field holding the URL
+	Field string `yaml:"field"`
+
+	// BatchSize is the most URLs dequeued and warmed in one run
+	BatchSize int `yaml:"batch_size"`
+
+	// Coordinator pushes each cycle's URLs onto the queue for worker
+	// instances instead of warming them, and doesn't consume the queue
+	Coordinator bool `yaml:"coordinator"`
+
+	// ClaimAfter is how long a stream entry may go unacknowledged by the
+	// consumer it was delivered to before another consumer claims it
+	ClaimAfter time.Duration `yaml:"claim_after"`
+}
+
+// CrawlConfig contains configuration for crawl mode
+type CrawlConfig struct {
+	// Enabled determines if HTML responses are parsed for links to warm
+	Enabled bool `yaml:"enabled"`
+
+	// MaxDepth is how many links away from a seed URL pages are followed
+	MaxDepth int `yaml:"max_depth"`
+
+	// MaxPages caps the number of discovered pages warmed per cycle
+	MaxPages int `yaml:"max_pages"`
+}
+
+// RobotsConfig contains configuration for robots.txt awareness
+type RobotsConfig struct {
+	// Sitemaps warms the sitemaps listed in the robots.txt of every host
+	// being warmed
+	Sitemaps bool `yaml:"sitemaps"`
+
+	// Respect skips crawled pages disallowed by robots.txt and spaces
+	// requests to a host by its Crawl-delay in crawl mode
+	Respect bool `yaml:"respect"`
+
+	// UserAgent is the robots.txt group followed (default: the product
+	// token of user_agent)
+	UserAgent string `yaml:"user_agent"`
+}
+
+// AssetsConfig contains configuration for full-page asset warming
+type AssetsConfig struct {
+	// Enabled determines if assets referenced by warmed pages are warmed
+	Enabled bool `yaml:"enabled"`
+
+	// Images warms only the image assets, every srcset width and format
+	// included, when the other assets aren't enabled
+	Images bool `yaml:"images"`
+
+	// Preload warms the resources of the Link: rel=preload and rel=prefetch
+	// headers of every warmed response
+	Preload bool `yaml:"preload"`
+
+	// Hosts are extra hosts (e.g. a CDN) assets may be served from, in
+	// addition to the host of the page
+	Hosts []string `yaml:"hosts"`
+}
+
+// SkipListConfig contains configuration for skipping persistently failing URLs
+type SkipListConfig struct {
+	// File persists the skip list across runs (empty = disabled)
+	File string `yaml:"file"`
+
+	// After is the number of consecutive failed cycles before a URL is skipped
+	After int `yaml:"after"`
+
+	// RetryAfter is how long a skipped URL is left alone before a re-check
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// ShardConfig contains configuration for splitting the URLs between instances
+type ShardConfig struct {
+	// Index is the shard this instance warms, from 0 to Count-1
+	Index int `yaml:"index"`
+
+	// Count is the number of instances sharing the URLs (0 = not sharded)
+	Count int `yaml:"count"`
+}
+
+// CheckpointConfig contains configuration for resuming interrupted cycles
+type CheckpointConfig struct {
+	// File persists the progress of the running cycle (empty = disabled)
+	File string `yaml:"file"`
+
+	// Interval is how often the progress is written during the cycle
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RetryBudgetConfig contains configuration for the run-level retry budget
+type RetryBudgetConfig struct {
+	// Ratio is the most retries allowed per request started in the cycle,
+	// e.g. 0.1 for 10% (0 = no budget)
+	Ratio float64 `yaml:"ratio"`
+
+	// MinRetries are allowed on top of the ratio, so a cycle can retry its
+	// first few failures
+	MinRetries int `yaml:"min_retries"`
+}
+
+// ConditionalConfig contains configuration for conditional requests
+type ConditionalConfig struct {
+	// Enabled sends If-None-Match and If-Modified-Since from the ETag and
+	// Last-Modified of the URL's last full response
+	Enabled bool `yaml:"enabled"`
+
+	// File persists the validators across runs (empty = kept in memory)
+	File string `yaml:"file"`
+}
+
+// SamplingConfig contains configuration for warming a sample of each
+// cycle's URLs within a request budget
+type SamplingConfig struct {
+	// Mode chooses the URLs kept: the highest-priority ones, a random or a
+	// sitemap-priority-weighted sample
+	Mode string `yaml:"mode"`
+
+	// Percent keeps this share of the URLs, capped at max_urls (0 = all)
+	Percent float64 `yaml:"percent"`
+}
+
+// Sampling modes
+const (
+	// SampleTop keeps the highest-priority URLs, the first listed among
+	// equal priorities
+	SampleTop = "top"
+
+	// SampleRandom keeps a new uniform random sample every cycle
+	SampleRandom = "random"
+
+	// SampleWeighted keeps a new random sample every cycle, favoring URLs
+	// with a higher sitemap <priority>
+	SampleWeighted = "weighted"
+)
+
+// URL ordering strategies
+const (
+	// OrderListed dispatches URLs in the order they are listed
+	OrderListed = "listed"
+
+	// OrderShuffled dispatches URLs in a new random order every cycle
+	OrderShuffled = "shuffled"
+
+	// OrderSitemapPriority dispatches URLs by descending sitemap <priority>
+	OrderSitemapPriority = "sitemap-priority"
+
+	// OrderSlowestFirst dispatches historically slow or frequently-missing
+	// URLs first so they get the most retry headroom
+	OrderSlowestFirst = "slowest-first"
+)
+
+// Warm-on-start modes for continuous operation
+const (
+	// WarmOnStartAll warms every URL immediately at start
+	WarmOnStartAll = "all"
+
+	// WarmOnStartCritical warms only critical URLs at start and leaves the
+	// rest to the regular schedule
+	WarmOnStartCritical = "critical"
+
+	// WarmOnStartNone waits for the first scheduled cycle
+	WarmOnStartNone = "none"
+)
+
+// CDNs the deploy webhooks purge before warming
+const (
+	// PurgeFastly purges every object of the Fastly service
+	PurgeFastly = "fastly"
+
+	// PurgeCloudFront invalidates the configured CloudFront paths
+	PurgeCloudFront = "cloudfront"
+
+	// PurgeNone only warms
+	PurgeNone = "none"
+)
+
+// Overlap policies for ticks that arrive while a cycle is still running
+const (
+	// OverlapSkip drops the tick
+	OverlapSkip = "skip"
+
+	// OverlapQueue runs one cycle as soon as the running one finishes
+	OverlapQueue = "queue"
+
+	// OverlapConcurrent starts another cycle alongside, up to
+	// MaxConcurrentCycles
+	OverlapConcurrent = "concurrent"
+
+	// OverlapCancel stops the running cycle and starts the new one as soon
+	// as it has returned
+	OverlapCancel = "cancel"
+)
+
+// DNSConfig contains configuration for resolving origin hosts
+type DNSConfig struct {
+	// Servers are the name servers to query, as host or host:port (default
+	// port 53), in place of the system resolver
+	Servers []string `yaml:"servers"`
+
+	// Hosts maps hostnames to the IP address they resolve to, like
+	// /etc/hosts
+	Hosts map[string]string `yaml:"hosts"`
+}
+
+// configured reports whether any DNS option is set
+func (d *DNSConfig) configured() bool {
+	return len(d.Servers) > 0 || len(d.Hosts) > 0
+}
+
+// ProxyPoolConfig contains configuration for rotating over forward proxies
+type ProxyPoolConfig struct {
+	// Proxies are the http, https or socks5 proxy URLs to rotate over
+	Proxies []string `yaml:"proxies"`
+
+	// Rotation is round-robin or per-host
+	Rotation string `yaml:"rotation"`
+}
+
+// Proxy pool rotations
+const (
+	// ProxyRotationRoundRobin sends each request through the next proxy
+	ProxyRotationRoundRobin = "round-robin"
+
+	// ProxyRotationPerHost pins each host to one proxy
+	ProxyRotationPerHost = "per-host"
+)
+
+// HTTP/2 modes for connections to origins
+const (
+	// HTTP2Auto negotiates HTTP/2 over TLS and falls back to HTTP/1.1
+	HTTP2Auto = "auto"
+
+	// HTTP2Force requires HTTP/2 over TLS; plaintext requests still use
+	// HTTP/1.1
+	HTTP2Force = "force"
+
+	// HTTP2Disable uses HTTP/1.1 only
+	HTTP2Disable = "disable"
+
+	// HTTP2H2C speaks HTTP/2 over plaintext connections with prior
+	// knowledge, and requires it over TLS
+	HTTP2H2C = "h2c"
+)
+
+// ConnectionPoolConfig contains the transport's connection reuse settings;
+// zero values keep the defaults
+type ConnectionPoolConfig struct {
+	// MaxIdleConns caps idle connections across all hosts (default: 100)
+	MaxIdleConns int `yaml:"max_idle_conns"`
+
+	// MaxIdleConnsPerHost caps idle connections kept per host (default: the
+	// number of workers, so each can reuse one)
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host"`
+
+	// MaxConnsPerHost caps connections per host, including those in use
+	// (default: unlimited)
+	MaxConnsPerHost int `yaml:"max_conns_per_host"`
+
+	// IdleConnTimeout is how long an idle connection is kept (default: 90s)
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+
+	// DisableKeepAlives opens a new connection for every request
+	DisableKeepAlives bool `yaml:"disable_keep_alives"`
+}
+
+// configured reports whether any connection pool setting is set
+func (p *ConnectionPoolConfig) configured() bool {
+	return *p != ConnectionPoolConfig{}
+}
+
+// Address families connections to origins may use
+const (
+	// IPFamilyDual dials IPv6 and IPv4 addresses, using the first to connect
+	IPFamilyDual = "dual"
+
+	// IPFamilyIPv4 connects over IPv4 only
+	IPFamilyIPv4 = "ipv4"
+
+	// IPFamilyIPv6 connects over IPv6 only
+	IPFamilyIPv6 = "ipv6"
+)
+
+// ByteRangeConfig selects the byte ranges a group of large files is warmed
+// by, as a media player or download manager would request them
+type ByteRangeConfig struct {
+	// Match selects the URLs, with the same patterns as -only; empty
+	// matches every URL
+	Match string `yaml:"match"`
+
+	// Ranges are byte ranges such as "0-1048575", each warmed with its own
+	// Range request
+	Ranges []string `yaml:"ranges"`
+
+	// Chunks adds that many consecutive ranges of ChunkSize bytes from the
+	// start of the file
+	Chunks int `yaml:"chunks"`
+
+	// ChunkSize is the size of each chunk in bytes
+	ChunkSize int64 `yaml:"chunk_size"`
+}
+
+// DeviceConfig describes a device profile URLs are warmed as
+type DeviceConfig struct {
+	// Name identifies the device in logs and reports; desktop, mobile,
+	// tablet and bot have a built-in User-Agent
+	Name string `yaml:"name"`
+
+	// UserAgent replaces the global user_agent for this device
+	UserAgent string `yaml:"user_agent"`
+
+	// Headers are set on the device's requests, e.g. a device-detection
+	// header the cache varies on
+	Headers map[string]string `yaml:"headers"`
+}
+
+// RegionConfig describes one egress region used for multi-region warming
+type RegionConfig struct {
+	// Name identifies the region in logs and reports
+	Name string `yaml:"name"`
+
+	// Proxy is the forward proxy requests egress through (empty = direct)
+	Proxy string `yaml:"proxy"`
+
+	// Resolve maps hostnames to IP addresses to connect to in this region,
+	// with the same host:port and IP:port forms as ConnectTo, over ConnectTo
+	Resolve map[string]string `yaml:"resolve"`
+
+	// Address is an IP or IP:port every host without a Resolve entry is
+	// connected to, such as one edge POP of the CDN
+	Address string `yaml:"address"`
+
+	// IPFamily overrides the global ip_family for this region, e.g. to warm
+	// the IPv6 path of dual-stack edges in a region of its own
+	IPFamily string `yaml:"ip_family"`
+}
+
+// ShieldConfig contains configuration for warming through a CDN origin
+// shield before the edge
+type ShieldConfig struct {
+	// Enabled warms every URL through the shield before the edge regions
+	Enabled bool `yaml:"enabled"`
+
+	// RegionConfig is how the shield is reached: a proxy and/or resolver
+	// overrides pointing at the shield endpoint
+	RegionConfig `yaml:",inline"`
+
+	// VerifyAttempts is how often URLs that were not a HIT at the shield
+	// are re-requested to confirm it cached them
+	VerifyAttempts int `yaml:"verify_attempts"`
+
+	// VerifyDelay is the pause before each verification
+	VerifyDelay time.Duration `yaml:"verify_delay"`
+}
+
+// ShadowConfig contains configuration for mirroring warm requests to a
+// shadow host, such as the next release's canary stack
+type ShadowConfig struct {
+	// BaseURL replaces the scheme and host of mirrored URLs, and prefixes
+	// their path with its own (enables mirroring)
+	BaseURL string `yaml:"base_url"`
+
+	// PreserveHost sends mirrored requests with the primary URL's Host
+	// header, for stacks reached by address or internal name
+	PreserveHost bool `yaml:"preserve_host"`
+
+	// CompareContent also compares a SHA-256 hash of the bodies
+	CompareContent bool `yaml:"compare_content"`
+}
+
+// WavesConfig contains configuration for warming a cycle in waves, such as
+// a canary wave before the rest
+type WavesConfig struct {
+	// Sizes are the waves warmed before the remaining URLs, each a
+	// percentage of the cycle's URLs ("10%") or a URL count ("500")
+	Sizes []string `yaml:"sizes"`
+
+	// MinSuccessRate is the percentage of a wave's requests that must
+	// succeed for the next wave to start (0 = not checked)
+	MinSuccessRate float64 `yaml:"min_success_rate"`
+
+	// MaxLatency is the highest 95th percentile request time of a wave for
+	// the next wave to start (0 = not checked)
+	MaxLatency time.Duration `yaml:"max_latency"`
+}
+
+// TTLReportConfig contains configuration for the TTL inventory report
+type TTLReportConfig struct {
+	// Enabled determines if effective TTLs are reported after each cycle
+	Enabled bool `yaml:"enabled"`
+
+	// PrefixDepth is how many path segments TTLs are grouped by
+	PrefixDepth int `yaml:"prefix_depth"`
+
+	// MinTTL flags URLs with a shorter TTL (default: the -interval)
+	MinTTL time.Duration `yaml:"min_ttl"`
+}
+
+// TTLScheduleConfig contains configuration for re-warming URLs by their TTL
+type TTLScheduleConfig struct {
+	// Enabled leaves a URL out of cycles until the last one before its
+	// effective TTL runs out
+	Enabled bool `yaml:"enabled"`
+
+	// Lead is how long before expiry a URL is due again
+	Lead time.Duration `yaml:"lead"`
+
+	// MaxInterval caps how long a URL with a long TTL is left alone
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+// ArtifactsConfig contains configuration for per-cycle run artifacts (the
+// run report, events file and failure list)
+type ArtifactsConfig struct {
+	// Dir is a local directory artifacts are written to, one subdirectory per run
+	Dir string `yaml:"dir"`
+
+	// Upload is an object storage destination: s3://bucket/prefix,
+	// gs://bucket/prefix or azblob://account/container/prefix
+	Upload string `yaml:"upload"`
+
+	// Endpoint overrides the S3 endpoint for S3-compatible stores (MinIO, R2)
+	Endpoint string `yaml:"endpoint"`
+
+	// Region is the S3 bucket region (default: AWS_REGION)
+	Region string `yaml:"region"`
+}
+
+// MetricsConfig contains configuration for metrics collection
+type MetricsConfig struct {
+	// Enabled determines if metrics collection is enabled
+	Enabled bool `yaml:"enabled"`
+
+	// Port is the port to expose metrics on
+	Port int `yaml:"port"`
+
+	// Path is the path to expose metrics on
+	Path string `yaml:"path"`
+}
+
+// StatsDConfig contains configuration for pushing metrics to a StatsD or
+// DogStatsD agent
+type StatsDConfig struct {
+	// Address is the host:port of the agent (empty = disabled)
+	Address string `yaml:"address"`
+
+	// Prefix is prepended to every metric name
+	Prefix string `yaml:"prefix"`
+
+	// DogStatsD adds tags in the DogStatsD format; plain StatsD has none
+	DogStatsD bool `yaml:"dogstatsd"`
+
+	// Tags are added to every metric (DogStatsD only)
+	Tags map[string]string `yaml:"tags"`
+
+	// FlushInterval is how often batched metrics are sent
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// FleetConfig contains configuration for merging the cycle summaries of
+// several warmer instances into one fleet-wide report
+type FleetConfig struct {
+	// Push is the aggregator endpoint each cycle's summary is sent to
+	Push string `yaml:"push"`
+
+	// Instance identifies this instance in the fleet report
+	Instance string `yaml:"instance"`
+
+	// Token is the shared secret sent to, and required by, the aggregator
+	Token string `yaml:"token"`
+
+	// Aggregate serves the fleet endpoint on the metrics server, accepting
+	// pushed summaries and reporting the merged view
+	Aggregate bool `yaml:"aggregate"`
+
+	// StaleAfter leaves instances out of the fleet totals once their last
+	// summary is older than this
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// ProbeEndpointConfig contains configuration for the blackbox probe endpoint
+type ProbeEndpointConfig struct {
+	// Enabled serves the probe endpoint on the metrics server
+	Enabled bool `yaml:"enabled"`
+
+	// Hosts are the hosts probe targets may be on (default: the hosts of
+	// the configured URLs), so the endpoint can't send credentials elsewhere
+	Hosts []string `yaml:"hosts"`
+}
+
+// AdminConfig contains configuration for the admin API
+type AdminConfig struct {
+	// Enabled serves the admin endpoints on the metrics server
+	Enabled bool `yaml:"enabled"`
+
+	// Token, if set, must be sent as "Authorization: Bearer <token>"
+	Token string `yaml:"token"`
+}
+
+// GRPCConfig contains configuration for the gRPC control and status API
+type GRPCConfig struct {
+	// Enabled determines if the gRPC server is started
+	Enabled bool `yaml:"enabled"`
+
+	// Port is the port to serve gRPC on, in cleartext HTTP/2
+	Port int `yaml:"port"`
+
+	// Token, if set, must be sent as "authorization: Bearer <token>"
+	// metadata with every call
+	Token string `yaml:"token"`
+}
+
+// WebhookConfig contains configuration for the inbound webhook endpoint
+type WebhookConfig struct {
+	// Enabled determines if the webhook server is started
+	Enabled bool `yaml:"enabled"`
+
+	// Port is the port to listen for webhooks on
+	Port int `yaml:"port"`
+
+	// Path is the base path under which webhook handlers are mounted
+	Path string `yaml:"path"`
+
+	// Secret, if set, must be sent in the X-Webhook-Secret header or the
+	// "secret" query parameter
+	Secret string `yaml:"secret"`
+
+	// CMS maps CMS publish events to the URLs they affect
+	CMS CMSConfig `yaml:"cms"`
+
+	// Fastly purges surrogate keys and warms the URLs mapped to them
+	Fastly FastlyConfig `yaml:"fastly"`
+
+	// Deploy purges and warms after GitHub and GitLab deployment and
+	// release webhooks
+	Deploy DeployConfig `yaml:"deploy"`
+}
+
+// DeployConfig describes the deploy webhooks that trigger a purge and a
+// full cycle
+type DeployConfig struct {
+	// GitHubSecret verifies the signature of GitHub webhooks; setting it
+	// enables the github handler
+	GitHubSecret string `yaml:"github_secret"`
+
+	// GitLabToken must match the token of GitLab webhooks; setting it
+	// enables the gitlab handler
+	GitLabToken string `yaml:"gitlab_token"`
+
+	// Environments restricts deployment events to these environments
+	// (default: every environment)
+	Environments []string `yaml:"environments"`
+
+	// Purge is the CDN purged before the cycle: fastly purges the whole
+	// service, cloudfront invalidates the cloudfront paths (default: none)
+	Purge string `yaml:"purge"`
+}
+
+// CloudFrontConfig describes the invalidations the invalidate subcommand
+// creates before warming
+type CloudFrontConfig struct {
+	// DistributionID is the distribution invalidations are created for
+	DistributionID string `yaml:"distribution_id"`
+
+	// Paths are invalidated when none are given on the command line; a
+	// trailing * matches any suffix, as in CloudFront
+	Paths []string `yaml:"paths"`
+
+	// BaseURL is the site the paths are warmed on. Without one, configured
+	// URLs on any host whose path matches are warmed.
+	BaseURL string `yaml:"base_url"`
+
+	// PollInterval is how often the invalidation's status is checked
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// Timeout bounds the wait for the invalidation to complete
+	Timeout time.Duration `yaml:"timeout"`
+
+	// Endpoint overrides the CloudFront API endpoint
+	Endpoint string `yaml:"endpoint"`
+}
+
+// FastlyConfig describes surrogate-key purges sent to Fastly
+type FastlyConfig struct {
+	// ServiceID is the Fastly service keys are purged from (empty = disabled)
+	ServiceID string `yaml:"service_id"`
+
+	// APIToken authenticates purges; it needs the purge_select scope
+	APIToken string `yaml:"api_token"`
+
+	// APITokenEnv reads the API token from this environment variable
+	// instead, keeping it out of the config file
+	APITokenEnv string `yaml:"api_token_env"`
+
+	// SoftPurge marks purged objects stale instead of removing them
+	SoftPurge bool `yaml:"soft_purge"`
+
+	// Delay is how long to wait after a purge before warming, for it to
+	// reach every POP
+	Delay time.Duration `yaml:"delay"`
+
+	// Keys maps each surrogate key to the URLs warmed after it is purged
+	Keys map[string][]string `yaml:"keys"`
+
+	// APIURL is the base URL of the Fastly API
+	APIURL string `yaml:"api_url"`
+}
+
+// CMSConfig describes how CMS content maps to site URLs
+type CMSConfig struct {
+	// BaseURL is prepended to generated paths (e.g. "https://example.com")
+	BaseURL string `yaml:"base_url"`
+
+	// ContentTypes maps a CMS content type (post, page, blogPost...) to its URLs
+	ContentTypes map[string]CMSContentType `yaml:"content_types"`
+}
+
+// CMSContentType describes the URLs affected by publishing one content type
+type CMSContentType struct {
+	// Path is the permalink template, e.g. "/blog/{slug}"
+	Path string `yaml:"path"`
+
+	// Listings are listing pages that show this content type, e.g. "/blog"
+	Listings []string `yaml:"listings"`
+
+	// Pages is the number of paginated pages to warm for each listing
+	Pages int `yaml:"pages"`
+
+	// PagePattern builds paginated listing URLs (default "{listing}/page/{n}")
+	PagePattern string `yaml:"page_pattern"`
+}
+
+// DefaultConfig returns a configuration with sensible defaults
+func DefaultConfig() *Config {
+	return &Config{
+		URLs:            []URLEntry{},
+		Workers:         10,
+		Timeout:         30 * time.Second,
+		RetryCount:      3,
+		RetryDelay:      1 * time.Second,
+		UserAgent:       "Cache-Warmer/1.0",
+		Headers:         make(map[string]string),
+		Method:          http.MethodGet,
+		HTTP2:           HTTP2Auto,
+		IPFamily:        IPFamilyDual,
+		FollowRedirects: true,
+		MaxRedirects:    5,
+		SuccessCodes:    []int{200, 201, 202, 204, 301, 302, 304},
+		Order:           OrderListed,
+		Sampling:        SamplingConfig{Mode: SampleTop},
+		WarmOnStart:     WarmOnStartAll,
+		Backfill:        WarmOnStartAll,
+		OverlapPolicy:   OverlapQueue,
+
+		MaxConcurrentCycles: 2,
+
+		LeaderElection: LeaderElectionConfig{
+			Key:      "cache-warmer:leader",
+			Identity: defaultInstanceName(),
+			TTL:      15 * time.Second,
+		},
+
+		ProxyPool: ProxyPoolConfig{
+			Rotation: ProxyRotationRoundRobin,
+		},
+		AccessLog: AccessLogConfig{
+			Top: 100,
+		},
+		S3Logs: S3LogsConfig{
+			Format: S3LogFormatALB,
+			Window: 24 * time.Hour,
+			Top:    100,
+		},
+		GoogleAnalytics: GoogleAnalyticsConfig{
+			Days: 7,
+			Top:  100,
+		},
+		RedisQueue: RedisQueueConfig{
+			Group:      "cache-warmer",
+			Consumer:   defaultInstanceName(),
+			Field:      "url",
+			BatchSize:  100,
+			ClaimAfter: 5 * time.Minute,
+		},
+		Crawl: CrawlConfig{
+			Enabled:  false,
+			MaxDepth: 2,
+			MaxPages: 500,
+		},
+		Shield: ShieldConfig{
+			RegionConfig:   RegionConfig{Name: "shield"},
+			VerifyAttempts: 1,
+			VerifyDelay:    1 * time.Second,
+		},
+		TTLReport: TTLReportConfig{
+			PrefixDepth: 1,
+		},
+		RetryBudget: RetryBudgetConfig{
+			MinRetries: 10,
+		},
+		TTLSchedule: TTLScheduleConfig{
+			Lead:        30 * time.Second,
+			MaxInterval: 24 * time.Hour,
+		},
+		GraphQL: GraphQLConfig{
+			Method: GraphQLMethodPost,
+		},
+		SkipList: SkipListConfig{
+			After:      3,
+			RetryAfter: 24 * time.Hour,
+		},
+		Checkpoint: CheckpointConfig{
+			Interval: 10 * time.Second,
+		},
+		Metrics: MetricsConfig{
+			Enabled: false,
+			Port:    8080,
+			Path:    "/metrics",
+		},
+		StatsD: StatsDConfig{
+			Prefix:        "cache_warmer.",
+			FlushInterval: 1 * time.Second,
+		},
+		Webhook: WebhookConfig{
+			Enabled: false,
+			Port:    8081,
+			Path:    "/webhooks",
+			Fastly: FastlyConfig{
+				Delay:  2 * time.Second,
+				APIURL: "https://api.fastly.com",
+			},
+		},
+		GRPC: GRPCConfig{
+			Enabled: false,
+			Port:    9090,
+		},
+		CloudFront: CloudFrontConfig{
+			PollInterval: 15 * time.Second,
+			Timeout:      15 * time.Minute,
+		},
+		Fleet: FleetConfig{
+			Instance:   defaultInstanceName(),
+			StaleAfter: time.Hour,
+		},
+		RateLimitBudget: RateLimitBudgetConfig{
+			Enabled:       false,
+			Reserve:       10,
+			SlowdownBelow: 100,
+			MaxPause:      5 * time.Minute,
+		},
+	}
+}
+
+// LoadConfig loads configuration from file and applies command line overrides
+func LoadConfig(configFile, urlsOverride string, workersOverride int, timeoutOverride time.Duration) (*Config, error) {
+	// Start with default configuration
+	config := DefaultConfig()
+
+	// Load from file if it exists
+	if configFile != "" {
+		if err := config.LoadFromFile(configFile); err != nil {
+			// If config file is explicitly specified but doesn't exist, return error
+			if configFile != "config.yaml" {
+				return nil, fmt.Errorf("failed to load config file %s: %v", configFile, err)
+			}
+			// If using default config file name and it doesn't exist, that's OK
+			// We'll just use defaults
+		}
+	}
+
+	// Apply command line overrides
+	if urlsOverride != "" {
+		config.OverrideURLs(strings.Split(urlsOverride, ","))
+	}
+
+	if workersOverride > 0 {
+		config.Workers = workersOverride
+	}
+
+	if timeoutOverride > 0 {
+		config.Timeout = timeoutOverride
+	}
+
+	return config, nil
+}
+
+// LoadFromFile loads configuration from a YAML file
+func (c *Config) LoadFromFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	// Create a temporary config to unmarshal into
+	var fileConfig Config
+	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	// Merge file config with current config (file config takes precedence)
+	if len(fileConfig.URLs) > 0 {
+		c.URLs = fileConfig.URLs
+	}
+	c.Templates = fileConfig.Templates
+	c.RemoteList = fileConfig.RemoteList
+	if fileConfig.Sitemap != "" {
+		c.Sitemap = fileConfig.Sitemap
+	}
+	c.OpenAPI = fileConfig.OpenAPI
+	c.GraphQL.Endpoint = fileConfig.GraphQL.Endpoint
+	if fileConfig.GraphQL.Method != "" {
+		c.GraphQL.Method = strings.ToUpper(fileConfig.GraphQL.Method)
+	}
+	c.GraphQL.Persisted = fileConfig.GraphQL.Persisted
+	c.GraphQL.Queries = fileConfig.GraphQL.Queries
+	if fileConfig.Workers > 0 {
+		c.Workers = fileConfig.Workers
+	}
+	if fileConfig.Interval > 0 {
+		c.Interval = fileConfig.Interval
+	}
+	if fileConfig.Jitter > 0 {
+		c.Jitter = fileConfig.Jitter
+	}
+	c.Stagger = fileConfig.Stagger
+	if fileConfig.Timeout > 0 {
+		c.Timeout = fileConfig.Timeout
+	}
+	if fileConfig.RequestTimeout > 0 {
+		c.RequestTimeout = fileConfig.RequestTimeout
+	}
+	if fileConfig.URLBudget > 0 {
+		c.URLBudget = fileConfig.URLBudget
+	}
+	if fileConfig.CycleTimeout > 0 {
+		c.CycleTimeout = fileConfig.CycleTimeout
+	}
+	if fileConfig.RetryCount > 0 {
+		c.RetryCount = fileConfig.RetryCount
+	}
+	if fileConfig.RetryDelay > 0 {
+		c.RetryDelay = fileConfig.RetryDelay
+	}
+	if fileConfig.UserAgent != "" {
+		c.UserAgent = fileConfig.UserAgent
+	}
+	if len(fileConfig.RetryPolicy) > 0 {
+		c.RetryPolicy = fileConfig.RetryPolicy
+	}
+	if fileConfig.RetryBudget.Ratio > 0 {
+		c.RetryBudget.Ratio = fileConfig.RetryBudget.Ratio
+	}
+	if fileConfig.RetryBudget.MinRetries > 0 {
+		c.RetryBudget.MinRetries = fileConfig.RetryBudget.MinRetries
+	}
+	if len(fileConfig.Headers) > 0 {
+		c.Headers = fileConfig.Headers
+	}
+	if fileConfig.Method != "" {
+		c.Method = strings.ToUpper(fileConfig.Method)
+	}
+	if fileConfig.MaxRedirects > 0 {
+		c.MaxRedirects = fileConfig.MaxRedirects
+	}
+	if len(fileConfig.SuccessCodes) > 0 {
+		c.SuccessCodes = fileConfig.SuccessCodes
+	}
+	c.SuccessRules = fileConfig.SuccessRules
+	c.LocaleChecks = fileConfig.LocaleChecks
+	if fileConfig.UnixSocket != "" {
+		c.UnixSocket = fileConfig.UnixSocket
+	}
+	if len(fileConfig.ConnectTo) > 0 {
+		c.ConnectTo = fileConfig.ConnectTo
+	}
+	c.DNS = fileConfig.DNS
+	c.TLS = fileConfig.TLS
+	if fileConfig.HTTP2 != "" {
+		c.HTTP2 = strings.ToLower(fileConfig.HTTP2)
+	}
+	if fileConfig.IPFamily != "" {
+		c.IPFamily = strings.ToLower(fileConfig.IPFamily)
+	}
+	c.ConnectionPool = fileConfig.ConnectionPool
+	if fileConfig.ProxyURL != "" {
+		c.ProxyURL = fileConfig.ProxyURL
+	}
+	if len(fileConfig.ProxyPool.Proxies) > 0 {
+		c.ProxyPool.Proxies = fileConfig.ProxyPool.Proxies
+	}
+	if fileConfig.ProxyPool.Rotation != "" {
+		c.ProxyPool.Rotation = fileConfig.ProxyPool.Rotation
+	}
+
+	if fileConfig.Order != "" {
+		c.Order = fileConfig.Order
+	}
+	c.MaxURLs = fileConfig.MaxURLs
+	if fileConfig.Sampling.Mode != "" {
+		c.Sampling.Mode = fileConfig.Sampling.Mode
+	}
+	c.Sampling.Percent = fileConfig.Sampling.Percent
+	if fileConfig.WarmOnStart != "" {
+		c.WarmOnStart = fileConfig.WarmOnStart
+	}
+	if fileConfig.StateFile != "" {
+		c.StateFile = fileConfig.StateFile
+	}
+	if fileConfig.Backfill != "" {
+		c.Backfill = fileConfig.Backfill
+	}
+	if fileConfig.OverlapPolicy != "" {
+		c.OverlapPolicy = fileConfig.OverlapPolicy
+	}
+	if fileConfig.MaxConcurrentCycles > 0 {
+		c.MaxConcurrentCycles = fileConfig.MaxConcurrentCycles
+	}
+
+	// Merge leader election config
+	c.LeaderElection.Redis = fileConfig.LeaderElection.Redis
+	if fileConfig.LeaderElection.Key != "" {
+		c.LeaderElection.Key = fileConfig.LeaderElection.Key
+	}
+	if fileConfig.LeaderElection.Identity != "" {
+		c.LeaderElection.Identity = fileConfig.LeaderElection.Identity
+	}
+	if fileConfig.LeaderElection.TTL > 0 {
+		c.LeaderElection.TTL = fileConfig.LeaderElection.TTL
+	}
+	c.Tiers = fileConfig.Tiers
+	c.Groups = fileConfig.Groups
+	if fileConfig.HistoryFile != "" {
+		c.HistoryFile = fileConfig.HistoryFile
+	}
+	if fileConfig.FailuresFile != "" {
+		c.FailuresFile = fileConfig.FailuresFile
+	}
+	if fileConfig.ResultsFile != "" {
+		c.ResultsFile = fileConfig.ResultsFile
+	}
+	c.SlowThreshold = fileConfig.SlowThreshold
+	c.FailThreshold = fileConfig.FailThreshold
+	c.FailPriority = fileConfig.FailPriority
+	// Merge access log config
+	if len(fileConfig.AccessLog.Files) > 0 {
+		c.AccessLog.Files = fileConfig.AccessLog.Files
+	}
+	if fileConfig.AccessLog.BaseURL != "" {
+		c.AccessLog.BaseURL = fileConfig.AccessLog.BaseURL
+	}
+	if fileConfig.AccessLog.Top > 0 {
+		c.AccessLog.Top = fileConfig.AccessLog.Top
+	}
+
+	// Merge S3 log config
+	if fileConfig.S3Logs.Source != "" {
+		c.S3Logs.Source = fileConfig.S3Logs.Source
+	}
+	if fileConfig.S3Logs.Format != "" {
+		c.S3Logs.Format = fileConfig.S3Logs.Format
+	}
+	if fileConfig.S3Logs.Window > 0 {
+		c.S3Logs.Window = fileConfig.S3Logs.Window
+	}
+	if fileConfig.S3Logs.BaseURL != "" {
+		c.S3Logs.BaseURL = fileConfig.S3Logs.BaseURL
+	}
+	if fileConfig.S3Logs.Top > 0 {
+		c.S3Logs.Top = fileConfig.S3Logs.Top
+	}
+	c.S3Logs.Endpoint = fileConfig.S3Logs.Endpoint
+	c.S3Logs.Region = fileConfig.S3Logs.Region
+
+	// Merge Google Analytics config
+	if fileConfig.GoogleAnalytics.PropertyID != "" {
+		c.GoogleAnalytics.PropertyID = fileConfig.GoogleAnalytics.PropertyID
+	}
+	if fileConfig.GoogleAnalytics.Days > 0 {
+		c.GoogleAnalytics.Days = fileConfig.GoogleAnalytics.Days
+	}
+	if fileConfig.GoogleAnalytics.Top > 0 {
+		c.GoogleAnalytics.Top = fileConfig.GoogleAnalytics.Top
+	}
+	if fileConfig.GoogleAnalytics.BaseURL != "" {
+		c.GoogleAnalytics.BaseURL = fileConfig.GoogleAnalytics.BaseURL
+	}
+	c.GoogleAnalytics.Hostname = fileConfig.GoogleAnalytics.Hostname
+	c.GoogleAnalytics.IncludeQuery = fileConfig.GoogleAnalytics.IncludeQuery
+
+	// Merge Redis queue config
+	c.RedisQueue.URL = fileConfig.RedisQueue.URL
+	c.RedisQueue.List = fileConfig.RedisQueue.List
+	c.RedisQueue.Stream = fileConfig.RedisQueue.Stream
+	if fileConfig.RedisQueue.Group != "" {
+		c.RedisQueue.Group = fileConfig.RedisQueue.Group
+	}
+	if fileConfig.RedisQueue.Consumer != "" {
+		c.RedisQueue.Consumer = fileConfig.RedisQueue.Consumer
+	}
+	if fileConfig.RedisQueue.Field != "" {
+		c.RedisQueue.Field = fileConfig.RedisQueue.Field
+	}
+	if fileConfig.RedisQueue.BatchSize > 0 {
+		c.RedisQueue.BatchSize = fileConfig.RedisQueue.BatchSize
+	}
+	c.RedisQueue.Coordinator = fileConfig.RedisQueue.Coordinator
+	if fileConfig.RedisQueue.ClaimAfter > 0 {
+		c.RedisQueue.ClaimAfter = fileConfig.RedisQueue.ClaimAfter
+	}
+
+	if fileConfig.Crawl.MaxDepth > 0 {
+		c.Crawl.MaxDepth = fileConfig.Crawl.MaxDepth
+	}
+	if fileConfig.Crawl.MaxPages > 0 {
+		c.Crawl.MaxPages = fileConfig.Crawl.MaxPages
+	}
+	c.Crawl.Enabled = fileConfig.Crawl.Enabled
+	c.Robots = fileConfig.Robots
+	c.Assets = fileConfig.Assets
+
+	if fileConfig.SkipList.File != "" {
+		c.SkipList.File = fileConfig.SkipList.File
+	}
+	if fileConfig.SkipList.After > 0 {
+		c.SkipList.After = fileConfig.SkipList.After
+	}
+	if fileConfig.SkipList.RetryAfter > 0 {
+		c.SkipList.RetryAfter = fileConfig.SkipList.RetryAfter
+	}
+
+	// Merge checkpoint config
+	if fileConfig.Checkpoint.File != "" {
+		c.Checkpoint.File = fileConfig.Checkpoint.File
+	}
+	if fileConfig.Checkpoint.Interval > 0 {
+		c.Checkpoint.Interval = fileConfig.Checkpoint.Interval
+	}
+	c.Conditional = fileConfig.Conditional
+	if len(fileConfig.Regions) > 0 {
+		c.Regions = fileConfig.Regions
+	}
+	if len(fileConfig.Devices) > 0 {
+		c.Devices = fileConfig.Devices
+	}
+	c.ByteRanges = fileConfig.ByteRanges
+	c.Artifacts = fileConfig.Artifacts
+
+	// Merge shield config
+	c.Shield.Enabled = fileConfig.Shield.Enabled
+	if fileConfig.Shield.Name != "" {
+		c.Shield.Name = fileConfig.Shield.Name
+	}
+	c.Shield.Proxy = fileConfig.Shield.Proxy
+	c.Shield.Resolve = fileConfig.Shield.Resolve
+	c.Shield.Address = fileConfig.Shield.Address
+	c.Shield.IPFamily = fileConfig.Shield.IPFamily
+	if fileConfig.Shield.VerifyAttempts > 0 {
+		c.Shield.VerifyAttempts = fileConfig.Shield.VerifyAttempts
+	}
+	if fileConfig.Shield.VerifyDelay > 0 {
+		c.Shield.VerifyDelay = fileConfig.Shield.VerifyDelay
+	}
+
+	c.Shadow = fileConfig.Shadow
+	c.Waves = fileConfig.Waves
+
+	// Merge TTL report config
+	c.TTLReport.Enabled = fileConfig.TTLReport.Enabled
+	if fileConfig.TTLReport.PrefixDepth > 0 {
+		c.TTLReport.PrefixDepth = fileConfig.TTLReport.PrefixDepth
+	}
+	c.TTLReport.MinTTL = fileConfig.TTLReport.MinTTL
+
+	// Merge TTL schedule config
+	c.TTLSchedule.Enabled = fileConfig.TTLSchedule.Enabled
+	if fileConfig.TTLSchedule.Lead > 0 {
+		c.TTLSchedule.Lead = fileConfig.TTLSchedule.Lead
+	}
+	if fileConfig.TTLSchedule.MaxInterval > 0 {
+		c.TTLSchedule.MaxInterval = fileConfig.TTLSchedule.MaxInterval
+	}
+
+	if len(fileConfig.CaptureHeaders) > 0 {
+		c.CaptureHeaders = fileConfig.CaptureHeaders
+	}
+
+	// Set boolean values (these can be explicitly false)
+	c.FollowRedirects = fileConfig.FollowRedirects
+
+	// Merge metrics config
+	if fileConfig.Metrics.Port > 0 {
+		c.Metrics.Port = fileConfig.Metrics.Port
+	}
+	if fileConfig.Metrics.Path != "" {
+		c.Metrics.Path = fileConfig.Metrics.Path
+	}
+	c.Metrics.Enabled = fileConfig.Metrics.Enabled
+
+	// Merge StatsD config
+	c.StatsD.Address = fileConfig.StatsD.Address
+	if fileConfig.StatsD.Prefix != "" {
+		c.StatsD.Prefix = fileConfig.StatsD.Prefix
+	}
+	c.StatsD.DogStatsD = fileConfig.StatsD.DogStatsD
+	c.StatsD.Tags = fileConfig.StatsD.Tags
+	if fileConfig.StatsD.FlushInterval > 0 {
+		c.StatsD.FlushInterval = fileConfig.StatsD.FlushInterval
+	}
+
+	// Merge webhook config
+	if fileConfig.Webhook.Port > 0 {
+		c.Webhook.Port = fileConfig.Webhook.Port
+	}
+	if fileConfig.Webhook.Path != "" {
+		c.Webhook.Path = fileConfig.Webhook.Path
+	}
+	c.Webhook.Enabled = fileConfig.Webhook.Enabled
+	c.Webhook.Secret = fileConfig.Webhook.Secret
+	c.Webhook.CMS = fileConfig.Webhook.CMS
+
+	// Merge Fastly purge config
+	c.Webhook.Fastly.ServiceID = fileConfig.Webhook.Fastly.ServiceID
+	c.Webhook.Fastly.APIToken = fileConfig.Webhook.Fastly.APIToken
+	c.Webhook.Fastly.APITokenEnv = fileConfig.Webhook.Fastly.APITokenEnv
+	c.Webhook.Fastly.SoftPurge = fileConfig.Webhook.Fastly.SoftPurge
+	c.Webhook.Fastly.Keys = fileConfig.Webhook.Fastly.Keys
+	if fileConfig.Webhook.Fastly.Delay > 0 {
+		c.Webhook.Fastly.Delay = fileConfig.Webhook.Fastly.Delay
+	}
+	if fileConfig.Webhook.Fastly.APIURL != "" {
+		c.Webhook.Fastly.APIURL = fileConfig.Webhook.Fastly.APIURL
+	}
+
+	// Merge deploy webhook config
+	c.Webhook.Deploy = fileConfig.Webhook.Deploy
+
+	// Merge gRPC config
+	c.GRPC.Enabled = fileConfig.GRPC.Enabled
+	if fileConfig.GRPC.Port > 0 {
+		c.GRPC.Port = fileConfig.GRPC.Port
+	}
+	c.GRPC.Token = fileConfig.GRPC.Token
+
+	// Merge CloudFront config
+	c.CloudFront.DistributionID = fileConfig.CloudFront.DistributionID
+	c.CloudFront.Paths = fileConfig.CloudFront.Paths
+	c.CloudFront.BaseURL = fileConfig.CloudFront.BaseURL
+	c.CloudFront.Endpoint = fileConfig.CloudFront.Endpoint
+	if fileConfig.CloudFront.PollInterval > 0 {
+		c.CloudFront.PollInterval = fileConfig.CloudFront.PollInterval
+	}
+	if fileConfig.CloudFront.Timeout > 0 {
+		c.CloudFront.Timeout = fileConfig.CloudFront.Timeout
+	}
+
+	// Merge fleet config
+	c.Fleet.Push = fileConfig.Fleet.Push
+	if fileConfig.Fleet.Instance != "" {
+		c.Fleet.Instance = fileConfig.Fleet.Instance
+	}
+	c.Fleet.Token = fileConfig.Fleet.Token
+	c.Fleet.Aggregate = fileConfig.Fleet.Aggregate
+	if fileConfig.Fleet.StaleAfter > 0 {
+		c.Fleet.StaleAfter = fileConfig.Fleet.StaleAfter
+	}
+
+	// Merge probe endpoint config
+	c.ProbeEndpoint = fileConfig.ProbeEndpoint
+	c.Admin = fileConfig.Admin
+
+	// Merge rate-limit budget config
+	if fileConfig.RateLimitBudget.Reserve > 0 {
+		c.RateLimitBudget.Reserve = fileConfig.RateLimitBudget.Reserve
+	}
+	if fileConfig.RateLimitBudget.SlowdownBelow > 0 {
+		c.RateLimitBudget.SlowdownBelow = fileConfig.RateLimitBudget.SlowdownBelow
+	}
+	if fileConfig.RateLimitBudget.MaxPause > 0 {
+		c.RateLimitBudget.MaxPause = fileConfig.RateLimitBudget.MaxPause
+	}
+	c.RateLimitBudget.Enabled = fileConfig.RateLimitBudget.Enabled
+
+	// Merge politeness config
+	c.Politeness = fileConfig.Politeness
+
+	// Merge all-addresses config
+	c.AllAddresses = fileConfig.AllAddresses
+
+	// Merge normalize config
+	c.Normalize = fileConfig.Normalize
+
+	// Merge coalescing config
+	c.Coalescing = fileConfig.Coalescing
+
+	// Merge variants config
+	c.Variants = fileConfig.Variants
+	c.Shard = fileConfig.Shard
+
+	// Merge cookie jar config
+	c.Cookies = fileConfig.Cookies
+
+	// Merge authentication config
+	c.Auth = fileConfig.Auth
+	if fileConfig.BasicAuth != nil {
+		c.BasicAuth = fileConfig.BasicAuth
+	}
+	if c.Auth.Type == AuthOAuth2 && c.Auth.Grant == "" {
+		c.Auth.Grant = OAuth2ClientCredentials
+	}
+
+	// Merge admission config
+	if fileConfig.Admission.MaxInFlight > 0 {
+		c.Admission.MaxInFlight = fileConfig.Admission.MaxInFlight
+	}
+	if fileConfig.Admission.MaxHeapMB > 0 {
+		c.Admission.MaxHeapMB = fileConfig.Admission.MaxHeapMB
+	}
+
+	// Merge rate limit config
+	c.RateLimit = fileConfig.RateLimit
+
+	return nil
+}
+
+// HasCycleURLs reports whether warming cycles have URLs to warm, from the
+// list or a source re-read every cycle
+func (c *Config) HasCycleURLs() bool {
+	return len(c.URLs) > 0 || len(c.Templates) > 0 || c.RemoteList.URL != "" || c.Sitemap != "" || c.OpenAPI.Spec != "" || c.GraphQL.Endpoint != "" ||
+		len(c.AccessLog.Files) > 0 || c.S3Logs.Source != "" || c.GoogleAnalytics.PropertyID != ""
+}
+
+// Validate checks if the configuration is valid
+func (c *Config) Validate() error {
+	// Check if we have at least one URL or a source to take them from
+	if !c.HasCycleURLs() && c.RedisQueue.URL == "" && len(c.Groups) == 0 {
+		return fmt.Errorf("at least one URL or a source to take them from (URL list, sitemap, OpenAPI spec, GraphQL queries, access logs, analytics, a Redis queue or a group) must be specified")
+	}
+
+	if c.RemoteList.URL != "" {
+		if err := ValidateURL(c.RemoteList.URL); err != nil {
+			return fmt.Errorf("invalid url_list: %v", err)
+		}
+	}
+
+	if c.Sitemap != "" {
+		if err := ValidateURL(c.Sitemap); err != nil {
+			return fmt.Errorf("invalid sitemap: %v", err)
+		}
+	}
+
+	if c.OpenAPI.Spec != "" {
+		if strings.Contains(c.OpenAPI.Spec, "://") {
+			if err := ValidateURL(c.OpenAPI.Spec); err != nil {
+				return fmt.Errorf("invalid openapi spec: %v", err)
+			}
+		}
+		if c.OpenAPI.Server != "" {
+			if err := ValidateURL(c.OpenAPI.Server); err != nil {
+				return fmt.Errorf("invalid openapi server: %v", err)
+			}
+		}
+	}
+
+	if c.GraphQL.Endpoint != "" {
+		if err := ValidateURL(c.GraphQL.Endpoint); err != nil {
+			return fmt.Errorf("invalid graphql endpoint: %v", err)
+		}
+		if strings.Contains(c.GraphQL.Endpoint, "#") {
+			return fmt.Errorf("graphql endpoint must not contain a fragment")
+		}
+		if c.GraphQL.Method != GraphQLMethodPost && c.GraphQL.Method != GraphQLMethodGet {
+			return fmt.Errorf("graphql method must be %s or %s, got %q", GraphQLMethodPost, GraphQLMethodGet, c.GraphQL.Method)
+		}
+		if len(c.GraphQL.Queries) == 0 {
+			return fmt.Errorf("graphql requires at least one query")
+		}
+		if _, err := loadGraphQLQueries(&c.GraphQL); err != nil {
+			return err
+		}
+	}
+
+	if len(c.AccessLog.Files) > 0 {
+		if err := ValidateURL(c.AccessLog.BaseURL); err != nil {
+			return fmt.Errorf("invalid access log base_url: %v", err)
+		}
+		if c.AccessLog.Top < 1 {
+			return fmt.Errorf("access log top must be at least 1, got %d", c.AccessLog.Top)
+		}
+	}
+
+	if c.S3Logs.Source != "" {
+		if u, err := url.Parse(c.S3Logs.Source); err != nil || u.Scheme != "s3" || u.Host == "" {
+			return fmt.Errorf("invalid S3 log source %q, expected s3://bucket/prefix", c.S3Logs.Source)
+		}
+		switch c.S3Logs.Format {
+		case S3LogFormatALB, S3LogFormatCloudFront:
+		default:
+			return fmt.Errorf("unknown S3 log format %q, expected %s or %s", c.S3Logs.Format, S3LogFormatALB, S3LogFormatCloudFront)
+		}
+		if c.S3Logs.Window <= 0 {
+			return fmt.Errorf("S3 log window must be positive, got %v", c.S3Logs.Window)
+		}
+		if err := ValidateURL(c.S3Logs.BaseURL); err != nil {
+			return fmt.Errorf("invalid S3 log base_url: %v", err)
+		}
+		if c.S3Logs.Top < 1 {
+			return fmt.Errorf("S3 log top must be at least 1, got %d", c.S3Logs.Top)
+		}
+	}
+
+	if c.GoogleAnalytics.PropertyID != "" {
+		if err := ValidateURL(c.GoogleAnalytics.BaseURL); err != nil {
+			return fmt.Errorf("invalid google_analytics base_url: %v", err)
+		}
+		if c.GoogleAnalytics.Days < 1 {
+			return fmt.Errorf("google_analytics days must be at least 1, got %d", c.GoogleAnalytics.Days)
+		}
+		if c.GoogleAnalytics.Top < 1 {
+			return fmt.Errorf("google_analytics top must be at least 1, got %d", c.GoogleAnalytics.Top)
+		}
+	}
+
+	if c.RedisQueue.URL != "" {
+		if u, err := url.Parse(c.RedisQueue.URL); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("invalid Redis queue url %q, expected redis://host:port", c.RedisQueue.URL)
+		}
+		if (c.RedisQueue.List == "") == (c.RedisQueue.Stream == "") {
+			return fmt.Errorf("Redis queue needs exactly one of list or stream")
+		}
+		if c.RedisQueue.BatchSize < 1 {
+			return fmt.Errorf("Redis queue batch_size must be at least 1, got %d", c.RedisQueue.BatchSize)
+		}
+		if c.RedisQueue.Coordinator && !c.HasCycleURLs() {
+			return fmt.Errorf("Redis queue coordinator needs URLs or a URL source to enqueue")
+		}
+	} else if c.RedisQueue.Coordinator {
+		return fmt.Errorf("Redis queue coordinator requires a url")
+	}
+
+	// Validate each URL
+	for i, entry := range c.URLs {
+		urlStr := entry.URL
+		if urlStr == "" {
+			return fmt.Errorf("URL at index %d is empty", i)
+		}
+
+		// Parse URL to check if it's valid
+		parsedURL, err := url.Parse(urlStr)
+		if err != nil {
+			return fmt.Errorf("invalid URL at index %d (%s): %v", i, urlStr, err)
+		}
+
+		// Check if scheme is http or https
+		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+			return fmt.Errorf("URL at index %d (%s) must use http or https scheme", i, urlStr)
+		}
+
+		// Check if host is present
+		if parsedURL.Host == "" {
+			return fmt.Errorf("URL at index %d (%s) must have a host", i, urlStr)
+		}
+	}
+
+	// Validate URL groups
+	groupNames := make(map[string]bool)
+	for i := range c.Groups {
+		g := &c.Groups[i]
+		if g.Name == "" {
+			return fmt.Errorf("group at index %d has no name", i)
+		}
+		if groupNames[g.Name] {
+			return fmt.Errorf("duplicate group name %q", g.Name)
+		}
+		groupNames[g.Name] = true
+		if err := g.validate(); err != nil {
+			return fmt.Errorf("group %s: %v", g.Name, err)
+		}
+		if g.Interval > 0 && c.Jitter >= g.Interval {
+			return fmt.Errorf("group %s: jitter (%v) must be shorter than the interval (%v)", g.Name, c.Jitter, g.Interval)
+		}
+	}
+	if len(c.Groups) > 0 && c.RedisQueue.Coordinator {
+		return fmt.Errorf("groups can't be used with a Redis queue coordinator")
+	}
+
+	// Validate URL templates with their first expansion
+	for i := range c.Templates {
+		urls, err := c.Templates[i].Expand()
+		if err != nil {
+			return err
+		}
+		if err := ValidateURL(urls[0]); err != nil {
+			return fmt.Errorf("invalid URL template %s: %v", c.Templates[i].URL, err)
+		}
+	}
+
+	return c.ValidateSettings()
+}
+
+// ValidateURL checks that a single URL can be warmed
+func ValidateURL(urlStr string) error {
+	parsedURL, err := url.Parse(urlStr)
+	if err != nil {
+		return fmt.Errorf("invalid URL %s: %v", urlStr, err)
+	}
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("URL %s must use http or https scheme", urlStr)
+	}
+	if parsedURL.Host == "" {
+		return fmt.Errorf("URL %s must have a host", urlStr)
+	}
+	return nil
+}
+
+// ValidateSettings checks everything except the URL list, for commands such
+// as probe that take their URLs from elsewhere
+func (c *Config) ValidateSettings() error {
+	// Validate workers count
+	if c.Workers <= 0 {
+		return fmt.Errorf("workers count must be positive, got %d", c.Workers)
+	}
+
+	if c.Workers > 1000 {
+		return fmt.Errorf("workers count is too high (%d), maximum is 1000", c.Workers)
+	}
+
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must be non-negative, got %v", c.Interval)
+	}
+	if c.Jitter < 0 {
+		return fmt.Errorf("jitter must be non-negative, got %v", c.Jitter)
+	}
+	if c.Interval > 0 && c.Jitter >= c.Interval {
+		return fmt.Errorf("jitter (%v) must be shorter than the interval (%v)", c.Jitter, c.Interval)
+	}
+
+	// Validate timeout
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive, got %v", c.Timeout)
+	}
+
+	// timeout bounds every HTTP request, so a longer attempt would never
+	// get to use its extra time
+	if c.RequestTimeout < 0 || c.RequestTimeout > c.Timeout {
+		return fmt.Errorf("request timeout must be between 0 and timeout (%v), got %v", c.Timeout, c.RequestTimeout)
+	}
+
+	if c.URLBudget < 0 {
+		return fmt.Errorf("url budget must be non-negative, got %v", c.URLBudget)
+	}
+
+	if c.CycleTimeout < 0 {
+		return fmt.Errorf("cycle timeout must be non-negative, got %v", c.CycleTimeout)
+	}
+
+	// Validate retry configuration
+	if c.RetryCount < 0 {
+		return fmt.Errorf("retry count must be non-negative, got %d", c.RetryCount)
+	}
+
+	if c.RetryDelay < 0 {
+		return fmt.Errorf("retry delay must be non-negative, got %v", c.RetryDelay)
+	}
+
+	for class, retries := range c.RetryPolicy {
+		if !isRetryPolicyKey(class) {
+			return fmt.Errorf("unknown retry_policy class %q, expected one of %s", class, strings.Join(retryPolicyKeys, ", "))
+		}
+		if retries < 0 {
+			return fmt.Errorf("retry_policy %s must be non-negative, got %d", class, retries)
+		}
+	}
+
+	if c.RetryBudget.Ratio < 0 {
+		return fmt.Errorf("retry budget ratio must be non-negative, got %v", c.RetryBudget.Ratio)
+	}
+	if c.RetryBudget.MinRetries < 0 {
+		return fmt.Errorf("retry budget min retries must be non-negative, got %d", c.RetryBudget.MinRetries)
+	}
+
+	if c.Limit < 0 {
+		return fmt.Errorf("limit must be non-negative, got %d", c.Limit)
+	}
+	if c.MaxURLs < 0 {
+		return fmt.Errorf("max_urls must be non-negative, got %d", c.MaxURLs)
+	}
+	if c.Sampling.Percent < 0 || c.Sampling.Percent > 100 {
+		return fmt.Errorf("sampling percent must be between 0 and 100, got %v", c.Sampling.Percent)
+	}
+	switch c.Sampling.Mode {
+	case SampleTop, SampleRandom, SampleWeighted:
+	default:
+		return fmt.Errorf("unknown sampling mode %q, expected %s, %s or %s",
+			c.Sampling.Mode, SampleTop, SampleRandom, SampleWeighted)
+	}
+	if c.Sampling.Mode != SampleTop && c.MaxURLs == 0 && c.Sampling.Percent == 0 {
+		return fmt.Errorf("sampling mode %s requires max_urls or sampling percent", c.Sampling.Mode)
+	}
+	if err := c.Shard.validate(); err != nil {
+		return fmt.Errorf("shard: %v", err)
+	}
+	if c.TraceFor < 0 {
+		return fmt.Errorf("trace-for must be non-negative, got %v", c.TraceFor)
+	}
+
+	// Validate redirect configuration
+	if c.MaxRedirects < 0 {
+		return fmt.Errorf("max redirects must be non-negative, got %d", c.MaxRedirects)
+	}
+
+	// Validate request method
+	switch c.Method {
+	case http.MethodGet:
+	case http.MethodHead:
+		if c.Crawl.Enabled || c.Assets.Enabled || c.Assets.Images {
+			return fmt.Errorf("method %s returns no pages to find links in; crawl and assets need %s", c.Method, http.MethodGet)
+		}
+	default:
+		return fmt.Errorf("method must be %s or %s, got %q", http.MethodGet, http.MethodHead, c.Method)
+	}
+
+	// Validate success codes
+	if len(c.SuccessCodes) == 0 {
+		return fmt.Errorf("at least one success code must be specified")
+	}
+
+	for _, code := range c.SuccessCodes {
+		if code < 100 || code >= 600 {
+			return fmt.Errorf("invalid HTTP status code: %d", code)
+		}
+	}
+
+	// Validate success rules
+	for i, rule := range c.SuccessRules {
+		if rule.When == "" {
+			return fmt.Errorf("success rule at index %d has no when expression", i)
+		}
+	}
+	if _, err := compileSuccessRules(c.SuccessRules); err != nil {
+		return err
+	}
+
+	// Validate locale checks
+	for i, check := range c.LocaleChecks {
+		if check.Language == "" && check.Charset == "" {
+			return fmt.Errorf("locale check at index %d needs a language or charset", i)
+		}
+	}
+
+	// Validate byte ranges
+	for i := range c.ByteRanges {
+		if err := c.ByteRanges[i].validate(); err != nil {
+			return fmt.Errorf("byte range group at index %d: %v", i, err)
+		}
+	}
+
+	// Validate ordering
+	switch c.Order {
+	case OrderListed, OrderShuffled:
+	case OrderSlowestFirst:
+		if c.HistoryFile == "" {
+			return fmt.Errorf("order %s requires history_file to be set", c.Order)
+		}
+	case OrderSitemapPriority:
+		hasSitemap := c.Sitemap != "" || c.Robots.Sitemaps
+		for _, g := range c.Groups {
+			hasSitemap = hasSitemap || g.Sitemap != ""
+		}
+		if !hasSitemap {
+			return fmt.Errorf("order %s requires a sitemap", c.Order)
+		}
+	default:
+		return fmt.Errorf("unknown order %q, expected %s, %s, %s or %s",
+			c.Order, OrderListed, OrderShuffled, OrderSitemapPriority, OrderSlowestFirst)
+	}
+
+	// Validate warm-on-start mode
+	switch c.WarmOnStart {
+	case WarmOnStartAll, WarmOnStartCritical, WarmOnStartNone:
+	default:
+		return fmt.Errorf("unknown warm_on_start %q, expected %s, %s or %s",
+			c.WarmOnStart, WarmOnStartAll, WarmOnStartCritical, WarmOnStartNone)
+	}
+	switch c.Backfill {
+	case WarmOnStartAll, WarmOnStartCritical, WarmOnStartNone:
+	default:
+		return fmt.Errorf("unknown backfill %q, expected %s, %s or %s",
+			c.Backfill, WarmOnStartAll, WarmOnStartCritical, WarmOnStartNone)
+	}
+
+	// Validate overlap policy
+	switch c.OverlapPolicy {
+	case OverlapSkip, OverlapQueue, OverlapConcurrent, OverlapCancel:
+	default:
+		return fmt.Errorf("unknown overlap_policy %q, expected %s, %s, %s or %s",
+			c.OverlapPolicy, OverlapSkip, OverlapQueue, OverlapConcurrent, OverlapCancel)
+	}
+	if c.MaxConcurrentCycles < 1 {
+		return fmt.Errorf("max_concurrent_cycles must be at least 1, got %d", c.MaxConcurrentCycles)
+	}
+
+	// Validate leader election
+	if c.LeaderElection.Redis != "" {
+		if u, err := url.Parse(c.LeaderElection.Redis); err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+			return fmt.Errorf("invalid leader_election redis %q, expected redis://host:port", c.LeaderElection.Redis)
+		}
+		if c.LeaderElection.TTL < time.Second {
+			return fmt.Errorf("leader_election ttl must be at least 1s, got %v", c.LeaderElection.TTL)
+		}
+	}
+
+	// Validate frequency tiers
+	tierNames := make(map[string]bool)
+	for i, t := range c.Tiers {
+		if t.Match == "" {
+			return fmt.Errorf("tier at index %d has no match pattern", i)
+		}
+		if (t.Every > 0) == (t.Interval > 0) {
+			return fmt.Errorf("tier %s needs either every or interval", t.label())
+		}
+		if t.Every < 0 || t.Interval < 0 {
+			return fmt.Errorf("tier %s every and interval must be positive", t.label())
+		}
+		if tierNames[t.label()] {
+			return fmt.Errorf("duplicate tier name %q", t.label())
+		}
+		tierNames[t.label()] = true
+	}
+
+	// Validate crawl settings
+	if c.Crawl.Enabled {
+		if c.Crawl.MaxDepth < 1 {
+			return fmt.Errorf("crawl max depth must be at least 1, got %d", c.Crawl.MaxDepth)
+		}
+
+		if c.Crawl.MaxPages < 1 {
+			return fmt.Errorf("crawl max pages must be at least 1, got %d", c.Crawl.MaxPages)
+		}
+	}
+
+	// Validate skip list
+	if c.SkipList.File != "" {
+		if c.SkipList.After < 1 {
+			return fmt.Errorf("skip list after must be at least 1, got %d", c.SkipList.After)
+		}
+
+		if c.SkipList.RetryAfter <= 0 {
+			return fmt.Errorf("skip list retry_after must be positive, got %v", c.SkipList.RetryAfter)
+		}
+	}
+
+	// Validate checkpoint
+	if c.Checkpoint.File != "" && c.Checkpoint.Interval <= 0 {
+		return fmt.Errorf("checkpoint interval must be positive, got %v", c.Checkpoint.Interval)
+	}
+
+	// Validate conditional requests
+	if c.Conditional.File != "" && !c.Conditional.Enabled {
+		return fmt.Errorf("conditional file requires conditional requests to be enabled")
+	}
+
+	// Validate transport overrides
+	if c.Transport != nil && c.UnixSocket != "" {
+		return fmt.Errorf("unix_socket cannot be combined with a custom transport")
+	}
+
+	if (c.Transport != nil || c.UnixSocket != "") && len(c.Regions) > 0 {
+		return fmt.Errorf("regions cannot be combined with unix_socket or a custom transport")
+	}
+
+	// Validate connection overrides
+	if len(c.ConnectTo) > 0 {
+		if c.Transport != nil || c.UnixSocket != "" {
+			return fmt.Errorf("connect_to cannot be combined with unix_socket or a custom transport")
+		}
+		if c.ProxyURL != "" || len(c.ProxyPool.Proxies) > 0 {
+			return fmt.Errorf("connect_to cannot be combined with proxy_url or proxy_pool; the proxy connects to hosts")
+		}
+		if err := validateConnectTo(c.ConnectTo); err != nil {
+			return fmt.Errorf("invalid connect_to: %v", err)
+		}
+	}
+
+	// Validate DNS options
+	if c.DNS.configured() && (c.Transport != nil || c.UnixSocket != "") {
+		return fmt.Errorf("dns cannot be combined with unix_socket or a custom transport")
+	}
+	for _, server := range c.DNS.Servers {
+		host, port, err := net.SplitHostPort(nameServerAddr(server))
+		if err != nil || net.ParseIP(host) == nil || !validPort(port) {
+			return fmt.Errorf("invalid dns server %q, expected IP or IP:port", server)
+		}
+	}
+	for host, ip := range c.DNS.Hosts {
+		if net.ParseIP(ip) == nil {
+			return fmt.Errorf("dns hosts maps %s to invalid IP address %q", host, ip)
+		}
+	}
+
+	// Validate the forward proxy
+	if c.ProxyURL != "" {
+		if c.Transport != nil || c.UnixSocket != "" {
+			return fmt.Errorf("proxy_url cannot be combined with unix_socket or a custom transport")
+		}
+		if err := validateProxyURL(c.ProxyURL); err != nil {
+			return fmt.Errorf("invalid proxy_url: %v", err)
+		}
+	}
+	if len(c.ProxyPool.Proxies) > 0 {
+		if c.Transport != nil || c.UnixSocket != "" || c.ProxyURL != "" {
+			return fmt.Errorf("proxy_pool cannot be combined with proxy_url, unix_socket or a custom transport")
+		}
+		for i, proxy := range c.ProxyPool.Proxies {
+			if err := validateProxyURL(proxy); err != nil {
+				return fmt.Errorf("invalid proxy_pool proxy at index %d: %v", i, err)
+			}
+		}
+	}
+	switch c.ProxyPool.Rotation {
+	case ProxyRotationRoundRobin, ProxyRotationPerHost:
+	default:
+		return fmt.Errorf("unknown proxy_pool rotation %q, expected %s or %s",
+			c.ProxyPool.Rotation, ProxyRotationRoundRobin, ProxyRotationPerHost)
+	}
+
+	// Validate TLS options
+	if c.Transport != nil && (c.TLS.CAFile != "" || c.TLS.InsecureSkipVerify) {
+		return fmt.Errorf("tls options cannot be combined with a custom transport")
+	}
+	switch c.HTTP2 {
+	case HTTP2Auto:
+	case HTTP2Force, HTTP2Disable, HTTP2H2C:
+		if c.Transport != nil {
+			return fmt.Errorf("http2 %s cannot be combined with a custom transport", c.HTTP2)
+		}
+	default:
+		return fmt.Errorf("unknown http2 mode %q, expected %s, %s, %s or %s",
+			c.HTTP2, HTTP2Auto, HTTP2Force, HTTP2Disable, HTTP2H2C)
+	}
+	switch c.IPFamily {
+	case IPFamilyDual:
+	case IPFamilyIPv4, IPFamilyIPv6:
+		if c.Transport != nil || c.UnixSocket != "" {
+			return fmt.Errorf("ip_family %s cannot be combined with unix_socket or a custom transport", c.IPFamily)
+		}
+	default:
+		return fmt.Errorf("unknown ip_family %q, expected %s, %s or %s",
+			c.IPFamily, IPFamilyDual, IPFamilyIPv4, IPFamilyIPv6)
+	}
+	if c.ConnectionPool.configured() {
+		pool := c.ConnectionPool
+		if c.Transport != nil {
+			return fmt.Errorf("connection_pool cannot be combined with a custom transport")
+		}
+		if pool.MaxIdleConns < 0 || pool.MaxIdleConnsPerHost < 0 || pool.MaxConnsPerHost < 0 || pool.IdleConnTimeout < 0 {
+			return fmt.Errorf("connection_pool limits and idle_conn_timeout must not be negative")
+		}
+	}
+	if c.TLS.CAFile != "" {
+		if _, err := loadCAPool(c.TLS.CAFile); err != nil {
+			return fmt.Errorf("invalid tls ca_file: %v", err)
+		}
+	}
+
+	// Validate regions
+	regionNames := make(map[string]bool)
+	for i, region := range c.Regions {
+		if region.Name == "" {
+			return fmt.Errorf("region at index %d must have a name", i)
+		}
+		if regionNames[region.Name] {
+			return fmt.Errorf("duplicate region name: %s", region.Name)
+		}
+		regionNames[region.Name] = true
+
+		if err := validateRegion(&region); err != nil {
+			return err
+		}
+	}
+
+	// Validate device profiles
+	deviceNames := make(map[string]bool)
+	for i, device := range c.Devices {
+		if device.Name == "" {
+			return fmt.Errorf("device at index %d must have a name", i)
+		}
+		if deviceNames[device.Name] {
+			return fmt.Errorf("duplicate device name: %s", device.Name)
+		}
+		deviceNames[device.Name] = true
+
+		if _, builtIn := deviceUserAgents[device.Name]; device.UserAgent == "" && !builtIn {
+			return fmt.Errorf("device %s needs a user_agent (built-in devices: desktop, mobile, tablet, bot)", device.Name)
+		}
+	}
+
+	// Validate the origin shield
+	if c.Shield.Enabled {
+		if c.Transport != nil || c.UnixSocket != "" {
+			return fmt.Errorf("shield cannot be combined with unix_socket or a custom transport")
+		}
+		if c.Shield.Name == "" || regionNames[c.Shield.Name] {
+			return fmt.Errorf("shield name %q must be set and differ from the region names", c.Shield.Name)
+		}
+		if c.Shield.Proxy == "" && c.Shield.Address == "" && len(c.Shield.Resolve) == 0 {
+			return fmt.Errorf("shield needs a proxy, address or resolve overrides pointing at the shield")
+		}
+		if err := validateRegion(&c.Shield.RegionConfig); err != nil {
+			return err
+		}
+	}
+
+	// Validate the shadow host
+	if c.Shadow.BaseURL != "" {
+		if err := ValidateURL(c.Shadow.BaseURL); err != nil {
+			return fmt.Errorf("invalid shadow base_url: %v", err)
+		}
+		if len(c.Regions) > 0 || c.Shield.Enabled || c.AllAddresses.Enabled {
+			return fmt.Errorf("shadow mirrors one request per URL and cannot be combined with regions, shield or all_addresses")
+		}
+		if c.Shadow.CompareContent && c.Method == http.MethodHead {
+			return fmt.Errorf("shadow compare_content needs method %s", http.MethodGet)
+		}
+	}
+
+	// Validate waves
+	for _, size := range c.Waves.Sizes {
+		if _, _, err := parseWaveSize(size); err != nil {
+			return err
+		}
+	}
+	if c.Waves.MinSuccessRate < 0 || c.Waves.MinSuccessRate > 100 {
+		return fmt.Errorf("waves min_success_rate must be between 0 and 100, got %v", c.Waves.MinSuccessRate)
+	}
+	if c.Waves.MaxLatency < 0 {
+		return fmt.Errorf("waves max_latency must be non-negative, got %v", c.Waves.MaxLatency)
+	}
+	if len(c.Waves.Sizes) > 0 && c.Waves.MinSuccessRate == 0 && c.Waves.MaxLatency == 0 {
+		return fmt.Errorf("waves need min_success_rate or max_latency to check each wave against")
+	}
+
+	// Validate artifact upload destination
+	if c.Artifacts.Upload != "" {
+		if _, err := NewObjectStore(c.Artifacts.Upload, c.Artifacts.Endpoint, c.Artifacts.Region); err != nil {
+			return fmt.Errorf("invalid artifacts upload: %v", err)
+		}
+	}
+
+	if c.SlowThreshold < 0 {
+		return fmt.Errorf("slow_threshold must be non-negative, got %v", c.SlowThreshold)
+	}
+	if c.FailThreshold < 0 || c.FailThreshold >= 100 {
+		return fmt.Errorf("fail_threshold must be a percentage from 0 to below 100, got %v", c.FailThreshold)
+	}
+	if c.ResultsFile != "" {
+		switch strings.ToLower(filepath.Ext(c.ResultsFile)) {
+		case ".csv", ".json":
+		default:
+			return fmt.Errorf("results_file must end in .csv or .json, got %s", c.ResultsFile)
+		}
+	}
+
+	// Validate StatsD configuration
+	if c.StatsD.Address != "" {
+		if _, _, err := net.SplitHostPort(c.StatsD.Address); err != nil {
+			return fmt.Errorf("invalid statsd address %q: %v", c.StatsD.Address, err)
+		}
+		if c.StatsD.FlushInterval <= 0 {
+			return fmt.Errorf("statsd flush_interval must be positive, got %v", c.StatsD.FlushInterval)
+		}
+		if len(c.StatsD.Tags) > 0 && !c.StatsD.DogStatsD {
+			return fmt.Errorf("statsd tags require dogstatsd")
+		}
+	}
+
+	// Validate metrics configuration
+	if c.Metrics.Enabled {
+		if c.Metrics.Port <= 0 || c.Metrics.Port > 65535 {
+			return fmt.Errorf("metrics port must be between 1 and 65535, got %d", c.Metrics.Port)
+		}
+
+		if c.Metrics.Path == "" {
+			return fmt.Errorf("metrics path cannot be empty")
+		}
+
+		if !strings.HasPrefix(c.Metrics.Path, "/") {
+			return fmt.Errorf("metrics path must start with '/', got %s", c.Metrics.Path)
+		}
+	}
 
-	// RetryDelay is the delay between retries
-	RetryDelay time.Duration `yaml:"retry_delay"`
+	// Validate fleet configuration
+	if c.Fleet.Push != "" {
+		if err := ValidateURL(c.Fleet.Push); err != nil {
+			return fmt.Errorf("invalid fleet push URL: %v", err)
+		}
+	}
+	if c.Fleet.Aggregate {
+		if !c.Metrics.Enabled {
+			return fmt.Errorf("fleet aggregate requires metrics to be enabled")
+		}
+		if c.Metrics.Path == FleetPath {
+			return fmt.Errorf("metrics path %s conflicts with the fleet endpoint", FleetPath)
+		}
+	}
+	if c.ProbeEndpoint.Enabled {
+		if !c.Metrics.Enabled {
+			return fmt.Errorf("probe endpoint requires metrics to be enabled")
+		}
+		if c.Metrics.Path == ProbePath {
+			return fmt.Errorf("metrics path %s conflicts with the probe endpoint", ProbePath)
+		}
+	}
+	if c.Admin.Enabled {
+		if !c.Metrics.Enabled {
+			return fmt.Errorf("admin API requires metrics to be enabled")
+		}
+		if strings.HasPrefix(c.Metrics.Path, AdminPath+"/") {
+			return fmt.Errorf("metrics path %s conflicts with the admin API", c.Metrics.Path)
+		}
+	}
 
-	// UserAgent is the User-Agent header to use for requests
-	UserAgent string `yaml:"user_agent"`
+	// Validate webhook configuration
+	if c.Webhook.Enabled {
+		if c.Webhook.Port <= 0 || c.Webhook.Port > 65535 {
+			return fmt.Errorf("webhook port must be between 1 and 65535, got %d", c.Webhook.Port)
+		}
 
-	// Headers contains custom headers to include in requests
-	Headers map[string]string `yaml:"headers"`
+		if c.Metrics.Enabled && c.Webhook.Port == c.Metrics.Port {
+			return fmt.Errorf("webhook port %d conflicts with metrics port", c.Webhook.Port)
+		}
 
-	// FollowRedirects determines if redirects should be followed
-	FollowRedirects bool `yaml:"follow_redirects"`
+		if !strings.HasPrefix(c.Webhook.Path, "/") {
+			return fmt.Errorf("webhook path must start with '/', got %s", c.Webhook.Path)
+		}
 
-	// MaxRedirects is the maximum number of redirects to follow
-	MaxRedirects int `yaml:"max_redirects"`
+		if len(c.Webhook.CMS.ContentTypes) > 0 {
+			parsedURL, err := url.Parse(c.Webhook.CMS.BaseURL)
+			if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+				return fmt.Errorf("webhook cms base_url must be an absolute http or https URL, got %q", c.Webhook.CMS.BaseURL)
+			}
+		}
 
-	// SuccessCodes defines which HTTP status codes are considered successful
-	SuccessCodes []int `yaml:"success_codes"`
+		for name, ct := range c.Webhook.CMS.ContentTypes {
+			if ct.Pages < 0 {
+				return fmt.Errorf("webhook cms content type %s: pages must be non-negative, got %d", name, ct.Pages)
+			}
+		}
 
-	// Metrics configuration
-	Metrics MetricsConfig `yaml:"metrics"`
-}
+		if err := c.Webhook.Fastly.validate(); err != nil {
+			return fmt.Errorf("webhook fastly: %v", err)
+		}
 
-// MetricsConfig contains configuration for metrics collection
-type MetricsConfig struct {
-	// Enabled determines if metrics collection is enabled
-	Enabled bool `yaml:"enabled"`
+		switch c.Webhook.Deploy.Purge {
+		case "", PurgeNone:
+		case PurgeFastly:
+			if c.Webhook.Fastly.ServiceID == "" {
+				return fmt.Errorf("webhook deploy purge %s requires a webhook fastly service_id", PurgeFastly)
+			}
+		case PurgeCloudFront:
+			if c.CloudFront.DistributionID == "" || len(c.CloudFront.Paths) == 0 {
+				return fmt.Errorf("webhook deploy purge %s requires a cloudfront distribution_id and paths", PurgeCloudFront)
+			}
+		default:
+			return fmt.Errorf("unknown webhook deploy purge %q, expected %s, %s or %s",
+				c.Webhook.Deploy.Purge, PurgeFastly, PurgeCloudFront, PurgeNone)
+		}
+	}
 
-	// Port is the port to expose metrics on
-	Port int `yaml:"port"`
+	// Validate gRPC configuration
+	if c.GRPC.Enabled {
+		if c.GRPC.Port <= 0 || c.GRPC.Port > 65535 {
+			return fmt.Errorf("grpc port must be between 1 and 65535, got %d", c.GRPC.Port)
+		}
 
-	// Path is the path to expose metrics on
-	Path string `yaml:"path"`
-}
+		if (c.Metrics.Enabled && c.GRPC.Port == c.Metrics.Port) || (c.Webhook.Enabled && c.GRPC.Port == c.Webhook.Port) {
+			return fmt.Errorf("grpc port %d conflicts with the metrics or webhook port", c.GRPC.Port)
+		}
+	}
 
-// DefaultConfig returns a configuration with sensible defaults
-func DefaultConfig() *Config {
-	return &Config{
-		URLs:            []string{},
-		Workers:         10,
-		Timeout:         30 * time.Second,
-		RetryCount:      3,
-		RetryDelay:      1 * time.Second,
-		UserAgent:       "Cache-Warmer/1.0",
-		Headers:         make(map[string]string),
-		FollowRedirects: true,
-		MaxRedirects:    5,
-		SuccessCodes:    []int{200, 201, 202, 204, 301, 302, 304},
-		Metrics: MetricsConfig{
-			Enabled: false,
-			Port:    8080,
-			Path:    "/metrics",
-		},
+	// Validate CloudFront invalidations
+	if err := c.CloudFront.validate(); err != nil {
+		return fmt.Errorf("cloudfront: %v", err)
 	}
-}
 
-// LoadConfig loads configuration from file and applies command line overrides
-func LoadConfig(configFile, urlsOverride string, workersOverride int, timeoutOverride time.Duration) (*Config, error) {
-	// Start with default configuration
-	config := DefaultConfig()
+	// Validate rate-limit budget configuration
+	if c.RateLimitBudget.Enabled {
+		if c.RateLimitBudget.Reserve < 0 {
+			return fmt.Errorf("rate limit budget reserve must be non-negative, got %d", c.RateLimitBudget.Reserve)
+		}
 
-	// Load from file if it exists
-	if configFile != "" {
-		if err := config.LoadFromFile(configFile); err != nil {
-			// If config file is explicitly specified but doesn't exist, return error
-			if configFile != "config.yaml" {
-				return nil, fmt.Errorf("failed to load config file %s: %v", configFile, err)
-			}
-			// If using default config file name and it doesn't exist, that's OK
-			// We'll just use defaults
+		if c.RateLimitBudget.SlowdownBelow < c.RateLimitBudget.Reserve {
+			return fmt.Errorf("rate limit budget slowdown_below (%d) must not be below reserve (%d)",
+				c.RateLimitBudget.SlowdownBelow, c.RateLimitBudget.Reserve)
 		}
-	}
 
-	// Apply command line overrides
-	if urlsOverride != "" {
-		urls := strings.Split(urlsOverride, ",")
-		// Trim whitespace from each URL
-		for i, u := range urls {
-			urls[i] = strings.TrimSpace(u)
+		if c.RateLimitBudget.MaxPause <= 0 {
+			return fmt.Errorf("rate limit budget max pause must be positive, got %v", c.RateLimitBudget.MaxPause)
 		}
-		config.URLs = urls
 	}
 
-	if workersOverride > 0 {
-		config.Workers = workersOverride
+	// Validate politeness configuration
+	if c.Politeness.Delay < 0 {
+		return fmt.Errorf("politeness delay must be non-negative, got %v", c.Politeness.Delay)
 	}
 
-	if timeoutOverride > 0 {
-		config.Timeout = timeoutOverride
+	if c.Politeness.Jitter < 0 {
+		return fmt.Errorf("politeness jitter must be non-negative, got %v", c.Politeness.Jitter)
 	}
 
-	return config, nil
-}
+	// Validate all-addresses configuration
+	if c.AllAddresses.Enabled && c.UnixSocket != "" {
+		return fmt.Errorf("all_addresses cannot be combined with unix_socket")
+	}
+	if c.AllAddresses.Enabled && (c.ProxyURL != "" || len(c.ProxyPool.Proxies) > 0) {
+		return fmt.Errorf("all_addresses cannot be combined with proxy_url or proxy_pool; the proxy resolves hosts")
+	}
 
-// LoadFromFile loads configuration from a YAML file
-func (c *Config) LoadFromFile(filename string) error {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return fmt.Errorf("failed to read config file: %v", err)
+	// Validate variants configuration
+	for _, scheme := range c.Variants.Schemes {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("variants schemes must be http or https, got %q", scheme)
+		}
 	}
 
-	// Create a temporary config to unmarshal into
-	var fileConfig Config
-	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
-		return fmt.Errorf("failed to parse config file: %v", err)
+	// Validate normalize configuration
+	if !c.Normalize.Enabled && (c.Normalize.StripFragment || len(c.Normalize.StripQuery) > 0) {
+		return fmt.Errorf("normalize strip_fragment and strip_query require normalize enabled")
+	}
+	for _, pattern := range c.Normalize.StripQuery {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid normalize strip_query pattern %q: %v", pattern, err)
+		}
 	}
 
-	// Merge file config with current config (file config takes precedence)
-	if len(fileConfig.URLs) > 0 {
-		c.URLs = fileConfig.URLs
+	// Validate coalescing configuration
+	if c.Coalescing.Stagger < 0 {
+		return fmt.Errorf("coalescing stagger must be non-negative, got %v", c.Coalescing.Stagger)
 	}
-	if fileConfig.Workers > 0 {
-		c.Workers = fileConfig.Workers
+	for _, pattern := range c.Coalescing.IgnoreQuery {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid coalescing ignore_query pattern %q: %v", pattern, err)
+		}
 	}
-	if fileConfig.Timeout > 0 {
-		c.Timeout = fileConfig.Timeout
+
+	// Validate cookie jar configuration
+	if len(c.Cookies.Preload) > 0 && !c.Cookies.Enabled {
+		return fmt.Errorf("cookies preload needs cookies enabled")
 	}
-	if fileConfig.RetryCount > 0 {
-		c.RetryCount = fileConfig.RetryCount
+	for i, cookie := range c.Cookies.Preload {
+		if err := ValidateURL(cookie.URL); err != nil {
+			return fmt.Errorf("invalid preload cookie URL at index %d: %v", i, err)
+		}
+		if cookie.Name == "" || strings.ContainsAny(cookie.Name, " =;,") {
+			return fmt.Errorf("invalid preload cookie name %q at index %d", cookie.Name, i)
+		}
 	}
-	if fileConfig.RetryDelay > 0 {
-		c.RetryDelay = fileConfig.RetryDelay
+
+	// Validate authentication
+	switch c.Auth.Type {
+	case "":
+	case AuthLogin:
+		if err := ValidateURL(c.Auth.URL); err != nil {
+			return fmt.Errorf("invalid auth url: %v", err)
+		}
+		if len(c.Auth.Form) == 0 {
+			return fmt.Errorf("auth type %s needs the login form fields", AuthLogin)
+		}
+	case AuthOAuth2:
+		if err := ValidateURL(c.Auth.URL); err != nil {
+			return fmt.Errorf("invalid auth url: %v", err)
+		}
+		switch c.Auth.Grant {
+		case OAuth2ClientCredentials:
+			if c.Auth.ClientID == "" {
+				return fmt.Errorf("auth grant %s needs a client_id", c.Auth.Grant)
+			}
+		case OAuth2Password:
+			if c.Auth.Username == "" {
+				return fmt.Errorf("auth grant %s needs a username", c.Auth.Grant)
+			}
+		default:
+			return fmt.Errorf("unknown auth grant %q, expected %s or %s", c.Auth.Grant, OAuth2ClientCredentials, OAuth2Password)
+		}
+	default:
+		return fmt.Errorf("unknown auth type %q, expected %s or %s", c.Auth.Type, AuthLogin, AuthOAuth2)
 	}
-	if fileConfig.UserAgent != "" {
-		c.UserAgent = fileConfig.UserAgent
+	if c.Auth.Refresh < 0 {
+		return fmt.Errorf("auth refresh must be non-negative, got %v", c.Auth.Refresh)
 	}
-	if len(fileConfig.Headers) > 0 {
-		c.Headers = fileConfig.Headers
+	if c.BasicAuth != nil {
+		if err := c.BasicAuth.validate(); err != nil {
+			return fmt.Errorf("invalid basic_auth: %v", err)
+		}
 	}
-	if fileConfig.MaxRedirects > 0 {
-		c.MaxRedirects = fileConfig.MaxRedirects
+	for _, entry := range c.URLs {
+		if entry.BasicAuth != nil {
+			if err := entry.BasicAuth.validate(); err != nil {
+				return fmt.Errorf("invalid basic_auth for %s: %v", entry.URL, err)
+			}
+		}
 	}
-	if len(fileConfig.SuccessCodes) > 0 {
-		c.SuccessCodes = fileConfig.SuccessCodes
+
+	// Validate TTL report configuration
+	if c.TTLReport.Enabled {
+		if c.TTLReport.PrefixDepth < 1 {
+			return fmt.Errorf("ttl report prefix depth must be at least 1, got %d", c.TTLReport.PrefixDepth)
+		}
+		if c.TTLReport.MinTTL < 0 {
+			return fmt.Errorf("ttl report min ttl must be non-negative, got %v", c.TTLReport.MinTTL)
+		}
 	}
 
-	// Set boolean values (these can be explicitly false)
-	c.FollowRedirects = fileConfig.FollowRedirects
+	// Validate TTL schedule configuration
+	if c.TTLSchedule.Enabled {
+		if c.TTLSchedule.Lead < 0 {
+			return fmt.Errorf("ttl schedule lead must be non-negative, got %v", c.TTLSchedule.Lead)
+		}
+		if c.TTLSchedule.MaxInterval <= 0 {
+			return fmt.Errorf("ttl schedule max interval must be positive, got %v", c.TTLSchedule.MaxInterval)
+		}
+	}
 
-	// Merge metrics config
-	if fileConfig.Metrics.Port > 0 {
-		c.Metrics.Port = fileConfig.Metrics.Port
+	for _, name := range c.CaptureHeaders {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :") {
+			return fmt.Errorf("invalid capture_headers entry %q", name)
+		}
 	}
-	if fileConfig.Metrics.Path != "" {
-		c.Metrics.Path = fileConfig.Metrics.Path
+
+	// Validate admission control
+	if c.Admission.MaxInFlight < 0 {
+		return fmt.Errorf("admission max in flight must be non-negative, got %d", c.Admission.MaxInFlight)
 	}
-	c.Metrics.Enabled = fileConfig.Metrics.Enabled
 
-	return nil
-}
+	if c.Admission.MaxHeapMB < 0 {
+		return fmt.Errorf("admission max heap must be non-negative, got %d", c.Admission.MaxHeapMB)
+	}
 
-// Validate checks if the configuration is valid
-func (c *Config) Validate() error {
-	// Check if we have at least one URL
-	if len(c.URLs) == 0 {
-		return fmt.Errorf("at least one URL must be specified")
+	// Validate rate limit
+	if c.RateLimit.RequestsPerSecond < 0 {
+		return fmt.Errorf("rate limit requests per second must be non-negative, got %v", c.RateLimit.RequestsPerSecond)
+	}
+	if c.RateLimit.Burst < 0 {
+		return fmt.Errorf("rate limit burst must be non-negative, got %d", c.RateLimit.Burst)
 	}
 
-	// Validate each URL
-	for i, urlStr := range c.URLs {
-		if urlStr == "" {
-			return fmt.Errorf("URL at index %d is empty", i)
-		}
+	return nil
+}
 
-		// Parse URL to check if it's valid
-		parsedURL, err := url.Parse(urlStr)
-		if err != nil {
-			return fmt.Errorf("invalid URL at index %d (%s): %v", i, urlStr, err)
-		}
+// retryPolicyKeys are the failure classes retry_policy accepts
+var retryPolicyKeys = []string{
+	ErrorClassTimeout, ErrorClassDNS, ErrorClassTLS, ErrorClassConnection, ErrorClassProxy,
+	ErrorClassStatus, "status_4xx", "status_5xx", ErrorClassAssertion, ErrorClassOther,
+}
 
-		// Check if scheme is http or https
-		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-			return fmt.Errorf("URL at index %d (%s) must use http or https scheme", i, urlStr)
+// isRetryPolicyKey reports whether key is a valid retry_policy class
+func isRetryPolicyKey(key string) bool {
+	for _, k := range retryPolicyKeys {
+		if k == key {
+			return true
 		}
+	}
+	return false
+}
 
-		// Check if host is present
-		if parsedURL.Host == "" {
-			return fmt.Errorf("URL at index %d (%s) must have a host", i, urlStr)
+// RetriesFor returns how many times a request failing with err is retried.
+// status_4xx and status_5xx fall back to status, and classes without an
+// entry in retry_policy fall back to retry_count.
+func (c *Config) RetriesFor(err error) int {
+	class := ErrorClass(err)
+
+	var statusErr *StatusCodeError
+	if errors.As(err, &statusErr) {
+		key := fmt.Sprintf("status_%dxx", statusErr.StatusCode/100)
+		if retries, ok := c.RetryPolicy[key]; ok {
+			return retries
 		}
 	}
 
-	// Validate workers count
-	if c.Workers <= 0 {
-		return fmt.Errorf("workers count must be positive, got %d", c.Workers)
+	if retries, ok := c.RetryPolicy[class]; ok {
+		return retries
 	}
+	return c.RetryCount
+}
 
-	if c.Workers > 1000 {
-		return fmt.Errorf("workers count is too high (%d), maximum is 1000", c.Workers)
+// IsSuccessCode checks if the given HTTP status code is considered successful
+func (c *Config) IsSuccessCode(code int) bool {
+	for _, successCode := range c.SuccessCodes {
+		if code == successCode {
+			return true
+		}
 	}
+	return false
+}
 
-	// Validate timeout
-	if c.Timeout <= 0 {
-		return fmt.Errorf("timeout must be positive, got %v", c.Timeout)
+// defaultInstanceName names this instance after the host, which is the pod
+// name on Kubernetes
+func defaultInstanceName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
 	}
+	return "cache-warmer"
+}
 
-	// Validate retry configuration
-	if c.RetryCount < 0 {
-		return fmt.Errorf("retry count must be non-negative, got %d", c.RetryCount)
+// validateRegion checks a region's proxy URL, resolver overrides and address
+// family
+func validateRegion(region *RegionConfig) error {
+	if region.Proxy != "" {
+		if err := validateProxyURL(region.Proxy); err != nil {
+			return fmt.Errorf("region %s has invalid proxy: %v", region.Name, err)
+		}
 	}
 
-	if c.RetryDelay < 0 {
-		return fmt.Errorf("retry delay must be non-negative, got %v", c.RetryDelay)
+	if err := validateConnectTo(region.Resolve); err != nil {
+		return fmt.Errorf("region %s has invalid resolve: %v", region.Name, err)
 	}
 
-	// Validate redirect configuration
-	if c.MaxRedirects < 0 {
-		return fmt.Errorf("max redirects must be non-negative, got %d", c.MaxRedirects)
+	if region.Address != "" {
+		if region.Proxy != "" {
+			return fmt.Errorf("region %s cannot have both a proxy and an address", region.Name)
+		}
+		if err := validateConnectTarget(region.Address); err != nil {
+			return fmt.Errorf("region %s has invalid address: %v", region.Name, err)
+		}
 	}
 
-	// Validate success codes
-	if len(c.SuccessCodes) == 0 {
-		return fmt.Errorf("at least one success code must be specified")
+	switch region.IPFamily {
+	case "", IPFamilyDual, IPFamilyIPv4, IPFamilyIPv6:
+	default:
+		return fmt.Errorf("region %s has unknown ip_family %q, expected %s, %s or %s",
+			region.Name, region.IPFamily, IPFamilyDual, IPFamilyIPv4, IPFamilyIPv6)
 	}
+	return nil
+}
 
-	for _, code := range c.SuccessCodes {
-		if code < 100 || code >= 600 {
-			return fmt.Errorf("invalid HTTP status code: %d", code)
+// validateConnectTo checks that connection overrides map a host or host:port
+// to an IP or IP:port
+func validateConnectTo(overrides map[string]string) error {
+	for from, to := range overrides {
+		if from == "" {
+			return fmt.Errorf("empty host")
 		}
-	}
-
-	// Validate metrics configuration
-	if c.Metrics.Enabled {
-		if c.Metrics.Port <= 0 || c.Metrics.Port > 65535 {
-			return fmt.Errorf("metrics port must be between 1 and 65535, got %d", c.Metrics.Port)
+		if _, port, err := net.SplitHostPort(from); err == nil && !validPort(port) {
+			return fmt.Errorf("%s has invalid port", from)
 		}
-
-		if c.Metrics.Path == "" {
-			return fmt.Errorf("metrics path cannot be empty")
+		if err := validateConnectTarget(to); err != nil {
+			return fmt.Errorf("%s maps to %v", from, err)
 		}
+	}
+	return nil
+}
 
-		if !strings.HasPrefix(c.Metrics.Path, "/") {
-			return fmt.Errorf("metrics path must start with '/', got %s", c.Metrics.Path)
+// validateConnectTarget checks that to is an IP or IP:port
+func validateConnectTarget(to string) error {
+	ip := to
+	if host, port, err := net.SplitHostPort(to); err == nil {
+		if !validPort(port) {
+			return fmt.Errorf("%q with invalid port", to)
 		}
+		ip = host
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid IP address %q", to)
 	}
-
 	return nil
 }
 
-// IsSuccessCode checks if the given HTTP status code is considered successful
-func (c *Config) IsSuccessCode(code int) bool {
-	for _, successCode := range c.SuccessCodes {
-		if code == successCode {
-			return true
-		}
+// validPort reports whether port is a TCP port number
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// validateProxyURL checks that a forward proxy URL is usable
+func validateProxyURL(raw string) error {
+	proxyURL, err := url.Parse(raw)
+	if err != nil || proxyURL.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q", raw)
 	}
-	return false
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("proxy must use http, https, socks5 or socks5h scheme, got %s", proxyURL.Scheme)
+	}
+	return nil
 }
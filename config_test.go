@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestConfig loads a configuration file with the given contents over
+// the defaults
+func loadTestConfig(t *testing.T, contents string) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	if err := config.LoadFromFile(path); err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	return config
+}
+
+func TestLoadFromFileShield(t *testing.T) {
+	config := loadTestConfig(t, `
+urls:
+  - url: "https://example.com/"
+shield:
+  enabled: true
+  name: "origin-shield"
+  address: "203.0.113.10"
+  ip_family: "ipv4"
+  verify_attempts: 3
+`)
+
+	shield := config.Shield
+	if !shield.Enabled || shield.Name != "origin-shield" || shield.VerifyAttempts != 3 {
+		t.Errorf("shield = %+v", shield)
+	}
+	if shield.Address != "203.0.113.10" {
+		t.Errorf("shield address = %q, want 203.0.113.10", shield.Address)
+	}
+	if shield.IPFamily != IPFamilyIPv4 {
+		t.Errorf("shield ip_family = %q, want %s", shield.IPFamily, IPFamilyIPv4)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("invalid configuration: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outcomes of a cycle started on request
+const (
+	CycleStarted = "started"
+	CycleQueued  = "queued"
+	CycleSkipped = "skipped"
+)
+
+// runtimeURLs are the changes made to the URL list through the control
+// APIs while the process runs. They apply from the next cycle on and are
+// not persisted.
+type runtimeURLs struct {
+	added   []string
+	removed map[string]bool
+}
+
+// pauseGate holds the workers before their next request while warming is
+// paused
+type pauseGate struct {
+	mutex sync.Mutex
+	since time.Time
+
+	// resumed is closed on resume, and nil while not paused
+	resumed chan struct{}
+}
+
+// Wait blocks while paused. It returns early with the context error if ctx
+// is cancelled.
+func (g *pauseGate) Wait(ctx context.Context) error {
+	g.mutex.Lock()
+	resumed := g.resumed
+	g.mutex.Unlock()
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddURLs adds URLs to every following cycle, or brings back removed ones.
+// It returns how many URLs changed.
+func (cw *CacheWarmer) AddURLs(urls []string) (int, error) {
+	for _, u := range urls {
+		if err := ValidateURL(u); err != nil {
+			return 0, err
+		}
+	}
+
+	cw.controlMutex.Lock()
+	defer cw.controlMutex.Unlock()
+
+	listed := cw.CurrentConfig().URLList()
+	changed := 0
+	for _, u := range urls {
+		if cw.runtime.removed[u] {
+			delete(cw.runtime.removed, u)
+			changed++
+			continue
+		}
+		if !containsString(cw.runtime.added, u) && !containsString(listed, u) {
+			cw.runtime.added = append(cw.runtime.added, u)
+			changed++
+		}
+	}
+	if changed > 0 {
+		cw.logger.Info("Added %d URLs to the following cycles", changed)
+	}
+	return changed, nil
+}
+
+// RemoveURLs leaves URLs out of every following cycle, whatever source
+// yields them. It returns how many URLs changed.
+func (cw *CacheWarmer) RemoveURLs(urls []string) int {
+	cw.controlMutex.Lock()
+	defer cw.controlMutex.Unlock()
+
+	changed := 0
+	for _, u := range urls {
+		if i := indexString(cw.runtime.added, u); i >= 0 {
+			cw.runtime.added = append(cw.runtime.added[:i], cw.runtime.added[i+1:]...)
+			changed++
+			continue
+		}
+		if !cw.runtime.removed[u] {
+			if cw.runtime.removed == nil {
+				cw.runtime.removed = make(map[string]bool)
+			}
+			cw.runtime.removed[u] = true
+			changed++
+		}
+	}
+	if changed > 0 {
+		cw.logger.Info("Removed %d URLs from the following cycles", changed)
+	}
+	return changed
+}
+
+// RuntimeURLs returns the URLs added and removed through the control APIs
+func (cw *CacheWarmer) RuntimeURLs() (added, removed []string) {
+	cw.controlMutex.Lock()
+	defer cw.controlMutex.Unlock()
+
+	added = append([]string(nil), cw.runtime.added...)
+	for u := range cw.runtime.removed {
+		removed = append(removed, u)
+	}
+	return added, removed
+}
+
+// addRuntimeURLs appends the URLs added at runtime to a cycle's URLs
+func (cw *CacheWarmer) addRuntimeURLs(urls []string) []string {
+	cw.controlMutex.Lock()
+	defer cw.controlMutex.Unlock()
+	return append(urls, cw.runtime.added...)
+}
+
+// dropRemovedURLs leaves the URLs removed at runtime out of a cycle's URLs
+func (cw *CacheWarmer) dropRemovedURLs(urls []string) []string {
+	cw.controlMutex.Lock()
+	defer cw.controlMutex.Unlock()
+	if len(cw.runtime.removed) == 0 {
+		return urls
+	}
+
+	kept := urls[:0:0]
+	for _, u := range urls {
+		if !cw.runtime.removed[u] {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+// setCycleRunner lets the control APIs start continuous mode's cycles
+func (cw *CacheWarmer) setCycleRunner(runner *cycleRunner) {
+	cw.controlMutex.Lock()
+	defer cw.controlMutex.Unlock()
+	cw.runner = runner
+}
+
+// StartCycle starts a warming cycle now, subject to the overlap policy the
+// same as a scheduled one. Cycles can only be started in continuous mode,
+// and only on the leader.
+func (cw *CacheWarmer) StartCycle() (string, error) {
+	runner, err := cw.cycleRunner()
+	if err != nil {
+		return "", err
+	}
+
+	cw.logger.Info("Starting a warming cycle on request")
+	return runner.Run(func(ctx context.Context) {
+		cw.WarmCache(ctx)
+	}), nil
+}
+
+// cycleRunner returns the runner cycles are started on, or why this process
+// can't start one
+func (cw *CacheWarmer) cycleRunner() (*cycleRunner, error) {
+	cw.controlMutex.Lock()
+	runner := cw.runner
+	cw.controlMutex.Unlock()
+
+	if runner == nil {
+		return nil, fmt.Errorf("cycles can only be started in continuous mode")
+	}
+	if !cw.IsLeader() {
+		return nil, fmt.Errorf("standing by for the leader, start the cycle there")
+	}
+	return runner, nil
+}
+
+// StopCycles cancels the running cycles of continuous mode and drops the
+// queued one. It returns how many cycles it stopped.
+func (cw *CacheWarmer) StopCycles() (int, error) {
+	cw.controlMutex.Lock()
+	runner := cw.runner
+	cw.controlMutex.Unlock()
+
+	if runner == nil {
+		return 0, fmt.Errorf("cycles can only be stopped in continuous mode")
+	}
+
+	stopped := runner.Cancel()
+	cw.logger.Info("Stopped %d warming cycles on request", stopped)
+	return stopped, nil
+}
+
+// Pause holds every run before its next request, and skips scheduled
+// cycles, until Resume. It returns false if warming was already paused.
+func (cw *CacheWarmer) Pause() bool {
+	cw.pause.mutex.Lock()
+	defer cw.pause.mutex.Unlock()
+	if cw.pause.resumed != nil {
+		return false
+	}
+
+	cw.pause.resumed = make(chan struct{})
+	cw.pause.since = time.Now()
+	cw.logger.Info("Warming paused")
+	return true
+}
+
+// Resume lets paused runs continue. It returns false if warming was not
+// paused.
+func (cw *CacheWarmer) Resume() bool {
+	cw.pause.mutex.Lock()
+	defer cw.pause.mutex.Unlock()
+	if cw.pause.resumed == nil {
+		return false
+	}
+
+	close(cw.pause.resumed)
+	cw.pause.resumed = nil
+	cw.logger.Info("Warming resumed after %v", time.Since(cw.pause.since).Round(time.Second))
+	return true
+}
+
+// Paused reports whether warming is paused, and since when
+func (cw *CacheWarmer) Paused() (bool, time.Time) {
+	cw.pause.mutex.Lock()
+	defer cw.pause.mutex.Unlock()
+	return cw.pause.resumed != nil, cw.pause.since
+}
+
+// ActiveRuns returns how many runs (cycles and triggered warms) are in
+// progress
+func (cw *CacheWarmer) ActiveRuns() int64 {
+	return atomic.LoadInt64(&cw.activeRuns)
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	return indexString(list, s) >= 0
+}
+
+// indexString returns the index of s in list, or -1
+func indexString(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
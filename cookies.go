@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// newCookieJar creates the jar shared by every warm client, holding the
+// preloaded cookies
+func newCookieJar(cc *CookiesConfig) http.CookieJar {
+	// Options with a public suffix list never make New fail
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	for _, cookie := range cc.Preload {
+		// Validate guarantees the URL parses
+		u, err := url.Parse(cookie.URL)
+		if err != nil {
+			continue
+		}
+		jar.SetCookies(u, []*http.Cookie{{Name: cookie.Name, Value: cookie.Value}})
+	}
+	return jar
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxHTMLParseSize caps how much of a page is parsed for links
+const maxHTMLParseSize = 5 << 20
+
+// crawler collects pages and assets discovered while warming HTML pages
+type crawler struct {
+	pageHosts  map[string]bool
+	assetHosts map[string]bool
+	seen       map[string]bool
+	pages      []string
+	assets     []string
+
+	// robots.txt rules by host when they are respected, and the next
+	// request slot of hosts with a Crawl-delay
+	robots      map[string]*robotsRules
+	nextRequest map[string]time.Time
+}
+
+// newCrawler creates a crawler that follows pages on the hosts of the seed
+// URLs and assets on those hosts plus any extra asset hosts
+func newCrawler(seeds []string, assetHosts []string) *crawler {
+	c := &crawler{
+		pageHosts:  make(map[string]bool),
+		assetHosts: make(map[string]bool),
+		seen:       make(map[string]bool),
+	}
+	for _, seed := range seeds {
+		c.seen[seed] = true
+		if parsed, err := url.Parse(seed); err == nil {
+			host := strings.ToLower(parsed.Host)
+			c.pageHosts[host] = true
+			c.assetHosts[host] = true
+		}
+	}
+	for _, host := range assetHosts {
+		c.assetHosts[strings.ToLower(host)] = true
+	}
+	return c
+}
+
+// add appends links on allowed hosts that have not been seen yet to pending
+func (c *crawler) add(pending *[]string, links []string, hosts map[string]bool) {
+	for _, link := range links {
+		if c.seen[link] {
+			continue
+		}
+		parsed, err := url.Parse(link)
+		if err != nil || !hosts[strings.ToLower(parsed.Host)] {
+			continue
+		}
+		c.seen[link] = true
+		*pending = append(*pending, link)
+	}
+}
+
+// nextPages returns up to limit discovered pages and resets the pending list
+func (c *crawler) nextPages(limit int) []string {
+	pages := c.pages
+	c.pages = nil
+	if len(pages) > limit {
+		pages = pages[:limit]
+	}
+	return pages
+}
+
+// nextAssets returns the discovered assets and resets the pending list
+func (c *crawler) nextAssets() []string {
+	assets := c.assets
+	c.assets = nil
+	return assets
+}
+
+// discoverLinks records the links of a warmed HTML document for run r, as enabled by the crawl and assets settings
+func (cw *CacheWarmer) discoverLinks(r *warmRun, links htmlLinks) {
+	r.crawlMutex.Lock()
+	defer r.crawlMutex.Unlock()
+	if r.crawler == nil {
+		return
+	}
+
+	if cw.config.Crawl.Enabled {
+		pages := links.Pages
+		if r.crawler.robots != nil {
+			pages = pages[:0:0]
+			for _, page := range links.Pages {
+				if r.crawler.robotsAllowed(page) {
+					pages = append(pages, page)
+				} else {
+					cw.logger.Debug("Not crawling %s: disallowed by robots.txt", page)
+				}
+			}
+		}
+		r.crawler.add(&r.crawler.pages, pages, r.crawler.pageHosts)
+	}
+	if cw.config.Assets.Enabled {
+		r.crawler.add(&r.crawler.assets, links.Assets, r.crawler.assetHosts)
+	} else if cw.config.Assets.Images {
+		r.crawler.add(&r.crawler.assets, links.Images, r.crawler.assetHosts)
+	}
+}
+
+// parsesHTML reports whether warmed HTML pages need to be parsed for links
+func (cw *CacheWarmer) parsesHTML() bool {
+	return cw.config.Crawl.Enabled || cw.config.Assets.Enabled || cw.config.Assets.Images
+}
+
+// followsLinks reports whether warmed responses lead to more URLs to warm
+func (cw *CacheWarmer) followsLinks() bool {
+	return cw.parsesHTML() || cw.config.Assets.Preload
+}
+
+// followLinks warms the assets of warmed pages and, when crawling, the pages
+// discovered from the seed URLs level by level, up to the configured depth
+// and page limit. It returns false if the run was cancelled.
+func (cw *CacheWarmer) followLinks(ctx context.Context) bool {
+	run := runOf(ctx)
+	remaining := cw.config.Crawl.MaxPages
+
+	for depth := 1; ; depth++ {
+		if !cw.warmAssets(ctx) {
+			return false
+		}
+
+		if !cw.config.Crawl.Enabled || depth > cw.config.Crawl.MaxDepth {
+			return true
+		}
+		if remaining <= 0 {
+			cw.logger.Info("Crawl stopped at the limit of %d pages", cw.config.Crawl.MaxPages)
+			return true
+		}
+
+		run.crawlMutex.Lock()
+		urls := run.crawler.nextPages(remaining)
+		run.crawlMutex.Unlock()
+		if len(urls) == 0 {
+			return true
+		}
+
+		remaining -= len(urls)
+		atomic.AddInt64(&run.stats.CrawledURLs, int64(len(urls)))
+		cw.logger.Info("Crawling %d pages discovered at depth %d", len(urls), depth)
+
+		if !cw.dispatch(ctx, urls) {
+			return false
+		}
+	}
+}
+
+// warmAssets warms the assets referenced by the pages warmed so far
+func (cw *CacheWarmer) warmAssets(ctx context.Context) bool {
+	run := runOf(ctx)
+	run.crawlMutex.Lock()
+	assets := run.crawler.nextAssets()
+	run.crawlMutex.Unlock()
+	if len(assets) == 0 {
+		return true
+	}
+
+	atomic.AddInt64(&run.stats.AssetURLs, int64(len(assets)))
+	cw.logger.Info("Warming %d assets referenced by warmed pages", len(assets))
+	return cw.dispatch(ctx, assets)
+}
@@ -0,0 +1,68 @@
+package main
+
+// CriticalSummary reports the outcome of URLs marked critical in a run,
+// independent of the overall success rate
+type CriticalSummary struct {
+	Requests   int            `json:"requests"`
+	Successful int            `json:"successful"`
+	Failed     []ResultRecord `json:"failed,omitempty"`
+}
+
+// criticalSet returns the URLs marked critical in the config, including
+// those of URL groups
+func criticalSet(config *Config) map[string]bool {
+	urls := config.CriticalURLList()
+	for i := range config.Groups {
+		urls = append(urls, config.Groups[i].criticalURLList()...)
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		set[normalizeURL(&config.Normalize, url)] = true
+	}
+	return set
+}
+
+// GetCriticalSummary returns the outcome of critical URLs among results
+func (cw *CacheWarmer) GetCriticalSummary(results []Result) CriticalSummary {
+	var summary CriticalSummary
+	for _, result := range results {
+		if !result.Critical {
+			continue
+		}
+		summary.Requests++
+		if result.Success {
+			summary.Successful++
+		} else {
+			summary.Failed = append(summary.Failed, result.Record())
+		}
+	}
+	return summary
+}
+
+// reportCritical prints the outcome of critical URLs, logging every failure
+// as an error, and updates the critical gauges on the metrics endpoint
+func (cw *CacheWarmer) reportCritical(results []Result) {
+	s := cw.GetCriticalSummary(results)
+	if cw.metrics != nil {
+		cw.metrics.SetCriticalResults(s.Requests, len(s.Failed))
+	}
+	if s.Requests == 0 {
+		return
+	}
+
+	if len(s.Failed) == 0 {
+		cw.logger.Info("  Critical URLs: %d/%d successful", s.Successful, s.Requests)
+		return
+	}
+	cw.logger.Error("  Critical URLs: %d/%d successful, %d failed", s.Successful, s.Requests, len(s.Failed))
+	for _, record := range s.Failed {
+		via := ""
+		if record.Region != "" {
+			via = " via " + record.Region
+		}
+		cw.logger.Error("    critical URL failed: %s%s: %s", record.URL, via, record.Error)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cycleRunner starts the cycles of continuous mode, applying the overlap
+// policy to ticks that arrive while earlier cycles are still running
+type cycleRunner struct {
+	warmer  *CacheWarmer
+	logger  *Logger
+	policy  string
+	limit   int
+	timeout time.Duration
+
+	mutex   sync.Mutex
+	running int
+	queued  func(context.Context)
+	stopped bool
+	wg      sync.WaitGroup
+
+	// cancels ends the running cycles on Cancel, by start order
+	cancels map[int]context.CancelFunc
+	started int
+}
+
+// newCycleRunner creates a runner for the warmer's cycles
+func newCycleRunner(warmer *CacheWarmer, config *Config, logger *Logger) *cycleRunner {
+	limit := 1
+	if config.OverlapPolicy == OverlapConcurrent {
+		limit = config.MaxConcurrentCycles
+	}
+	return &cycleRunner{
+		warmer:  warmer,
+		logger:  logger,
+		policy:  config.OverlapPolicy,
+		limit:   limit,
+		timeout: config.CycleTimeout,
+	}
+}
+
+// Run starts cycle in the background, bounded by the cycle timeout, unless
+// the overlap policy says to skip or queue it, the latter after cancelling
+// the running cycles with policy cancel. It returns which it did.
+func (r *cycleRunner) Run(cycle func(context.Context)) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return CycleSkipped
+	}
+	if r.running < r.limit {
+		r.start(cycle)
+		return CycleStarted
+	}
+
+	// The newest tick wins over the running cycles, which stop promptly
+	if r.policy == OverlapCancel {
+		r.logger.Warn("Previous cycle still running, cancelling it for the next one")
+		for _, cancel := range r.cancels {
+			cancel()
+		}
+		r.queued = cycle
+		if r.warmer.metrics != nil {
+			r.warmer.metrics.RecordCancelledCycles(len(r.cancels))
+		}
+		if r.warmer.statsd != nil {
+			r.warmer.statsd.Count("cycles.cancelled", int64(len(r.cancels)))
+		}
+		return CycleQueued
+	}
+
+	queue := r.policy == OverlapQueue && r.queued == nil
+	switch {
+	case queue:
+		r.queued = cycle
+		r.logger.Warn("Previous cycle still running, queuing the next one")
+	case r.policy == OverlapQueue:
+		r.logger.Warn("Skipping scheduled cycle, one is already queued")
+	default:
+		r.logger.Warn("Skipping scheduled cycle, %d still running (overlap_policy: %s)", r.running, r.policy)
+	}
+	if r.warmer.metrics != nil {
+		r.warmer.metrics.RecordOverlap(queue)
+	}
+	if r.warmer.statsd != nil {
+		if queue {
+			r.warmer.statsd.Count("cycles.queued", 1)
+		} else {
+			r.warmer.statsd.Count("cycles.skipped", 1)
+		}
+	}
+	if queue {
+		return CycleQueued
+	}
+	return CycleSkipped
+}
+
+// start runs cycle in a goroutine, then the queued cycle if there is one.
+// The mutex must be held.
+func (r *cycleRunner) start(cycle func(context.Context)) {
+	r.running++
+	r.started++
+	id := r.started
+	ctx, cancel := cycleContext(r.timeout)
+	if r.cancels == nil {
+		r.cancels = make(map[int]context.CancelFunc)
+	}
+	r.cancels[id] = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		cycle(ctx)
+		cancel()
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.running--
+		delete(r.cancels, id)
+		if next := r.queued; next != nil && !r.stopped {
+			r.queued = nil
+			r.start(next)
+		}
+	}()
+}
+
+// Stop drops any queued cycle and starts no more
+func (r *cycleRunner) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stopped = true
+	r.queued = nil
+}
+
+// Cancel stops the running cycles and drops the queued one, but keeps
+// starting the following cycles. It returns how many cycles it stopped.
+func (r *cycleRunner) Cancel() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, cancel := range r.cancels {
+		cancel()
+	}
+	r.queued = nil
+	return len(r.cancels)
+}
+
+// Wait waits for the running cycles to return
+func (r *cycleRunner) Wait() {
+	r.wg.Wait()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// daemon runs continuous mode as a system service. It holds the PID file
+// against a second instance and reports its state to systemd through the
+// sd_notify protocol when started by a Type=notify unit.
+type daemon struct {
+	logger *Logger
+	pid    *pidFile
+
+	// done stops the watchdog keepalive
+	done chan struct{}
+}
+
+// startDaemon takes the PID file, if one is set, and starts sending
+// watchdog keepalives if systemd asked for them
+func startDaemon(pidPath string, logger *Logger) (*daemon, error) {
+	d := &daemon{logger: logger, done: make(chan struct{})}
+
+	if pidPath != "" {
+		pid, err := acquirePIDFile(pidPath)
+		if err != nil {
+			return nil, err
+		}
+		d.pid = pid
+	}
+
+	if interval := sdWatchdogInterval(); interval > 0 {
+		logger.Info("Sending systemd watchdog keepalives every %v", interval)
+		go d.keepalive(interval)
+	}
+	return d, nil
+}
+
+// Ready tells systemd that startup has finished
+func (d *daemon) Ready(status string) {
+	d.notify("READY=1\nSTATUS=" + status)
+}
+
+// Stopping tells systemd that shutdown has begun
+func (d *daemon) Stopping() {
+	d.notify("STOPPING=1\nSTATUS=Shutting down")
+}
+
+// Close stops the keepalives and removes the PID file
+func (d *daemon) Close() {
+	close(d.done)
+	if d.pid != nil {
+		if err := d.pid.Remove(); err != nil {
+			d.logger.Warn("%v", err)
+		}
+	}
+}
+
+// keepalive pings the systemd watchdog until Close
+func (d *daemon) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.notify("WATCHDOG=1")
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// notify sends a state change to systemd, logging rather than failing if
+// the notification socket is unreachable
+func (d *daemon) notify(state string) {
+	if err := sdNotify(state); err != nil {
+		d.logger.Warn("Failed to notify systemd: %v", err)
+	}
+}
+
+// sdNotify sends state to the socket in $NOTIFY_SOCKET. It does nothing
+// when the process was not started by systemd with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Names starting with @ are in the abstract namespace, which net
+	// handles itself
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", socket, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to write to %s: %v", socket, err)
+	}
+	return nil
+}
+
+// sdWatchdogInterval returns how often to ping the systemd watchdog (half
+// of WatchdogSec), or 0 if it is not enabled for this process
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// pidFile is a PID file written by this process
+type pidFile struct {
+	path string
+}
+
+// acquirePIDFile writes the process's PID to path. It fails if the file
+// names another running process, and replaces it if that process is gone.
+func acquirePIDFile(path string) (*pidFile, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		// O_EXCL makes two instances starting at once race safely
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write PID file %s: %v", path, err)
+			}
+			return &pidFile{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create PID file %s: %v", path, err)
+		}
+
+		// A PID naming this process was left by an earlier run (e.g. as
+		// PID 1 of a restarted container)
+		pid, err := readPIDFile(path)
+		if err == nil && pid != os.Getpid() && processAlive(pid) {
+			return nil, fmt.Errorf("already running as PID %d (%s)", pid, path)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove stale PID file %s: %v", path, err)
+		}
+	}
+	return nil, fmt.Errorf("PID file %s was taken by another instance starting at the same time", path)
+}
+
+// Remove deletes the PID file, unless another instance has replaced it
+func (p *pidFile) Remove() error {
+	if pid, err := readPIDFile(p.path); err != nil || pid != os.Getpid() {
+		return nil
+	}
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove PID file: %v", err)
+	}
+	return nil
+}
+
+// readPIDFile returns the PID in the file at path
+func readPIDFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// processAlive reports whether a process with the given PID exists. A
+// process owned by another user still counts.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// deployEvent is a finished deployment or a published release, normalized
+// from a GitHub or GitLab webhook payload
+type deployEvent struct {
+	// Kind is deployment or release
+	Kind string
+
+	// Environment is the environment deployed to, empty for releases
+	Environment string
+
+	// Ref is the deployed branch, tag or commit, or the release tag
+	Ref string
+}
+
+// deployVerifier checks that a deploy webhook was sent by the forge
+type deployVerifier func(config *DeployConfig, r *http.Request, body []byte) bool
+
+// deployParser extracts a deploy event from a webhook request. A nil event
+// with a nil error means the payload is not one that triggers warming.
+type deployParser func(r *http.Request, body []byte) (*deployEvent, error)
+
+// deployHandler returns a handler that verifies and parses a deploy webhook,
+// then purges the CDN and starts a full cycle
+func (ws *WebhookServer) deployHandler(source string, verify deployVerifier, parse deployParser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		if !verify(&ws.config.Deploy, r, body) {
+			ws.logger.Warn("Rejected %s webhook from %s: verification failed", source, r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		event, err := parse(r, body)
+		if err != nil {
+			ws.logger.Warn("Invalid %s webhook payload: %v", source, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if event == nil || !ws.deploysTo(event) {
+			ws.logger.Debug("Ignoring %s webhook: not a finished deployment or release", source)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ignored"})
+			return
+		}
+
+		// Only purge if a cycle can follow
+		if _, err := ws.warmer.cycleRunner(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if event.Environment != "" {
+			ws.logger.Info("Received %s %s of %s to %s, purging and warming", source, event.Kind, event.Ref, event.Environment)
+		} else {
+			ws.logger.Info("Received %s %s %s, purging and warming", source, event.Kind, event.Ref)
+		}
+
+		go ws.purgeAndWarm()
+
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "accepted", "event": event.Kind, "ref": event.Ref})
+	}
+}
+
+// deploysTo reports whether event warms: releases always do, deployments
+// only to the configured environments
+func (ws *WebhookServer) deploysTo(event *deployEvent) bool {
+	environments := ws.config.Deploy.Environments
+	return event.Kind != "deployment" || len(environments) == 0 || containsString(environments, event.Environment)
+}
+
+// purgeAndWarm purges the configured CDN, waits for the purge to take
+// effect, then starts a full cycle. A failed purge is logged and the cycle
+// still runs.
+func (ws *WebhookServer) purgeAndWarm() {
+	ctx := ws.warmer.ctx
+	config := ws.warmer.config
+
+	var err error
+	switch ws.config.Deploy.Purge {
+	case PurgeFastly:
+		err = purgeFastlyService(ctx, &ws.config.Fastly)
+		if err == nil {
+			ws.logger.Info("Purged Fastly service %s, warming in %v", ws.config.Fastly.ServiceID, ws.config.Fastly.Delay)
+			select {
+			case <-time.After(ws.config.Fastly.Delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	case PurgeCloudFront:
+		err = invalidateAndWait(ctx, &config.CloudFront, config.CloudFront.Paths, ws.logger)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		ws.logger.Error("Purge before warming failed, warming anyway: %v", err)
+	}
+
+	status, err := ws.warmer.StartCycle()
+	if err != nil {
+		ws.logger.Error("Failed to start the cycle after the deploy: %v", err)
+		return
+	}
+	if status != CycleStarted {
+		ws.logger.Warn("The cycle after the deploy was %s (overlap_policy: %s)", status, config.OverlapPolicy)
+	}
+}
+
+// invalidateAndWait invalidates paths on the distribution and waits until
+// CloudFront has completed the invalidation, up to its timeout
+func invalidateAndWait(ctx context.Context, config *CloudFrontConfig, paths []string, logger *Logger) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	inv, err := createInvalidation(ctx, config, paths)
+	if err != nil {
+		return err
+	}
+	logger.Info("Created invalidation %s for %d paths on distribution %s, waiting for it to complete",
+		inv.ID, len(paths), config.DistributionID)
+	if inv.Status == "Completed" {
+		return nil
+	}
+	return waitForInvalidation(ctx, config, inv.ID, logger)
+}
+
+// verifyGitHubSignature checks the X-Hub-Signature-256 header, an HMAC of
+// the body keyed with the webhook secret
+func verifyGitHubSignature(config *DeployConfig, r *http.Request, body []byte) bool {
+	signature, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok {
+		return false
+	}
+	provided, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(config.GitHubSecret))
+	mac.Write(body)
+	return hmac.Equal(provided, mac.Sum(nil))
+}
+
+// verifyGitLabToken checks the X-Gitlab-Token header against the token
+func verifyGitLabToken(config *DeployConfig, r *http.Request, body []byte) bool {
+	provided := r.Header.Get("X-Gitlab-Token")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(config.GitLabToken)) == 1
+}
+
+// parseGitHubDeploy handles deployment_status events that succeeded and
+// release events that published a release
+func parseGitHubDeploy(r *http.Request, body []byte) (*deployEvent, error) {
+	var payload struct {
+		Action           string `json:"action"`
+		DeploymentStatus struct {
+			State       string `json:"state"`
+			Environment string `json:"environment"`
+		} `json:"deployment_status"`
+		Deployment struct {
+			Environment string `json:"environment"`
+			Ref         string `json:"ref"`
+			SHA         string `json:"sha"`
+		} `json:"deployment"`
+		Release struct {
+			TagName string `json:"tag_name"`
+		} `json:"release"`
+	}
+
+	switch r.Header.Get("X-GitHub-Event") {
+	case "deployment_status":
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("failed to parse deployment_status payload: %v", err)
+		}
+		if payload.DeploymentStatus.State != "success" {
+			return nil, nil
+		}
+		return &deployEvent{
+			Kind:        "deployment",
+			Environment: firstNonEmpty(payload.DeploymentStatus.Environment, payload.Deployment.Environment),
+			Ref:         firstNonEmpty(payload.Deployment.Ref, payload.Deployment.SHA),
+		}, nil
+	case "release":
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, fmt.Errorf("failed to parse release payload: %v", err)
+		}
+		if payload.Action != "published" {
+			return nil, nil
+		}
+		return &deployEvent{Kind: "release", Ref: payload.Release.TagName}, nil
+	}
+	return nil, nil
+}
+
+// parseGitLabDeploy handles Deployment Hook events that succeeded and
+// Release Hook events that created a release
+func parseGitLabDeploy(r *http.Request, body []byte) (*deployEvent, error) {
+	var payload struct {
+		ObjectKind  string `json:"object_kind"`
+		Status      string `json:"status"`
+		Action      string `json:"action"`
+		Environment string `json:"environment"`
+		Ref         string `json:"ref"`
+		Tag         string `json:"tag"`
+	}
+
+	switch r.Header.Get("X-Gitlab-Event") {
+	case "Deployment Hook", "Release Hook":
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse %s payload: %v", r.Header.Get("X-Gitlab-Event"), err)
+	}
+
+	switch {
+	case payload.ObjectKind == "deployment" && payload.Status == "success":
+		return &deployEvent{Kind: "deployment", Environment: payload.Environment, Ref: payload.Ref}, nil
+	case payload.ObjectKind == "release" && payload.Action == "create":
+		return &deployEvent{Kind: "release", Ref: payload.Tag}, nil
+	}
+	return nil, nil
+}
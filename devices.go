@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// deviceUserAgents are the User-Agents of the built-in device names, to which
+// the configured user_agent is appended so the warmer stays identifiable
+var deviceUserAgents = map[string]string{
+	"desktop": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+	"mobile":  "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+	"tablet":  "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+	"bot":     "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+}
+
+// userAgent returns the device's User-Agent: its own, or the built-in one for
+// its name followed by base
+func (d *DeviceConfig) userAgent(base string) string {
+	if d.UserAgent != "" {
+		return d.UserAgent
+	}
+	return deviceUserAgents[d.Name] + " " + base
+}
+
+// deviceSet returns the configured devices by name
+func deviceSet(config *Config) map[string]*DeviceConfig {
+	if len(config.Devices) == 0 {
+		return nil
+	}
+	set := make(map[string]*DeviceConfig, len(config.Devices))
+	for i := range config.Devices {
+		set[config.Devices[i].Name] = &config.Devices[i]
+	}
+	return set
+}
+
+// jobDevices returns the devices every URL is warmed as, or a single empty
+// name to warm it once with the global User-Agent
+func (cw *CacheWarmer) jobDevices() []string {
+	if len(cw.config.Devices) == 0 {
+		return []string{""}
+	}
+	names := make([]string, len(cw.config.Devices))
+	for i, device := range cw.config.Devices {
+		names[i] = device.Name
+	}
+	return names
+}
+
+// applyDevice sets the User-Agent and headers of the named device on req
+func (cw *CacheWarmer) applyDevice(req *http.Request, name string) {
+	device := cw.devices[name]
+	if device == nil {
+		return
+	}
+	req.Header.Set("User-Agent", device.userAgent(cw.config.UserAgent))
+	for key, value := range device.Headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// GetDeviceSummaries returns per-device aggregates of results, in
+// configuration order, with the device as the summary's name
+func (cw *CacheWarmer) GetDeviceSummaries(results []Result) []RegionSummary {
+	index := make(map[string]int)
+	summaries := make([]RegionSummary, 0, len(cw.config.Devices))
+	for i, device := range cw.config.Devices {
+		index[device.Name] = i
+		summaries = append(summaries, RegionSummary{Name: device.Name})
+	}
+
+	for _, result := range results {
+		i, ok := index[result.Device]
+		if !ok {
+			continue
+		}
+		s := &summaries[i]
+		s.Requests++
+		s.TotalDuration += result.Duration
+		if result.Success {
+			s.Successes++
+		}
+		switch result.CacheStatus {
+		case CacheStatusHit:
+			s.Hits++
+		case CacheStatusMiss:
+			s.Misses++
+		}
+	}
+
+	return summaries
+}
+
+// printDeviceComparison prints per-device latency and hit rate side by side
+func (cw *CacheWarmer) printDeviceComparison(results []Result) {
+	cw.logger.Info("  Device comparison:")
+	cw.logger.Info("    %-16s %8s %8s %8s %12s", "DEVICE", "REQUESTS", "SUCCESS", "HIT RATE", "AVG LATENCY")
+	for _, s := range cw.GetDeviceSummaries(results) {
+		cw.logger.Info("    %-16s %8d %8d %7.1f%% %12v",
+			s.Name, s.Requests, s.Successes, s.HitRate(), s.AverageDuration().Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// hostResolver resolves hosts from the static hosts map first, then through
+// the configured name servers or the system resolver
+type hostResolver struct {
+	hosts    map[string]string
+	resolver *net.Resolver
+}
+
+// newHostResolver creates the resolver for the dns options
+func newHostResolver(config *DNSConfig) *hostResolver {
+	r := &hostResolver{
+		hosts:    make(map[string]string, len(config.Hosts)),
+		resolver: net.DefaultResolver,
+	}
+	for host, ip := range config.Hosts {
+		r.hosts[normalizeHost(host)] = ip
+	}
+	if len(config.Servers) > 0 {
+		r.resolver = nameServerResolver(config.Servers)
+	}
+	return r
+}
+
+// nameServerResolver returns a resolver that queries servers in turn instead
+// of those in the system configuration
+func nameServerResolver(servers []string) *net.Resolver {
+	addrs := make([]string, len(servers))
+	for i, server := range servers {
+		addrs[i] = nameServerAddr(server)
+	}
+
+	var next uint64
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			// Retries of a query go to the next server
+			i := atomic.AddUint64(&next, 1) - 1
+			return dialer.DialContext(ctx, network, addrs[i%uint64(len(addrs))])
+		},
+	}
+}
+
+// nameServerAddr adds the DNS port to a server without one
+func nameServerAddr(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, "53")
+}
+
+// normalizeHost lowercases host and drops a trailing dot
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// LookupIPAddr returns the addresses of host
+func (r *hostResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if ip, ok := r.hosts[normalizeHost(host)]; ok {
+		return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+	}
+	return r.resolver.LookupIPAddr(ctx, host)
+}
+
+// DialContext connects to addr, resolving its host with the resolver
+func (r *hostResolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := *dialer
+	d.Resolver = r.resolver
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if ip, ok := r.hosts[normalizeHost(host)]; ok {
+			addr = net.JoinHostPort(ip, port)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Failure classes reported in results, run reports and metrics
+const (
+	ErrorClassTimeout    = "timeout"
+	ErrorClassDNS        = "dns"
+	ErrorClassTLS        = "tls"
+	ErrorClassConnection = "connection"
+	ErrorClassProxy      = "proxy"
+	ErrorClassStatus     = "status"
+	ErrorClassAssertion  = "assertion"
+	ErrorClassOther      = "other"
+)
+
+// transportError is the common part of errors raised while talking to the
+// origin; Op describes what failed (e.g. "request failed")
+type transportError struct {
+	Op  string
+	Err error
+}
+
+func (e *transportError) Error() string { return fmt.Sprintf("%s: %v", e.Op, e.Err) }
+func (e *transportError) Unwrap() error { return e.Err }
+
+// TimeoutError reports a request or body read that exceeded its deadline
+type TimeoutError struct{ transportError }
+
+// DNSError reports a failure to resolve the origin's hostname
+type DNSError struct{ transportError }
+
+// TLSError reports a failed TLS handshake or certificate verification
+type TLSError struct{ transportError }
+
+// ConnectionError reports a refused, reset or otherwise broken connection
+type ConnectionError struct{ transportError }
+
+// ProxyError reports a forward proxy that could not be reached or refused to
+// carry the request
+type ProxyError struct{ transportError }
+
+// proxyConnectError is a forward proxy's non-200 answer to CONNECT
+type proxyConnectError struct {
+	Proxy  string
+	Status string
+}
+
+func (e *proxyConnectError) Error() string {
+	return fmt.Sprintf("proxy %s refused CONNECT: %s", e.Proxy, e.Status)
+}
+
+// StatusCodeError reports a response whose status is not a success code
+type StatusCodeError struct {
+	StatusCode int
+}
+
+func (e *StatusCodeError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// AssertionError reports a response that arrived but failed a check on its
+// headers, body or timing
+type AssertionError struct {
+	Check   string
+	Message string
+}
+
+func (e *AssertionError) Error() string {
+	return fmt.Sprintf("%s check failed: %s", e.Check, e.Message)
+}
+
+// classifyTransportError wraps an error from the HTTP client or a body read
+// in the typed error matching its cause
+func classifyTransportError(op string, err error) error {
+	base := transportError{Op: op, Err: err}
+
+	var opErr *net.OpError
+	var connectErr *proxyConnectError
+	if (errors.As(err, &opErr) && (opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks "))) ||
+		errors.As(err, &connectErr) {
+		return &ProxyError{base}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &TimeoutError{base}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &DNSError{base}
+	}
+
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &recordErr) || errors.As(err, &verifyErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return &TLSError{base}
+	}
+
+	return &ConnectionError{base}
+}
+
+// ErrorClass returns the failure class of an error, or "" for nil
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var (
+		proxyErr      *ProxyError
+		timeoutErr    *TimeoutError
+		dnsErr        *DNSError
+		tlsErr        *TLSError
+		connectionErr *ConnectionError
+		statusErr     *StatusCodeError
+		assertionErr  *AssertionError
+	)
+	switch {
+	case errors.As(err, &proxyErr):
+		return ErrorClassProxy
+	case errors.As(err, &timeoutErr):
+		return ErrorClassTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.As(err, &tlsErr):
+		return ErrorClassTLS
+	case errors.As(err, &connectionErr):
+		return ErrorClassConnection
+	case errors.As(err, &statusErr):
+		return ErrorClassStatus
+	case errors.As(err, &assertionErr):
+		return ErrorClassAssertion
+	default:
+		return ErrorClassOther
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FailuresPath is where the failed URLs of the last cycle are served on the
+// metrics server
+const FailuresPath = "/failures"
+
+// failedURLs returns the distinct URLs that failed in any region, in the
+// order they were warmed
+func failedURLs(results []Result) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	for _, result := range results {
+		if result.Success || seen[result.URL] {
+			continue
+		}
+		seen[result.URL] = true
+		urls = append(urls, result.URL)
+	}
+	return urls
+}
+
+// formatFailures renders the failed URLs of a run one per line, after a
+// comment naming the run, as read by -urls-file
+func formatFailures(urls []string, runID string, at time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %d URLs failed in run %s at %s\n", len(urls), runID, at.Format(time.RFC3339))
+	for _, url := range urls {
+		buf.WriteString(url)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// recordFailures keeps the URLs that failed this cycle for the failures
+// endpoint and writes them to the failures file if one is configured
+func (cw *CacheWarmer) recordFailures(runID string, results []Result) {
+	data := formatFailures(failedURLs(results), runID, time.Now())
+
+	cw.failuresMutex.Lock()
+	cw.failures = data
+	cw.failuresMutex.Unlock()
+
+	if cw.config.FailuresFile == "" {
+		return
+	}
+	if err := writeFileAtomic(cw.config.FailuresFile, data); err != nil {
+		cw.logger.Error("Failed to write failures file: %v", err)
+	}
+}
+
+// failuresHandler serves the URLs that failed in the last completed cycle
+func (cw *CacheWarmer) failuresHandler(w http.ResponseWriter, r *http.Request) {
+	cw.failuresMutex.Lock()
+	data := cw.failures
+	cw.failuresMutex.Unlock()
+
+	if data == nil {
+		http.Error(w, "no cycle has completed yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+// writeFileAtomic replaces path with data, so readers never see a partial
+// file
+func writeFileAtomic(path string, data []byte) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %v", err)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return nil
+}
+
+// readURLsFile reads one URL per line, ignoring blank lines and # comments
+func readURLsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open URL file: %v", err)
+	}
+	defer f.Close()
+
+	var urls []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read URL file: %v", err)
+	}
+	return urls, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// fastlyMaxKeys is the most surrogate keys Fastly purges in one call
+const fastlyMaxKeys = 256
+
+// validate checks the Fastly purge settings, if a service is configured
+func (f *FastlyConfig) validate() error {
+	if f.ServiceID == "" {
+		if len(f.Keys) > 0 {
+			return fmt.Errorf("keys require a service_id")
+		}
+		return nil
+	}
+
+	switch {
+	case f.APIToken == "" && f.APITokenEnv == "":
+		return fmt.Errorf("api_token or api_token_env is required")
+	case f.APIToken != "" && f.APITokenEnv != "":
+		return fmt.Errorf("set api_token or api_token_env, not both")
+	case f.APITokenEnv != "" && os.Getenv(f.APITokenEnv) == "":
+		return fmt.Errorf("environment variable %s is not set", f.APITokenEnv)
+	case f.Delay < 0:
+		return fmt.Errorf("delay must be non-negative, got %v", f.Delay)
+	}
+
+	if u, err := url.Parse(f.APIURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("api_url must be an absolute http or https URL, got %q", f.APIURL)
+	}
+
+	for key, urls := range f.Keys {
+		if !validSurrogateKey(key) {
+			return fmt.Errorf("invalid surrogate key %q", key)
+		}
+		for _, u := range urls {
+			if err := ValidateURL(u); err != nil {
+				return fmt.Errorf("key %s: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// apiToken returns the configured API token, or the one in APITokenEnv
+func (f *FastlyConfig) apiToken() string {
+	if f.APITokenEnv != "" {
+		return os.Getenv(f.APITokenEnv)
+	}
+	return f.APIToken
+}
+
+// validSurrogateKey reports whether key can be sent in a Surrogate-Key
+// header, which separates keys with spaces
+func validSurrogateKey(key string) bool {
+	return key != "" && !strings.ContainsAny(key, " \t\r\n")
+}
+
+// fastlyHandler purges the surrogate keys of a {"keys": [...]} payload and
+// warms the URLs mapped to them once the purge has had time to spread
+func (ws *WebhookServer) fastlyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !ws.authorized(r) {
+		ws.logger.Warn("Rejected Fastly purge from %s: invalid secret", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var payload struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBody)).Decode(&payload); err != nil {
+		http.Error(w, fmt.Sprintf("failed to parse purge payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(payload.Keys) == 0 || len(payload.Keys) > fastlyMaxKeys {
+		http.Error(w, fmt.Sprintf("between 1 and %d keys are required", fastlyMaxKeys), http.StatusBadRequest)
+		return
+	}
+	for _, key := range payload.Keys {
+		if !validSurrogateKey(key) {
+			http.Error(w, fmt.Sprintf("invalid surrogate key %q", key), http.StatusBadRequest)
+			return
+		}
+	}
+
+	config := &ws.config.Fastly
+	if err := purgeSurrogateKeys(r.Context(), config, payload.Keys); err != nil {
+		ws.logger.Error("Fastly purge of %s failed: %v", strings.Join(payload.Keys, ", "), err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	urls := keyURLs(config, payload.Keys)
+	ws.logger.Info("Purged surrogate keys %s from Fastly, warming %d URLs in %v",
+		strings.Join(payload.Keys, ", "), len(urls), config.Delay)
+
+	if len(urls) > 0 {
+		go func() {
+			select {
+			case <-time.After(config.Delay):
+				ws.warmer.WarmURLs(context.Background(), urls)
+			case <-ws.warmer.ctx.Done():
+			}
+		}()
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "accepted", "keys": payload.Keys, "urls": urls})
+}
+
+// keyURLs returns the distinct URLs mapped to keys
+func keyURLs(config *FastlyConfig, keys []string) []string {
+	seen := make(map[string]bool)
+	urls := []string{}
+	for _, key := range keys {
+		for _, u := range config.Keys[key] {
+			if !seen[u] {
+				seen[u] = true
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
+
+// purgeSurrogateKeys purges keys from the service with one batch call to
+// the Fastly API
+func purgeSurrogateKeys(ctx context.Context, config *FastlyConfig, keys []string) error {
+	return fastlyPurge(ctx, config, "purge", keys)
+}
+
+// purgeFastlyService purges every object of the service. Fastly can't soft
+// purge a whole service.
+func purgeFastlyService(ctx context.Context, config *FastlyConfig) error {
+	return fastlyPurge(ctx, config, "purge_all", nil)
+}
+
+// fastlyPurge sends a purge call for the service to the Fastly API, with
+// the surrogate keys to purge if any
+func fastlyPurge(ctx context.Context, config *FastlyConfig, action string, keys []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("%s/service/%s/%s", strings.TrimSuffix(config.APIURL, "/"), url.PathEscape(config.ServiceID), action)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create purge request: %v", err)
+	}
+	req.Header.Set("Fastly-Key", config.apiToken())
+	req.Header.Set("Accept", "application/json")
+	if len(keys) > 0 {
+		req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+		if config.SoftPurge {
+			req.Header.Set("Fastly-Soft-Purge", "1")
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("purge request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("purge API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FleetPath is where the aggregator serves the fleet endpoint on the metrics
+// server
+const FleetPath = "/fleet"
+
+// fleetPushTimeout bounds how long sending a cycle summary may take
+const fleetPushTimeout = 10 * time.Second
+
+// maxFleetPushSize caps the size of a pushed cycle summary
+const maxFleetPushSize = 10 << 20
+
+// FleetPush is the cycle summary an instance sends to the aggregator
+type FleetPush struct {
+	Instance string    `json:"instance"`
+	Report   RunReport `json:"report"`
+}
+
+// FleetMember is the last cycle summary received from one instance
+type FleetMember struct {
+	Instance   string    `json:"instance"`
+	ReceivedAt time.Time `json:"received_at"`
+	Stale      bool      `json:"stale,omitempty"`
+	Report     RunReport `json:"report"`
+}
+
+// FleetReport merges the last cycle of every instance into one view. Stale
+// instances are listed but left out of the totals.
+type FleetReport struct {
+	GeneratedAt      time.Time       `json:"generated_at"`
+	Instances        int             `json:"instances"`
+	StaleInstances   int             `json:"stale_instances"`
+	Total            int64           `json:"total_requests"`
+	Successful       int64           `json:"successful"`
+	Failed           int64           `json:"failed"`
+	SuccessRate      float64         `json:"success_rate"`
+	Failures         map[string]int  `json:"failure_classes,omitempty"`
+	CriticalRequests int             `json:"critical_requests"`
+	CriticalFailures int             `json:"critical_failures"`
+	Regions          []RegionSummary `json:"regions,omitempty"`
+	Members          []FleetMember   `json:"members"`
+}
+
+// fleetAggregator keeps the last cycle summary of every instance that
+// pushes to it
+type fleetAggregator struct {
+	config *FleetConfig
+	logger *Logger
+
+	mutex   sync.Mutex
+	members map[string]FleetMember
+}
+
+// newFleetAggregator creates an aggregator with no members
+func newFleetAggregator(config *FleetConfig, logger *Logger) *fleetAggregator {
+	return &fleetAggregator{
+		config:  config,
+		logger:  logger,
+		members: make(map[string]FleetMember),
+	}
+}
+
+// Record stores an instance's cycle summary, replacing its previous one
+func (a *fleetAggregator) Record(push FleetPush) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.members[push.Instance] = FleetMember{
+		Instance:   push.Instance,
+		ReceivedAt: time.Now(),
+		Report:     push.Report,
+	}
+}
+
+// Report merges the stored summaries into the fleet-wide view
+func (a *fleetAggregator) Report() FleetReport {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	now := time.Now()
+	report := FleetReport{
+		GeneratedAt: now,
+		Members:     make([]FleetMember, 0, len(a.members)),
+	}
+	for _, member := range a.members {
+		member.Stale = now.Sub(member.ReceivedAt) > a.config.StaleAfter
+		report.Members = append(report.Members, member)
+	}
+	sort.Slice(report.Members, func(i, j int) bool {
+		return report.Members[i].Instance < report.Members[j].Instance
+	})
+
+	regions := make(map[string]int)
+	for _, member := range report.Members {
+		report.Instances++
+		if member.Stale {
+			report.StaleInstances++
+			continue
+		}
+
+		r := member.Report
+		report.Total += r.Total
+		report.Successful += r.Successful
+		report.Failed += r.Failed
+		for class, count := range r.Failures {
+			if report.Failures == nil {
+				report.Failures = make(map[string]int)
+			}
+			report.Failures[class] += count
+		}
+		if r.Critical != nil {
+			report.CriticalRequests += r.Critical.Requests
+			report.CriticalFailures += len(r.Critical.Failed)
+		}
+		for _, s := range r.Regions {
+			i, ok := regions[s.Name]
+			if !ok {
+				i = len(report.Regions)
+				regions[s.Name] = i
+				report.Regions = append(report.Regions, RegionSummary{Name: s.Name})
+			}
+			merged := &report.Regions[i]
+			merged.Requests += s.Requests
+			merged.Successes += s.Successes
+			merged.Hits += s.Hits
+			merged.Misses += s.Misses
+			merged.TotalDuration += s.TotalDuration
+		}
+	}
+	if report.Total > 0 {
+		report.SuccessRate = float64(report.Successful) / float64(report.Total) * 100
+	}
+	return report
+}
+
+// handler accepts pushed summaries with POST and serves the fleet report
+// with GET
+func (a *fleetAggregator) handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, a.Report())
+	case http.MethodPost:
+		if !a.authorized(r) {
+			a.logger.Warn("Rejected fleet summary from %s: invalid token", r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var push FleetPush
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxFleetPushSize)).Decode(&push); err != nil {
+			http.Error(w, fmt.Sprintf("invalid fleet summary: %v", err), http.StatusBadRequest)
+			return
+		}
+		if push.Instance == "" {
+			http.Error(w, "fleet summary has no instance", http.StatusBadRequest)
+			return
+		}
+
+		a.Record(push)
+		a.logger.Debug("Received cycle summary %s from %s", push.Report.RunID, push.Instance)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized checks the bearer token, if one is configured
+func (a *fleetAggregator) authorized(r *http.Request) bool {
+	if a.config.Token == "" {
+		return true
+	}
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(a.config.Token)) == 1
+}
+
+// fleetSummary returns the report of run r without per-URL results, which
+// the fleet view does not need
+func (cw *CacheWarmer) fleetSummary(r *warmRun) FleetPush {
+	report := cw.buildRunReport(r, r.Results(0))
+	report.Results = nil
+	return FleetPush{Instance: cw.config.Fleet.Instance, Report: report}
+}
+
+// publishFleetSummary records the cycle summary with the local aggregator
+// and pushes it to the configured one
+func (cw *CacheWarmer) publishFleetSummary(r *warmRun) {
+	push := cw.fleetSummary(r)
+
+	if cw.fleet != nil {
+		cw.fleet.Record(push)
+	}
+	if cw.config.Fleet.Push == "" {
+		return
+	}
+
+	if err := cw.pushFleetSummary(push); err != nil {
+		cw.logger.Error("Failed to push cycle summary to %s: %v", cw.config.Fleet.Push, err)
+		return
+	}
+	cw.logger.Debug("Pushed cycle summary to %s", cw.config.Fleet.Push)
+}
+
+// pushFleetSummary sends a cycle summary to the aggregator
+func (cw *CacheWarmer) pushFleetSummary(push FleetPush) error {
+	body, err := json.Marshal(push)
+	if err != nil {
+		return fmt.Errorf("failed to encode cycle summary: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), fleetPushTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cw.config.Fleet.Push, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cw.config.Fleet.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cw.config.Fleet.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
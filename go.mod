@@ -1,3 +1,8 @@
 module cache-warmer
 
 go 1.25.0
+
+require (
+	golang.org/x/net v0.44.0
+	gopkg.in/yaml.v2 v2.4.0
+)
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleMetadataTokenURL is the GCE/GKE metadata server token endpoint
+const googleMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// googleServiceAccount is the subset of a service account key file we need
+type googleServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// googleTokenSource obtains OAuth2 access tokens for Google APIs
+type googleTokenSource struct {
+	scope   string
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newGoogleTokenSource creates a token source for the given OAuth2 scope
+func newGoogleTokenSource(scope string) *googleTokenSource {
+	return &googleTokenSource{scope: scope}
+}
+
+// Token returns a cached access token, refreshing it when close to expiry.
+// Tokens come from GOOGLE_OAUTH_ACCESS_TOKEN, a service account key file in
+// GOOGLE_APPLICATION_CREDENTIALS, or the metadata server, in that order.
+func (ts *googleTokenSource) Token(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.token != "" && time.Until(ts.expires) > time.Minute {
+		return ts.token, nil
+	}
+
+	var token string
+	var expiresIn int64
+	var err error
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		token, expiresIn, err = ts.serviceAccountToken(ctx, path)
+	} else {
+		token, expiresIn, err = ts.metadataToken(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ts.token = token
+	ts.expires = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	return ts.token, nil
+}
+
+// serviceAccountToken exchanges a signed JWT assertion for an access token
+func (ts *googleTokenSource) serviceAccountToken(ctx context.Context, path string) (string, int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read Google credentials: %v", err)
+	}
+
+	var sa googleServiceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return "", 0, fmt.Errorf("failed to parse Google credentials: %v", err)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", 0, fmt.Errorf("Google credentials contain no PEM private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to parse Google private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", 0, fmt.Errorf("Google private key is not an RSA key")
+	}
+
+	now := time.Now()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": ts.scope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to sign Google JWT: %v", err)
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doGoogleTokenRequest(req)
+}
+
+// metadataToken fetches a token for the instance's default service account
+func (ts *googleTokenSource) metadataToken(ctx context.Context) (string, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", googleMetadataTokenURL+"?scopes="+url.QueryEscape(ts.scope), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return doGoogleTokenRequest(req)
+}
+
+// doGoogleTokenRequest performs a token request and decodes the response
+func doGoogleTokenRequest(req *http.Request) (string, int64, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("Google token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("Google token request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", 0, fmt.Errorf("failed to parse Google token response: %v", err)
+	}
+	return token.AccessToken, token.ExpiresIn, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// GraphQLMethodPost sends queries as a JSON POST body
+	GraphQLMethodPost = "POST"
+
+	// GraphQLMethodGet sends queries as URL parameters, which CDNs can cache
+	GraphQLMethodGet = "GET"
+)
+
+// maxGraphQLResponseSize caps how much of a response is read to look for
+// GraphQL errors
+const maxGraphQLResponseSize = 10 << 20
+
+// graphQLOperationPattern finds the type and name of the first operation
+var graphQLOperationPattern = regexp.MustCompile(`(?m)^\s*(query|mutation|subscription)\b\s*([_A-Za-z][_0-9A-Za-z]*)?`)
+
+// graphQLQuery is a query loaded from a .graphql file, ready to send
+type graphQLQuery struct {
+	// url is the URL the query is warmed and reported as
+	url string
+
+	name          string
+	query         string
+	operationName string
+	variables     map[string]interface{}
+	hash          string
+}
+
+// loadGraphQLQueries reads the configured query files
+func loadGraphQLQueries(config *GraphQLConfig) ([]*graphQLQuery, error) {
+	var queries []*graphQLQuery
+	seen := make(map[string]bool, len(config.Queries))
+	for _, qc := range config.Queries {
+		data, err := os.ReadFile(qc.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GraphQL query: %v", err)
+		}
+
+		q := &graphQLQuery{query: strings.TrimSpace(string(data))}
+		if m := graphQLOperationPattern.FindStringSubmatch(q.query); m != nil {
+			if m[1] != "query" {
+				return nil, fmt.Errorf("GraphQL file %s contains a %s; only queries can be warmed", qc.File, m[1])
+			}
+			q.operationName = m[2]
+		} else if !strings.HasPrefix(q.query, "{") {
+			return nil, fmt.Errorf("GraphQL file %s contains no query", qc.File)
+		}
+
+		q.name = qc.Name
+		if q.name == "" {
+			q.name = q.operationName
+		}
+		if q.name == "" {
+			q.name = strings.TrimSuffix(filepath.Base(qc.File), filepath.Ext(qc.File))
+		}
+
+		if len(qc.Variables) > 0 {
+			variables, ok := jsonValue(qc.Variables).(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("GraphQL variables for %s must be a mapping", qc.File)
+			}
+			q.variables = variables
+		}
+
+		sum := sha256.Sum256([]byte(q.query))
+		q.hash = hex.EncodeToString(sum[:])
+
+		q.url, err = q.warmURL(config)
+		if err != nil {
+			return nil, err
+		}
+		if seen[q.url] {
+			return nil, fmt.Errorf("duplicate GraphQL query %q; set a distinct name", q.name)
+		}
+		seen[q.url] = true
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// warmURL returns the URL the query is warmed and reported as: the GET
+// request itself, or the endpoint with the query name as fragment for POST
+func (q *graphQLQuery) warmURL(config *GraphQLConfig) (string, error) {
+	if config.Method != GraphQLMethodGet {
+		return config.Endpoint + "#" + url.PathEscape(q.name), nil
+	}
+
+	params := url.Values{}
+	if !config.Persisted {
+		params.Set("query", q.query)
+	} else {
+		params.Set("extensions", q.extensions())
+	}
+	if q.operationName != "" {
+		params.Set("operationName", q.operationName)
+	}
+	if q.variables != nil {
+		variables, err := json.Marshal(q.variables)
+		if err != nil {
+			return "", fmt.Errorf("invalid GraphQL variables for %s: %v", q.name, err)
+		}
+		params.Set("variables", string(variables))
+	}
+
+	separator := "?"
+	if strings.Contains(config.Endpoint, "?") {
+		separator = "&"
+	}
+	return config.Endpoint + separator + params.Encode(), nil
+}
+
+// extensions returns the automatic persisted query extension for the query
+func (q *graphQLQuery) extensions() string {
+	return fmt.Sprintf(`{"persistedQuery":{"version":1,"sha256Hash":"%s"}}`, q.hash)
+}
+
+// body returns the JSON POST body of the query; withQuery false sends only
+// the persisted query hash
+func (q *graphQLQuery) body(withQuery, persisted bool) ([]byte, error) {
+	payload := map[string]interface{}{}
+	if withQuery {
+		payload["query"] = q.query
+	}
+	if q.operationName != "" {
+		payload["operationName"] = q.operationName
+	}
+	if q.variables != nil {
+		payload["variables"] = q.variables
+	}
+	if persisted {
+		payload["extensions"] = json.RawMessage(q.extensions())
+	}
+	return json.Marshal(payload)
+}
+
+// graphQLRequest turns a warm request for a POST query into the query's
+// request; GET queries are already encoded in the URL
+func (cw *CacheWarmer) graphQLRequest(req *http.Request, q *graphQLQuery) error {
+	if cw.config.GraphQL.Method == GraphQLMethodGet {
+		req.Header.Set("Accept", "application/json")
+		return nil
+	}
+
+	body, err := q.body(!cw.config.GraphQL.Persisted, cw.config.GraphQL.Persisted)
+	if err != nil {
+		return fmt.Errorf("failed to encode GraphQL query %s: %v", q.name, err)
+	}
+	setGraphQLBody(req, body)
+	return nil
+}
+
+// setGraphQLBody makes req a JSON POST with the given body
+func setGraphQLBody(req *http.Request, body []byte) {
+	req.Method = http.MethodPost
+	req.URL.Fragment = ""
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+}
+
+// graphQLResponse is the part of a GraphQL response checked after warming
+type graphQLResponse struct {
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			Code string `json:"code"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// checkGraphQLResponse reads a GraphQL response body and fails on errors in
+// it. A persisted query the server doesn't know yet is registered, and the
+// attempt fails so the retry finds it stored.
+func (cw *CacheWarmer) checkGraphQLResponse(ctx context.Context, client *http.Client, q *graphQLQuery, body io.Reader) error {
+	data, err := io.ReadAll(io.LimitReader(body, maxGraphQLResponseSize))
+	if err != nil {
+		return classifyTransportError("incomplete response body", err)
+	}
+
+	var resp graphQLResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return &AssertionError{Check: "graphql", Message: fmt.Sprintf("response is not JSON: %v", err)}
+	}
+	if len(resp.Errors) == 0 {
+		return nil
+	}
+
+	first := resp.Errors[0]
+	if cw.config.GraphQL.Persisted && (first.Message == "PersistedQueryNotFound" || first.Extensions.Code == "PERSISTED_QUERY_NOT_FOUND") {
+		if err := cw.registerPersistedQuery(ctx, client, q); err != nil {
+			return err
+		}
+		return &AssertionError{Check: "graphql", Message: "persisted query was not stored yet; registered it"}
+	}
+	return &AssertionError{Check: "graphql", Message: fmt.Sprintf("%d errors, first: %s", len(resp.Errors), first.Message)}
+}
+
+// registerPersistedQuery sends the full query text with its hash so the
+// server stores it for hash-only requests
+func (cw *CacheWarmer) registerPersistedQuery(ctx context.Context, client *http.Client, q *graphQLQuery) error {
+	body, err := q.body(true, true)
+	if err != nil {
+		return fmt.Errorf("failed to encode GraphQL query %s: %v", q.name, err)
+	}
+
+	req, err := cw.newRequest(ctx, cw.config.GraphQL.Endpoint)
+	if err != nil {
+		return err
+	}
+	setGraphQLBody(req, body)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return classifyTransportError("persisted query registration failed", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if !cw.config.IsSuccessCode(resp.StatusCode) {
+		return &AssertionError{Check: "graphql", Message: fmt.Sprintf("persisted query registration returned status %d", resp.StatusCode)}
+	}
+	cw.logger.Debug("Registered persisted GraphQL query %s (%s)", q.name, q.hash)
+	return nil
+}
+
+// jsonValue converts YAML-decoded values to types encoding/json can marshal
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = jsonValue(value)
+		}
+		return s
+	default:
+		return v
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// urlGroup is a URL group and the cache of its remote URL list
+type urlGroup struct {
+	config *GroupConfig
+	list   remoteURLList
+}
+
+// groupContextKey carries the group a run warms in its context
+type groupContextKey struct{}
+
+// withGroup returns a context for a run over the URLs of g, so its workers
+// and requests use the group's settings
+func withGroup(ctx context.Context, g *urlGroup) context.Context {
+	return context.WithValue(ctx, groupContextKey{}, g)
+}
+
+// groupOf returns the group a run warms, or nil for the main URLs
+func groupOf(ctx context.Context) *urlGroup {
+	g, _ := ctx.Value(groupContextKey{}).(*urlGroup)
+	return g
+}
+
+// newURLGroups creates the configured URL groups
+func newURLGroups(config *Config) []*urlGroup {
+	groups := make([]*urlGroup, len(config.Groups))
+	for i := range config.Groups {
+		groups[i] = &urlGroup{config: &config.Groups[i]}
+	}
+	return groups
+}
+
+// criticalURLList returns the addresses of the group's URLs marked critical
+func (g *GroupConfig) criticalURLList() []string {
+	var urls []string
+	for _, entry := range g.URLs {
+		if entry.Critical {
+			urls = append(urls, entry.URL)
+		}
+	}
+	return urls
+}
+
+// runWorkers returns the number of workers of the run ctx belongs to
+func (cw *CacheWarmer) runWorkers(ctx context.Context) int {
+	if g := groupOf(ctx); g != nil && g.config.Workers > 0 {
+		return g.config.Workers
+	}
+	return cw.settingsOf(ctx).config.Workers
+}
+
+// isSuccessCode checks a status code against the success codes of the run
+// ctx belongs to
+func (cw *CacheWarmer) isSuccessCode(ctx context.Context, code int) bool {
+	if g := groupOf(ctx); g != nil && len(g.config.SuccessCodes) > 0 {
+		for _, successCode := range g.config.SuccessCodes {
+			if code == successCode {
+				return true
+			}
+		}
+		return false
+	}
+	return cw.config.IsSuccessCode(code)
+}
+
+// groupURLs returns the group's URLs followed by those from its URL list
+// and sitemap, which are re-read every cycle
+func (cw *CacheWarmer) groupURLs(ctx context.Context, g *urlGroup) []string {
+	urls := make([]string, 0, len(g.config.URLs))
+	for _, entry := range g.config.URLs {
+		urls = append(urls, entry.URL)
+	}
+
+	if g.config.RemoteList.URL != "" {
+		listURLs, _, err := cw.fetchRemoteList(ctx, &g.config.RemoteList, &g.list)
+		if err != nil {
+			cw.logger.Error("Failed to load URL list of group %s: %v", g.config.Name, err)
+		}
+		urls = append(urls, listURLs...)
+	}
+
+	if g.config.Sitemap != "" {
+		sitemapURLs, err := cw.fetchSitemap(ctx, g.config.Sitemap)
+		if err != nil {
+			cw.logger.Error("Failed to load sitemap of group %s: %v", g.config.Name, err)
+		} else {
+			urls = append(urls, sitemapURLs...)
+		}
+	}
+
+	return cw.dropRemovedURLs(urls)
+}
+
+// WarmGroup runs one cycle over the URLs of a group
+func (cw *CacheWarmer) WarmGroup(ctx context.Context, g *urlGroup) (RunSummary, error) {
+	ctx = withGroup(ctx, g)
+	urls := cw.selectURLs(ctx, cw.groupURLs(ctx, g))
+	cw.logger.Info("Starting cache warming cycle of group %s", g.config.Name)
+	return cw.warm(ctx, urls)
+}
+
+// groupSchedule warms every group on its own interval in continuous mode,
+// applying the overlap policy to each group's cycles separately
+type groupSchedule struct {
+	runners []*cycleRunner
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// startGroups starts the schedules of the URL groups
+func (cw *CacheWarmer) startGroups() *groupSchedule {
+	s := &groupSchedule{done: make(chan struct{})}
+	for _, g := range cw.groups {
+		runner := newCycleRunner(cw, cw.config, cw.logger)
+		s.runners = append(s.runners, runner)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			cw.scheduleGroup(g, runner, s.done)
+		}()
+	}
+	return s
+}
+
+// scheduleGroup starts the cycles of g until done is closed, after the
+// initial warm selected by warm_on_start
+func (cw *CacheWarmer) scheduleGroup(g *urlGroup, runner *cycleRunner, done <-chan struct{}) {
+	interval := g.config.Interval
+	if interval <= 0 {
+		interval = cw.CurrentConfig().Interval
+	}
+	cw.logger.Info("Warming group %s every %v", g.config.Name, interval)
+
+	cycle := func(ctx context.Context) {
+		cw.WarmGroup(ctx, g)
+	}
+	scheduled := func(ctx context.Context) {
+		ctx, err := cw.beginScheduledCycle(ctx, interval)
+		if err == nil {
+			cw.WarmGroup(ctx, g)
+		}
+	}
+	if cw.IsLeader() {
+		switch cw.config.WarmOnStart {
+		case WarmOnStartAll:
+			runner.Run(cycle)
+		case WarmOnStartCritical:
+			if urls := cw.selectURLs(withGroup(cw.ctx, g), g.config.criticalURLList()); len(urls) > 0 {
+				runner.Run(func(ctx context.Context) {
+					cw.logger.Info("Warming %d critical URLs of group %s", len(urls), g.config.Name)
+					cw.warm(withGroup(ctx, g), urls)
+				})
+			}
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !cw.IsLeader() {
+				continue
+			}
+			if paused, _ := cw.Paused(); paused {
+				cw.logger.Info("Skipping scheduled cycle of group %s, warming is paused", g.config.Name)
+				continue
+			}
+			runner.Run(scheduled)
+		case <-done:
+			return
+		}
+	}
+}
+
+// Stop stops starting group cycles and drops the queued ones
+func (s *groupSchedule) Stop() {
+	close(s.done)
+	s.wg.Wait()
+	for _, runner := range s.runners {
+		runner.Stop()
+	}
+}
+
+// Wait waits for the running group cycles to return
+func (s *groupSchedule) Wait() {
+	for _, runner := range s.runners {
+		runner.Wait()
+	}
+}
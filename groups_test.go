@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWarmGroupRunsOverlapWithoutSharingState(t *testing.T) {
+	// The fast group fails under /missing; the slow one only succeeds
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow/") {
+			time.Sleep(30 * time.Millisecond)
+		}
+		if strings.HasPrefix(r.URL.Path, "/missing/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	entries := func(urls []string) []URLEntry {
+		list := make([]URLEntry, len(urls))
+		for i, url := range urls {
+			list[i] = URLEntry{URL: url}
+		}
+		return list
+	}
+	cw := newTestWarmer(t, origin, func(config *Config) {
+		config.Groups = []GroupConfig{
+			{Name: "slow", URLs: entries(testURLs(origin, "slow", 12)), Workers: 2},
+			{Name: "fast", URLs: entries(testURLs(origin, "missing", 4)), Workers: 4},
+		}
+	})
+
+	var wg sync.WaitGroup
+	summaries := make([]RunSummary, len(cw.groups))
+	for i, g := range cw.groups {
+		wg.Add(1)
+		go func(i int, g *urlGroup) {
+			defer wg.Done()
+			summaries[i], _ = cw.WarmGroup(context.Background(), g)
+		}(i, g)
+	}
+	wg.Wait()
+
+	if s := summaries[0]; s.TotalRequests != 12 || s.FailedRequests != 0 {
+		t.Errorf("slow group: %d requests, %d failed, want 12 and 0", s.TotalRequests, s.FailedRequests)
+	}
+	if s := summaries[1]; s.TotalRequests != 4 || s.FailedRequests != 4 {
+		t.Errorf("fast group: %d requests, %d failed, want 4 and 4", s.TotalRequests, s.FailedRequests)
+	}
+}
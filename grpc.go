@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// grpcService prefixes the paths of the API's methods, as declared in
+// proto/cachewarmer.proto
+const grpcService = "/cachewarmer.v1.CacheWarmer/"
+
+// maxGRPCMessage limits the size of accepted request messages
+const maxGRPCMessage = 4 << 20
+
+// gRPC status codes returned by the API
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcError is the failure of a call, sent as its grpc-status and
+// grpc-message trailers
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return e.message
+}
+
+// GRPCServer serves the gRPC control and status API over cleartext HTTP/2.
+// It speaks the gRPC wire protocol itself, so serving it doesn't pull in
+// the gRPC and protobuf libraries.
+type GRPCServer struct {
+	config *GRPCConfig
+	warmer *CacheWarmer
+	logger *Logger
+	server *http.Server
+
+	// done ends the result streams on Shutdown
+	done chan struct{}
+}
+
+// NewGRPCServer creates a new gRPC server and starts listening
+func NewGRPCServer(config *GRPCConfig, warmer *CacheWarmer, logger *Logger) *GRPCServer {
+	gs := &GRPCServer{
+		config: config,
+		warmer: warmer,
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+
+	// gRPC clients connect with HTTP/2 prior knowledge
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	gs.server = &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.Port),
+		Handler:           http.HandlerFunc(gs.handle),
+		Protocols:         &protocols,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Start server in background
+	go func() {
+		logger.Info("Starting gRPC server on port %d", config.Port)
+		if err := gs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("gRPC server error: %v", err)
+		}
+	}()
+
+	return gs
+}
+
+// handle runs a call and reports its outcome in the trailers
+func (gs *GRPCServer) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	code, message := grpcOK, ""
+	if err := gs.call(w, r); err != nil {
+		code, message = grpcInternal, err.Error()
+		if e, ok := err.(*grpcError); ok {
+			code = e.code
+		}
+	}
+
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(message))
+	}
+}
+
+// call dispatches a request to its method
+func (gs *GRPCServer) call(w http.ResponseWriter, r *http.Request) error {
+	if !bearerAuthorized(r, gs.config.Token) {
+		gs.logger.Warn("Rejected gRPC call from %s: invalid token", r.RemoteAddr)
+		return &grpcError{grpcUnauthenticated, "invalid or missing token"}
+	}
+
+	method, ok := strings.CutPrefix(r.URL.Path, grpcService)
+	if !ok {
+		return &grpcError{grpcUnimplemented, fmt.Sprintf("unknown service of %s", r.URL.Path)}
+	}
+	request, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	fields, err := decodeProto(request)
+	if err != nil {
+		return &grpcError{grpcInvalidArgument, fmt.Sprintf("invalid request: %v", err)}
+	}
+
+	switch method {
+	case "StartCycle":
+		status, err := gs.warmer.StartCycle()
+		if err != nil {
+			return &grpcError{grpcFailedPrecondition, err.Error()}
+		}
+		var response protoEncoder
+		response.String(1, status)
+		return writeGRPCMessage(w, response.buf)
+	case "WatchResults":
+		return gs.watchResults(w, r, fields)
+	case "AddURLs":
+		changed, err := gs.warmer.AddURLs(protoStrings(fields, 1))
+		if err != nil {
+			return &grpcError{grpcInvalidArgument, err.Error()}
+		}
+		return writeGRPCMessage(w, gs.urlsResponse(changed))
+	case "RemoveURLs":
+		changed := gs.warmer.RemoveURLs(protoStrings(fields, 1))
+		return writeGRPCMessage(w, gs.urlsResponse(changed))
+	case "GetStatistics":
+		return writeGRPCMessage(w, gs.statistics())
+	default:
+		return &grpcError{grpcUnimplemented, fmt.Sprintf("unknown method %s", method)}
+	}
+}
+
+// watchResults streams results until the client cancels or the server
+// shuts down. Results are dropped rather than stalling the workers if the
+// client falls behind.
+func (gs *GRPCServer) watchResults(w http.ResponseWriter, r *http.Request, fields []protoField) error {
+	failuresOnly := false
+	for _, f := range fields {
+		if f.Number == 1 {
+			failuresOnly = f.Varint != 0
+		}
+	}
+
+	results, stop := gs.warmer.subscribe(1000)
+	defer stop()
+
+	// Send the headers now so the client sees the stream open
+	w.WriteHeader(http.StatusOK)
+	http.NewResponseController(w).Flush()
+
+	for {
+		select {
+		case result := <-results:
+			if failuresOnly && result.Success {
+				continue
+			}
+			if err := writeGRPCMessage(w, resultMessage(result)); err != nil {
+				return err
+			}
+		case <-r.Context().Done():
+			return nil
+		case <-gs.done:
+			return &grpcError{grpcUnavailable, "the cache warmer is shutting down"}
+		}
+	}
+}
+
+// urlsResponse encodes the outcome of AddURLs and RemoveURLs
+func (gs *GRPCServer) urlsResponse(changed int) []byte {
+	added, removed := gs.warmer.RuntimeURLs()
+	var response protoEncoder
+	response.Int64(1, int64(changed))
+	response.Strings(2, added)
+	response.Strings(3, removed)
+	return response.buf
+}
+
+// statistics encodes the current run's statistics and scheduler gauges
+func (gs *GRPCServer) statistics() []byte {
+	stats := gs.warmer.GetStatistics()
+	scheduler := gs.warmer.SchedulerStats()
+
+	var response protoEncoder
+	response.String(1, stats.RunID)
+	if !stats.StartTime.IsZero() {
+		response.Int64(2, stats.StartTime.UnixMilli())
+	}
+	response.Int64(3, stats.TotalRequests)
+	response.Int64(4, stats.SuccessRequests)
+	response.Int64(5, stats.FailedRequests)
+	if stats.TotalRequests > 0 {
+		response.Double(6, float64(stats.TotalDuration)/float64(stats.TotalRequests)/float64(time.Millisecond))
+	}
+	response.Int64(7, stats.Retries)
+	response.Int64(8, scheduler.BusyWorkers)
+	response.Int64(9, scheduler.QueueDepth)
+	response.Int64(10, scheduler.InFlightRequests)
+	response.Int64(11, gs.warmer.ActiveRuns())
+	response.Int64(12, stats.BytesTransferred)
+	return response.buf
+}
+
+// resultMessage encodes a per-URL result
+func resultMessage(result Result) []byte {
+	var m protoEncoder
+	m.String(1, result.URL)
+	m.String(2, result.Region)
+	m.String(3, result.Device)
+	m.String(4, result.Range)
+	m.Int64(5, int64(result.StatusCode))
+	m.String(6, result.CacheStatus)
+	m.Int64(7, int64(result.Attempts))
+	m.Int64(8, result.Duration.Milliseconds())
+	m.Bool(9, result.Success)
+	if result.Err != nil {
+		m.String(10, result.Err.Error())
+	}
+	m.Bool(11, result.Critical)
+	m.Bool(12, result.Coalesced)
+	m.Int64(13, result.TTFB.Milliseconds())
+	m.Int64(14, result.Bytes)
+	return m.buf
+}
+
+// protoStrings returns the values of a repeated string field
+func protoStrings(fields []protoField, number int) []string {
+	var values []string
+	for _, f := range fields {
+		if f.Number == number {
+			values = append(values, string(f.Bytes))
+		}
+	}
+	return values
+}
+
+// readGRPCMessage reads the single length-prefixed message of a request
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "missing request message"}
+	}
+	if prefix[0] != 0 {
+		return nil, &grpcError{grpcUnimplemented, "compressed messages are not supported"}
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > maxGRPCMessage {
+		return nil, &grpcError{grpcInvalidArgument, fmt.Sprintf("request message larger than %d bytes", maxGRPCMessage)}
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(body, message); err != nil {
+		return nil, &grpcError{grpcInvalidArgument, "truncated request message"}
+	}
+	return message, nil
+}
+
+// writeGRPCMessage sends one length-prefixed message and flushes it
+func writeGRPCMessage(w http.ResponseWriter, message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	return http.NewResponseController(w).Flush()
+}
+
+// encodeGRPCMessage percent-encodes a status message as the grpc-message
+// trailer requires
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Shutdown ends the result streams and stops the server
+func (gs *GRPCServer) Shutdown() {
+	gs.logger.Info("Shutting down gRPC server...")
+	close(gs.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := gs.server.Shutdown(ctx); err != nil {
+		gs.logger.Error("Error shutting down gRPC server: %v", err)
+	} else {
+		gs.logger.Info("gRPC server shutdown complete")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// historySmoothing is the weight of the newest sample in the latency average
+const historySmoothing = 0.3
+
+// URLHistory is the persisted warming history of a single URL
+type URLHistory struct {
+	// AverageDurationMs is an exponentially weighted average of request time
+	AverageDurationMs float64 `json:"average_duration_ms"`
+
+	// Samples is the number of results recorded for the URL
+	Samples int `json:"samples"`
+
+	// Misses counts results that were cache misses or failures
+	Misses int `json:"misses"`
+
+	// LastWarmed is when the URL was last warmed
+	LastWarmed time.Time `json:"last_warmed"`
+}
+
+// MissRate returns the fraction of results that were misses or failures
+func (h *URLHistory) MissRate() float64 {
+	if h.Samples == 0 {
+		return 0
+	}
+	return float64(h.Misses) / float64(h.Samples)
+}
+
+// slowness ranks URLs for slowest-first ordering: slow URLs that also miss
+// often are the ones that need the most retry headroom
+func (h *URLHistory) slowness() float64 {
+	return h.AverageDurationMs * (1 + h.MissRate())
+}
+
+// History stores per-URL warming history across cycles and restarts
+type History struct {
+	path  string
+	mutex sync.Mutex
+	URLs  map[string]*URLHistory `json:"urls"`
+}
+
+// newHistory creates an empty history persisted at path
+func newHistory(path string) *History {
+	return &History{
+		path: path,
+		URLs: make(map[string]*URLHistory),
+	}
+}
+
+// LoadHistory reads the history file at path; a missing file yields an
+// empty history
+func LoadHistory(path string) (*History, error) {
+	h := newHistory(path)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf("failed to parse history file: %v", err)
+	}
+	if h.URLs == nil {
+		h.URLs = make(map[string]*URLHistory)
+	}
+	return h, nil
+}
+
+// Record adds a result to the URL's history
+func (h *History) Record(result Result) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	entry := h.URLs[result.URL]
+	if entry == nil {
+		entry = &URLHistory{}
+		h.URLs[result.URL] = entry
+	}
+
+	durationMs := float64(result.Duration) / float64(time.Millisecond)
+	if entry.Samples == 0 {
+		entry.AverageDurationMs = durationMs
+	} else {
+		entry.AverageDurationMs = historySmoothing*durationMs + (1-historySmoothing)*entry.AverageDurationMs
+	}
+
+	entry.Samples++
+	if !result.Success || result.CacheStatus == CacheStatusMiss {
+		entry.Misses++
+	}
+	entry.LastWarmed = time.Now()
+}
+
+// Get returns the history of a URL, or nil if it has never been warmed
+func (h *History) Get(url string) *URLHistory {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.URLs[url]
+}
+
+// SortSlowestFirst returns a copy of urls ordered by historical slowness.
+// URLs without history keep their listed order after those with history.
+func (h *History) SortSlowestFirst(urls []string) []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	sorted := make([]string, len(urls))
+	copy(sorted, urls)
+
+	score := func(url string) float64 {
+		if entry := h.URLs[url]; entry != nil {
+			return entry.slowness()
+		}
+		return -1
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return score(sorted[i]) > score(sorted[j])
+	})
+
+	return sorted
+}
+
+// Save writes the history file atomically
+func (h *History) Save() error {
+	h.mutex.Lock()
+	data, err := json.MarshalIndent(h, "", "  ")
+	h.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode history: %v", err)
+	}
+
+	if dir := filepath.Dir(h.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create history directory: %v", err)
+		}
+	}
+
+	tmp := h.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return fmt.Errorf("failed to replace history file: %v", err)
+	}
+	return nil
+}
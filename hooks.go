@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RunSummary describes a finished run; it is returned by WarmCache and
+// delivered to completion callbacks
+type RunSummary struct {
+	RunID             string
+	StartedAt         time.Time
+	Duration          time.Duration
+	TotalRequests     int64
+	SuccessRequests   int64
+	FailedRequests    int64
+	DuplicatesSkipped int64
+	CoalescedRequests int64
+	SkippedURLs       int64
+	CrawledURLs       int64
+	AssetURLs         int64
+	Cancelled         bool
+
+	// CriticalFailures counts failed requests for URLs marked critical, and
+	// PriorityFailures those for URLs of fail_priority or higher
+	CriticalFailures int64
+	PriorityFailures int64
+
+	// Latency holds the request duration percentiles, TTFB the time to first
+	// byte percentiles and BytesTransferred the response body bytes read
+	Latency          LatencyPercentiles
+	TTFB             LatencyPercentiles
+	BytesTransferred int64
+
+	// HaltedWave is the wave whose checks failed, leaving the later waves
+	// unwarmed (0 = none)
+	HaltedWave int64
+}
+
+// hooks holds callbacks registered by embedding code
+type hooks struct {
+	mutex      sync.RWMutex
+	onResult   []func(Result)
+	onComplete []func(RunSummary)
+
+	// Result channels of the control API's watchers, until they leave
+	subscribers map[chan Result]bool
+}
+
+// OnResult registers fn to be called with every per-URL outcome as soon as it
+// completes. Callbacks run on worker goroutines, possibly concurrently, and
+// should return quickly; slow callbacks delay warming.
+func (cw *CacheWarmer) OnResult(fn func(Result)) {
+	cw.hooks.mutex.Lock()
+	defer cw.hooks.mutex.Unlock()
+	cw.hooks.onResult = append(cw.hooks.onResult, fn)
+}
+
+// OnComplete registers fn to be called with the run summary after every run,
+// including runs cut short by a deadline or Shutdown
+func (cw *CacheWarmer) OnComplete(fn func(RunSummary)) {
+	cw.hooks.mutex.Lock()
+	defer cw.hooks.mutex.Unlock()
+	cw.hooks.onComplete = append(cw.hooks.onComplete, fn)
+}
+
+// Results returns a channel that receives every per-URL outcome. The channel
+// is buffered; outcomes are dropped rather than stalling the workers if the
+// consumer falls more than buffer results behind.
+func (cw *CacheWarmer) Results(buffer int) <-chan Result {
+	results := make(chan Result, buffer)
+	cw.OnResult(func(result Result) {
+		select {
+		case results <- result:
+		default:
+		}
+	})
+	return results
+}
+
+// subscribe is Results for watchers that come and go: it also returns a
+// function that stops the delivery
+func (cw *CacheWarmer) subscribe(buffer int) (<-chan Result, func()) {
+	results := make(chan Result, buffer)
+	cw.hooks.mutex.Lock()
+	defer cw.hooks.mutex.Unlock()
+	if cw.hooks.subscribers == nil {
+		cw.hooks.subscribers = make(map[chan Result]bool)
+	}
+	cw.hooks.subscribers[results] = true
+
+	return results, func() {
+		cw.hooks.mutex.Lock()
+		defer cw.hooks.mutex.Unlock()
+		delete(cw.hooks.subscribers, results)
+	}
+}
+
+// emitResult delivers a result to the registered callbacks and watchers
+func (cw *CacheWarmer) emitResult(result Result) {
+	cw.hooks.mutex.RLock()
+	defer cw.hooks.mutex.RUnlock()
+	for _, fn := range cw.hooks.onResult {
+		fn(result)
+	}
+	for results := range cw.hooks.subscribers {
+		select {
+		case results <- result:
+		default:
+		}
+	}
+}
+
+// finishRun builds the summary of run r and delivers it to the registered
+// callbacks
+func (cw *CacheWarmer) finishRun(r *warmRun, cancelled bool) RunSummary {
+	stats := r.Statistics()
+	summary := RunSummary{
+		RunID:             stats.RunID,
+		StartedAt:         stats.StartTime,
+		Duration:          time.Since(stats.StartTime),
+		TotalRequests:     stats.TotalRequests,
+		SuccessRequests:   stats.SuccessRequests,
+		FailedRequests:    stats.FailedRequests,
+		DuplicatesSkipped: stats.DuplicatesSkipped,
+		CoalescedRequests: stats.CoalescedRequests,
+		SkippedURLs:       stats.SkippedURLs,
+		CrawledURLs:       stats.CrawledURLs,
+		AssetURLs:         stats.AssetURLs,
+		Cancelled:         cancelled,
+		HaltedWave:        stats.HaltedWave,
+		Latency:           stats.Latency,
+		TTFB:              stats.TTFB,
+		BytesTransferred:  stats.BytesTransferred,
+	}
+	critical := r.settings.critical != nil
+	if critical || cw.config.FailPriority != 0 {
+		results := r.Results(0)
+		if critical {
+			summary.CriticalFailures = int64(len(cw.GetCriticalSummary(results).Failed))
+		}
+		if cw.config.FailPriority != 0 {
+			summary.PriorityFailures = priorityFailures(results, cw.config.FailPriority)
+		}
+	}
+
+	cw.hooks.mutex.RLock()
+	defer cw.hooks.mutex.RUnlock()
+	for _, fn := range cw.hooks.onComplete {
+		fn(summary)
+	}
+	return summary
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlLinks are the references found in an HTML document
+type htmlLinks struct {
+	// Pages are <a href> targets
+	Pages []string
+
+	// Assets are stylesheets, scripts, images, fonts and other subresources
+	Assets []string
+
+	// Images are the image assets, with every srcset candidate of <img>,
+	// <picture> sources and image preloads
+	Images []string
+}
+
+// assetLinkRels are <link rel> values that reference a subresource
+var assetLinkRels = map[string]bool{
+	"stylesheet":       true,
+	"preload":          true,
+	"modulepreload":    true,
+	"icon":             true,
+	"shortcut":         true,
+	"apple-touch-icon": true,
+	"manifest":         true,
+}
+
+// isHTML reports whether a response carries an HTML document
+func isHTML(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// extractLinks tokenizes an HTML document and returns the absolute http(s)
+// URLs it references, resolved against base (or a <base href> if present)
+func extractLinks(r io.Reader, base *url.URL) htmlLinks {
+	var links htmlLinks
+	tokenizer := html.NewTokenizer(r)
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return links
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if !hasAttr {
+				continue
+			}
+			attrs := tagAttributes(tokenizer)
+
+			switch string(name) {
+			case "base":
+				if resolved := resolveLink(base, attrs["href"]); resolved != "" {
+					base, _ = url.Parse(resolved)
+				}
+			case "a":
+				if strings.Contains(strings.ToLower(attrs["rel"]), "nofollow") {
+					continue
+				}
+				if _, ok := attrs["download"]; ok {
+					continue
+				}
+				if link := resolveLink(base, attrs["href"]); link != "" {
+					links.Pages = append(links.Pages, link)
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					if !assetLinkRels[rel] {
+						continue
+					}
+					if rel == "preload" && strings.EqualFold(attrs["as"], "image") {
+						links.addImage(base, attrs["href"])
+						links.addSrcset(base, attrs["imagesrcset"])
+					} else {
+						links.addAsset(base, attrs["href"])
+					}
+					break
+				}
+			case "script", "iframe", "embed", "track":
+				links.addAsset(base, attrs["src"])
+			case "img":
+				// data-src and data-srcset hold the real sources of lazy-loaded images
+				links.addImage(base, attrs["src"])
+				links.addImage(base, attrs["data-src"])
+				links.addSrcset(base, attrs["srcset"])
+				links.addSrcset(base, attrs["data-srcset"])
+			case "source":
+				// <picture> sources carry srcset, <video> and <audio> ones src
+				links.addAsset(base, attrs["src"])
+				links.addSrcset(base, attrs["srcset"])
+				links.addSrcset(base, attrs["data-srcset"])
+			case "video", "audio", "input":
+				links.addAsset(base, attrs["src"])
+				links.addImage(base, attrs["poster"])
+			}
+		}
+	}
+}
+
+// addAsset resolves and records an asset reference
+func (l *htmlLinks) addAsset(base *url.URL, ref string) {
+	if link := resolveLink(base, ref); link != "" {
+		l.Assets = append(l.Assets, link)
+	}
+}
+
+// addImage resolves and records an image reference, which is an asset too
+func (l *htmlLinks) addImage(base *url.URL, ref string) {
+	if link := resolveLink(base, ref); link != "" {
+		l.Assets = append(l.Assets, link)
+		l.Images = append(l.Images, link)
+	}
+}
+
+// addSrcset records every image candidate of a srcset attribute
+func (l *htmlLinks) addSrcset(base *url.URL, srcset string) {
+	for _, candidate := range parseSrcset(srcset) {
+		l.addImage(base, candidate)
+	}
+}
+
+// parseSrcset returns the URLs of a srcset attribute ("a.jpg 1x, b.jpg 2x").
+// As in browsers, a URL ends at whitespace, so image CDN URLs with commas
+// such as /w_400,h_300/a.jpg stay whole.
+func parseSrcset(srcset string) []string {
+	const space = " \t\n\r\f"
+
+	var urls []string
+	for s := srcset; ; {
+		s = strings.TrimLeft(s, space+",")
+		if s == "" {
+			return urls
+		}
+		end := strings.IndexAny(s, space)
+		if end < 0 {
+			end = len(s)
+		}
+		candidate := s[:end]
+		s = s[end:]
+
+		// A URL directly followed by a comma has no descriptors
+		if trimmed := strings.TrimRight(candidate, ","); trimmed != candidate {
+			urls = append(urls, trimmed)
+			continue
+		}
+		urls = append(urls, candidate)
+
+		// Skip the descriptors, up to the next comma outside parentheses
+		i, depth := 0, 0
+		for ; i < len(s) && (s[i] != ',' || depth > 0); i++ {
+			switch s[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			}
+		}
+		s = s[i:]
+	}
+}
+
+// tagAttributes returns the current tag's attributes with lowercase keys
+func tagAttributes(tokenizer *html.Tokenizer) map[string]string {
+	attrs := make(map[string]string)
+	for {
+		key, value, more := tokenizer.TagAttr()
+		attrs[strings.ToLower(string(key))] = string(value)
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// resolveLink resolves a reference against base, dropping fragments and
+// anything that isn't http or https (mailto:, javascript:, data: ...)
+func resolveLink(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+
+	parsed, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	resolved := base.ResolveReference(parsed)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return ""
+	}
+	resolved.Fragment = ""
+	resolved.RawFragment = ""
+	return resolved.String()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// leaderRenewScript extends the lock's TTL if this instance still holds it
+const leaderRenewScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`
+
+// leaderReleaseScript deletes the lock if this instance still holds it
+const leaderReleaseScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`
+
+// leaderElector holds or waits for a Redis lock, so only one of several
+// replicas in continuous mode runs the scheduled cycles. The holder renews
+// the lock every third of its TTL; if it stops, the lock expires and a
+// standby takes it over.
+type leaderElector struct {
+	config  *LeaderElectionConfig
+	logger  *Logger
+	leading int32
+
+	// conn is only used by the election loop, and by Shutdown once the
+	// loop has stopped
+	conn *redisConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newLeaderElector makes a first attempt to become the leader, so the
+// initial warm knows whether to run, then keeps the election going
+func newLeaderElector(config *LeaderElectionConfig, logger *Logger) *leaderElector {
+	ctx, cancel := context.WithCancel(context.Background())
+	le := &leaderElector{
+		config: config,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	le.elect()
+	go le.run()
+
+	return le
+}
+
+// run renews or retries for the lock until Shutdown
+func (le *leaderElector) run() {
+	defer close(le.done)
+
+	ticker := time.NewTicker(le.config.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			le.elect()
+		case <-le.ctx.Done():
+			return
+		}
+	}
+}
+
+// elect renews the lock if this instance holds it, or takes it if it is
+// free. Errors step down, since the lock can't be renewed either.
+func (le *leaderElector) elect() {
+	leading, err := le.tryElect()
+	if err != nil {
+		le.logger.Error("Leader election failed: %v", err)
+		if le.conn != nil {
+			le.conn.Close()
+			le.conn = nil
+		}
+	}
+
+	switch was := le.Leading(); {
+	case leading && !was:
+		le.logger.Info("Became the leader as %s, running the scheduled cycles", le.config.Identity)
+	case !leading && was:
+		le.logger.Warn("No longer the leader, standing by")
+	}
+	value := int32(0)
+	if leading {
+		value = 1
+	}
+	atomic.StoreInt32(&le.leading, value)
+}
+
+// tryElect makes one renewal or acquisition attempt
+func (le *leaderElector) tryElect() (bool, error) {
+	if le.conn == nil {
+		conn, err := dialRedis(le.ctx, le.config.Redis)
+		if err != nil {
+			return false, err
+		}
+		le.conn = conn
+	}
+
+	ttl := strconv.FormatInt(le.config.TTL.Milliseconds(), 10)
+	if le.Leading() {
+		reply, err := le.conn.Do(10*time.Second, "EVAL", leaderRenewScript, "1", le.config.Key, le.config.Identity, ttl)
+		if err != nil {
+			return false, fmt.Errorf("failed to renew leader lock: %v", err)
+		}
+		if renewed, _ := reply.(int64); renewed == 1 {
+			return true, nil
+		}
+	}
+
+	reply, err := le.conn.Do(10*time.Second, "SET", le.config.Key, le.config.Identity, "NX", "PX", ttl)
+	if err != nil {
+		return false, fmt.Errorf("failed to take leader lock: %v", err)
+	}
+	return reply == "OK", nil
+}
+
+// Leading reports whether this instance currently holds the lock
+func (le *leaderElector) Leading() bool {
+	return atomic.LoadInt32(&le.leading) == 1
+}
+
+// Shutdown stops the election and releases the lock if held, so a standby
+// takes over without waiting for it to expire
+func (le *leaderElector) Shutdown() {
+	le.cancel()
+	<-le.done
+
+	if le.conn == nil {
+		return
+	}
+	defer le.conn.Close()
+	if le.Leading() {
+		if _, err := le.conn.Do(10*time.Second, "EVAL", leaderReleaseScript, "1", le.config.Key, le.config.Identity); err != nil {
+			le.logger.Error("Failed to release leader lock: %v", err)
+		}
+		atomic.StoreInt32(&le.leading, 0)
+	}
+}
+
+// IsLeader reports whether this instance should run scheduled cycles: it
+// holds the leader lock, or leader election isn't configured
+func (cw *CacheWarmer) IsLeader() bool {
+	return cw.elector == nil || cw.elector.Leading()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// localeCheck verifies the language and charset a group of locale variant
+// URLs is served in
+type localeCheck struct {
+	match    *urlPattern
+	language string
+	charset  string
+}
+
+// compileLocaleChecks compiles the configured locale checks in order
+func compileLocaleChecks(configs []LocaleCheckConfig) []localeCheck {
+	checks := make([]localeCheck, 0, len(configs))
+	for _, lc := range configs {
+		check := localeCheck{language: lc.Language, charset: lc.Charset}
+		if lc.Match != "" {
+			pattern := compileURLPattern(lc.Match)
+			check.match = &pattern
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkLocale fails a response whose Content-Language or charset differs
+// from the first locale check matching rawURL. A CDN that ignores Vary can
+// serve one language variant from cache for every locale.
+func (cw *CacheWarmer) checkLocale(rawURL string, header http.Header) error {
+	for _, check := range cw.localeChecks {
+		if check.match != nil && !check.match.Match(rawURL) {
+			continue
+		}
+
+		if check.language != "" {
+			served := header.Get("Content-Language")
+			if !matchesLanguage(served, check.language) {
+				if served == "" {
+					served = "none"
+				}
+				return &AssertionError{Check: "language", Message: fmt.Sprintf("expected Content-Language %s, got %s", check.language, served)}
+			}
+		}
+
+		if check.charset != "" {
+			charset := ""
+			if _, params, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+				charset = params["charset"]
+			}
+			if !strings.EqualFold(charset, check.charset) {
+				if charset == "" {
+					charset = "none"
+				}
+				return &AssertionError{Check: "charset", Message: fmt.Sprintf("expected charset %s, got %s", check.charset, charset)}
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// matchesLanguage reports whether a Content-Language value lists the
+// expected language tag or a more specific one (de matches de-DE)
+func matchesLanguage(served, expected string) bool {
+	for _, tag := range strings.Split(served, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.EqualFold(tag, expected) ||
+			len(tag) > len(expected) && tag[len(expected)] == '-' && strings.EqualFold(tag[:len(expected)], expected) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -13,14 +16,38 @@ import (
 const Version = "0.0.1"
 
 func main() {
+	// Dispatch subcommands before parsing the main flag set
+	if len(os.Args) > 1 && os.Args[1] == "probe" {
+		os.Exit(runProbe(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "invalidate" {
+		os.Exit(runInvalidate(os.Args[2:]))
+	}
+
 	// Define command line flags for configuration
 	var (
 		configFile = flag.String("config", "config.yaml", "Path to configuration file")
 		urls       = flag.String("urls", "", "Comma-separated list of URLs to warm (overrides config file)")
+		urlsFile   = flag.String("urls-file", "", "File with one URL to warm per line, e.g. a failures_file (overrides config file)")
 		workers    = flag.Int("workers", 10, "Number of concurrent workers")
-		interval   = flag.Duration("interval", 0, "Interval between warming cycles (0 = run once)")
+		interval   = flag.Duration("interval", 0, "Interval between warming cycles (0 = run once, overrides config file)")
+		watchEvery = flag.Duration("watch-config", 0, "Reload the config file when it changes, checking this often (0 = on SIGHUP only)")
+		daemonMode = flag.Bool("daemon", false, "Run continuous mode as a system service, with sd_notify readiness and watchdog support")
+		pidPath    = flag.String("pid-file", "", "PID file to write in daemon mode, refusing to start if another instance holds it")
 		timeout    = flag.Duration("timeout", 30*time.Second, "HTTP request timeout")
+		cycleLimit = flag.Duration("cycle-timeout", 0, "Deadline for a whole warming cycle (0 = none, overrides config file)")
+		rateLimit  = flag.Float64("rate-limit", 0, "Cap on requests per second across all workers (0 = none, overrides config file)")
+		shardIndex = flag.Int("shard-index", 0, "Shard of the URLs this instance warms, from 0 to -shard-count minus 1")
+		shardCount = flag.Int("shard-count", 0, "Number of instances splitting the URLs between them (0 = not sharded, overrides config file)")
+		only       = flag.String("only", "", "Comma-separated URL patterns to warm, e.g. \"/checkout/*\" (filters the configured URLs)")
+		limit      = flag.Int("limit", 0, "Warm at most this many URLs per cycle (0 = all)")
+		failPct    = flag.Float64("fail-threshold", 0, "Exit non-zero if more than this percentage of a single run's requests fail (0 = never, overrides config file)")
+		failPrio   = flag.Int("fail-priority", 0, "Exit non-zero if a URL with at least this priority fails in a single run (0 = never, overrides config file)")
+		head       = flag.Bool("head", false, "Send HEAD instead of GET requests, without transferring bodies (overrides config file)")
+		traceURL   = flag.String("trace-url", "", "Comma-separated URL patterns to dump requests and responses for, e.g. \"/checkout/*\"")
+		traceFor   = flag.Duration("trace-for", 0, "Stop tracing -trace-url URLs after this long (0 = never)")
 		verbose    = flag.Bool("verbose", false, "Enable verbose logging")
+		selfTest   = flag.Bool("self-test", false, "Validate retry/timeout/redirect settings against a built-in mock origin")
 		version    = flag.Bool("version", false, "Show version information")
 		help       = flag.Bool("help", false, "Show help information")
 	)
@@ -42,13 +69,91 @@ func main() {
 	logger := NewLogger(*verbose)
 	logger.Info("Starting Cache Warmer v%s", Version)
 
+	// Flags left at their defaults don't override the config file
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	// loadConfig reads the config file and applies the command line
+	// overrides, at startup and again on every reload
+	loadConfig := func() (*Config, error) {
+		var workersOverride int
+		var timeoutOverride time.Duration
+		if setFlags["workers"] {
+			workersOverride = *workers
+		}
+		if setFlags["timeout"] {
+			timeoutOverride = *timeout
+		}
+		config, err := LoadConfig(*configFile, *urls, workersOverride, timeoutOverride)
+		if err != nil {
+			return nil, err
+		}
+
+		if *urlsFile != "" {
+			list, err := readURLsFile(*urlsFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load URLs: %v", err)
+			}
+			config.OverrideURLs(list)
+		}
+		if *cycleLimit > 0 {
+			config.CycleTimeout = *cycleLimit
+		}
+		if *rateLimit > 0 {
+			config.RateLimit.RequestsPerSecond = *rateLimit
+		}
+		if *shardCount > 0 {
+			config.Shard = ShardConfig{Index: *shardIndex, Count: *shardCount}
+		} else if *shardIndex > 0 {
+			config.Shard.Index = *shardIndex
+		}
+		if *head {
+			config.Method = http.MethodHead
+		}
+		if *only != "" {
+			for _, pattern := range strings.Split(*only, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern != "" {
+					config.Only = append(config.Only, pattern)
+				}
+			}
+		}
+		if *traceURL != "" {
+			for _, pattern := range strings.Split(*traceURL, ",") {
+				if pattern = strings.TrimSpace(pattern); pattern != "" {
+					config.Trace = append(config.Trace, pattern)
+				}
+			}
+		}
+		config.TraceFor = *traceFor
+		config.Limit = *limit
+		if setFlags["fail-threshold"] {
+			config.FailThreshold = *failPct
+		}
+		if setFlags["fail-priority"] {
+			config.FailPriority = *failPrio
+		}
+		if setFlags["interval"] {
+			config.Interval = *interval
+		}
+		return config, nil
+	}
+
 	// Load configuration
-	config, err := LoadConfig(*configFile, *urls, *workers, *timeout)
+	config, err := loadConfig()
 	if err != nil {
 		logger.Error("Failed to load configuration: %v", err)
 		os.Exit(1)
 	}
 
+	// Self-test mode runs against the mock origin instead of configured URLs
+	if *selfTest {
+		if !RunSelfTest(config, logger) {
+			os.Exit(2)
+		}
+		logger.Info("Self-test passed")
+		return
+	}
+
 	// Validate configuration
 	if err := config.Validate(); err != nil {
 		logger.Error("Invalid configuration: %v", err)
@@ -58,6 +163,24 @@ func main() {
 	logger.Info("Loaded configuration with %d URLs and %d workers",
 		len(config.URLs), config.Workers)
 
+	// Daemon mode takes the PID file before opening any port
+	var service *daemon
+	if *daemonMode {
+		if config.Interval <= 0 {
+			logger.Error("Daemon mode requires an interval")
+			os.Exit(1)
+		}
+		service, err = startDaemon(*pidPath, logger)
+		if err != nil {
+			logger.Error("Failed to start daemon: %v", err)
+			os.Exit(1)
+		}
+		defer service.Close()
+	} else if *pidPath != "" {
+		logger.Error("-pid-file requires -daemon")
+		os.Exit(1)
+	}
+
 	// Create cache warmer instance
 	warmer := NewCacheWarmer(config, logger)
 
@@ -66,23 +189,98 @@ func main() {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// Run the cache warmer
-	if *interval > 0 {
+	if config.Interval > 0 {
 		// Continuous mode - run at specified intervals
-		logger.Info("Running in continuous mode with %v interval", *interval)
-		ticker := time.NewTicker(*interval)
+		logger.Info("Running in continuous mode with %v interval", config.Interval)
+		ticker := time.NewTicker(config.Interval)
 		defer ticker.Stop()
 
-		// Run initial warming
-		warmer.WarmCache()
+		// Reload the configuration on SIGHUP, and when the file changes if
+		// watched
+		reloads := make(chan struct{}, 1)
+		hupChan := make(chan os.Signal, 1)
+		signal.Notify(hupChan, syscall.SIGHUP)
+		if *watchEvery > 0 {
+			stopWatching := make(chan struct{})
+			defer close(stopWatching)
+			go watchConfigFile(*configFile, *watchEvery, reloads, stopWatching)
+		}
+
+		// Run initial warming as configured by warm_on_start; ticks that
+		// arrive while a cycle is running follow overlap_policy
+		runner := newCycleRunner(warmer, config, logger)
+		warmer.setCycleRunner(runner)
+		runner.Run(warmer.WarmOnStart)
+
+		// URL groups follow their own schedules
+		groups := warmer.startGroups()
+
+		// Tell systemd the service is up once the initial warm is done
+		var ready <-chan struct{}
+		if service != nil {
+			ready = warmer.ReadyChan()
+		}
 
 		for {
 			select {
+			case <-ready:
+				service.Ready(fmt.Sprintf("Warming every %v", config.Interval))
+				ready = nil
+			case <-hupChan:
+				logger.Info("Received SIGHUP, reloading the configuration")
+				select {
+				case reloads <- struct{}{}:
+				default:
+				}
+			case <-reloads:
+				next, err := loadConfig()
+				if err == nil {
+					err = next.Validate()
+				}
+				if err != nil {
+					logger.Error("Keeping the current configuration: %v", err)
+					continue
+				}
+				warmer.QueueReload(next)
 			case <-ticker.C:
-				logger.Info("Starting scheduled cache warming cycle")
-				warmer.WarmCache()
+				// Apply a reloaded configuration between cycles
+				interval := warmer.CurrentConfig().Interval
+				if warmer.ApplyReload() {
+					if next := warmer.CurrentConfig().Interval; next != interval {
+						ticker.Reset(next)
+					}
+				}
+				current := warmer.CurrentConfig()
+				if !warmer.IsLeader() {
+					logger.Debug("Skipping scheduled cycle, standing by for the leader")
+					continue
+				}
+				if paused, _ := warmer.Paused(); paused {
+					logger.Info("Skipping scheduled cycle, warming is paused")
+					continue
+				}
+				if !current.HasCycleURLs() && len(current.Groups) > 0 {
+					// Only the groups have URLs
+					continue
+				}
+				runner.Run(func(ctx context.Context) {
+					ctx, err := warmer.beginScheduledCycle(ctx, current.Interval)
+					if err != nil {
+						return
+					}
+					logger.Info("Starting scheduled cache warming cycle")
+					warmer.WarmCache(ctx)
+				})
 			case sig := <-sigChan:
 				logger.Info("Received signal %v, shutting down gracefully", sig)
+				if service != nil {
+					service.Stopping()
+				}
+				runner.Stop()
+				groups.Stop()
 				warmer.Shutdown()
+				runner.Wait()
+				groups.Wait()
 				return
 			}
 		}
@@ -91,15 +289,85 @@ func main() {
 		logger.Info("Running in single execution mode")
 
 		// Set up signal handler for graceful shutdown during single run
+		done := make(chan struct{})
 		go func() {
 			<-sigChan
 			logger.Info("Received shutdown signal, stopping cache warming")
 			warmer.Shutdown()
+			close(done)
 		}()
 
-		warmer.WarmCache()
-		logger.Info("Cache warming completed")
+		failed := false
+		if config.HasCycleURLs() {
+			ctx, cancel := cycleContext(config.CycleTimeout)
+			summary, err := warmer.WarmCache(ctx)
+			cancel()
+			if config.RedisQueue.Coordinator {
+				// The workers warm the enqueued URLs
+				if err != nil {
+					os.Exit(1)
+				}
+				return
+			}
+			failed = cycleFailed(logger, config, "Cache warming", summary, err)
+		}
+
+		// Then warm the URL groups one after another, by priority
+		for _, g := range sortGroupsByPriority(warmer.groups) {
+			ctx, cancel := cycleContext(config.CycleTimeout)
+			summary, err := warmer.WarmGroup(ctx, g)
+			cancel()
+			if cycleFailed(logger, config, "Cache warming of group "+g.config.Name, summary, err) {
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(2)
+		}
+		if config.HasCycleURLs() || len(warmer.groups) > 0 {
+			logger.Info("Cache warming completed")
+		}
+
+		// Keep draining the Redis queue until shut down
+		if config.RedisQueue.URL != "" {
+			logger.Info("Waiting for URLs from the Redis queue")
+			<-done
+		}
+	}
+}
+
+// cycleFailed logs why a single-run cycle failed, if it did: it ran out of
+// time, halted a wave, had a critical URL or one of fail_priority fail, or
+// had more failures than fail_threshold allows
+func cycleFailed(logger *Logger, config *Config, cycle string, summary RunSummary, err error) bool {
+	var failedPct float64
+	if summary.TotalRequests > 0 {
+		failedPct = float64(summary.FailedRequests) / float64(summary.TotalRequests) * 100
+	}
+	switch {
+	case err == context.DeadlineExceeded:
+		logger.Error("%s did not finish within %v", cycle, config.CycleTimeout)
+	case summary.HaltedWave > 0:
+		logger.Error("%s stopped after wave %d failed its checks", cycle, summary.HaltedWave)
+	case summary.CriticalFailures > 0:
+		logger.Error("%s: %d critical URL requests failed", cycle, summary.CriticalFailures)
+	case summary.PriorityFailures > 0:
+		logger.Error("%s: %d requests for URLs of priority %d or higher failed", cycle, summary.PriorityFailures, config.FailPriority)
+	case config.FailThreshold > 0 && failedPct > config.FailThreshold:
+		logger.Error("%s: %.1f%% of requests failed, over the fail threshold of %v%%", cycle, failedPct, config.FailThreshold)
+	default:
+		return false
+	}
+	return true
+}
+
+// cycleContext returns the context for one warming cycle, bounded by the
+// cycle timeout if one is configured
+func cycleContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
 	}
+	return context.WithCancel(context.Background())
 }
 
 // printUsage displays comprehensive usage information
@@ -108,21 +376,73 @@ func printUsage() {
 
 USAGE:
     cache-warmer [OPTIONS]
+    cache-warmer probe [-config file] [-region name] [-i] [URL...]
+    cache-warmer invalidate [-config file] [PATH...]
 
 OPTIONS:
     -config string
         Path to configuration file (default "config.yaml")
     -urls string
         Comma-separated list of URLs to warm (overrides config file)
+    -urls-file string
+        File with one URL to warm per line; blank lines and # comments are
+        ignored, so a failures_file can be re-run directly (overrides config
+        file)
     -workers int
         Number of concurrent workers (default 10)
     -interval duration
         Interval between warming cycles, 0 = run once (default 0)
-        Examples: 5m, 1h, 30s
+        Examples: 5m, 1h, 30s (overrides config file)
+    -watch-config duration
+        In continuous mode, reload the config file when it changes, checking
+        this often; changes apply at the next cycle (default 0 = on SIGHUP
+        only)
+    -daemon
+        Run continuous mode as a system service: report readiness and
+        shutdown to systemd (Type=notify) and ping its watchdog. Requires
+        -interval
+    -pid-file string
+        PID file to write in daemon mode; the warmer refuses to start while
+        another running instance holds it
     -timeout duration
         HTTP request timeout (default 30s)
+    -cycle-timeout duration
+        Deadline for a whole warming cycle; unfinished requests are abandoned
+        and the partial results reported (default 0 = none)
+    -rate-limit float
+        Cap on requests started per second across all workers, regions and
+        runs, 0 = none (overrides config file)
+    -shard-index int
+        Shard of the URLs this instance warms, from 0 to -shard-count minus 1
+        (default 0)
+    -shard-count int
+        Split the URLs by hash between this many instances, each started
+        with its own -shard-index, 0 = not sharded (overrides config file)
+    -only string
+        Comma-separated URL patterns; only matching configured, sitemap or log
+        URLs are warmed. Patterns match the path unless they include a scheme,
+        and * matches anything (e.g. "/checkout/*")
+    -limit int
+        Warm at most this many URLs per cycle, 0 = all (default 0)
+    -fail-threshold float
+        Exit with status 2 if more than this percentage of a single run's
+        requests fail, 0 = never (overrides config file)
+    -fail-priority int
+        Exit with status 2 if a URL with at least this priority fails in a
+        single run, 0 = never (overrides config file)
+    -head
+        Send HEAD instead of GET requests, for origins that fill their cache
+        on HEAD; bodies are not transferred (overrides config file)
+    -trace-url string
+        Comma-separated URL patterns (as for -only) whose requests are dumped
+        in full: headers, redirect hops, timings and a body excerpt
+    -trace-for duration
+        Stop tracing -trace-url URLs after this long (default 0 = never)
     -verbose
         Enable verbose logging
+    -self-test
+        Warm a built-in mock origin (slow, flaky, redirect-looping and
+        truncated responses) to validate retry/timeout/redirect settings
     -version
         Show version information
     -help
@@ -135,9 +455,31 @@ EXAMPLES:
     # Run continuously every 5 minutes
     cache-warmer -config myconfig.yaml -interval 5m -workers 20
 
+    # Run as a systemd service (Type=notify)
+    cache-warmer -config config.yaml -interval 10m -daemon -pid-file /run/cache-warmer/cache-warmer.pid
+
     # Single run with verbose output
     cache-warmer -config config.yaml -verbose
 
+    # Gate a deploy: fail the pipeline if over 5%% of requests or any URL of
+    # priority 10 or higher fail
+    cache-warmer -config config.yaml -fail-threshold 5 -fail-priority 10
+
+    # Re-warm just the checkout pages after a hotfix
+    cache-warmer -config config.yaml -only "/checkout/*"
+
+    # Dump every exchange for one misbehaving page during a normal run
+    cache-warmer -config config.yaml -interval 5m -trace-url "/checkout/*" -trace-for 30m
+
+    # Debug why a single URL won't warm (omit the URL for interactive mode)
+    cache-warmer probe -config config.yaml https://example.com/checkout
+
+    # Invalidate paths on CloudFront, wait for it, then warm them everywhere
+    cache-warmer invalidate -config config.yaml "/products/*" /index.html
+
+    # Check how the configured retry/timeout settings handle a bad origin
+    cache-warmer -config config.yaml -self-test -timeout 2s
+
 CONFIGURATION FILE:
     The tool supports YAML configuration files. See config.yaml.example for format.
     Command line options override configuration file settings.
@@ -145,6 +487,7 @@ CONFIGURATION FILE:
 EXIT CODES:
     0 - Success
     1 - Configuration error
-    2 - Runtime error
+    2 - Runtime error, a critical URL or one of -fail-priority failed to
+        warm, or more requests failed than -fail-threshold allows
 `, Version)
 }
@@ -6,25 +6,58 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Metrics provides metrics collection and HTTP endpoint for monitoring
 type Metrics struct {
 	server *http.Server
+	mux    *http.ServeMux
 	logger *Logger
 	mutex  sync.RWMutex
+	ready  int32
+
+	// Reports live worker pool and queue gauges, if set
+	scheduler func() SchedulerStats
+
+	// Reports the fleet-wide view on an aggregator, if set
+	fleet func() FleetReport
+
+	// Requests by host, status and cache state, and their latency, time to
+	// first byte and response bytes by host, for the Prometheus exposition
+	requestSeries map[requestLabels]int64
+	latencies     map[string]*histogram
+	firstByte     map[string]*histogram
+	responseBytes map[string]int64
+
+	// Request durations and times to first byte since start, for percentiles
+	latency latencyHistogram
+	ttfb    latencyHistogram
 
 	// Metrics data
 	RequestCounts    map[string]int64   `json:"request_counts"`
 	RequestDurations map[string][]int64 `json:"request_durations_ms"`
+	ResponseBytes    map[string]int64   `json:"response_bytes"`
 	SuccessRates     map[string]float64 `json:"success_rates"`
+	FailureClasses   map[string]int64   `json:"failure_classes"`
 	LastUpdated      time.Time          `json:"last_updated"`
 
 	// Counters
 	TotalRequests  int64 `json:"total_requests"`
 	TotalSuccesses int64 `json:"total_successes"`
 	TotalFailures  int64 `json:"total_failures"`
+	TotalBytes     int64 `json:"total_bytes"`
+
+	// Critical URL requests and failures in the last run
+	CriticalRequests int64 `json:"critical_requests"`
+	CriticalFailures int64 `json:"critical_failures"`
+
+	// Scheduled cycles skipped or queued because earlier cycles were still
+	// running, and running cycles cancelled for a newer one
+	SkippedCycles   int64 `json:"skipped_cycles"`
+	QueuedCycles    int64 `json:"queued_cycles"`
+	CancelledCycles int64 `json:"cancelled_cycles"`
 }
 
 // NewMetrics creates a new metrics instance and starts the HTTP server
@@ -33,14 +66,21 @@ func NewMetrics(port int, path string, logger *Logger) *Metrics {
 		logger:           logger,
 		RequestCounts:    make(map[string]int64),
 		RequestDurations: make(map[string][]int64),
+		ResponseBytes:    make(map[string]int64),
 		SuccessRates:     make(map[string]float64),
+		FailureClasses:   make(map[string]int64),
 		LastUpdated:      time.Now(),
+		requestSeries:    make(map[requestLabels]int64),
+		latencies:        make(map[string]*histogram),
+		firstByte:        make(map[string]*histogram),
+		responseBytes:    make(map[string]int64),
 	}
 
 	// Create HTTP server for metrics endpoint
 	mux := http.NewServeMux()
 	mux.HandleFunc(path, metrics.metricsHandler)
 	mux.HandleFunc("/health", metrics.healthHandler)
+	mux.HandleFunc("/ready", metrics.readyHandler)
 
 	server := &http.Server{
 		Addr:         fmt.Sprintf(":%d", port),
@@ -50,6 +90,7 @@ func NewMetrics(port int, path string, logger *Logger) *Metrics {
 	}
 
 	metrics.server = server
+	metrics.mux = mux
 
 	// Start server in background
 	go func() {
@@ -62,15 +103,34 @@ func NewMetrics(port int, path string, logger *Logger) *Metrics {
 	return metrics
 }
 
-// RecordRequest records metrics for a completed request
-func (m *Metrics) RecordRequest(url, status string, duration time.Duration) {
+// RecordRequest records metrics for a completed request from its result:
+// the status code (0 if there was no response), cache status, time to first
+// byte and response size of its last attempt
+func (m *Metrics) RecordRequest(result *Result, status string, duration time.Duration) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	url := result.URL
+
 	// Update request counts
 	m.RequestCounts[url]++
 	m.TotalRequests++
 
+	// Update the labelled series
+	labels := newRequestLabels(url, result.StatusCode, result.CacheStatus)
+	m.requestSeries[labels]++
+	hostHistogram(m.latencies, labels.host).observe(duration)
+	m.latency.Observe(duration)
+	if result.TTFB > 0 {
+		hostHistogram(m.firstByte, labels.host).observe(result.TTFB)
+		m.ttfb.Observe(result.TTFB)
+	}
+
+	// Update transferred bytes
+	m.ResponseBytes[url] += result.Bytes
+	m.responseBytes[labels.host] += result.Bytes
+	m.TotalBytes += result.Bytes
+
 	// Update duration tracking
 	durationMs := duration.Milliseconds()
 	if m.RequestDurations[url] == nil {
@@ -91,9 +151,6 @@ func (m *Metrics) RecordRequest(url, status string, duration time.Duration) {
 	}
 
 	// Calculate success rate for this URL
-	successCount := int64(0)
-	totalCount := m.RequestCounts[url]
-
 	// This is a simplified calculation - in a real implementation,
 	// you'd want to track successes/failures per URL separately
 	if status == "success" {
@@ -105,24 +162,94 @@ func (m *Metrics) RecordRequest(url, status string, duration time.Duration) {
 	m.LastUpdated = time.Now()
 }
 
-// metricsHandler serves metrics data as JSON
+// hostHistogram returns the histogram of host in byHost, adding it if needed
+func hostHistogram(byHost map[string]*histogram, host string) *histogram {
+	h := byHost[host]
+	if h == nil {
+		h = &histogram{}
+		byHost[host] = h
+	}
+	return h
+}
+
+// RecordFailureClass counts a failed request under its error class
+func (m *Metrics) RecordFailureClass(class string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.FailureClasses[class]++
+}
+
+// SetCriticalResults records the critical URL outcome of the last run
+func (m *Metrics) SetCriticalResults(requests, failures int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.CriticalRequests = int64(requests)
+	m.CriticalFailures = int64(failures)
+}
+
+// RecordOverlap counts a scheduled cycle that was skipped or queued
+func (m *Metrics) RecordOverlap(queued bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if queued {
+		m.QueuedCycles++
+	} else {
+		m.SkippedCycles++
+	}
+}
+
+// RecordCancelledCycles counts running cycles cancelled by overlap policy
+// cancel
+func (m *Metrics) RecordCancelledCycles(count int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.CancelledCycles += int64(count)
+}
+
+// metricsHandler serves metrics in the Prometheus text format, or as JSON
+// with ?format=json
 func (m *Metrics) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
+	if r.URL.Query().Get("format") != "json" {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writePrometheus(w)
+		return
+	}
+
 	// Set content type
 	w.Header().Set("Content-Type", "application/json")
 
 	// Create response structure
 	response := struct {
-		Metrics     *Metrics  `json:"metrics"`
-		Summary     Summary   `json:"summary"`
-		GeneratedAt time.Time `json:"generated_at"`
+		Metrics     *Metrics                      `json:"metrics"`
+		Summary     Summary                       `json:"summary"`
+		URLLatency  map[string]LatencyPercentiles `json:"request_percentiles"`
+		Scheduler   *SchedulerStats               `json:"scheduler,omitempty"`
+		Fleet       *FleetReport                  `json:"fleet,omitempty"`
+		GeneratedAt time.Time                     `json:"generated_at"`
 	}{
 		Metrics:     m,
 		Summary:     m.calculateSummary(),
+		URLLatency:  make(map[string]LatencyPercentiles, len(m.RequestDurations)),
 		GeneratedAt: time.Now(),
 	}
+	for url, durations := range m.RequestDurations {
+		response.URLLatency[url] = samplePercentiles(durations)
+	}
+	if m.scheduler != nil {
+		stats := m.scheduler()
+		response.Scheduler = &stats
+	}
+	if m.fleet != nil {
+		fleet := m.fleet()
+		response.Fleet = &fleet
+	}
 
 	// Encode and send response
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -149,12 +276,60 @@ func (m *Metrics) healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
+// SetSchedulerSource sets the function reporting worker pool and queue gauges
+func (m *Metrics) SetSchedulerSource(source func() SchedulerStats) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.scheduler = source
+}
+
+// SetFleetSource sets the function reporting the fleet-wide view
+func (m *Metrics) SetFleetSource(source func() FleetReport) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.fleet = source
+}
+
+// HandleFunc serves an additional endpoint on the metrics server
+func (m *Metrics) HandleFunc(path string, handler http.HandlerFunc) {
+	m.mux.HandleFunc(path, handler)
+}
+
+// SetReady marks the process ready (or not) on the readiness endpoint
+func (m *Metrics) SetReady(ready bool) {
+	value := int32(0)
+	if ready {
+		value = 1
+	}
+	atomic.StoreInt32(&m.ready, value)
+}
+
+// readyHandler reports 200 once the initial warm has completed, 503 before
+func (m *Metrics) readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ready := atomic.LoadInt32(&m.ready) == 1
+	status := "warming"
+	if ready {
+		status = "ready"
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+		Ready  bool   `json:"ready"`
+	}{status, ready})
+}
+
 // Summary contains calculated summary statistics
 type Summary struct {
-	TotalURLs           int     `json:"total_urls"`
-	AverageResponseTime float64 `json:"average_response_time_ms"`
-	OverallSuccessRate  float64 `json:"overall_success_rate"`
-	RequestsPerSecond   float64 `json:"requests_per_second"`
+	TotalURLs           int                `json:"total_urls"`
+	AverageResponseTime float64            `json:"average_response_time_ms"`
+	ResponseTime        LatencyPercentiles `json:"response_time"`
+	TimeToFirstByte     LatencyPercentiles `json:"time_to_first_byte"`
+	OverallSuccessRate  float64            `json:"overall_success_rate"`
+	RequestsPerSecond   float64            `json:"requests_per_second"`
 }
 
 // calculateSummary calculates summary statistics from current metrics
@@ -177,6 +352,8 @@ func (m *Metrics) calculateSummary() Summary {
 	if totalMeasurements > 0 {
 		summary.AverageResponseTime = float64(totalDuration) / float64(totalMeasurements)
 	}
+	summary.ResponseTime = m.latency.Percentiles()
+	summary.TimeToFirstByte = m.ttfb.Percentiles()
 
 	// Calculate overall success rate
 	if m.TotalRequests > 0 {
@@ -200,10 +377,13 @@ func (m *Metrics) GetMetricsData() map[string]interface{} {
 	return map[string]interface{}{
 		"request_counts":    m.RequestCounts,
 		"request_durations": m.RequestDurations,
+		"response_bytes":    m.ResponseBytes,
 		"success_rates":     m.SuccessRates,
+		"failure_classes":   m.FailureClasses,
 		"total_requests":    m.TotalRequests,
 		"total_successes":   m.TotalSuccesses,
 		"total_failures":    m.TotalFailures,
+		"total_bytes":       m.TotalBytes,
 		"last_updated":      m.LastUpdated,
 		"summary":           m.calculateSummary(),
 	}
@@ -216,10 +396,19 @@ func (m *Metrics) Reset() {
 
 	m.RequestCounts = make(map[string]int64)
 	m.RequestDurations = make(map[string][]int64)
+	m.ResponseBytes = make(map[string]int64)
 	m.SuccessRates = make(map[string]float64)
+	m.FailureClasses = make(map[string]int64)
+	m.requestSeries = make(map[requestLabels]int64)
+	m.latencies = make(map[string]*histogram)
+	m.firstByte = make(map[string]*histogram)
+	m.responseBytes = make(map[string]int64)
+	m.latency.Reset()
+	m.ttfb.Reset()
 	m.TotalRequests = 0
 	m.TotalSuccesses = 0
 	m.TotalFailures = 0
+	m.TotalBytes = 0
 	m.LastUpdated = time.Now()
 }
 
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// normalizeURL returns rawURL with its scheme and host lowercased, a default
+// port dropped and dot-segments resolved, and the fragment and query
+// parameters stripped as configured. It returns rawURL unchanged if
+// normalization is disabled or the URL doesn't parse.
+func normalizeURL(config *NormalizeConfig, rawURL string) string {
+	if !config.Enabled {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+
+	// An empty reference resolves to the URL itself, minus dot-segments
+	u = u.ResolveReference(&url.URL{})
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	if config.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+	if len(config.StripQuery) > 0 && u.RawQuery != "" {
+		u.RawQuery = stripQuery(u.RawQuery, config.StripQuery)
+		u.ForceQuery = false
+	}
+	return u.String()
+}
+
+// stripQuery drops the parameters matching patterns from rawQuery, keeping
+// the order of the others since caches key on the query as sent
+func stripQuery(rawQuery string, patterns []string) string {
+	params := strings.Split(rawQuery, "&")
+	kept := params[:0]
+	for _, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(name); err == nil && matchesParam(patterns, name) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
+
+// matchesParam reports whether the query parameter name matches one of the
+// glob patterns
+func matchesParam(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeURLs normalizes urls as configured and returns how many changed
+func normalizeURLs(config *NormalizeConfig, urls []string) ([]string, int) {
+	if !config.Enabled {
+		return urls, 0
+	}
+	normalized := make([]string, len(urls))
+	var changed int
+	for i, url := range urls {
+		normalized[i] = normalizeURL(config, url)
+		if normalized[i] != url {
+			changed++
+		}
+	}
+	return normalized, changed
+}
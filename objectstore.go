@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ObjectStore uploads objects to a bucket in S3, GCS or Azure Blob Storage
+type ObjectStore interface {
+	// Put stores data under key, relative to the store's prefix
+	Put(ctx context.Context, key, contentType string, data []byte) error
+}
+
+// NewObjectStore creates an object store for a destination URL of the form
+// s3://bucket/prefix, gs://bucket/prefix or azblob://account/container/prefix.
+// Endpoint overrides the S3 endpoint for S3-compatible stores.
+func NewObjectStore(destination, endpoint, region string) (ObjectStore, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object store URL %q: %v", destination, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("object store URL %q has no bucket", destination)
+	}
+	prefix := strings.Trim(u.Path, "/")
+
+	switch u.Scheme {
+	case "s3":
+		return &s3Store{bucket: u.Host, prefix: prefix, endpoint: endpoint, region: awsRegion(region)}, nil
+	case "gs":
+		return &gcsStore{bucket: u.Host, prefix: prefix, tokens: newGoogleTokenSource("https://www.googleapis.com/auth/devstorage.read_write")}, nil
+	case "azblob":
+		parts := strings.SplitN(prefix, "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("object store URL %q has no container", destination)
+		}
+		store := &azureStore{account: u.Host, container: parts[0]}
+		if len(parts) == 2 {
+			store.prefix = parts[1]
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unsupported object store scheme %q, expected s3, gs or azblob", u.Scheme)
+	}
+}
+
+// joinKey joins a store prefix and a relative key
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// s3Store uploads to Amazon S3 or an S3-compatible endpoint
+type s3Store struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	region   string
+}
+
+// Put uploads an object with a SigV4-signed PUT
+func (s *s3Store) Put(ctx context.Context, key, contentType string, data []byte) error {
+	objectURL := s3ObjectURL(s.endpoint, s.region, s.bucket, joinKey(s.prefix, key))
+	header := http.Header{"Content-Type": {contentType}}
+	if _, err := doAWSRequest(ctx, "PUT", objectURL, data, header, s.region, "s3"); err != nil {
+		return fmt.Errorf("S3 upload failed: %v", err)
+	}
+	return nil
+}
+
+// gcsStore uploads to Google Cloud Storage using the JSON API
+type gcsStore struct {
+	bucket string
+	prefix string
+	tokens *googleTokenSource
+}
+
+// Put uploads an object with a simple media upload
+func (g *gcsStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	token, err := g.tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("GCS upload failed: %v", err)
+	}
+
+	uploadURL := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		url.PathEscape(g.bucket), url.QueryEscape(joinKey(g.prefix, key)))
+	req, err := http.NewRequestWithContext(ctx, "POST", uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+
+	return doUpload(req, "GCS")
+}
+
+// azureStore uploads block blobs to Azure Blob Storage, authenticating with
+// AZURE_STORAGE_SAS_TOKEN or a shared key in AZURE_STORAGE_KEY
+type azureStore struct {
+	account   string
+	container string
+	prefix    string
+}
+
+// azureStorageVersion is the Blob service REST API version used
+const azureStorageVersion = "2020-10-02"
+
+// Put uploads a block blob in a single request
+func (a *azureStore) Put(ctx context.Context, key, contentType string, data []byte) error {
+	blobPath := "/" + a.container + "/" + joinKey(a.prefix, key)
+	blobURL := fmt.Sprintf("https://%s.blob.core.windows.net%s", a.account, (&url.URL{Path: blobPath}).EscapedPath())
+
+	sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	if sas != "" {
+		blobURL += "?" + sas
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", blobURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Ms-Blob-Type", "BlockBlob")
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureStorageVersion)
+
+	if sas == "" {
+		accountKey := os.Getenv("AZURE_STORAGE_KEY")
+		if accountKey == "" {
+			return fmt.Errorf("Azure upload failed: set AZURE_STORAGE_SAS_TOKEN or AZURE_STORAGE_KEY")
+		}
+		if err := a.sign(req, blobPath, len(data), accountKey); err != nil {
+			return fmt.Errorf("Azure upload failed: %v", err)
+		}
+	}
+
+	return doUpload(req, "Azure")
+}
+
+// sign adds a Shared Key Authorization header to req
+func (a *azureStore) sign(req *http.Request, blobPath string, contentLength int, accountKey string) error {
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return fmt.Errorf("invalid AZURE_STORAGE_KEY: %v", err)
+	}
+
+	var msHeaders []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			msHeaders = append(msHeaders, lower+":"+strings.TrimSpace(req.Header.Get(name)))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	length := ""
+	if contentLength > 0 {
+		length = strconv.Itoa(contentLength)
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		"", // Content-Encoding
+		"", // Content-Language
+		length,
+		"", // Content-MD5
+		req.Header.Get("Content-Type"),
+		"", // Date (x-ms-date is used instead)
+		"", // If-Modified-Since
+		"", // If-Match
+		"", // If-None-Match
+		"", // If-Unmodified-Since
+		"", // Range
+		strings.Join(msHeaders, "\n"),
+		"/" + a.account + blobPath,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.account, signature))
+	return nil
+}
+
+// doUpload sends an upload request and turns non-2xx responses into errors
+func doUpload(req *http.Request, service string) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s upload failed: %v", service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s upload failed: %s: %s", service, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxOpenAPISpecSize caps the size of an OpenAPI document
+const maxOpenAPISpecSize = 20 << 20
+
+// openAPIDocument covers the parts of OpenAPI 3 and Swagger 2 documents
+// needed to generate GET requests
+type openAPIDocument struct {
+	OpenAPI string `yaml:"openapi"`
+	Swagger string `yaml:"swagger"`
+
+	// OpenAPI 3 servers
+	Servers []openAPIServer `yaml:"servers"`
+
+	// Swagger 2 server
+	Schemes  []string `yaml:"schemes"`
+	Host     string   `yaml:"host"`
+	BasePath string   `yaml:"basePath"`
+
+	Paths map[string]openAPIPathItem `yaml:"paths"`
+
+	// Reusable parameters: components/parameters in OpenAPI 3, parameters
+	// in Swagger 2
+	Components struct {
+		Parameters map[string]openAPIParameter `yaml:"parameters"`
+	} `yaml:"components"`
+	Parameters map[string]openAPIParameter `yaml:"parameters"`
+}
+
+// openAPIServer is an OpenAPI 3 server URL template
+type openAPIServer struct {
+	URL       string `yaml:"url"`
+	Variables map[string]struct {
+		Default string `yaml:"default"`
+	} `yaml:"variables"`
+}
+
+// openAPIPathItem is the set of operations on one path
+type openAPIPathItem struct {
+	Parameters []openAPIParameter `yaml:"parameters"`
+	Get        *openAPIOperation  `yaml:"get"`
+}
+
+// openAPIOperation is a single GET operation
+type openAPIOperation struct {
+	Tags       []string           `yaml:"tags"`
+	Parameters []openAPIParameter `yaml:"parameters"`
+}
+
+// openAPIParameter is a path, query, header or cookie parameter, or a $ref
+// to a reusable one
+type openAPIParameter struct {
+	Ref      string `yaml:"$ref"`
+	Name     string `yaml:"name"`
+	In       string `yaml:"in"`
+	Required bool   `yaml:"required"`
+
+	Example  interface{} `yaml:"example"`
+	Examples map[string]struct {
+		Value interface{} `yaml:"value"`
+	} `yaml:"examples"`
+	Schema *openAPISchema `yaml:"schema"`
+
+	// Swagger 2 declares these on the parameter itself
+	XExample interface{}   `yaml:"x-example"`
+	Default  interface{}   `yaml:"default"`
+	Enum     []interface{} `yaml:"enum"`
+}
+
+// openAPISchema holds the example values of a parameter schema
+type openAPISchema struct {
+	Example interface{}   `yaml:"example"`
+	Default interface{}   `yaml:"default"`
+	Enum    []interface{} `yaml:"enum"`
+}
+
+// fetchOpenAPIURLs loads the configured OpenAPI document and returns a URL
+// for every GET operation whose parameters can be filled in
+func (cw *CacheWarmer) fetchOpenAPIURLs(ctx context.Context) ([]string, error) {
+	config := &cw.CurrentConfig().OpenAPI
+
+	data, err := cw.loadOpenAPISpec(ctx, config.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec %s: %v", config.Spec, err)
+	}
+	if doc.OpenAPI == "" && doc.Swagger == "" {
+		return nil, fmt.Errorf("%s is not an OpenAPI or Swagger document", config.Spec)
+	}
+
+	server := config.Server
+	if server == "" {
+		server, err = doc.serverURL(config.Spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	server = strings.TrimRight(server, "/")
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var urls []string
+	for _, path := range paths {
+		item := doc.Paths[path]
+		if item.Get == nil || !matchesTags(config.Tags, item.Get.Tags) {
+			continue
+		}
+
+		endpoint, err := doc.endpoint(path, item, config.Parameters)
+		if err != nil {
+			cw.logger.Debug("Skipping OpenAPI operation GET %s: %v", path, err)
+			continue
+		}
+		urls = append(urls, server+endpoint)
+	}
+	return urls, nil
+}
+
+// loadOpenAPISpec reads the document from an http(s) URL or a local file
+func (cw *CacheWarmer) loadOpenAPISpec(ctx context.Context, spec string) ([]byte, error) {
+	var body io.Reader
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		req, err := cw.newRequest(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := cw.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch OpenAPI spec %s: %v", spec, err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch OpenAPI spec %s: status code %d", spec, resp.StatusCode)
+		}
+		body = resp.Body
+	} else {
+		file, err := os.Open(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open OpenAPI spec: %v", err)
+		}
+		defer file.Close()
+		body = file
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(body, maxOpenAPISpecSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI spec %s: %v", spec, err)
+	}
+	if n > maxOpenAPISpecSize {
+		return nil, fmt.Errorf("OpenAPI spec %s exceeds %d bytes", spec, maxOpenAPISpecSize)
+	}
+	return buf.Bytes(), nil
+}
+
+// serverURL returns the base URL operations are warmed against: the first
+// OpenAPI 3 server, or the Swagger 2 scheme, host and basePath. Relative
+// servers and a missing host are resolved against the spec's own URL.
+func (doc *openAPIDocument) serverURL(spec string) (string, error) {
+	var base *url.URL
+	if u, err := url.Parse(spec); err == nil && u.Host != "" {
+		base = u
+	}
+
+	var raw string
+	switch {
+	case len(doc.Servers) > 0:
+		server := doc.Servers[0]
+		raw = server.URL
+		for name, variable := range server.Variables {
+			raw = strings.ReplaceAll(raw, "{"+name+"}", variable.Default)
+		}
+	case doc.Swagger != "" && doc.Host != "":
+		scheme := "https"
+		if len(doc.Schemes) > 0 {
+			scheme = doc.Schemes[0]
+		}
+		raw = scheme + "://" + doc.Host + doc.BasePath
+	default:
+		// Swagger 2 without a host, and OpenAPI 3 without servers, are
+		// served from the document's own host
+		raw = doc.BasePath
+	}
+	if raw == "" {
+		raw = "/"
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL %q in OpenAPI spec: %v", raw, err)
+	}
+	if u.Host == "" {
+		if base == nil {
+			return "", fmt.Errorf("OpenAPI spec %s declares no absolute server URL; set openapi.server", spec)
+		}
+		u = base.ResolveReference(u)
+		if doc.Swagger != "" && len(doc.Schemes) > 0 {
+			u.Scheme = doc.Schemes[0]
+		}
+	}
+	return u.String(), nil
+}
+
+// endpoint fills in the path and query parameters of a GET operation,
+// returning an error if a required parameter has no value
+func (doc *openAPIDocument) endpoint(path string, item openAPIPathItem, overrides map[string]string) (string, error) {
+	// Operation parameters override path-level ones with the same name and location
+	params := make(map[string]openAPIParameter)
+	var order []string
+	for _, p := range append(append([]openAPIParameter{}, item.Parameters...), item.Get.Parameters...) {
+		p = doc.resolveParameter(p)
+		key := p.In + ":" + p.Name
+		if _, ok := params[key]; !ok {
+			order = append(order, key)
+		}
+		params[key] = p
+	}
+
+	query := url.Values{}
+	for _, key := range order {
+		p := params[key]
+		if p.In != "path" && p.In != "query" {
+			continue
+		}
+
+		values, ok := p.value(overrides)
+		if !ok {
+			if p.In == "path" || p.Required {
+				return "", fmt.Errorf("no example value for %s parameter %s", p.In, p.Name)
+			}
+			continue
+		}
+
+		// Optional query parameters are only sent if set explicitly, so
+		// the default variant of the response is warmed
+		if p.In == "query" {
+			if _, set := overrides[p.Name]; !set && !p.Required {
+				continue
+			}
+			query[p.Name] = values
+			continue
+		}
+
+		escaped := make([]string, len(values))
+		for i, v := range values {
+			escaped[i] = url.PathEscape(v)
+		}
+		path = strings.ReplaceAll(path, "{"+p.Name+"}", strings.Join(escaped, ","))
+	}
+
+	if strings.Contains(path, "{") {
+		return "", fmt.Errorf("undeclared parameter in path")
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path, nil
+}
+
+// resolveParameter follows a local $ref to a reusable parameter
+func (doc *openAPIDocument) resolveParameter(p openAPIParameter) openAPIParameter {
+	if p.Ref == "" {
+		return p
+	}
+	name := p.Ref[strings.LastIndex(p.Ref, "/")+1:]
+	switch {
+	case strings.HasPrefix(p.Ref, "#/components/parameters/"):
+		if resolved, ok := doc.Components.Parameters[name]; ok {
+			return resolved
+		}
+	case strings.HasPrefix(p.Ref, "#/parameters/"):
+		if resolved, ok := doc.Parameters[name]; ok {
+			return resolved
+		}
+	}
+	return p
+}
+
+// value returns the parameter's value: the configured override, or the
+// first of its example, examples, x-example, default or enum, on the
+// parameter or its schema
+func (p openAPIParameter) value(overrides map[string]string) ([]string, bool) {
+	if v, ok := overrides[p.Name]; ok {
+		return []string{v}, true
+	}
+
+	candidates := []interface{}{p.Example}
+	if len(p.Examples) > 0 {
+		names := make([]string, 0, len(p.Examples))
+		for name := range p.Examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		candidates = append(candidates, p.Examples[names[0]].Value)
+	}
+	candidates = append(candidates, p.XExample)
+	if p.Schema != nil {
+		candidates = append(candidates, p.Schema.Example)
+	}
+	candidates = append(candidates, p.Default)
+	if p.Schema != nil {
+		candidates = append(candidates, p.Schema.Default)
+	}
+	if len(p.Enum) > 0 {
+		candidates = append(candidates, p.Enum[0])
+	}
+	if p.Schema != nil && len(p.Schema.Enum) > 0 {
+		candidates = append(candidates, p.Schema.Enum[0])
+	}
+
+	for _, candidate := range candidates {
+		switch v := candidate.(type) {
+		case nil:
+			continue
+		case []interface{}:
+			if len(v) == 0 {
+				continue
+			}
+			values := make([]string, len(v))
+			for i, item := range v {
+				values[i] = fmt.Sprint(item)
+			}
+			return values, true
+		default:
+			return []string{fmt.Sprint(v)}, true
+		}
+	}
+	return nil, false
+}
+
+// matchesTags reports whether an operation with the given tags is selected;
+// every operation is if no tags are configured
+func matchesTags(selected, tags []string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if containsFold(selected, tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sitemapDefaultPriority is the <priority> of sitemap entries without one,
+// as defined by the sitemaps protocol, and of URLs from other sources
+const sitemapDefaultPriority = 0.5
+
+// orderURLs puts a cycle's URLs in the configured dispatch order
+func (cw *CacheWarmer) orderURLs(urls []string) []string {
+	switch cw.config.Order {
+	case OrderSlowestFirst:
+		return cw.history.SortSlowestFirst(urls)
+	case OrderShuffled:
+		shuffled := make([]string, len(urls))
+		copy(shuffled, urls)
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		return shuffled
+	case OrderSitemapPriority:
+		return cw.sitemapPriorities.Sort(urls)
+	}
+	return urls
+}
+
+// sitemapPriorities are the <priority> values of the sitemap entries read
+// last, for order sitemap-priority and weighted sampling
+type sitemapPriorities struct {
+	mutex sync.Mutex
+
+	// values holds the entries whose priority isn't the default
+	values map[string]float64
+}
+
+// Set records the <priority> text of a sitemap entry
+func (p *sitemapPriorities) Set(url, priority string) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(priority), 64)
+	if err != nil || value < 0 || value > 1 {
+		value = sitemapDefaultPriority
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if value == sitemapDefaultPriority {
+		delete(p.values, url)
+		return
+	}
+	if p.values == nil {
+		p.values = make(map[string]float64)
+	}
+	p.values[url] = value
+}
+
+// Weights returns the sitemap priority of each of urls
+func (p *sitemapPriorities) Weights(urls []string) []float64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	weights := make([]float64, len(urls))
+	for i, url := range urls {
+		weights[i] = sitemapDefaultPriority
+		if value, ok := p.values[url]; ok {
+			weights[i] = value
+		}
+	}
+	return weights
+}
+
+// Sort orders urls by descending sitemap priority, keeping the listed order
+// among URLs of equal priority
+func (p *sitemapPriorities) Sort(urls []string) []string {
+	weights := p.Weights(urls)
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return weights[order[i]] > weights[order[j]]
+	})
+
+	sorted := make([]string, len(urls))
+	for i, index := range order {
+		sorted[i] = urls[index]
+	}
+	return sorted
+}
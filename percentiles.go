@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Latency histogram buckets grow by latencyBucketGrowth from
+// latencyBucketMin, so a reported percentile is within 10% of the true one;
+// the last bucket holds everything beyond about ten minutes
+const (
+	latencyBucketMin    = 100 * time.Microsecond
+	latencyBucketGrowth = 1.1
+	latencyBucketCount  = 165
+)
+
+// LatencyPercentiles are the p50, p90, p95 and p99 of request durations
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+}
+
+// MarshalJSON encodes the percentiles in milliseconds
+func (p LatencyPercentiles) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return json.Marshal(struct {
+		P50 float64 `json:"p50_ms"`
+		P90 float64 `json:"p90_ms"`
+		P95 float64 `json:"p95_ms"`
+		P99 float64 `json:"p99_ms"`
+	}{ms(p.P50), ms(p.P90), ms(p.P95), ms(p.P99)})
+}
+
+// latencyHistogram counts request durations into exponentially growing
+// buckets. It is safe for concurrent use without locking.
+type latencyHistogram struct {
+	counts [latencyBucketCount]int64
+	total  int64
+	max    int64
+}
+
+// latencyBucket returns the index of the bucket d falls in
+func latencyBucket(d time.Duration) int {
+	if d <= latencyBucketMin {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(latencyBucketMin)) / math.Log(latencyBucketGrowth)))
+	if i >= latencyBucketCount {
+		return latencyBucketCount - 1
+	}
+	return i
+}
+
+// Observe adds a request duration
+func (h *latencyHistogram) Observe(d time.Duration) {
+	atomic.AddInt64(&h.counts[latencyBucket(d)], 1)
+	atomic.AddInt64(&h.total, 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			return
+		}
+	}
+}
+
+// Reset clears the histogram for a new run
+func (h *latencyHistogram) Reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.total, 0)
+	atomic.StoreInt64(&h.max, 0)
+}
+
+// Quantile returns the duration q of the observations are at or below: the
+// upper bound of the bucket holding that rank, capped at the longest
+// observation. It returns 0 with no observations.
+func (h *latencyHistogram) Quantile(q float64) time.Duration {
+	total := atomic.LoadInt64(&h.total)
+	if total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(total)))
+	max := time.Duration(atomic.LoadInt64(&h.max))
+
+	var seen int64
+	for i := range h.counts {
+		seen += atomic.LoadInt64(&h.counts[i])
+		if seen >= rank {
+			bound := time.Duration(float64(latencyBucketMin) * math.Pow(latencyBucketGrowth, float64(i)))
+			if i == latencyBucketCount-1 || bound > max {
+				return max
+			}
+			return bound
+		}
+	}
+	return max
+}
+
+// Percentiles returns the p50, p90, p95 and p99 of the observations
+func (h *latencyHistogram) Percentiles() LatencyPercentiles {
+	return LatencyPercentiles{
+		P50: h.Quantile(0.50),
+		P90: h.Quantile(0.90),
+		P95: h.Quantile(0.95),
+		P99: h.Quantile(0.99),
+	}
+}
+
+// samplePercentiles returns the exact percentiles of a small sample of
+// durations in milliseconds, such as the recent durations of one URL
+func samplePercentiles(samples []int64) LatencyPercentiles {
+	if len(samples) == 0 {
+		return LatencyPercentiles{}
+	}
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(q float64) time.Duration {
+		rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return time.Duration(sorted[rank]) * time.Millisecond
+	}
+	return LatencyPercentiles{P50: at(0.50), P90: at(0.90), P95: at(0.95), P99: at(0.99)}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// politenessPacer spaces one worker's consecutive requests to the same host
+// by a minimum delay plus random jitter, like a polite crawler. Each worker
+// owns its pacer, so no locking is needed.
+type politenessPacer struct {
+	config *PolitenessConfig
+	hosts  map[string]bool
+	last   map[string]time.Time
+}
+
+// newPolitenessPacer creates a pacer, or returns nil if politeness is disabled
+func newPolitenessPacer(config *PolitenessConfig) *politenessPacer {
+	if config.Delay <= 0 && config.Jitter <= 0 {
+		return nil
+	}
+
+	p := &politenessPacer{
+		config: config,
+		last:   make(map[string]time.Time),
+	}
+	if len(config.Hosts) > 0 {
+		p.hosts = make(map[string]bool, len(config.Hosts))
+		for _, host := range config.Hosts {
+			p.hosts[strings.ToLower(host)] = true
+		}
+	}
+	return p
+}
+
+// Wait blocks until a request to rawURL's host is allowed and marks the
+// request as made. It returns early with the context error if ctx is cancelled.
+func (p *politenessPacer) Wait(ctx context.Context, rawURL string) error {
+	if p == nil {
+		return nil
+	}
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	if p.hosts != nil && !p.hosts[host] {
+		return nil
+	}
+
+	if last, ok := p.last[host]; ok {
+		interval := p.config.Delay
+		if p.config.Jitter > 0 {
+			interval += time.Duration(rand.Int63n(int64(p.config.Jitter) + 1))
+		}
+
+		if delay := time.Until(last.Add(interval)); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	p.last[host] = time.Now()
+	return nil
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// predicateEnv is what a success predicate is evaluated against
+type predicateEnv struct {
+	status   int
+	duration time.Duration
+	header   http.Header
+	cache    string
+	path     string
+}
+
+// predicate is a compiled success expression such as
+// status in 200..299 and header["X-Cache"] != "BYPASS" and duration < 2s
+type predicate struct {
+	source string
+	eval   func(env *predicateEnv) bool
+}
+
+// Eval reports whether the response described by env satisfies the predicate
+func (p *predicate) Eval(env *predicateEnv) bool {
+	return p.eval(env)
+}
+
+// valueKind is the type of an operand; only operands of the same kind can
+// be compared
+type valueKind int
+
+const (
+	kindNumber valueKind = iota
+	kindDuration
+	kindString
+)
+
+func (k valueKind) String() string {
+	switch k {
+	case kindNumber:
+		return "number"
+	case kindDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// operand is a compiled value; numbers and durations are held in num,
+// strings in str
+type operand struct {
+	kind valueKind
+	num  func(env *predicateEnv) float64
+	str  func(env *predicateEnv) string
+}
+
+// compilePredicate parses a success expression. It supports the fields
+// status, duration, cache, path and header["Name"]; comparisons with ==, !=,
+// <, <=, >, >= and contains; in with a range (200..299) or a list
+// ([200, 304]); and, or, not and parentheses.
+func compilePredicate(source string) (*predicate, error) {
+	tokens, err := tokenizePredicate(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid success expression %q: %v", source, err)
+	}
+	p := &predicateParser{tokens: tokens}
+	eval, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid success expression %q: %v", source, err)
+	}
+	return &predicate{source: source, eval: eval}, nil
+}
+
+// Token types of the expression language
+const (
+	tokenIdent = iota
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenSymbol
+)
+
+type predicateToken struct {
+	kind int
+	text string
+
+	// Decoded literal value
+	num float64
+	str string
+}
+
+// tokenizePredicate splits an expression into identifiers, literals and
+// symbols
+func tokenizePredicate(source string) ([]predicateToken, error) {
+	var tokens []predicateToken
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+
+		case c == '"':
+			end := i + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			text := source[i : end+1]
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", text)
+			}
+			tokens = append(tokens, predicateToken{kind: tokenString, text: text, str: value})
+			i = end + 1
+
+		case c >= '0' && c <= '9':
+			end := i
+			for end < len(source) && unicode.IsDigit(rune(source[end])) {
+				end++
+			}
+			// A fraction, but not the start of a range
+			if end+1 < len(source) && source[end] == '.' && unicode.IsDigit(rune(source[end+1])) {
+				end++
+				for end < len(source) && unicode.IsDigit(rune(source[end])) {
+					end++
+				}
+			}
+			number := end
+			for end < len(source) && unicode.IsLetter(rune(source[end])) {
+				end++
+			}
+			text := source[i:end]
+			if end > number {
+				d, err := time.ParseDuration(text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid duration %s", text)
+				}
+				tokens = append(tokens, predicateToken{kind: tokenDuration, text: text, num: float64(d)})
+			} else {
+				n, err := strconv.ParseFloat(text, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %s", text)
+				}
+				tokens = append(tokens, predicateToken{kind: tokenNumber, text: text, num: n})
+			}
+			i = end
+
+		case c == '_' || unicode.IsLetter(rune(c)):
+			end := i
+			for end < len(source) && (source[end] == '_' || unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, predicateToken{kind: tokenIdent, text: source[i:end]})
+			i = end
+
+		default:
+			symbol := ""
+			for _, s := range []string{"==", "!=", "<=", ">=", "..", "<", ">", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(source[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if symbol == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, predicateToken{kind: tokenSymbol, text: symbol})
+			i += len(symbol)
+		}
+	}
+	return tokens, nil
+}
+
+// predicateParser is a recursive descent parser over the tokens
+type predicateParser struct {
+	tokens []predicateToken
+	pos    int
+}
+
+// peek reports whether the next token has the given text
+func (p *predicateParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].text == text
+}
+
+// accept consumes the next token if it has the given text
+func (p *predicateParser) accept(text string) bool {
+	if p.peek(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes a token with the given text or fails
+func (p *predicateParser) expect(text string) error {
+	if p.accept(text) {
+		return nil
+	}
+	if p.pos >= len(p.tokens) {
+		return fmt.Errorf("expected %q at end of expression", text)
+	}
+	return fmt.Errorf("expected %q, found %q", text, p.tokens[p.pos].text)
+}
+
+// next consumes and returns the next token
+func (p *predicateParser) next() (predicateToken, error) {
+	if p.pos >= len(p.tokens) {
+		return predicateToken{}, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *predicateParser) parseOr() (func(*predicateEnv) bool, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *predicateEnv) bool { return l(env) || right(env) }
+	}
+	return left, nil
+}
+
+func (p *predicateParser) parseAnd() (func(*predicateEnv) bool, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *predicateEnv) bool { return l(env) && right(env) }
+	}
+	return left, nil
+}
+
+func (p *predicateParser) parseNot() (func(*predicateEnv) bool, error) {
+	if p.accept("not") {
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *predicateEnv) bool { return !inner(env) }, nil
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses operand op operand, operand [not] in set, or
+// operand contains operand
+func (p *predicateParser) parseComparison() (func(*predicateEnv) bool, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	negate := p.accept("not")
+	if negate && !p.peek("in") {
+		return nil, p.expect("in")
+	}
+	if p.accept("in") {
+		in, err := p.parseSet(left)
+		if err != nil {
+			return nil, err
+		}
+		if negate {
+			return func(env *predicateEnv) bool { return !in(env) }, nil
+		}
+		return in, nil
+	}
+
+	op, err := p.next()
+	if err != nil {
+		return nil, fmt.Errorf("expected a comparison after %s", p.tokens[p.pos-1].text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if left.kind != right.kind {
+		return nil, fmt.Errorf("cannot compare %s with %s", left.kind, right.kind)
+	}
+
+	if left.kind == kindString {
+		l, r := left.str, right.str
+		switch op.text {
+		case "==":
+			return func(env *predicateEnv) bool { return l(env) == r(env) }, nil
+		case "!=":
+			return func(env *predicateEnv) bool { return l(env) != r(env) }, nil
+		case "contains":
+			return func(env *predicateEnv) bool { return strings.Contains(l(env), r(env)) }, nil
+		}
+		return nil, fmt.Errorf("operator %q does not apply to strings", op.text)
+	}
+
+	l, r := left.num, right.num
+	switch op.text {
+	case "==":
+		return func(env *predicateEnv) bool { return l(env) == r(env) }, nil
+	case "!=":
+		return func(env *predicateEnv) bool { return l(env) != r(env) }, nil
+	case "<":
+		return func(env *predicateEnv) bool { return l(env) < r(env) }, nil
+	case "<=":
+		return func(env *predicateEnv) bool { return l(env) <= r(env) }, nil
+	case ">":
+		return func(env *predicateEnv) bool { return l(env) > r(env) }, nil
+	case ">=":
+		return func(env *predicateEnv) bool { return l(env) >= r(env) }, nil
+	}
+	return nil, fmt.Errorf("unknown operator %q", op.text)
+}
+
+// parseSet parses the range or list after in
+func (p *predicateParser) parseSet(left operand) (func(*predicateEnv) bool, error) {
+	if p.accept("[") {
+		var members []operand
+		for {
+			member, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			if member.kind != left.kind {
+				return nil, fmt.Errorf("cannot compare %s with %s", left.kind, member.kind)
+			}
+			members = append(members, member)
+			if !p.accept(",") {
+				break
+			}
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return func(env *predicateEnv) bool {
+			for _, m := range members {
+				if left.kind == kindString && left.str(env) == m.str(env) ||
+					left.kind != kindString && left.num(env) == m.num(env) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	low, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(".."); err != nil {
+		return nil, err
+	}
+	high, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if left.kind == kindString || low.kind != left.kind || high.kind != left.kind {
+		return nil, fmt.Errorf("range of %s and %s does not apply to %s", low.kind, high.kind, left.kind)
+	}
+	return func(env *predicateEnv) bool {
+		v := left.num(env)
+		return v >= low.num(env) && v <= high.num(env)
+	}, nil
+}
+
+// parseOperand parses a field or a literal
+func (p *predicateParser) parseOperand() (operand, error) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenIdent {
+		name := p.tokens[p.pos].text
+		p.pos++
+		switch name {
+		case "status":
+			return operand{kind: kindNumber, num: func(env *predicateEnv) float64 { return float64(env.status) }}, nil
+		case "duration":
+			return operand{kind: kindDuration, num: func(env *predicateEnv) float64 { return float64(env.duration) }}, nil
+		case "cache":
+			return operand{kind: kindString, str: func(env *predicateEnv) string { return env.cache }}, nil
+		case "path":
+			return operand{kind: kindString, str: func(env *predicateEnv) string { return env.path }}, nil
+		case "header":
+			if err := p.expect("["); err != nil {
+				return operand{}, err
+			}
+			key, err := p.next()
+			if err != nil {
+				return operand{}, err
+			}
+			if key.kind != tokenString {
+				return operand{}, fmt.Errorf("header name must be a string, found %s", key.text)
+			}
+			if err := p.expect("]"); err != nil {
+				return operand{}, err
+			}
+			return operand{kind: kindString, str: func(env *predicateEnv) string { return env.header.Get(key.str) }}, nil
+		}
+		return operand{}, fmt.Errorf("unknown field %s", name)
+	}
+	return p.parseLiteral()
+}
+
+// parseLiteral parses a number, duration or string
+func (p *predicateParser) parseLiteral() (operand, error) {
+	t, err := p.next()
+	if err != nil {
+		return operand{}, err
+	}
+	switch t.kind {
+	case tokenNumber:
+		return operand{kind: kindNumber, num: func(*predicateEnv) float64 { return t.num }}, nil
+	case tokenDuration:
+		return operand{kind: kindDuration, num: func(*predicateEnv) float64 { return t.num }}, nil
+	case tokenString:
+		return operand{kind: kindString, str: func(*predicateEnv) string { return t.str }}, nil
+	}
+	return operand{}, fmt.Errorf("expected a value, found %q", t.text)
+}
+
+// successRule decides success for the URLs matching its pattern in place of
+// the success_codes list
+type successRule struct {
+	match *urlPattern
+	when  *predicate
+}
+
+// compileSuccessRules compiles the configured success rules in order
+func compileSuccessRules(configs []SuccessRuleConfig) ([]successRule, error) {
+	rules := make([]successRule, 0, len(configs))
+	for _, rc := range configs {
+		when, err := compilePredicate(rc.When)
+		if err != nil {
+			return nil, err
+		}
+		rule := successRule{when: when}
+		if rc.Match != "" {
+			pattern := compileURLPattern(rc.Match)
+			rule.match = &pattern
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// successRuleFor returns the first success rule matching rawURL, or nil if
+// the URL is judged by its status code alone
+func (cw *CacheWarmer) successRuleFor(rawURL string) *successRule {
+	for i := range cw.successRules {
+		rule := &cw.successRules[i]
+		if rule.match == nil || rule.match.Match(rawURL) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// check evaluates the rule against a completed response
+func (r *successRule) check(resp *http.Response, cacheStatus string, duration time.Duration) error {
+	env := &predicateEnv{
+		status:   resp.StatusCode,
+		duration: duration,
+		header:   resp.Header,
+		cache:    cacheStatus,
+		path:     resp.Request.URL.Path,
+	}
+	if r.when.Eval(env) {
+		return nil
+	}
+	return &AssertionError{
+		Check:   "success",
+		Message: fmt.Sprintf("%s is false for status %d in %v", r.when.source, resp.StatusCode, duration.Round(time.Millisecond)),
+	}
+}
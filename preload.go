@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// preloadLinkRels are the Link header relations a browser fetches right away
+// or while idle
+var preloadLinkRels = map[string]bool{
+	"preload":       true,
+	"modulepreload": true,
+	"prefetch":      true,
+}
+
+// preloadLinks returns the absolute URLs of the preload and prefetch
+// entries of the Link headers in header, resolved against base
+func preloadLinks(header http.Header, base *url.URL) []string {
+	var links []string
+	for _, value := range header.Values("Link") {
+		for _, entry := range splitLinkHeader(value) {
+			target, params, ok := parseLinkEntry(entry)
+			if !ok {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(params["rel"])) {
+				if preloadLinkRels[rel] {
+					if link := resolveLink(base, target); link != "" {
+						links = append(links, link)
+					}
+					break
+				}
+			}
+		}
+	}
+	return links
+}
+
+// splitLinkHeader splits a Link header into its comma-separated entries,
+// ignoring commas inside <URI references> and quoted parameters
+func splitLinkHeader(value string) []string {
+	var entries []string
+	start, inURI, inQuote := 0, false, false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case inQuote:
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inQuote = false
+			}
+		case c == '<':
+			inURI = true
+		case c == '>':
+			inURI = false
+		case c == '"' && !inURI:
+			inQuote = true
+		case c == ',' && !inURI:
+			entries = append(entries, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(entries, value[start:])
+}
+
+// parseLinkEntry parses one `<target>; rel=preload; as=style` entry into its
+// target and lowercased parameters
+func parseLinkEntry(entry string) (string, map[string]string, bool) {
+	entry = strings.TrimSpace(entry)
+	end := strings.IndexByte(entry, '>')
+	if !strings.HasPrefix(entry, "<") || end < 0 {
+		return "", nil, false
+	}
+	target := entry[1:end]
+
+	params := make(map[string]string)
+	for _, param := range strings.Split(entry[end+1:], ";") {
+		key, value, _ := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		if _, seen := params[key]; !seen {
+			params[key] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return target, params, true
+}
+
+// discoverPreloads records the preloaded resources of a warmed response as
+// assets of run r
+func (cw *CacheWarmer) discoverPreloads(r *warmRun, links []string) {
+	r.crawlMutex.Lock()
+	defer r.crawlMutex.Unlock()
+	if r.crawler != nil {
+		r.crawler.add(&r.crawler.assets, links, r.crawler.assetHosts)
+	}
+}
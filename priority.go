@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+// prioritySet returns the priority of every URL entry given one, including
+// those of URL groups, or nil if no entry has one
+func prioritySet(config *Config) map[string]int {
+	var set map[string]int
+	add := func(entries []URLEntry) {
+		for _, entry := range entries {
+			if entry.Priority == 0 {
+				continue
+			}
+			if set == nil {
+				set = make(map[string]int)
+			}
+			set[normalizeURL(&config.Normalize, entry.URL)] = entry.Priority
+		}
+	}
+	add(config.URLs)
+	for i := range config.Groups {
+		add(config.Groups[i].URLs)
+	}
+	return set
+}
+
+// urlPriority returns the priority of url in the run ctx belongs to: its
+// entry's own, else its group's, else 0
+func (cw *CacheWarmer) urlPriority(ctx context.Context, url string) int {
+	if priority, ok := cw.settingsOf(ctx).priorities[normalizeURL(&cw.config.Normalize, url)]; ok {
+		return priority
+	}
+	if g := groupOf(ctx); g != nil {
+		return g.config.Priority
+	}
+	return 0
+}
+
+// prioritize orders urls by descending priority, keeping the existing order
+// among URLs of equal priority. warmInto dispatches URLs in that order, so
+// under a cycle deadline the lowest priorities are the ones left out.
+func (cw *CacheWarmer) prioritize(ctx context.Context, urls []string) []string {
+	// URLs without a priority of their own all share their run's
+	if cw.settingsOf(ctx).priorities == nil {
+		return urls
+	}
+
+	priorities := make([]int, len(urls))
+	for i, url := range urls {
+		priorities[i] = cw.urlPriority(ctx, url)
+	}
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return priorities[order[i]] > priorities[order[j]]
+	})
+
+	sorted := make([]string, len(urls))
+	for i, index := range order {
+		sorted[i] = urls[index]
+	}
+	return sorted
+}
+
+// priorityFailures counts the failed requests among results for URLs of at
+// least the given priority
+func priorityFailures(results []Result, priority int) int64 {
+	var failures int64
+	for _, result := range results {
+		if !result.Success && result.Priority >= priority {
+			failures++
+		}
+	}
+	return failures
+}
+
+// sortGroupsByPriority orders groups by descending priority, keeping the
+// configured order among groups of equal priority
+func sortGroupsByPriority(groups []*urlGroup) []*urlGroup {
+	sorted := make([]*urlGroup, len(groups))
+	copy(sorted, groups)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].config.Priority > sorted[j].config.Priority
+	})
+	return sorted
+}
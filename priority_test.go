@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestSelectURLsLimitKeepsHighestPrioritiesInOrder(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	urls := testURLs(origin, "page", 4)
+	cw := newTestWarmer(t, origin, func(c *Config) {
+		c.URLs = []URLEntry{
+			{URL: urls[0]},
+			{URL: urls[1], Priority: 5},
+			{URL: urls[2]},
+			{URL: urls[3], Priority: 10},
+		}
+		c.Limit = 3
+	})
+
+	// Selection leaves the order to warmInto, which dispatches by priority
+	got := cw.selectURLs(context.Background(), append(urls, urls[1]))
+	want := []string{urls[0], urls[1], urls[3], urls[1]}
+	if !slices.Equal(got, want) {
+		t.Errorf("selected %v, want %v", got, want)
+	}
+}
+
+func TestSampleTopKeepsHighestPriorities(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	urls := testURLs(origin, "page", 4)
+	cw := newTestWarmer(t, origin, func(c *Config) {
+		c.URLs = []URLEntry{
+			{URL: urls[0]},
+			{URL: urls[1]},
+			{URL: urls[2], Priority: 5},
+			{URL: urls[3]},
+		}
+		c.MaxURLs = 2
+	})
+
+	got := cw.sampleURLs(context.Background(), urls)
+	want := []string{urls[0], urls[2]}
+	if !slices.Equal(got, want) {
+		t.Errorf("sampled %v, want %v", got, want)
+	}
+}
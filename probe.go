@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// probeBodyExcerpt is the number of body bytes shown in a probe breakdown
+const probeBodyExcerpt = 512
+
+// ProbeReport is a detailed breakdown of a single warm request
+type ProbeReport struct {
+	URL             string
+	Region          string
+	Device          string
+	RequestHeaders  http.Header
+	Redirects       []string
+	RemoteAddr      string
+	Proto           string
+	StatusCode      int
+	ResponseHeaders http.Header
+	CacheStatus     string
+	TTL             time.Duration
+	TTLKnown        bool
+	BodyBytes       int64
+	BodyExcerpt     string
+	DNS             time.Duration
+	Connect         time.Duration
+	TLS             time.Duration
+	TTFB            time.Duration
+	Total           time.Duration
+	Success         bool
+	Err             error
+}
+
+// Probe performs a single warm request through the configured pipeline, as
+// the named device if any, and returns a verbose breakdown of what happened.
+// The response is judged as a warm request would judge it. An unknown region
+// name probes the first configured region (or the direct path).
+func (cw *CacheWarmer) Probe(ctx context.Context, url, regionName, device string) *ProbeReport {
+	r := cw.regions[0]
+	for _, candidate := range cw.regions {
+		if candidate.name == regionName {
+			r = candidate
+			break
+		}
+	}
+
+	report := &ProbeReport{URL: url, Region: r.name, Device: device}
+	start := time.Now()
+	defer func() { report.Total = time.Since(start) }()
+
+	// Record redirect hops while keeping the configured redirect policy,
+	// and the exchange itself
+	client := *r.client
+	checkRedirect := client.CheckRedirect
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		report.Redirects = append(report.Redirects, next.URL.String())
+		return checkRedirect(next, via)
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client.Transport = &probeTransport{base: transport, report: report}
+
+	// The request itself goes through the warm path, with its method,
+	// device, success rules and locale checks
+	result := Result{URL: url, Region: r.name, Device: device}
+	ctx = httptrace.WithClientTrace(ctx, probeClientTrace(report, start))
+	report.Success, report.Err = cw.makeRequest(withProbe(ctx), &client, url, &result)
+	report.StatusCode = result.StatusCode
+	report.CacheStatus = result.CacheStatus
+	report.TTL, report.TTLKnown = result.TTL, result.TTLKnown
+
+	return report
+}
+
+// probeContextKey marks the context of a probe request
+type probeContextKey struct{}
+
+// withProbe returns a context for a probe request, which leaves the state
+// kept for warm requests, such as stored validators, alone
+func withProbe(ctx context.Context) context.Context {
+	return context.WithValue(ctx, probeContextKey{}, true)
+}
+
+// isProbe reports whether ctx belongs to a probe request
+func isProbe(ctx context.Context) bool {
+	probe, _ := ctx.Value(probeContextKey{}).(bool)
+	return probe
+}
+
+// probeTransport records the exchange of a probe: the request headers as
+// sent and the protocol, headers and body of the final response
+type probeTransport struct {
+	base   http.RoundTripper
+	report *ProbeReport
+}
+
+// RoundTrip sends req and captures it and its response
+func (t *probeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.report.RequestHeaders == nil {
+		t.report.RequestHeaders = req.Header.Clone()
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each redirect hop replaces the response of the one before
+	t.report.Proto = resp.Proto
+	t.report.ResponseHeaders = resp.Header
+	t.report.BodyExcerpt = ""
+	t.report.BodyBytes = 0
+	resp.Body = &probeBody{body: resp.Body, report: t.report}
+	return resp, nil
+}
+
+// probeBody keeps the start of a probed response body and counts its bytes,
+// including those the warm path leaves unread
+type probeBody struct {
+	body   io.ReadCloser
+	report *ProbeReport
+}
+
+// Read reads from the body, capturing what is read
+func (b *probeBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if room := probeBodyExcerpt - len(b.report.BodyExcerpt); room > 0 {
+		b.report.BodyExcerpt += string(p[:min(n, room)])
+	}
+	b.report.BodyBytes += int64(n)
+	return n, err
+}
+
+// Close reads the rest of the body, e.g. of an error page, then closes it
+func (b *probeBody) Close() error {
+	io.Copy(io.Discard, b)
+	return b.body.Close()
+}
+
+// probeClientTrace returns a client trace that records the connection and
+// timing breakdown of a request started at start into report
+func probeClientTrace(report *ProbeReport, start time.Time) *httptrace.ClientTrace {
+	var dnsStart, connectStart, tlsStart time.Time
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { report.DNS = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { report.Connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { report.TLS = time.Since(tlsStart) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			report.RemoteAddr = info.Conn.RemoteAddr().String()
+		},
+		GotFirstResponseByte: func() { report.TTFB = time.Since(start) },
+	}
+}
+
+// hasRegion reports whether a region with the given name is configured
+func (cw *CacheWarmer) hasRegion(name string) bool {
+	for _, r := range cw.regions {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes a human-readable breakdown of the probe
+func (r *ProbeReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "URL:           %s\n", r.URL)
+	if r.Region != "" {
+		fmt.Fprintf(w, "Region:        %s\n", r.Region)
+	}
+	if r.Device != "" {
+		fmt.Fprintf(w, "Device:        %s\n", r.Device)
+	}
+
+	fmt.Fprintln(w, "Request headers:")
+	printHeaders(w, r.RequestHeaders)
+
+	for i, hop := range r.Redirects {
+		fmt.Fprintf(w, "Redirect %d:    %s\n", i+1, hop)
+	}
+
+	if r.StatusCode > 0 {
+		fmt.Fprintf(w, "Remote addr:   %s\n", r.RemoteAddr)
+		fmt.Fprintf(w, "Status:        %d %s (%s)\n", r.StatusCode, http.StatusText(r.StatusCode), r.Proto)
+		fmt.Fprintln(w, "Response headers:")
+		printHeaders(w, r.ResponseHeaders)
+
+		cacheStatus := r.CacheStatus
+		if cacheStatus == CacheStatusUnknown {
+			cacheStatus = "unknown (no recognised cache headers)"
+		}
+		fmt.Fprintf(w, "Cache status:  %s\n", cacheStatus)
+		fmt.Fprintf(w, "Body size:     %d bytes\n", r.BodyBytes)
+	}
+
+	fmt.Fprintln(w, "Timing:")
+	fmt.Fprintf(w, "  DNS lookup:  %v\n", r.DNS.Round(time.Microsecond))
+	fmt.Fprintf(w, "  Connect:     %v\n", r.Connect.Round(time.Microsecond))
+	fmt.Fprintf(w, "  TLS:         %v\n", r.TLS.Round(time.Microsecond))
+	fmt.Fprintf(w, "  First byte:  %v\n", r.TTFB.Round(time.Microsecond))
+	fmt.Fprintf(w, "  Total:       %v\n", r.Total.Round(time.Microsecond))
+
+	if r.BodyExcerpt != "" {
+		fmt.Fprintf(w, "Body excerpt:\n  %s\n", strings.ReplaceAll(strings.TrimSpace(r.BodyExcerpt), "\n", "\n  "))
+	}
+
+	if r.Success {
+		fmt.Fprintln(w, "Result:        WARMED")
+	} else {
+		fmt.Fprintf(w, "Result:        FAILED (%s): %v\n", ErrorClass(r.Err), r.Err)
+	}
+}
+
+// printHeaders writes headers in sorted order, one per line
+func printHeaders(w io.Writer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(w, "  %s: %s\n", key, value)
+		}
+	}
+}
+
+// runProbeInteractive reads URLs from stdin and probes each one until EOF
+// or "quit"
+func runProbeInteractive(warmer *CacheWarmer, regions []string) {
+	scanner := bufio.NewScanner(os.Stdin)
+	fmt.Println("Enter a URL to probe, or \"quit\" to exit.")
+
+	for {
+		fmt.Print("probe> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "quit", "exit":
+			return
+		}
+
+		if err := ValidateURL(line); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+
+		for _, name := range regions {
+			for _, device := range warmer.jobDevices() {
+				warmer.Probe(context.Background(), line, name, device).Print(os.Stdout)
+				fmt.Println()
+			}
+		}
+	}
+}
+
+// runProbe implements the "probe" subcommand and returns the exit code
+func runProbe(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	configFile := fs.String("config", "config.yaml", "Path to configuration file")
+	regionName := fs.String("region", "", "Probe through this region only (default: all configured regions)")
+	timeout := fs.Duration("timeout", 0, "HTTP request timeout (overrides config file)")
+	interactive := fs.Bool("i", false, "Read URLs to probe interactively from stdin")
+	verbose := fs.Bool("verbose", false, "Enable verbose logging")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cache-warmer probe [OPTIONS] URL...\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	logger := NewLogger(*verbose)
+
+	config, err := LoadConfig(*configFile, "", 0, *timeout)
+	if err != nil {
+		logger.Error("Failed to load configuration: %v", err)
+		return 1
+	}
+	if err := config.ValidateSettings(); err != nil {
+		logger.Error("Invalid configuration: %v", err)
+		return 1
+	}
+
+	// Probing never needs the background servers, and must not consume
+	// queued URLs
+	config.DisableServices()
+
+	warmer := NewCacheWarmer(config, logger)
+
+	regions := []string{*regionName}
+	if *regionName == "" {
+		regions = regions[:0]
+		for _, r := range warmer.regions {
+			regions = append(regions, r.name)
+		}
+	} else if !warmer.hasRegion(*regionName) {
+		logger.Error("Unknown region: %s", *regionName)
+		return 1
+	}
+
+	if *interactive || fs.NArg() == 0 {
+		runProbeInteractive(warmer, regions)
+		return 0
+	}
+
+	exitCode := 0
+	for _, url := range fs.Args() {
+		if err := ValidateURL(url); err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		for _, name := range regions {
+			for _, device := range warmer.jobDevices() {
+				report := warmer.Probe(context.Background(), url, name, device)
+				report.Print(os.Stdout)
+				fmt.Println()
+				if !report.Success {
+					exitCode = 2
+				}
+			}
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbeJudgesLikeAWarm(t *testing.T) {
+	// Mobile clients bypass the cache, which the success rule rejects
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.UserAgent(), "Mobile") {
+			w.Header().Set("X-Cache", "BYPASS")
+		}
+		w.Write([]byte("page"))
+	}))
+	defer origin.Close()
+
+	cw := newTestWarmer(t, origin, func(config *Config) {
+		config.Devices = []DeviceConfig{
+			{Name: "mobile", UserAgent: "Mobile-UA"},
+			{Name: "desktop", UserAgent: "Desktop-UA"},
+		}
+		config.SuccessRules = []SuccessRuleConfig{{When: `header["X-Cache"] != "BYPASS"`}}
+	})
+
+	url := origin.URL + "/page"
+	run := newWarmRun()
+	cw.warmInto(context.Background(), run, []string{url})
+	warmed := make(map[string]bool)
+	for _, result := range run.Results(0) {
+		warmed[result.Device] = result.Success
+	}
+
+	for _, device := range []string{"mobile", "desktop"} {
+		report := cw.Probe(context.Background(), url, "", device)
+		if report.Success != warmed[device] {
+			t.Errorf("%s: probe success = %v (%v), warm success = %v", device, report.Success, report.Err, warmed[device])
+		}
+		if want := device == "desktop"; report.Success != want {
+			t.Errorf("%s: probe success = %v, want %v", device, report.Success, want)
+		}
+	}
+}
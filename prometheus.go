@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram buckets
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// requestLabels identifies a series of the requests counter
+type requestLabels struct {
+	host       string
+	status     string
+	cacheState string
+}
+
+// newRequestLabels returns the labels of a request to rawURL that ended
+// with code, "error" if no response was received, and cacheStatus
+func newRequestLabels(rawURL string, code int, cacheStatus string) requestLabels {
+	labels := requestLabels{host: rawURL, status: "error", cacheState: "unknown"}
+	if u, err := url.Parse(rawURL); err == nil {
+		labels.host = strings.ToLower(u.Host)
+	}
+	if code > 0 {
+		labels.status = strconv.Itoa(code)
+	}
+	if cacheStatus != CacheStatusUnknown {
+		labels.cacheState = strings.ToLower(cacheStatus)
+	}
+	return labels
+}
+
+// histogram counts observations into cumulative latency buckets
+type histogram struct {
+	counts []int64
+	count  int64
+	sum    float64
+}
+
+// observe adds a duration to the histogram
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(latencyBuckets))
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// promWriter writes metrics in the Prometheus text exposition format
+type promWriter struct {
+	w io.Writer
+}
+
+// family writes the HELP and TYPE lines of a metric
+func (p promWriter) family(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels alternate names and values
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(p.w, "%s %s\n", b.String(), strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// single writes a metric family with one unlabelled sample
+func (p promWriter) single(name, kind, help string, value float64) {
+	p.family(name, kind, help)
+	p.sample(name, value)
+}
+
+// labelEscaper escapes label values as the exposition format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// histograms writes a histogram family with one histogram per host
+func (p promWriter) histograms(name, help string, byHost map[string]*histogram) {
+	p.family(name, "histogram", help)
+	hosts := make([]string, 0, len(byHost))
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		h := byHost[host]
+		for i, bound := range latencyBuckets {
+			p.sample(name+"_bucket", float64(h.counts[i]), "host", host, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		p.sample(name+"_bucket", float64(h.count), "host", host, "le", "+Inf")
+		p.sample(name+"_sum", h.sum, "host", host)
+		p.sample(name+"_count", float64(h.count), "host", host)
+	}
+}
+
+// writePrometheus writes the metrics in the Prometheus text exposition
+// format. The caller holds the read lock.
+func (m *Metrics) writePrometheus(w io.Writer) {
+	p := promWriter{w}
+
+	p.family("cache_warmer_requests_total", "counter", "Warm requests by host, final status code and cache state.")
+	series := make([]requestLabels, 0, len(m.requestSeries))
+	for labels := range m.requestSeries {
+		series = append(series, labels)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		if a.status != b.status {
+			return a.status < b.status
+		}
+		return a.cacheState < b.cacheState
+	})
+	for _, labels := range series {
+		p.sample("cache_warmer_requests_total", float64(m.requestSeries[labels]),
+			"host", labels.host, "status", labels.status, "cache_state", labels.cacheState)
+	}
+
+	p.histograms("cache_warmer_request_duration_seconds", "Warm request duration, retries included, by host.", m.latencies)
+	p.histograms("cache_warmer_time_to_first_byte_seconds", "Time to the first response byte of the last attempt, by host.", m.firstByte)
+
+	p.family("cache_warmer_response_bytes_total", "counter", "Response body bytes read by host.")
+	hosts := make([]string, 0, len(m.responseBytes))
+	for host := range m.responseBytes {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		p.sample("cache_warmer_response_bytes_total", float64(m.responseBytes[host]), "host", host)
+	}
+
+	p.family("cache_warmer_request_latency_seconds", "summary", "Warm request duration percentiles since start, retries included.")
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		p.sample("cache_warmer_request_latency_seconds", m.latency.Quantile(q).Seconds(),
+			"quantile", strconv.FormatFloat(q, 'g', -1, 64))
+	}
+	var sum float64
+	for _, h := range m.latencies {
+		sum += h.sum
+	}
+	p.sample("cache_warmer_request_latency_seconds_sum", sum)
+	p.sample("cache_warmer_request_latency_seconds_count", float64(m.TotalRequests))
+
+	p.family("cache_warmer_request_failures_total", "counter", "Failed warm requests by error class.")
+	classes := make([]string, 0, len(m.FailureClasses))
+	for class := range m.FailureClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		p.sample("cache_warmer_request_failures_total", float64(m.FailureClasses[class]), "class", class)
+	}
+
+	p.single("cache_warmer_critical_requests", "gauge", "Critical URL requests in the last run.", float64(m.CriticalRequests))
+	p.single("cache_warmer_critical_failures", "gauge", "Critical URL failures in the last run.", float64(m.CriticalFailures))
+	p.single("cache_warmer_skipped_cycles_total", "counter", "Scheduled cycles skipped because earlier cycles were running.", float64(m.SkippedCycles))
+	p.single("cache_warmer_queued_cycles_total", "counter", "Scheduled cycles queued behind running ones.", float64(m.QueuedCycles))
+	p.single("cache_warmer_cancelled_cycles_total", "counter", "Running cycles cancelled for a newer one.", float64(m.CancelledCycles))
+	if m.TotalRequests > 0 {
+		p.single("cache_warmer_last_request_timestamp_seconds", "gauge", "Unix time the last warm request completed.",
+			float64(m.LastUpdated.UnixNano())/1e9)
+	}
+
+	if m.scheduler != nil {
+		stats := m.scheduler()
+		heapPaused := 0.0
+		if stats.HeapPaused {
+			heapPaused = 1
+		}
+		p.single("cache_warmer_workers", "gauge", "Workers of the running cycles.", float64(stats.Workers))
+		p.single("cache_warmer_busy_workers", "gauge", "Workers making a request.", float64(stats.BusyWorkers))
+		p.single("cache_warmer_queue_depth", "gauge", "URLs waiting for a worker.", float64(stats.QueueDepth))
+		p.single("cache_warmer_in_flight_requests", "gauge", "Requests in flight.", float64(stats.InFlightRequests))
+		p.single("cache_warmer_heap_paused", "gauge", "1 while admission control pauses requests for the heap limit.", heapPaused)
+	}
+
+	if m.fleet != nil {
+		fleet := m.fleet()
+		p.single("cache_warmer_fleet_instances", "gauge", "Instances reporting to the fleet aggregator.", float64(fleet.Instances))
+		p.single("cache_warmer_fleet_stale_instances", "gauge", "Fleet instances whose last report is stale.", float64(fleet.StaleInstances))
+		p.family("cache_warmer_fleet_requests", "gauge", "Requests of the last cycle of every fleet instance by result.")
+		p.sample("cache_warmer_fleet_requests", float64(fleet.Successful), "result", "success")
+		p.sample("cache_warmer_fleet_requests", float64(fleet.Failed), "result", "failure")
+	}
+}
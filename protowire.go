@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Protobuf wire types used by the gRPC API's messages
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// protoEncoder builds a protobuf message field by field, leaving out zero
+// values as proto3 does. It covers the scalar types of the gRPC API, so the
+// API doesn't pull in the protobuf runtime.
+type protoEncoder struct {
+	buf []byte
+}
+
+// tag starts a field
+func (e *protoEncoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+// String encodes a string field
+func (e *protoEncoder) String(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, protoBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// Strings encodes a repeated string field
+func (e *protoEncoder) Strings(field int, list []string) {
+	for _, s := range list {
+		e.tag(field, protoBytes)
+		e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+		e.buf = append(e.buf, s...)
+	}
+}
+
+// Int64 encodes an int64 or int32 field
+func (e *protoEncoder) Int64(field int, n int64) {
+	if n == 0 {
+		return
+	}
+	e.tag(field, protoVarint)
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+// Bool encodes a bool field
+func (e *protoEncoder) Bool(field int, b bool) {
+	if b {
+		e.tag(field, protoVarint)
+		e.buf = append(e.buf, 1)
+	}
+}
+
+// Double encodes a double field
+func (e *protoEncoder) Double(field int, f float64) {
+	if f == 0 {
+		return
+	}
+	e.tag(field, protoFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(f))
+}
+
+// protoField is one decoded field of a protobuf message. Varint holds the
+// value of varint fields and Bytes that of length-delimited ones.
+type protoField struct {
+	Number int
+	Varint uint64
+	Bytes  []byte
+}
+
+// decodeProto splits a protobuf message into its fields. Fixed-width fields
+// are skipped, since no request of the gRPC API has any.
+func decodeProto(data []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		data = data[n:]
+		field := protoField{Number: int(key >> 3)}
+
+		switch key & 7 {
+		case protoVarint:
+			field.Varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", field.Number)
+			}
+			data = data[n:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return nil, fmt.Errorf("invalid length of field %d", field.Number)
+			}
+			field.Bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		case protoFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated field %d", field.Number)
+			}
+			data = data[8:]
+			continue
+		case protoFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated field %d", field.Number)
+			}
+			data = data[4:]
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d in field %d", key&7, field.Number)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// proxyPool picks the forward proxy for each request from a pool
+type proxyPool struct {
+	proxies []*url.URL
+	perHost bool
+
+	// next is the round-robin position
+	next uint64
+}
+
+// newProxyPool creates a pool over the configured proxies
+func newProxyPool(config *ProxyPoolConfig) *proxyPool {
+	pool := &proxyPool{perHost: config.Rotation == ProxyRotationPerHost}
+	for _, raw := range config.Proxies {
+		// Validate guarantees the proxy URLs parse
+		if proxyURL, err := url.Parse(raw); err == nil {
+			pool.proxies = append(pool.proxies, proxyURL)
+		}
+	}
+	return pool
+}
+
+// Proxy returns the proxy for req, for use as http.Transport.Proxy. Per-host
+// rotation always sends a host through the same proxy, so its cache and rate
+// limits see one client.
+func (p *proxyPool) Proxy(req *http.Request) (*url.URL, error) {
+	if p.perHost {
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToLower(req.URL.Hostname())))
+		return p.proxies[h.Sum32()%uint32(len(p.proxies))], nil
+	}
+	i := atomic.AddUint64(&p.next, 1) - 1
+	return p.proxies[i%uint64(len(p.proxies))], nil
+}
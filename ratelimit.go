@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitBudget tracks per-host API rate-limit budgets advertised by
+// X-RateLimit-Remaining/X-RateLimit-Reset style headers and paces requests
+// so warming never consumes the quota real clients need
+type RateLimitBudget struct {
+	config *RateLimitBudgetConfig
+	logger *Logger
+	mutex  sync.Mutex
+	hosts  map[string]*hostBudget
+}
+
+// hostBudget is the last known rate-limit state for a single host
+type hostBudget struct {
+	remaining   int64
+	known       bool
+	reset       time.Time
+	nextAllowed time.Time
+}
+
+// NewRateLimitBudget creates a new rate-limit budget tracker
+func NewRateLimitBudget(config *RateLimitBudgetConfig, logger *Logger) *RateLimitBudget {
+	return &RateLimitBudget{
+		config: config,
+		logger: logger,
+		hosts:  make(map[string]*hostBudget),
+	}
+}
+
+// Wait blocks until a request to host fits within its remaining budget.
+// It returns early with the context error if ctx is cancelled.
+func (b *RateLimitBudget) Wait(ctx context.Context, host string) error {
+	delay := b.reserve(host)
+	if delay <= 0 {
+		return nil
+	}
+
+	if delay >= time.Second {
+		b.logger.Debug("Rate-limit budget for %s is low, delaying request by %v", host, delay)
+	}
+
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve claims a request slot for host and returns how long to wait for it
+func (b *RateLimitBudget) reserve(host string) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	hb := b.hosts[host]
+	if hb == nil || !hb.known {
+		return 0
+	}
+
+	now := time.Now()
+
+	// The window has reset, so the old budget no longer applies
+	if !hb.reset.IsZero() && now.After(hb.reset) {
+		hb.known = false
+		return 0
+	}
+
+	untilReset := hb.reset.Sub(now)
+	if hb.reset.IsZero() {
+		untilReset = b.config.MaxPause
+	}
+
+	// Budget exhausted down to the reserve: pause until the window resets
+	if hb.remaining <= b.config.Reserve {
+		pause := untilReset
+		if pause > b.config.MaxPause {
+			pause = b.config.MaxPause
+		}
+		return pause
+	}
+
+	// Estimate consumption locally until the next response refreshes it
+	hb.remaining--
+
+	if hb.remaining >= b.config.SlowdownBelow {
+		return 0
+	}
+
+	// Spread the remaining spendable budget evenly over the rest of the window
+	spacing := untilReset / time.Duration(hb.remaining-b.config.Reserve+1)
+	if hb.nextAllowed.Before(now) {
+		hb.nextAllowed = now
+	}
+	delay := hb.nextAllowed.Sub(now)
+	hb.nextAllowed = hb.nextAllowed.Add(spacing)
+
+	return delay
+}
+
+// Observe updates the budget for host from a response's rate-limit headers
+func (b *RateLimitBudget) Observe(host string, statusCode int, header http.Header) {
+	remaining, hasRemaining := parseRateLimitInt(header,
+		"X-RateLimit-Remaining", "RateLimit-Remaining", "X-Rate-Limit-Remaining")
+	reset, hasReset := parseRateLimitReset(header,
+		"X-RateLimit-Reset", "RateLimit-Reset", "X-Rate-Limit-Reset")
+
+	// 429 and 503 usually carry Retry-After instead of a remaining count
+	if statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable {
+		if retryAfter, ok := parseRetryAfter(header.Get("Retry-After")); ok {
+			reset, hasReset = retryAfter, true
+			remaining, hasRemaining = 0, true
+		} else if statusCode == http.StatusTooManyRequests {
+			remaining, hasRemaining = 0, true
+		}
+	}
+
+	if !hasRemaining {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	hb := b.hosts[host]
+	if hb == nil {
+		hb = &hostBudget{}
+		b.hosts[host] = hb
+	}
+
+	wasPaused := hb.known && hb.remaining <= b.config.Reserve
+	hb.remaining = remaining
+	hb.known = true
+	if hasReset {
+		hb.reset = reset
+	} else {
+		hb.reset = time.Time{}
+	}
+
+	if !wasPaused && remaining <= b.config.Reserve {
+		b.logger.Warn("Rate-limit budget for %s reached reserve (%d remaining), pausing warming of this host",
+			host, remaining)
+	}
+}
+
+// parseRateLimitInt returns the first integer header value found in names
+func parseRateLimitInt(header http.Header, names ...string) (int64, bool) {
+	for _, name := range names {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+		// Some APIs send a list for multiple windows; the first is the tightest
+		if i := strings.IndexAny(value, ",;"); i >= 0 {
+			value = value[:i]
+		}
+		if n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// parseRateLimitReset parses a reset header that is either a Unix timestamp
+// or a number of seconds until the window resets
+func parseRateLimitReset(header http.Header, names ...string) (time.Time, bool) {
+	n, ok := parseRateLimitInt(header, names...)
+	if !ok {
+		return time.Time{}, false
+	}
+	// Values this large can only be epoch seconds
+	if n > 1000000000 {
+		return time.Unix(n, 0), true
+	}
+	return time.Now().Add(time.Duration(n) * time.Second), true
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or HTTP-date form
+func parseRetryAfter(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return time.Now().Add(time.Duration(seconds) * time.Second), true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
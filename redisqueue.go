@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisBlockTimeout is how long each blocking read waits for new entries
+const redisBlockTimeout = 5 * time.Second
+
+// redisReconnectDelay is the pause before reconnecting after an error
+const redisReconnectDelay = 5 * time.Second
+
+// redisEnqueueBatch is how many URLs a coordinator pushes per round trip
+const redisEnqueueBatch = 500
+
+// RedisQueue consumes URLs other services enqueue in a Redis list or stream
+// and warms them in batches with the worker pool
+type RedisQueue struct {
+	config *RedisQueueConfig
+	warmer *CacheWarmer
+	logger *Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// conn is the current connection, closed by Shutdown to unblock reads
+	conn      *redisConn
+	connMutex sync.Mutex
+}
+
+// NewRedisQueue creates a Redis queue consumer and starts draining the
+// queue, unless this instance is the coordinator feeding it
+func NewRedisQueue(config *RedisQueueConfig, warmer *CacheWarmer, logger *Logger) *RedisQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	rq := &RedisQueue{
+		config: config,
+		warmer: warmer,
+		logger: logger,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	if config.Coordinator {
+		close(rq.done)
+		return rq
+	}
+	go rq.run()
+
+	return rq
+}
+
+// run consumes the queue until Shutdown, reconnecting after errors
+func (rq *RedisQueue) run() {
+	defer close(rq.done)
+
+	source := "list " + rq.config.List
+	if rq.config.Stream != "" {
+		source = "stream " + rq.config.Stream
+	}
+
+	for rq.ctx.Err() == nil {
+		conn, err := dialRedis(rq.ctx, rq.config.URL)
+		if err == nil {
+			rq.setConn(conn)
+			rq.logger.Info("Consuming URLs from Redis %s", source)
+			if rq.config.Stream != "" {
+				err = rq.consumeStream(conn)
+			} else {
+				err = rq.consumeList(conn)
+			}
+			rq.setConn(nil)
+			conn.Close()
+		}
+
+		if rq.ctx.Err() != nil {
+			return
+		}
+		rq.logger.Error("Redis queue error, reconnecting in %v: %v", redisReconnectDelay, err)
+
+		select {
+		case <-time.After(redisReconnectDelay):
+		case <-rq.ctx.Done():
+		}
+	}
+}
+
+// consumeList pops up to batch_size URLs at a time, waiting for the first.
+// Popped URLs are removed from the list before they are warmed.
+func (rq *RedisQueue) consumeList(conn *redisConn) error {
+	for rq.ctx.Err() == nil {
+		reply, err := conn.Do(redisBlockTimeout+10*time.Second, "BLPOP", rq.config.List, strconv.Itoa(int(redisBlockTimeout.Seconds())))
+		if err != nil {
+			return err
+		}
+		pair, ok := reply.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+
+		entries := []string{fmt.Sprint(pair[1])}
+		for len(entries) < rq.config.BatchSize {
+			reply, err := conn.Do(10*time.Second, "LPOP", rq.config.List)
+			if err != nil {
+				return err
+			}
+			value, ok := reply.(string)
+			if !ok {
+				break
+			}
+			entries = append(entries, value)
+		}
+
+		rq.warm(entries)
+	}
+	return nil
+}
+
+// consumeStream reads entries as a member of the consumer group and
+// acknowledges them once warmed, so entries of a consumer that stopped
+// mid-batch are delivered again when it restarts, or claimed by another
+// consumer once they have been pending for claim_after
+func (rq *RedisQueue) consumeStream(conn *redisConn) error {
+	_, err := conn.Do(10*time.Second, "XGROUP", "CREATE", rq.config.Stream, rq.config.Group, "$", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %v", rq.config.Group, err)
+	}
+
+	// Re-read entries this consumer was delivered but never acknowledged
+	// before moving on to new ones
+	id := "0"
+	claimFrom := "0-0"
+	for rq.ctx.Err() == nil {
+		// Take over entries of consumers that went away between batches
+		if id == ">" && claimFrom != "" {
+			ids, entries, next, err := rq.claimStale(conn, claimFrom)
+			if e, ok := err.(redisError); ok && strings.HasPrefix(string(e), "ERR unknown command") {
+				rq.logger.Warn("Not claiming entries of other consumers: XAUTOCLAIM needs Redis 6.2 or later")
+				next, err = "", nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to claim pending entries: %v", err)
+			}
+			claimFrom = next
+			if len(ids) > 0 {
+				rq.logger.Info("Claimed %d Redis stream entries left unacknowledged for over %v", len(ids), rq.config.ClaimAfter)
+				if err := rq.process(conn, ids, entries); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		reply, err := conn.Do(redisBlockTimeout+10*time.Second, "XREADGROUP", "GROUP", rq.config.Group, rq.config.Consumer,
+			"COUNT", strconv.Itoa(rq.config.BatchSize), "BLOCK", strconv.FormatInt(redisBlockTimeout.Milliseconds(), 10),
+			"STREAMS", rq.config.Stream, id)
+		if err != nil {
+			return err
+		}
+
+		ids, entries := parseStreamEntries(reply, rq.config.Field)
+		if len(ids) == 0 {
+			id = ">"
+			continue
+		}
+
+		if err := rq.process(conn, ids, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// process warms the URLs of stream entries, then acknowledges the entries
+// unless the consumer was stopped before they were all warmed
+func (rq *RedisQueue) process(conn *redisConn, ids, entries []string) error {
+	rq.warm(entries)
+
+	if rq.ctx.Err() != nil {
+		return nil
+	}
+	args := append([]string{"XACK", rq.config.Stream, rq.config.Group}, ids...)
+	if _, err := conn.Do(10*time.Second, args...); err != nil {
+		return fmt.Errorf("failed to acknowledge entries: %v", err)
+	}
+	return nil
+}
+
+// claimStale claims up to batch_size entries that have been pending on
+// any consumer for claim_after, scanning the group's pending entries from
+// cursor. It returns the claimed entries and the cursor to continue from,
+// "0-0" once the scan has wrapped around.
+func (rq *RedisQueue) claimStale(conn *redisConn, cursor string) (ids, urls []string, next string, err error) {
+	reply, err := conn.Do(10*time.Second, "XAUTOCLAIM", rq.config.Stream, rq.config.Group, rq.config.Consumer,
+		strconv.FormatInt(rq.config.ClaimAfter.Milliseconds(), 10), cursor, "COUNT", strconv.Itoa(rq.config.BatchSize))
+	if err != nil {
+		return nil, nil, "", err
+	}
+	parts, _ := reply.([]interface{})
+	if len(parts) < 2 {
+		return nil, nil, "0-0", nil
+	}
+	ids, urls = parseStreamMessages(parts[1], rq.config.Field)
+	return ids, urls, fmt.Sprint(parts[0]), nil
+}
+
+// parseStreamEntries extracts entry IDs and the URL field from an
+// XREADGROUP reply. Entries without the field are still returned by ID so
+// they are acknowledged.
+func parseStreamEntries(reply interface{}, field string) (ids, urls []string) {
+	streams, _ := reply.([]interface{})
+	for _, s := range streams {
+		stream, ok := s.([]interface{})
+		if !ok || len(stream) != 2 {
+			continue
+		}
+		streamIDs, streamURLs := parseStreamMessages(stream[1], field)
+		ids = append(ids, streamIDs...)
+		urls = append(urls, streamURLs...)
+	}
+	return ids, urls
+}
+
+// parseStreamMessages extracts entry IDs and the URL field from a list of
+// stream entries, as in XREADGROUP and XAUTOCLAIM replies
+func parseStreamMessages(reply interface{}, field string) (ids, urls []string) {
+	messages, _ := reply.([]interface{})
+	for _, m := range messages {
+		message, ok := m.([]interface{})
+		if !ok || len(message) != 2 {
+			continue
+		}
+		ids = append(ids, fmt.Sprint(message[0]))
+		fields, _ := message[1].([]interface{})
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fmt.Sprint(fields[i]) == field {
+				urls = append(urls, fmt.Sprint(fields[i+1]))
+			}
+		}
+	}
+	return ids, urls
+}
+
+// warm validates dequeued URLs and warms them in one run
+func (rq *RedisQueue) warm(entries []string) {
+	urls := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if err := ValidateURL(entry); err != nil {
+			rq.logger.Warn("Ignoring invalid URL %q from Redis: %v", entry, err)
+			continue
+		}
+		urls = append(urls, entry)
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	rq.logger.Info("Dequeued %d URLs from Redis", len(urls))
+	rq.warmer.WarmURLs(rq.ctx, urls)
+}
+
+// Enqueue pushes a coordinator's URLs onto the queue for the worker
+// instances, over a connection of its own
+func (rq *RedisQueue) Enqueue(ctx context.Context, urls []string) error {
+	conn, err := dialRedis(ctx, rq.config.URL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if rq.config.Stream != "" {
+		if err := rq.prepareStream(conn); err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(urls); start += redisEnqueueBatch {
+		batch := urls[start:min(start+redisEnqueueBatch, len(urls))]
+		if rq.config.Stream == "" {
+			_, err = conn.Do(30*time.Second, append([]string{"RPUSH", rq.config.List}, batch...)...)
+		} else {
+			commands := make([][]string, len(batch))
+			for i, u := range batch {
+				commands[i] = []string{"XADD", rq.config.Stream, "*", rq.config.Field, u}
+			}
+			err = conn.Pipeline(30*time.Second, commands)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to enqueue URLs: %v", err)
+		}
+	}
+	return nil
+}
+
+// prepareStream creates the consumer group ahead of the workers, reading
+// from the start of the stream so entries added before the first worker
+// joins aren't skipped, and trims the entries the group is done with
+func (rq *RedisQueue) prepareStream(conn *redisConn) error {
+	_, err := conn.Do(10*time.Second, "XGROUP", "CREATE", rq.config.Stream, rq.config.Group, "0", "MKSTREAM")
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %v", rq.config.Group, err)
+	}
+
+	minID, err := rq.acknowledgedBefore(conn)
+	if err != nil || minID == "" {
+		return err
+	}
+	if _, err := conn.Do(30*time.Second, "XTRIM", rq.config.Stream, "MINID", "~", minID); err != nil {
+		return fmt.Errorf("failed to trim acknowledged entries: %v", err)
+	}
+	return nil
+}
+
+// acknowledgedBefore returns the ID every earlier entry of the stream was
+// acknowledged by the group before: the oldest entry still pending, or the
+// last one delivered if none is. It is empty if nothing was delivered yet.
+func (rq *RedisQueue) acknowledgedBefore(conn *redisConn) (string, error) {
+	reply, err := conn.Do(10*time.Second, "XPENDING", rq.config.Stream, rq.config.Group)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pending entries: %v", err)
+	}
+	if summary, _ := reply.([]interface{}); len(summary) == 4 {
+		if count, _ := summary[0].(int64); count > 0 {
+			return fmt.Sprint(summary[1]), nil
+		}
+	}
+
+	reply, err = conn.Do(10*time.Second, "XINFO", "GROUPS", rq.config.Stream)
+	if err != nil {
+		return "", fmt.Errorf("failed to read consumer groups: %v", err)
+	}
+	groups, _ := reply.([]interface{})
+	for _, g := range groups {
+		fields, _ := g.([]interface{})
+		info := make(map[string]string)
+		for i := 0; i+1 < len(fields); i += 2 {
+			info[fmt.Sprint(fields[i])] = fmt.Sprint(fields[i+1])
+		}
+		if info["name"] == rq.config.Group && info["last-delivered-id"] != "0-0" {
+			return info["last-delivered-id"], nil
+		}
+	}
+	return "", nil
+}
+
+// setConn records the connection in use so Shutdown can close it
+func (rq *RedisQueue) setConn(conn *redisConn) {
+	rq.connMutex.Lock()
+	defer rq.connMutex.Unlock()
+	rq.conn = conn
+}
+
+// Shutdown stops consuming, abandoning the batch being warmed. Unacknowledged
+// stream entries are delivered again on the next start.
+func (rq *RedisQueue) Shutdown() {
+	rq.cancel()
+
+	rq.connMutex.Lock()
+	if rq.conn != nil {
+		rq.conn.Close()
+	}
+	rq.connMutex.Unlock()
+
+	<-rq.done
+}
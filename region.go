@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// region is an egress path URLs are warmed through. The unnamed region is the
+// direct path used when no regions are configured.
+type region struct {
+	name   string
+	client *http.Client
+
+	// transport is cloned for clients pinned to one address of a host, nil
+	// if the region's requests cannot be pinned (e.g. through a proxy)
+	transport *http.Transport
+
+	// overrides are hosts the region resolves statically
+	overrides map[string]bool
+
+	// family is the ip_family the region connects over
+	family string
+
+	// Clients pinned to a resolved address, by address
+	pinned      map[string]*http.Client
+	pinnedMutex sync.Mutex
+}
+
+// label returns a log suffix identifying the region, empty for direct warming
+func (r *region) label() string {
+	if r.name == "" {
+		return ""
+	}
+	return fmt.Sprintf(" via %s", r.name)
+}
+
+// newRegion creates a region that warms through rc's proxy and resolver
+// overrides
+func newRegion(config *Config, rc *RegionConfig) *region {
+	transport := newRegionTransport(config, rc)
+	r := &region{
+		name:      rc.Name,
+		client:    newHTTPClient(config, transport),
+		overrides: overriddenHosts(config.ConnectTo, rc.Resolve),
+		family:    config.IPFamily,
+	}
+	if rc.IPFamily != "" {
+		r.family = rc.IPFamily
+	}
+	// Requests through a forward proxy are resolved by the proxy, and those
+	// of a region with an address all go to it
+	if rc.Proxy == "" && rc.Address == "" && config.ProxyURL == "" && len(config.ProxyPool.Proxies) == 0 {
+		r.transport = transport
+	}
+	return r
+}
+
+// overriddenHosts returns the hosts with a connection override in any of maps
+func overriddenHosts(maps ...map[string]string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, overrides := range maps {
+		for from := range overrides {
+			hosts[overrideHost(from)] = true
+		}
+	}
+	return hosts
+}
+
+// stageRegions returns the regions of a run in warming order: the origin
+// shield, if any, then the edge regions
+func (cw *CacheWarmer) stageRegions() []*region {
+	if cw.shield == nil {
+		return cw.regions
+	}
+	return append([]*region{cw.shield}, cw.regions...)
+}
+
+// regionDisplayName names the direct path in tables where every row is labelled
+func regionDisplayName(name string) string {
+	if name == "" {
+		return "direct"
+	}
+	return name
+}
+
+// RegionSummary aggregates the results of one region in a run
+type RegionSummary struct {
+	Name          string        `json:"name"`
+	Requests      int           `json:"requests"`
+	Successes     int           `json:"successes"`
+	Hits          int           `json:"hits"`
+	Misses        int           `json:"misses"`
+	TotalDuration time.Duration `json:"total_duration_ns"`
+}
+
+// HitRate returns the percentage of requests served from cache
+func (s RegionSummary) HitRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Requests) * 100
+}
+
+// AverageDuration returns the mean request duration for the region
+func (s RegionSummary) AverageDuration() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+	return s.TotalDuration / time.Duration(s.Requests)
+}
+
+// GetRegionSummaries returns per-region aggregates of results, in
+// configuration order
+func (cw *CacheWarmer) GetRegionSummaries(results []Result) []RegionSummary {
+	regions := cw.stageRegions()
+	index := make(map[string]int)
+	summaries := make([]RegionSummary, 0, len(regions))
+	for i, r := range regions {
+		index[r.name] = i
+		summaries = append(summaries, RegionSummary{Name: r.name})
+	}
+
+	for _, result := range results {
+		s := &summaries[index[result.Region]]
+		s.Requests++
+		s.TotalDuration += result.Duration
+		if result.Success {
+			s.Successes++
+		}
+		switch result.CacheStatus {
+		case CacheStatusHit:
+			s.Hits++
+		case CacheStatusMiss:
+			s.Misses++
+		}
+	}
+
+	return summaries
+}
+
+// GetPOPSummaries returns aggregates of results per CDN point of
+// presence that served the responses, by POP code, with the POP as the
+// summary's name. Responses without a known POP are left out.
+func (cw *CacheWarmer) GetPOPSummaries(results []Result) []RegionSummary {
+	index := make(map[string]int)
+	var summaries []RegionSummary
+	for _, result := range results {
+		if result.POP == "" {
+			continue
+		}
+		i, ok := index[result.POP]
+		if !ok {
+			i = len(summaries)
+			index[result.POP] = i
+			summaries = append(summaries, RegionSummary{Name: result.POP})
+		}
+		s := &summaries[i]
+		s.Requests++
+		s.TotalDuration += result.Duration
+		if result.Success {
+			s.Successes++
+		}
+		switch result.CacheStatus {
+		case CacheStatusHit:
+			s.Hits++
+		case CacheStatusMiss:
+			s.Misses++
+		}
+	}
+
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+// printPOPComparison prints hit status per CDN point of presence, if the
+// CDN reported which one served the responses
+func (cw *CacheWarmer) printPOPComparison(results []Result) {
+	summaries := cw.GetPOPSummaries(results)
+	if len(summaries) == 0 {
+		return
+	}
+	cw.logger.Info("  POP comparison:")
+	cw.logger.Info("    %-16s %8s %8s %8s %12s", "POP", "REQUESTS", "SUCCESS", "HIT RATE", "AVG LATENCY")
+	for _, s := range summaries {
+		cw.logger.Info("    %-16s %8d %8d %7.1f%% %12v",
+			s.Name, s.Requests, s.Successes, s.HitRate(), s.AverageDuration().Round(time.Millisecond))
+	}
+}
+
+// printRegionComparison prints per-region latency and hit status side by side
+func (cw *CacheWarmer) printRegionComparison(results []Result) {
+	cw.logger.Info("  Region comparison:")
+	cw.logger.Info("    %-16s %8s %8s %8s %12s", "REGION", "REQUESTS", "SUCCESS", "HIT RATE", "AVG LATENCY")
+	for _, s := range cw.GetRegionSummaries(results) {
+		cw.logger.Info("    %-16s %8d %8d %7.1f%% %12v",
+			regionDisplayName(s.Name), s.Requests, s.Successes, s.HitRate(), s.AverageDuration().Round(time.Millisecond))
+	}
+
+	// Per-URL breakdown is only useful when debugging a specific region
+	if !cw.logger.IsDebugEnabled() {
+		return
+	}
+
+	byURL := make(map[string]map[string]Result)
+	var order []string
+	for _, result := range results {
+		if byURL[result.URL] == nil {
+			byURL[result.URL] = make(map[string]Result)
+			order = append(order, result.URL)
+		}
+		byURL[result.URL][result.Region] = result
+	}
+
+	for _, url := range order {
+		regions := cw.stageRegions()
+		cells := make([]string, 0, len(regions))
+		for _, r := range regions {
+			name := regionDisplayName(r.name)
+			result, ok := byURL[url][r.name]
+			if !ok {
+				cells = append(cells, fmt.Sprintf("%s=-", name))
+				continue
+			}
+			status := result.CacheStatus
+			if status == CacheStatusUnknown {
+				status = "?"
+			}
+			if !result.Success {
+				status = "FAIL"
+			} else if result.POP != "" {
+				status += "@" + result.POP
+			}
+			cells = append(cells, fmt.Sprintf("%s=%s/%v", name, status, result.Duration.Round(time.Millisecond)))
+		}
+		cw.logger.Debug("    %s: %s", url, strings.Join(cells, " "))
+	}
+}
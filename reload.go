@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configReload holds a reloaded configuration until the next cycle
+// boundary
+type configReload struct {
+	mutex   sync.Mutex
+	pending *Config
+}
+
+// reloadable is what a reload replaces: the configuration with its URL
+// sources, worker count and interval, and the per-URL settings built from
+// it. It is never modified; a reload stores a new one, so runs reading the
+// previous one are unaffected.
+type reloadable struct {
+	config *Config
+
+	// URLs marked critical, reported separately from the success rate
+	critical map[string]bool
+
+	// Basic auth credentials of URLs that have their own
+	basicAuth map[string]*BasicAuthConfig
+
+	// Priorities of the URLs given one, which are dispatched first
+	priorities map[string]int
+}
+
+// newReloadable returns the settings of config
+func newReloadable(config *Config) *reloadable {
+	return &reloadable{
+		config:     config,
+		critical:   criticalSet(config),
+		basicAuth:  basicAuthSet(config),
+		priorities: prioritySet(config),
+	}
+}
+
+// CurrentConfig returns the configuration as last reloaded, for reading the
+// settings a reload replaces. It must not be modified.
+func (cw *CacheWarmer) CurrentConfig() *Config {
+	return cw.current.Load().config
+}
+
+// settingsOf returns the reloadable settings of the run ctx belongs to, as
+// of its start, or the current ones outside a run
+func (cw *CacheWarmer) settingsOf(ctx context.Context) *reloadable {
+	if r, ok := ctx.Value(runContextKey{}).(*warmRun); ok && r.settings != nil {
+		return r.settings
+	}
+	return cw.current.Load()
+}
+
+// QueueReload keeps a reloaded and validated configuration for the next
+// cycle boundary, replacing one still waiting
+func (cw *CacheWarmer) QueueReload(next *Config) {
+	cw.reload.mutex.Lock()
+	defer cw.reload.mutex.Unlock()
+	cw.reload.pending = next
+	cw.logger.Info("Configuration reloaded, applying it at the next cycle")
+}
+
+// ApplyReload applies the pending configuration, if any, and reports whether
+// it did. It is called at cycle boundaries. Runs in progress keep the
+// settings they started with; the following ones use the reloaded ones.
+//
+// Only the URL sources, the worker count and the interval are applied;
+// changes to other settings are logged and wait for a restart.
+func (cw *CacheWarmer) ApplyReload() bool {
+	cw.reload.mutex.Lock()
+	defer cw.reload.mutex.Unlock()
+	next := cw.reload.pending
+	if next == nil {
+		return false
+	}
+	cw.reload.pending = nil
+	current := cw.CurrentConfig()
+
+	// Continuous mode can't become a single run
+	if next.Interval <= 0 {
+		cw.logger.Warn("Ignoring the reloaded interval of 0, keeping %v", current.Interval)
+		next.Interval = current.Interval
+	}
+
+	if changed := restartOnlyChanges(current, next); len(changed) > 0 {
+		cw.logger.Warn("The reloaded configuration changes %s, which only apply after a restart",
+			strings.Join(changed, ", "))
+	}
+
+	c := *current
+	c.URLs = next.URLs
+	c.Templates = next.Templates
+	c.RemoteList = next.RemoteList
+	c.Sitemap = next.Sitemap
+	c.OpenAPI = next.OpenAPI
+	c.AccessLog = next.AccessLog
+	c.S3Logs = next.S3Logs
+	c.Workers = next.Workers
+	c.Interval = next.Interval
+
+	// Per-URL settings are looked up by URL
+	cw.current.Store(newReloadable(&c))
+
+	cw.logger.Info("Applied the reloaded configuration: %d URLs, %d workers, %v interval",
+		len(c.URLs), c.Workers, c.Interval)
+	return true
+}
+
+// restartOnlyChanges returns the settings, by their YAML key, in which next
+// differs from current other than those a reload applies, such as groups and
+// graphql
+func restartOnlyChanges(current, next *Config) []string {
+	a, b := *current, *next
+	for _, c := range []*Config{&a, &b} {
+		c.URLs = nil
+		c.Templates = nil
+		c.RemoteList = RemoteListConfig{}
+		c.Sitemap = ""
+		c.OpenAPI = OpenAPIConfig{}
+		c.AccessLog = AccessLogConfig{}
+		c.S3Logs = S3LogsConfig{}
+		c.Workers = 0
+		c.Interval = 0
+	}
+
+	var changed []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := range va.NumField() {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+		field := va.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			name = field.Name
+		}
+		changed = append(changed, name)
+	}
+	return changed
+}
+
+// watchConfigFile sends on changed whenever the file at path is modified,
+// checking every interval until done is closed
+func watchConfigFile(path string, interval time.Duration, changed chan<- struct{}, done <-chan struct{}) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		case <-done:
+			return
+		}
+	}
+}
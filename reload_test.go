@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestApplyReloadDuringRun(t *testing.T) {
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	cw := newTestWarmer(t, origin, func(config *Config) {
+		config.Interval = time.Minute
+	})
+
+	run := newWarmRun()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cw.warmInto(context.Background(), run, testURLs(origin, "page", 4))
+	}()
+	for cw.ActiveRuns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	next := *cw.config
+	next.URLs = []URLEntry{{URL: origin.URL + "/new", Critical: true}}
+	next.Workers = 7
+	next.Interval = 2 * time.Minute
+	cw.QueueReload(&next)
+	if !cw.ApplyReload() {
+		t.Fatal("reload waits for the running cycle")
+	}
+	close(release)
+	<-done
+
+	current := cw.CurrentConfig()
+	if current.Workers != 7 || current.Interval != 2*time.Minute || len(current.URLs) != 1 {
+		t.Errorf("reloaded configuration not applied: %d workers, %v interval, %d URLs",
+			current.Workers, current.Interval, len(current.URLs))
+	}
+	if !cw.current.Load().critical[origin.URL+"/new"] {
+		t.Error("reloaded critical URLs not applied")
+	}
+
+	// The running cycle kept the settings it started with
+	if run.settings.config.Workers != 4 || run.settings.critical != nil {
+		t.Errorf("running cycle's settings changed: %d workers, critical %v",
+			run.settings.config.Workers, run.settings.critical)
+	}
+	if cw.config.Workers != 4 {
+		t.Errorf("reload modified the original configuration: %d workers", cw.config.Workers)
+	}
+}
+
+func TestRestartOnlyChangesNamesGroupsAndGraphQL(t *testing.T) {
+	current := DefaultConfig()
+	next := *current
+	next.Workers = 9
+	next.Sitemap = "https://example.com/sitemap.xml"
+	next.Groups = []GroupConfig{{Name: "catalog"}}
+	next.GraphQL.Endpoint = "https://example.com/graphql"
+
+	got := restartOnlyChanges(current, &next)
+	if want := []string{"graphql", "groups"}; !slices.Equal(got, want) {
+		t.Errorf("restart-only changes %v, want %v", got, want)
+	}
+	if got := restartOnlyChanges(current, current); len(got) != 0 {
+		t.Errorf("unchanged configuration has restart-only changes %v", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// resultsCSVHeader names the columns of a CSV results file
+var resultsCSVHeader = []string{
+	"url", "region", "device", "status_code", "cache_status", "attempts",
+	"duration_ms", "ttfb_ms", "bytes", "success", "error_class", "error",
+}
+
+// formatResultsCSV renders per-URL results as CSV, one row per result in
+// completion order
+func formatResultsCSV(records []ResultRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(resultsCSVHeader)
+	for _, r := range records {
+		w.Write([]string{
+			r.URL,
+			r.Region,
+			r.Device,
+			strconv.Itoa(r.StatusCode),
+			r.CacheStatus,
+			strconv.Itoa(r.Attempts),
+			strconv.FormatFloat(r.DurationMs, 'f', 3, 64),
+			strconv.FormatFloat(r.TTFBMs, 'f', 3, 64),
+			strconv.FormatInt(r.Bytes, 10),
+			strconv.FormatBool(r.Success),
+			r.ErrorClass,
+			r.Error,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to encode results: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isCSVResultsFile reports whether path is written as CSV rather than JSON
+func isCSVResultsFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+// writeResultsFile writes every per-URL result of the cycle to the results
+// file, as CSV or JSON by its extension
+func (cw *CacheWarmer) writeResultsFile(results []Result) {
+	records := make([]ResultRecord, len(results))
+	for i, result := range results {
+		records[i] = result.Record()
+	}
+
+	var data []byte
+	var err error
+	if isCSVResultsFile(cw.config.ResultsFile) {
+		data, err = formatResultsCSV(records)
+	} else {
+		data, err = json.MarshalIndent(records, "", "  ")
+	}
+	if err == nil {
+		err = writeFileAtomic(cw.config.ResultsFile, data)
+	}
+	if err != nil {
+		cw.logger.Error("Failed to write results file: %v", err)
+		return
+	}
+	cw.logger.Info("Wrote %d results to %s", len(records), cw.config.ResultsFile)
+}
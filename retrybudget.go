@@ -0,0 +1,22 @@
+package main
+
+import "sync/atomic"
+
+// takeRetry reports whether one more retry fits in the retry budget of run
+// r and counts it if so. The budget grows with the requests started so far
+// in the run, plus min_retries.
+func (cw *CacheWarmer) takeRetry(r *warmRun) bool {
+	budget := &cw.config.RetryBudget
+	for {
+		retries := atomic.LoadInt64(&r.stats.Retries)
+		if budget.Ratio > 0 {
+			requests := atomic.LoadInt64(&r.stats.TotalRequests)
+			if retries >= int64(float64(requests)*budget.Ratio)+int64(budget.MinRetries) {
+				return false
+			}
+		}
+		if atomic.CompareAndSwapInt64(&r.stats.Retries, retries, retries+1) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRobotsSize caps how much of a robots.txt file is parsed, the minimum
+// RFC 9309 requires crawlers to read
+const maxRobotsSize = 500 << 10
+
+// robotsRule is an Allow or Disallow line of the group that applies
+type robotsRule struct {
+	pattern string
+	re      *regexp.Regexp
+	allow   bool
+}
+
+// robotsRules is what the warmer takes from a host's robots.txt
+type robotsRules struct {
+	rules      []robotsRule
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+// disallowAll is used for hosts whose robots.txt could not be fetched, which
+// RFC 9309 says to treat as disallowing everything
+var disallowAll = &robotsRules{rules: []robotsRule{{pattern: "/", re: regexp.MustCompile("^/"), allow: false}}}
+
+// parseRobots parses a robots.txt file, keeping the rules of the group for
+// agent, or of the * group if no group names it
+func parseRobots(data []byte, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+
+	type group struct {
+		agents []string
+		rules  []robotsRule
+		delay  time.Duration
+	}
+	var groups []*group
+	var current *group
+	robots := &robotsRules{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share one group
+			if current == nil || len(current.rules) > 0 || current.delay > 0 {
+				current = &group{}
+				groups = append(groups, current)
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if current == nil || value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{pattern: value, re: compileRobotsPattern(value), allow: key == "allow"})
+		case "crawl-delay":
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.delay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			if value != "" {
+				robots.sitemaps = append(robots.sitemaps, value)
+			}
+		}
+	}
+
+	// The most specific agent match wins, then *
+	var selected *group
+	longest := -1
+	for _, g := range groups {
+		for _, name := range g.agents {
+			if name != "*" && name != "" && strings.Contains(agent, name) && len(name) > longest {
+				selected, longest = g, len(name)
+			}
+		}
+	}
+	if selected == nil {
+		for _, g := range groups {
+			for _, name := range g.agents {
+				if name == "*" && selected == nil {
+					selected = g
+				}
+			}
+		}
+	}
+	if selected != nil {
+		robots.rules = selected.rules
+		robots.crawlDelay = selected.delay
+	}
+	return robots
+}
+
+// compileRobotsPattern turns a path pattern with * and a trailing $ into a
+// regexp anchored at the start of the path
+func compileRobotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Allowed reports whether the path (with query) may be crawled: the longest
+// matching rule decides, and Allow wins a tie
+func (r *robotsRules) Allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+	allowed := true
+	longest := -1
+	for _, rule := range r.rules {
+		if !rule.re.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsAgent returns the user agent token robots.txt groups are matched
+// against: the configured one, or the product name of user_agent
+func (c *Config) robotsAgent() string {
+	if c.Robots.UserAgent != "" {
+		return c.Robots.UserAgent
+	}
+	agent, _, _ := strings.Cut(c.UserAgent, "/")
+	return agent
+}
+
+// fetchRobots downloads and parses the robots.txt of an origin (scheme and
+// host). A missing file allows everything.
+func (cw *CacheWarmer) fetchRobots(ctx context.Context, origin string) (*robotsRules, error) {
+	robotsURL := origin + "/robots.txt"
+	req, err := cw.newRequest(ctx, robotsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := cw.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %v", robotsURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return &robotsRules{}, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to fetch %s: status code %d", robotsURL, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", robotsURL, err)
+	}
+	return parseRobots(data, cw.config.robotsAgent()), nil
+}
+
+// loadRobots fetches the robots.txt of every host among urls, keyed by
+// lower-case host. Hosts whose file can't be fetched are mapped to
+// disallowAll.
+func (cw *CacheWarmer) loadRobots(ctx context.Context, urls []string) map[string]*robotsRules {
+	robots := make(map[string]*robotsRules)
+	for _, rawURL := range urls {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Host == "" {
+			continue
+		}
+		host := strings.ToLower(parsed.Host)
+		if _, ok := robots[host]; ok {
+			continue
+		}
+
+		rules, err := cw.fetchRobots(ctx, parsed.Scheme+"://"+parsed.Host)
+		if err != nil {
+			cw.logger.Warn("Treating %s as disallowed: %v", host, err)
+			rules = disallowAll
+		}
+		robots[host] = rules
+	}
+	return robots
+}
+
+// robotsSitemapURLs returns the entries of the sitemaps listed in the
+// robots.txt of the hosts among urls, other than the configured sitemap
+func (cw *CacheWarmer) robotsSitemapURLs(ctx context.Context, urls []string) []string {
+	robots := cw.loadRobots(ctx, urls)
+	hosts := make([]string, 0, len(robots))
+	for host := range robots {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var found []string
+	seen := map[string]bool{cw.CurrentConfig().Sitemap: true}
+	for _, host := range hosts {
+		for _, sitemap := range robots[host].sitemaps {
+			if seen[sitemap] {
+				continue
+			}
+			seen[sitemap] = true
+
+			sitemapURLs, err := cw.fetchSitemap(ctx, sitemap)
+			if err != nil {
+				cw.logger.Error("Failed to load sitemap from robots.txt: %v", err)
+				continue
+			}
+			cw.logger.Info("Loaded %d URLs from sitemap %s listed in robots.txt", len(sitemapURLs), sitemap)
+			found = append(found, sitemapURLs...)
+		}
+	}
+	return found
+}
+
+// robotsAllowed reports whether a discovered page may be crawled
+func (c *crawler) robotsAllowed(rawURL string) bool {
+	if c.robots == nil {
+		return true
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return c.robots[strings.ToLower(parsed.Host)].Allowed(parsed.RequestURI())
+}
+
+// crawlDelay waits until the Crawl-delay of rawURL's host allows the next
+// request, spacing requests across all workers. It returns the context error
+// if ctx is cancelled first.
+func (cw *CacheWarmer) crawlDelay(ctx context.Context, rawURL string) error {
+	run := runOf(ctx)
+	run.crawlMutex.Lock()
+	var wait time.Duration
+	if run.crawler != nil && run.crawler.robots != nil {
+		if parsed, err := url.Parse(rawURL); err == nil {
+			wait = run.crawler.reserve(strings.ToLower(parsed.Host))
+		}
+	}
+	run.crawlMutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve books the next request slot for host under its Crawl-delay and
+// returns how long to wait for it
+func (c *crawler) reserve(host string) time.Duration {
+	rules := c.robots[host]
+	if rules == nil || rules.crawlDelay <= 0 {
+		return 0
+	}
+	now := time.Now()
+	at := c.nextRequest[host]
+	if at.Before(now) {
+		at = now
+	}
+	c.nextRequest[host] = at.Add(rules.crawlDelay)
+	return at.Sub(now)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warmRun is the state of one run over a list of URLs: its statistics,
+// per-URL results and crawl. Runs overlap, e.g. the cycles of URL groups or
+// a webhook warm during a cycle, so each has its own, carried in its context.
+type warmRun struct {
+	stats Statistics
+
+	// Request durations and times to first byte, for percentiles
+	latency latencyHistogram
+	ttfb    latencyHistogram
+
+	// Per-URL results, in completion order
+	results      []Result
+	resultsMutex sync.Mutex
+
+	// Links discovered when crawling or warming assets
+	crawler    *crawler
+	crawlMutex sync.Mutex
+
+	// Progress saved for resuming the cycle, if checkpoints are enabled
+	checkpoint *checkpoint
+
+	// Settings a reload replaces, as of the start of the run
+	settings *reloadable
+}
+
+// newWarmRun starts the state of a run
+func newWarmRun() *warmRun {
+	return &warmRun{stats: Statistics{StartTime: time.Now(), RunID: newRunID()}}
+}
+
+// runContextKey carries the state of a run in its context
+type runContextKey struct{}
+
+// withRun returns a context for requests recorded into r
+func withRun(ctx context.Context, r *warmRun) context.Context {
+	return context.WithValue(ctx, runContextKey{}, r)
+}
+
+// runOf returns the run ctx belongs to. Requests made outside a run, such as
+// probes, get a detached run that nothing reports.
+func runOf(ctx context.Context) *warmRun {
+	if r, ok := ctx.Value(runContextKey{}).(*warmRun); ok {
+		return r
+	}
+	return &warmRun{}
+}
+
+// record stores the outcome of a URL
+func (r *warmRun) record(result Result) {
+	r.resultsMutex.Lock()
+	r.results = append(r.results, result)
+	r.resultsMutex.Unlock()
+}
+
+// resultCount returns how many results have been recorded so far
+func (r *warmRun) resultCount() int {
+	r.resultsMutex.Lock()
+	defer r.resultsMutex.Unlock()
+	return len(r.results)
+}
+
+// Results returns a copy of the per-URL results recorded from first on
+func (r *warmRun) Results(first int) []Result {
+	r.resultsMutex.Lock()
+	defer r.resultsMutex.Unlock()
+
+	results := make([]Result, len(r.results)-first)
+	copy(results, r.results[first:])
+	return results
+}
+
+// Statistics returns a snapshot of the run's statistics
+func (r *warmRun) Statistics() Statistics {
+	return Statistics{
+		TotalRequests:   atomic.LoadInt64(&r.stats.TotalRequests),
+		SuccessRequests: atomic.LoadInt64(&r.stats.SuccessRequests),
+		FailedRequests:  atomic.LoadInt64(&r.stats.FailedRequests),
+		TotalDuration:   atomic.LoadInt64(&r.stats.TotalDuration),
+		StartTime:       r.stats.StartTime,
+		RunID:           r.stats.RunID,
+
+		DuplicatesSkipped: atomic.LoadInt64(&r.stats.DuplicatesSkipped),
+		CoalescedRequests: atomic.LoadInt64(&r.stats.CoalescedRequests),
+		SkippedURLs:       atomic.LoadInt64(&r.stats.SkippedURLs),
+		CrawledURLs:       atomic.LoadInt64(&r.stats.CrawledURLs),
+		AssetURLs:         atomic.LoadInt64(&r.stats.AssetURLs),
+		HaltedWave:        atomic.LoadInt64(&r.stats.HaltedWave),
+		ProxyErrors:       atomic.LoadInt64(&r.stats.ProxyErrors),
+		IPv4Requests:      atomic.LoadInt64(&r.stats.IPv4Requests),
+		IPv6Requests:      atomic.LoadInt64(&r.stats.IPv6Requests),
+		NotModified:       atomic.LoadInt64(&r.stats.NotModified),
+		Retries:           atomic.LoadInt64(&r.stats.Retries),
+		RetriesDenied:     atomic.LoadInt64(&r.stats.RetriesDenied),
+		Latency:           r.latency.Percentiles(),
+		TTFB:              r.ttfb.Percentiles(),
+		BytesTransferred:  atomic.LoadInt64(&r.stats.BytesTransferred),
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Load balancer and CDN log formats read from S3
+const (
+	S3LogFormatALB        = "alb"
+	S3LogFormatCloudFront = "cloudfront"
+)
+
+// albLogLine matches an Application Load Balancer access log entry, capturing
+// the ELB status code, the request line and the user agent
+var albLogLine = regexp.MustCompile(`^\S+ \S+ \S+ \S+ \S+ \S+ \S+ \S+ (\d{3}|-) \S+ \S+ \S+ "(\S+) (\S+) [^"]*" "([^"]*)"`)
+
+// s3ListResult is the subset of a ListObjectsV2 response we need
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// topS3LogURLs downloads the ALB or CloudFront logs delivered to S3 within the
+// configured window and returns the most requested paths joined to the base
+// URL, most popular first
+func topS3LogURLs(ctx context.Context, config *S3LogsConfig, userAgent string) ([]string, error) {
+	u, err := url.Parse(config.Source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 log source %q: %v", config.Source, err)
+	}
+	bucket, prefix := u.Host, strings.TrimPrefix(u.Path, "/")
+	region := awsRegion(config.Region)
+
+	keys, err := listS3Logs(ctx, config.Endpoint, region, bucket, prefix, time.Now().Add(-config.Window))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no logs under %s modified in the last %v", config.Source, config.Window)
+	}
+
+	parse := parseALBLine
+	if config.Format == S3LogFormatCloudFront {
+		parse = parseCloudFrontLine
+	}
+
+	counts := make(map[string]int)
+	for _, key := range keys {
+		data, err := doAWSRequest(ctx, "GET", s3ObjectURL(config.Endpoint, region, bucket, key), nil, nil, region, "s3")
+		if err != nil {
+			return nil, fmt.Errorf("failed to download log %s: %v", key, err)
+		}
+		if err := countLogRequests(bytes.NewReader(data), parse, userAgent, counts); err != nil {
+			return nil, fmt.Errorf("failed to read log %s: %v", key, err)
+		}
+	}
+	return rankPaths(counts, config.BaseURL, config.Top), nil
+}
+
+// listS3Logs returns the keys under prefix last modified after since
+func listS3Logs(ctx context.Context, endpoint, region, bucket, prefix string, since time.Time) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		listURL := s3ObjectURL(endpoint, region, bucket, "") + "?" + query.Encode()
+
+		data, err := doAWSRequest(ctx, "GET", listURL, nil, nil, region, "s3")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list logs in %s: %v", bucket, err)
+		}
+
+		var result s3ListResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse log listing: %v", err)
+		}
+		for _, object := range result.Contents {
+			if object.LastModified.After(since) && !strings.HasSuffix(object.Key, "/") {
+				keys = append(keys, object.Key)
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// parseALBLine parses an ALB access log entry, whose request line carries
+// the full URL
+func parseALBLine(line string) (logRequest, bool) {
+	match := albLogLine.FindStringSubmatch(line)
+	if match == nil {
+		return logRequest{}, false
+	}
+	requestURL, err := url.Parse(match[3])
+	if err != nil {
+		return logRequest{}, false
+	}
+	return logRequest{method: match[2], path: requestURL.RequestURI(), status: match[1], agent: match[4]}, true
+}
+
+// parseCloudFrontLine parses a tab-separated CloudFront standard log entry
+func parseCloudFrontLine(line string) (logRequest, bool) {
+	if strings.HasPrefix(line, "#") {
+		return logRequest{}, false
+	}
+	fields := strings.Split(line, "\t")
+	if len(fields) < 12 {
+		return logRequest{}, false
+	}
+
+	path := fields[7]
+	if query := fields[11]; query != "-" && query != "" {
+		path += "?" + query
+	}
+	agent, err := url.PathUnescape(fields[10])
+	if err != nil {
+		agent = fields[10]
+	}
+	return logRequest{method: fields[5], path: path, status: fields[8], agent: agent}, true
+}
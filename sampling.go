@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// sampleURLs keeps the sample of a cycle's distinct URLs chosen by max_urls
+// and the sampling mode, in their listed order. Repeats of a kept URL are
+// kept for duplicate stats.
+func (cw *CacheWarmer) sampleURLs(ctx context.Context, urls []string) []string {
+	if cw.config.MaxURLs == 0 && cw.config.Sampling.Percent == 0 {
+		return urls
+	}
+	distinct, _ := dedupeURLs(urls)
+	n := cw.config.sampleSize(len(distinct))
+	if n >= len(distinct) {
+		return urls
+	}
+
+	var kept []string
+	switch cw.config.Sampling.Mode {
+	case SampleRandom:
+		kept = make([]string, n)
+		for i, index := range rand.Perm(len(distinct))[:n] {
+			kept[i] = distinct[index]
+		}
+	case SampleWeighted:
+		kept = cw.weightedSample(distinct, n)
+	default:
+		kept = cw.prioritize(ctx, distinct)[:n]
+	}
+
+	cw.logger.Info("Sampled %d of %d URLs (%s)", n, len(distinct), cw.config.Sampling.Mode)
+	return keepURLs(urls, kept)
+}
+
+// keepURLs returns the urls that are among kept, in their order and with
+// their repeats
+func keepURLs(urls, kept []string) []string {
+	keep := make(map[string]bool, len(kept))
+	for _, url := range kept {
+		keep[url] = true
+	}
+	filtered := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if keep[url] {
+			filtered = append(filtered, url)
+		}
+	}
+	return filtered
+}
+
+// sampleSize returns how many of total distinct URLs a cycle warms: the
+// sampling percentage of them, capped at max_urls
+func (c *Config) sampleSize(total int) int {
+	n := total
+	if c.Sampling.Percent > 0 {
+		n = int(math.Ceil(float64(total) * c.Sampling.Percent / 100))
+	}
+	if c.MaxURLs > 0 && n > c.MaxURLs {
+		n = c.MaxURLs
+	}
+	return n
+}
+
+// weightedSample draws n of urls without replacement, each with a chance
+// proportional to its sitemap <priority>. URLs of priority 0 are only drawn
+// once all others are.
+func (cw *CacheWarmer) weightedSample(urls []string, n int) []string {
+	weights := cw.sitemapPriorities.Weights(urls)
+
+	// Efraimidis-Spirakis: keep the n largest of u^(1/w), compared as
+	// log(u)/w
+	keys := make([]float64, len(urls))
+	for i, w := range weights {
+		keys[i] = math.Inf(-1)
+		if w > 0 {
+			keys[i] = math.Log(1-rand.Float64()) / w
+		}
+	}
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]] > keys[order[j]]
+	})
+
+	sampled := make([]string, n)
+	for i, index := range order[:n] {
+		sampled[i] = urls[index]
+	}
+	return sampled
+}
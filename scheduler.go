@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SchedulerStats is a live snapshot of the worker pool and work queue. Busy
+// workers with few requests in flight point at politeness or retry waits;
+// idle workers with an empty queue point at a starved scheduler.
+type SchedulerStats struct {
+	Workers          int           `json:"workers"`
+	BusyWorkers      int64         `json:"busy_workers"`
+	QueueDepth       int64         `json:"queue_depth"`
+	InFlightRequests int64         `json:"in_flight_requests"`
+	HeapPaused       bool          `json:"heap_paused"`
+	PerWorker        []WorkerStats `json:"per_worker"`
+}
+
+// WorkerStats describes one worker of a running cycle
+type WorkerStats struct {
+	ID            int     `json:"id"`
+	Busy          bool    `json:"busy"`
+	Processed     int64   `json:"processed"`
+	URLsPerSecond float64 `json:"urls_per_second"`
+	Utilization   float64 `json:"utilization"`
+}
+
+// scheduler holds the gauges behind SchedulerStats. Concurrent runs (e.g.
+// webhook-triggered ones) add to the same gauges.
+type scheduler struct {
+	busy     int64
+	queued   int64
+	inFlight int64
+
+	mutex   sync.Mutex
+	workers []*workerGauge
+}
+
+// workerGauge tracks one worker from start to finish
+type workerGauge struct {
+	id        int
+	started   time.Time
+	busySince int64 // UnixNano while processing a job, otherwise 0
+	busyTime  int64 // nanoseconds spent on completed jobs
+	processed int64
+}
+
+// addWorker registers a worker started by dispatch
+func (s *scheduler) addWorker(id int) *workerGauge {
+	gauge := &workerGauge{id: id, started: time.Now()}
+	s.mutex.Lock()
+	s.workers = append(s.workers, gauge)
+	s.mutex.Unlock()
+	return gauge
+}
+
+// removeWorker unregisters a worker once it has exited
+func (s *scheduler) removeWorker(gauge *workerGauge) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, w := range s.workers {
+		if w == gauge {
+			s.workers = append(s.workers[:i], s.workers[i+1:]...)
+			return
+		}
+	}
+}
+
+// begin marks the worker busy with a job
+func (s *scheduler) begin(gauge *workerGauge) {
+	atomic.AddInt64(&s.busy, 1)
+	atomic.StoreInt64(&gauge.busySince, time.Now().UnixNano())
+}
+
+// end marks the worker's job finished
+func (s *scheduler) end(gauge *workerGauge) {
+	since := atomic.SwapInt64(&gauge.busySince, 0)
+	atomic.AddInt64(&gauge.busyTime, time.Now().UnixNano()-since)
+	atomic.AddInt64(&gauge.processed, 1)
+	atomic.AddInt64(&s.busy, -1)
+}
+
+// SchedulerStats returns a snapshot of the worker pool and work queue
+func (cw *CacheWarmer) SchedulerStats() SchedulerStats {
+	s := &cw.scheduler
+	stats := SchedulerStats{
+		BusyWorkers:      atomic.LoadInt64(&s.busy),
+		QueueDepth:       atomic.LoadInt64(&s.queued),
+		InFlightRequests: atomic.LoadInt64(&s.inFlight),
+		HeapPaused:       cw.admission.Paused(),
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	stats.Workers = len(s.workers)
+	stats.PerWorker = make([]WorkerStats, 0, len(s.workers))
+	for _, w := range s.workers {
+		busyTime := atomic.LoadInt64(&w.busyTime)
+		since := atomic.LoadInt64(&w.busySince)
+		if since != 0 {
+			busyTime += now.UnixNano() - since
+		}
+
+		ws := WorkerStats{
+			ID:        w.id,
+			Busy:      since != 0,
+			Processed: atomic.LoadInt64(&w.processed),
+		}
+		if elapsed := now.Sub(w.started); elapsed > 0 {
+			ws.URLsPerSecond = float64(ws.Processed) / elapsed.Seconds()
+			ws.Utilization = float64(busyTime) / float64(elapsed)
+		}
+		stats.PerWorker = append(stats.PerWorker, ws)
+	}
+	return stats
+}
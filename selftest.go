@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockOrigin is an embedded HTTP server that simulates misbehaving origins
+type MockOrigin struct {
+	listener net.Listener
+	server   *http.Server
+	slow     time.Duration
+	hang     time.Duration
+
+	// flaky counts requests per path so the first few can fail
+	flakyMutex sync.Mutex
+	flaky      map[string]int
+}
+
+// mockFlakyFailures is the number of 503 responses before /flaky recovers
+const mockFlakyFailures = 2
+
+// NewMockOrigin starts a mock origin on a random loopback port. Slow responses
+// take slow; hanging responses take hang, which should exceed the timeout.
+func NewMockOrigin(slow, hang time.Duration) (*MockOrigin, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mock origin: %v", err)
+	}
+
+	m := &MockOrigin{
+		listener: listener,
+		slow:     slow,
+		hang:     hang,
+		flaky:    make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", m.okHandler)
+	mux.HandleFunc("/slow", m.delayHandler(slow))
+	mux.HandleFunc("/timeout", m.delayHandler(hang))
+	mux.HandleFunc("/flaky", m.flakyHandler)
+	mux.HandleFunc("/error", m.errorHandler)
+	mux.HandleFunc("/redirect-loop", m.redirectLoopHandler)
+	mux.HandleFunc("/truncated", m.truncatedHandler)
+
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(listener)
+
+	return m, nil
+}
+
+// URL returns the absolute URL of a mock origin path
+func (m *MockOrigin) URL(path string) string {
+	return fmt.Sprintf("http://%s%s", m.listener.Addr().String(), path)
+}
+
+// okHandler always responds successfully
+func (m *MockOrigin) okHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Cache", "HIT")
+	fmt.Fprintln(w, "ok")
+}
+
+// delayHandler responds successfully after a delay, or gives up when the
+// client cancels the request
+func (m *MockOrigin) delayHandler(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+			fmt.Fprintln(w, "slow ok")
+		case <-r.Context().Done():
+		}
+	}
+}
+
+// flakyHandler fails with 503 a few times, then recovers
+func (m *MockOrigin) flakyHandler(w http.ResponseWriter, r *http.Request) {
+	m.flakyMutex.Lock()
+	m.flaky[r.URL.Path]++
+	count := m.flaky[r.URL.Path]
+	m.flakyMutex.Unlock()
+
+	if count <= mockFlakyFailures {
+		http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "recovered")
+}
+
+// errorHandler always fails with 500
+func (m *MockOrigin) errorHandler(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+// redirectLoopHandler redirects to itself forever
+func (m *MockOrigin) redirectLoopHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/redirect-loop", http.StatusFound)
+}
+
+// truncatedHandler promises a longer body than it sends, then drops the connection
+func (m *MockOrigin) truncatedHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Length", "1048576")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strings.Repeat("x", 1024)))
+
+	if hj, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
+
+// Close stops the mock origin
+func (m *MockOrigin) Close() {
+	m.server.Close()
+}
+
+// selfTestScenario is one simulated failure mode and the outcome a healthy
+// configuration should produce for it
+type selfTestScenario struct {
+	name    string
+	path    string
+	success bool
+	hint    string
+}
+
+// RunSelfTest warms the mock origin with the given configuration's retry,
+// timeout and redirect settings and reports whether each scenario behaved as
+// expected. It returns false if any scenario did not.
+func RunSelfTest(config *Config, logger *Logger) bool {
+	// Responses well inside the timeout should succeed; hanging ones must not
+	slow := config.Timeout / 2
+	if slow > 2*time.Second {
+		slow = 2 * time.Second
+	}
+	hang := config.Timeout + 500*time.Millisecond
+
+	origin, err := NewMockOrigin(slow, hang)
+	if err != nil {
+		logger.Error("%v", err)
+		return false
+	}
+	defer origin.Close()
+
+	scenarios := []selfTestScenario{
+		{"healthy response", "/ok", true, "basic requests are failing; check headers and success_codes"},
+		{"slow response", "/slow", true, fmt.Sprintf("timeout (%v) is too short for responses taking %v", config.Timeout, slow)},
+		{"flaky 5xx", "/flaky", true, fmt.Sprintf("retries for 5xx (%d) cannot absorb %d transient 503s", config.RetriesFor(&StatusCodeError{StatusCode: http.StatusServiceUnavailable}), mockFlakyFailures)},
+		{"persistent 5xx", "/error", false, "500 is treated as success; check success_codes"},
+		{"hanging response", "/timeout", false, "requests exceeding the timeout are not failing"},
+		{"redirect loop", "/redirect-loop", false, "redirect loops are not detected; enable follow_redirects or drop 302 from success_codes"},
+		{"truncated body", "/truncated", false, "truncated bodies are treated as success"},
+	}
+
+	// Run against a private copy so production-only features stay off
+	testConfig := *config
+	testConfig.URLs = make([]URLEntry, len(scenarios))
+	for i, sc := range scenarios {
+		testConfig.URLs[i] = URLEntry{URL: origin.URL(sc.path)}
+	}
+	testConfig.Templates = nil
+	testConfig.RemoteList.URL = ""
+	testConfig.Sitemap = ""
+	testConfig.OpenAPI.Spec = ""
+	testConfig.GraphQL.Endpoint = ""
+	testConfig.AccessLog.Files = nil
+	testConfig.S3Logs.Source = ""
+	testConfig.GoogleAnalytics.PropertyID = ""
+	testConfig.SkipList.File = ""
+	testConfig.Checkpoint.File = ""
+	testConfig.Shard = ShardConfig{}
+	testConfig.Interval = 0
+	testConfig.Conditional = ConditionalConfig{}
+	testConfig.StateFile = ""
+	testConfig.FailuresFile = ""
+	testConfig.Artifacts = ArtifactsConfig{}
+	testConfig.ResultsFile = ""
+	testConfig.HistoryFile = ""
+	testConfig.Tiers = nil
+	testConfig.Groups = nil
+	testConfig.TTLSchedule.Enabled = false
+	testConfig.Auth.Type = ""
+	testConfig.SuccessRules = nil
+	testConfig.LocaleChecks = nil
+	testConfig.Method = http.MethodGet
+	testConfig.Crawl.Enabled = false
+	testConfig.Robots.Sitemaps = false
+	testConfig.Assets = AssetsConfig{}
+	testConfig.Regions = nil
+	testConfig.Devices = nil
+	testConfig.Shadow.BaseURL = ""
+	testConfig.DisableServices()
+
+	// The mock origin is a plaintext HTTP/1.1 server on the IPv4 loopback
+	testConfig.HTTP2 = HTTP2Auto
+	testConfig.IPFamily = IPFamilyDual
+	testConfig.ProxyURL = ""
+	testConfig.ProxyPool.Proxies = nil
+
+	if err := testConfig.Validate(); err != nil {
+		logger.Error("Invalid configuration: %v", err)
+		return false
+	}
+
+	logger.Info("Running self-test against mock origin %s", origin.URL("/"))
+
+	warmer := NewCacheWarmer(&testConfig, logger)
+	warmer.WarmCache(context.Background())
+
+	results := make(map[string]Result)
+	for _, result := range warmer.GetResults() {
+		results[result.URL] = result
+	}
+
+	passed := true
+	logger.Info("Self-test results:")
+	for _, sc := range scenarios {
+		result := results[origin.URL(sc.path)]
+
+		outcome := "failure"
+		if result.Success {
+			outcome = "success"
+		}
+
+		if result.Success == sc.success {
+			logger.Info("  PASS  %-18s %s after %d attempt(s) in %v",
+				sc.name, outcome, result.Attempts, result.Duration.Round(time.Millisecond))
+		} else {
+			passed = false
+			logger.Warn("  FAIL  %-18s %s after %d attempt(s) in %v: %s",
+				sc.name, outcome, result.Attempts, result.Duration.Round(time.Millisecond), sc.hint)
+		}
+	}
+
+	return passed
+}
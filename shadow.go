@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ShadowResult is the outcome of mirroring a warm request to the shadow host
+type ShadowResult struct {
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+
+	// Mismatch says how the shadow response differed from the primary one,
+	// empty if they matched
+	Mismatch string `json:"mismatch,omitempty"`
+}
+
+// ShadowSummary compares the primary and shadow responses of a run
+type ShadowSummary struct {
+	BaseURL  string `json:"base_url"`
+	Requests int    `json:"requests"`
+
+	// StatusMismatches, ContentMismatches and Errors count the mirrored
+	// requests that got a different status, a different body or no response
+	StatusMismatches  int `json:"status_mismatches"`
+	ContentMismatches int `json:"content_mismatches"`
+	Errors            int `json:"errors"`
+
+	// PrimaryAvgMs and ShadowAvgMs are the mean response times of the
+	// requests both stacks answered, the primary one successfully
+	PrimaryAvgMs float64 `json:"primary_avg_ms"`
+	ShadowAvgMs  float64 `json:"shadow_avg_ms"`
+
+	// Mismatched lists the URLs whose responses differed
+	Mismatched []string `json:"mismatched,omitempty"`
+}
+
+// shadowURL maps a warm URL onto the shadow base URL
+func (cw *CacheWarmer) shadowURL(rawURL string) (string, error) {
+	base, err := url.Parse(cw.config.Shadow.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = base.Scheme
+	u.Host = base.Host
+	if prefix := strings.TrimSuffix(base.Path, "/"); prefix != "" {
+		if u.RawPath != "" {
+			u.RawPath = strings.TrimSuffix(base.EscapedPath(), "/") + u.RawPath
+		}
+		u.Path = prefix + u.Path
+	}
+	return u.String(), nil
+}
+
+// mirror repeats the request for result on the shadow host and records how
+// the response compares
+func (cw *CacheWarmer) mirror(ctx context.Context, result *Result) {
+	// GraphQL queries are sent as built for their own endpoint
+	if cw.graphQL[result.URL] != nil {
+		return
+	}
+
+	shadowURL, err := cw.shadowURL(result.URL)
+	if err != nil {
+		return
+	}
+	req, err := cw.newRequest(ctx, shadowURL)
+	if err != nil {
+		return
+	}
+	req.Method = cw.config.Method
+	if cw.config.Shadow.PreserveHost {
+		if primary, err := url.Parse(result.URL); err == nil {
+			req.Host = primary.Host
+		}
+	}
+
+	shadow := &ShadowResult{URL: shadowURL}
+	start := time.Now()
+	resp, err := cw.client.Do(req)
+	if err == nil {
+		defer resp.Body.Close()
+		var hash string
+		hash, err = readBodyHash(resp.Body, cw.config.Shadow.CompareContent)
+		shadow.StatusCode = resp.StatusCode
+		if err == nil {
+			shadow.DurationMs = float64(time.Since(start)) / float64(time.Millisecond)
+			shadow.Mismatch = compareShadow(result, resp.StatusCode, hash)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		shadow.Error = err.Error()
+		shadow.Mismatch = "shadow request failed"
+	}
+
+	if shadow.Mismatch != "" {
+		cw.logger.Warn("Shadow response for %s differs: %s", result.URL, shadow.Mismatch)
+	}
+	result.Shadow = shadow
+}
+
+// compareShadow describes how a shadow response differs from the primary
+// result, or returns "" if it matches
+func compareShadow(primary *Result, status int, hash string) string {
+	switch {
+	case primary.StatusCode == 0:
+		return fmt.Sprintf("status %d, primary failed without a response", status)
+	case status != primary.StatusCode:
+		return fmt.Sprintf("status %d, primary %d", status, primary.StatusCode)
+	case primary.Success && hash != primary.contentHash:
+		return "content differs"
+	}
+	return ""
+}
+
+// readBodyHash reads a response body to the end, returning its SHA-256 hash
+// if hashed is set
+func readBodyHash(body io.Reader, hashed bool) (string, error) {
+	if !hashed {
+		_, err := io.Copy(io.Discard, body)
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// GetShadowSummary compares the primary and shadow responses among results
+func (cw *CacheWarmer) GetShadowSummary(results []Result) ShadowSummary {
+	summary := ShadowSummary{BaseURL: cw.config.Shadow.BaseURL}
+	var answered int
+	var primaryMs, shadowMs float64
+	for _, result := range results {
+		shadow := result.Shadow
+		if shadow == nil || result.Coalesced {
+			continue
+		}
+		summary.Requests++
+		switch {
+		case shadow.Error != "":
+			summary.Errors++
+		case shadow.StatusCode != result.StatusCode:
+			summary.StatusMismatches++
+		case shadow.Mismatch != "":
+			summary.ContentMismatches++
+		}
+		if shadow.Mismatch != "" {
+			summary.Mismatched = append(summary.Mismatched, result.URL)
+		}
+		if shadow.Error == "" && result.Success {
+			answered++
+			primaryMs += float64(result.latency) / float64(time.Millisecond)
+			shadowMs += shadow.DurationMs
+		}
+	}
+	if answered > 0 {
+		summary.PrimaryAvgMs = primaryMs / float64(answered)
+		summary.ShadowAvgMs = shadowMs / float64(answered)
+	}
+	return summary
+}
+
+// printShadowSummary prints how the shadow host compared
+func (cw *CacheWarmer) printShadowSummary(results []Result) {
+	s := cw.GetShadowSummary(results)
+	cw.logger.Info("  Shadow (%s): %d mirrored, %d status mismatches, %d content mismatches, %d errors",
+		s.BaseURL, s.Requests, s.StatusMismatches, s.ContentMismatches, s.Errors)
+	cw.logger.Info("  Shadow response time: %.1fms (primary %.1fms)", s.ShadowAvgMs, s.PrimaryAvgMs)
+	for _, url := range s.Mismatched {
+		cw.logger.Debug("    differs on shadow: %s", url)
+	}
+}
+
+// hashingBody returns where makeRequest copies the response body to: a
+// SHA-256 hash when shadow comparison needs one, or io.Discard
+func (cw *CacheWarmer) hashingBody() (io.Writer, func() string) {
+	if cw.config.Shadow.BaseURL == "" || !cw.config.Shadow.CompareContent {
+		return io.Discard, func() string { return "" }
+	}
+	h := sha256.New()
+	return h, func() string { return hex.EncodeToString(h.Sum(nil)) }
+}
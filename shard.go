@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// validate checks the index falls within the shard count
+func (s *ShardConfig) validate() error {
+	if s.Count < 0 {
+		return fmt.Errorf("count must be non-negative, got %d", s.Count)
+	}
+	if s.Count == 0 {
+		if s.Index != 0 {
+			return fmt.Errorf("index %d requires a count", s.Index)
+		}
+		return nil
+	}
+	if s.Index < 0 || s.Index >= s.Count {
+		return fmt.Errorf("index must be between 0 and %d, got %d", s.Count-1, s.Index)
+	}
+	return nil
+}
+
+// inShard reports whether rawURL belongs to the configured shard. URLs are
+// assigned by hash, so every instance agrees on the split without talking
+// to the others, whatever order its sources list the URLs in.
+func (s *ShardConfig) inShard(rawURL string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(rawURL))
+	return int(h.Sum32()%uint32(s.Count)) == s.Index
+}
+
+// shardURLs keeps the URLs of this instance's shard
+func (cw *CacheWarmer) shardURLs(urls []string) []string {
+	if cw.config.Shard.Count <= 1 {
+		return urls
+	}
+
+	sharded := make([]string, 0, len(urls)/cw.config.Shard.Count+1)
+	for _, u := range urls {
+		if cw.config.Shard.inShard(u) {
+			sharded = append(sharded, u)
+		}
+	}
+
+	cw.logger.Info("Warming shard %d of %d: %d of %d URLs",
+		cw.config.Shard.Index, cw.config.Shard.Count, len(sharded), len(urls))
+	return sharded
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ShieldSummary reports the shield stage of a run
+type ShieldSummary struct {
+	Name      string `json:"name"`
+	Requests  int    `json:"requests"`
+	Successes int    `json:"successes"`
+
+	// Hits counts URLs that were a HIT at the shield, on the warm request
+	// or on verification
+	Hits int `json:"hits"`
+
+	// Verified counts URLs that only became a HIT on verification
+	Verified int `json:"verified"`
+
+	// NotCached lists warmed URLs that were never a HIT at the shield
+	NotCached []string `json:"not_cached,omitempty"`
+}
+
+// dispatch warms the URLs in stages: through the origin shield first, if one
+// is configured, verifying the shield cached them, then through the edge
+// regions. It returns false if the run was cancelled.
+func (cw *CacheWarmer) dispatch(ctx context.Context, urls []string) bool {
+	if cw.shield != nil && len(urls) > 0 {
+		cw.logger.Info("Shield stage: warming %d URLs through %s", len(urls), cw.shield.name)
+		first := runOf(ctx).resultCount()
+		if !cw.dispatchRegions(ctx, urls, []*region{cw.shield}) || !cw.verifyShield(ctx, first) {
+			return false
+		}
+		cw.logger.Info("Edge stage: warming %d URLs through %d regions", len(urls), len(cw.regions))
+	}
+	return cw.dispatchRegions(ctx, urls, cw.regions)
+}
+
+// verifyShield re-requests successful shield results from first on that were
+// not a HIT, up to verify_attempts times, and marks those that come back as a
+// HIT. It returns false if the run was cancelled.
+func (cw *CacheWarmer) verifyShield(ctx context.Context, first int) bool {
+	run := runOf(ctx)
+	run.resultsMutex.Lock()
+	var pending []int
+	for i := first; i < len(run.results); i++ {
+		result := run.results[i]
+		if result.Region == cw.shield.name && result.Success && result.CacheStatus != CacheStatusHit {
+			pending = append(pending, i)
+		}
+	}
+	run.resultsMutex.Unlock()
+
+	for attempt := 0; attempt < cw.config.Shield.VerifyAttempts && len(pending) > 0; attempt++ {
+		// Give the shield a moment to finish storing the responses
+		select {
+		case <-time.After(cw.config.Shield.VerifyDelay):
+		case <-ctx.Done():
+			return false
+		}
+
+		cw.logger.Debug("Verifying %d URLs at %s (attempt %d/%d)",
+			len(pending), cw.shield.name, attempt+1, cw.config.Shield.VerifyAttempts)
+
+		var (
+			wg      sync.WaitGroup
+			mutex   sync.Mutex
+			missing []int
+		)
+		slots := make(chan struct{}, cw.runWorkers(ctx))
+		for _, i := range pending {
+			run.resultsMutex.Lock()
+			url, device, byteRange := run.results[i].URL, run.results[i].Device, run.results[i].Range
+			run.resultsMutex.Unlock()
+
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(i int, url, device, byteRange string) {
+				defer wg.Done()
+				defer func() { <-slots }()
+
+				check := Result{URL: url, Region: cw.shield.name, Device: device, Range: byteRange}
+				if cw.throttle.Wait(ctx) != nil {
+					return
+				}
+				if ok, _ := cw.makeRequest(ctx, cw.shield.client, url, &check); ok && check.CacheStatus == CacheStatusHit {
+					run.resultsMutex.Lock()
+					run.results[i].Verified = true
+					run.resultsMutex.Unlock()
+					return
+				}
+				mutex.Lock()
+				missing = append(missing, i)
+				mutex.Unlock()
+			}(i, url, device, byteRange)
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			return false
+		}
+		pending = missing
+	}
+
+	if len(pending) > 0 {
+		cw.logger.Warn("Shield stage: %d URLs are not cached at %s; the edge will fetch them from origin",
+			len(pending), cw.shield.name)
+	}
+	return true
+}
+
+// GetShieldSummary returns the shield stage outcome among results
+func (cw *CacheWarmer) GetShieldSummary(results []Result) ShieldSummary {
+	summary := ShieldSummary{Name: cw.shield.name}
+	for _, result := range results {
+		if result.Region != cw.shield.name {
+			continue
+		}
+		summary.Requests++
+		if !result.Success {
+			continue
+		}
+		summary.Successes++
+		switch {
+		case result.CacheStatus == CacheStatusHit:
+			summary.Hits++
+		case result.Verified:
+			summary.Hits++
+			summary.Verified++
+		default:
+			summary.NotCached = append(summary.NotCached, result.URL)
+		}
+	}
+	return summary
+}
+
+// printShieldSummary prints the shield stage outcome
+func (cw *CacheWarmer) printShieldSummary(results []Result) {
+	s := cw.GetShieldSummary(results)
+	cw.logger.Info("  Shield stage (%s): %d/%d warmed, %d HIT (%d after verification), %d not cached",
+		s.Name, s.Successes, s.Requests, s.Hits, s.Verified, len(s.NotCached))
+	for _, url := range s.NotCached {
+		cw.logger.Debug("    not cached at %s: %s", s.Name, url)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxSitemapSize caps a single (decompressed) sitemap document, matching
+	// the 50MB limit of the sitemaps protocol
+	maxSitemapSize = 50 << 20
+
+	// maxSitemapDepth bounds how deeply sitemap indexes may nest
+	maxSitemapDepth = 3
+)
+
+// sitemapEntries maps each sitemap root element to the element its <loc>
+// entries are listed in
+var sitemapEntries = map[string]string{
+	"urlset":       "url",
+	"sitemapindex": "sitemap",
+}
+
+// fetchSitemap downloads an XML sitemap and returns every <loc> entry,
+// following sitemap indexes and decompressing gzipped sitemaps
+func (cw *CacheWarmer) fetchSitemap(ctx context.Context, sitemapURL string) ([]string, error) {
+	var urls []string
+	seen := make(map[string]bool)
+	if err := cw.collectSitemap(ctx, sitemapURL, 0, seen, &urls); err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// collectSitemap appends the entries of one sitemap, recursing into indexes
+func (cw *CacheWarmer) collectSitemap(ctx context.Context, sitemapURL string, depth int, seen map[string]bool, urls *[]string) error {
+	if seen[sitemapURL] {
+		return nil
+	}
+	seen[sitemapURL] = true
+
+	var sitemaps []string
+	root, err := cw.readSitemap(ctx, sitemapURL, func(root, loc, priority string) {
+		if root == "urlset" {
+			*urls = append(*urls, loc)
+			if cw.config.Order == OrderSitemapPriority || cw.config.Sampling.Mode == SampleWeighted {
+				cw.sitemapPriorities.Set(loc, priority)
+			}
+		} else {
+			sitemaps = append(sitemaps, loc)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	switch root {
+	case "urlset":
+	case "sitemapindex":
+		if depth >= maxSitemapDepth {
+			return fmt.Errorf("sitemap index %s is nested too deeply", sitemapURL)
+		}
+		for _, loc := range sitemaps {
+			if err := cw.collectSitemap(ctx, loc, depth+1, seen, urls); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("sitemap %s has unexpected root element <%s>", sitemapURL, root)
+	}
+
+	return nil
+}
+
+// readSitemap fetches a sitemap with the configured headers, transparently
+// decompressing .xml.gz files, and streams its entries to emit as they are
+// parsed, so a 50MB sitemap is never held in memory whole. It returns the
+// document's root element.
+func (cw *CacheWarmer) readSitemap(ctx context.Context, sitemapURL string, emit func(root, loc, priority string)) (string, error) {
+	req, err := cw.newRequest(ctx, sitemapURL)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cw.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch sitemap %s: %v", sitemapURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch sitemap %s: status code %d", sitemapURL, resp.StatusCode)
+	}
+
+	// Sniff the gzip magic number rather than trusting the file extension
+	body := bufio.NewReader(resp.Body)
+	var reader io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress sitemap %s: %v", sitemapURL, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	limited := &io.LimitedReader{R: reader, N: maxSitemapSize + 1}
+	root, err := parseSitemap(limited, emit)
+	if limited.N == 0 {
+		return "", fmt.Errorf("sitemap %s exceeds %d bytes", sitemapURL, maxSitemapSize)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to parse sitemap %s: %v", sitemapURL, err)
+	}
+	return root, nil
+}
+
+// parseSitemap decodes a sitemap document token by token, calling emit with
+// the <loc> and <priority> of each <url> of a urlset or <sitemap> of a
+// sitemap index. It stops at the root element if that is neither.
+func parseSitemap(r io.Reader, emit func(root, loc, priority string)) (string, error) {
+	decoder := xml.NewDecoder(r)
+
+	var (
+		root, entry    string
+		depth          int
+		inEntry, inLoc bool
+		inPriority     bool
+		loc, priority  strings.Builder
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			if root == "" {
+				return "", fmt.Errorf("no root element")
+			}
+			return root, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				root = t.Name.Local
+				if entry = sitemapEntries[root]; entry == "" {
+					return root, nil
+				}
+			case 2:
+				inEntry = t.Name.Local == entry
+				loc.Reset()
+				priority.Reset()
+			case 3:
+				// Only the entry's own <loc>, not e.g. <image:loc> nested
+				// deeper in image sitemaps
+				inLoc = inEntry && t.Name.Local == "loc"
+				inPriority = inEntry && t.Name.Local == "priority"
+			}
+		case xml.CharData:
+			switch {
+			case inLoc:
+				loc.Write(t)
+			case inPriority:
+				priority.Write(t)
+			}
+		case xml.EndElement:
+			depth--
+			switch depth {
+			case 2:
+				inLoc, inPriority = false, false
+			case 1:
+				// The entry is complete once its element closes
+				if value := strings.TrimSpace(loc.String()); inEntry && value != "" {
+					emit(root, value, priority.String())
+				}
+				inEntry = false
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SkipEntry is the persisted failure state of a single URL
+type SkipEntry struct {
+	// Failures is the number of consecutive cycles the URL failed in
+	Failures int `json:"failures"`
+
+	// LastError is the error of the most recent failure
+	LastError string `json:"last_error,omitempty"`
+
+	// SkippedAt is when the URL was moved to the skip list (zero = not skipped)
+	SkippedAt time.Time `json:"skipped_at"`
+
+	// RetryAt is when a skipped URL is next re-checked
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// SkipRecord is a skipped URL as shown in run reports
+type SkipRecord struct {
+	URL       string    `json:"url"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	SkippedAt time.Time `json:"skipped_at"`
+	RetryAt   time.Time `json:"retry_at"`
+}
+
+// SkipList tracks URLs that failed several consecutive cycles and stops
+// warming them until their retry time, persisted across restarts
+type SkipList struct {
+	path       string
+	after      int
+	retryAfter time.Duration
+	mutex      sync.Mutex
+	URLs       map[string]*SkipEntry `json:"urls"`
+}
+
+// newSkipList creates an empty skip list persisted according to config
+func newSkipList(config *SkipListConfig) *SkipList {
+	return &SkipList{
+		path:       config.File,
+		after:      config.After,
+		retryAfter: config.RetryAfter,
+		URLs:       make(map[string]*SkipEntry),
+	}
+}
+
+// LoadSkipList reads the skip list file; a missing file yields an empty list
+func LoadSkipList(config *SkipListConfig) (*SkipList, error) {
+	s := newSkipList(config)
+
+	data, err := ioutil.ReadFile(config.File)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read skip list file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse skip list file: %v", err)
+	}
+	if s.URLs == nil {
+		s.URLs = make(map[string]*SkipEntry)
+	}
+	return s, nil
+}
+
+// Filter returns the URLs that should be warmed now and the number skipped.
+// Skipped URLs whose retry time has passed are let through to be re-checked.
+func (s *SkipList) Filter(urls []string) ([]string, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	active := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if entry := s.URLs[url]; entry != nil && !entry.SkippedAt.IsZero() && now.Before(entry.RetryAt) {
+			continue
+		}
+		active = append(active, url)
+	}
+	return active, len(urls) - len(active)
+}
+
+// Rechecking reports whether url is on the skip list and due for its
+// re-check, which is a single attempt without retries
+func (s *SkipList) Rechecking(url string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.URLs[url]
+	return entry != nil && !entry.SkippedAt.IsZero() && !time.Now().Before(entry.RetryAt)
+}
+
+// Update records the outcome of a cycle. A URL counts as failed if every
+// result for it failed; it is skipped once it has failed enough cycles in a
+// row, and removed from the list as soon as it succeeds again. It returns the
+// URLs newly added to the skip list and the skipped URLs that recovered.
+func (s *SkipList) Update(results []Result) (added, recovered []string) {
+	succeeded := make(map[string]bool)
+	lastErr := make(map[string]string)
+	for _, result := range results {
+		// Critical URLs are warmed every cycle, however often they fail
+		if result.Critical {
+			continue
+		}
+		if result.Success {
+			succeeded[result.URL] = true
+		} else if _, ok := succeeded[result.URL]; !ok {
+			succeeded[result.URL] = false
+			if result.Err != nil {
+				lastErr[result.URL] = result.Err.Error()
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for url, ok := range succeeded {
+		if ok {
+			if entry := s.URLs[url]; entry != nil && !entry.SkippedAt.IsZero() {
+				recovered = append(recovered, url)
+			}
+			delete(s.URLs, url)
+			continue
+		}
+
+		entry := s.URLs[url]
+		if entry == nil {
+			entry = &SkipEntry{}
+			s.URLs[url] = entry
+		}
+		entry.Failures++
+		entry.LastError = lastErr[url]
+
+		if entry.Failures >= s.after {
+			if entry.SkippedAt.IsZero() {
+				entry.SkippedAt = now
+				added = append(added, url)
+			}
+			entry.RetryAt = now.Add(s.retryAfter)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(recovered)
+	return added, recovered
+}
+
+// Skipped returns the URLs currently on the skip list, sorted by URL
+func (s *SkipList) Skipped() []SkipRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var records []SkipRecord
+	for url, entry := range s.URLs {
+		if entry.SkippedAt.IsZero() {
+			continue
+		}
+		records = append(records, SkipRecord{
+			URL:       url,
+			Failures:  entry.Failures,
+			LastError: entry.LastError,
+			SkippedAt: entry.SkippedAt,
+			RetryAt:   entry.RetryAt,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].URL < records[j].URL
+	})
+	return records
+}
+
+// Save writes the skip list file atomically
+func (s *SkipList) Save() error {
+	s.mutex.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode skip list: %v", err)
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create skip list directory: %v", err)
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write skip list file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace skip list file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sort"
+	"time"
+)
+
+// slowestListed is how many of the slowest requests the summary lists
+const slowestListed = 10
+
+// requestTiming is the timing breakdown of a request, taken for requests
+// that may exceed slow_threshold. Phases skipped on a reused connection
+// stay 0.
+type requestTiming struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+}
+
+// timingTrace returns a client trace that records the connection setup of
+// a request into t
+func timingTrace(t *requestTiming) *httptrace.ClientTrace {
+	var dnsStart, connectStart, tlsStart time.Time
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { t.DNS = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { t.Connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { t.TLS = time.Since(tlsStart) },
+	}
+}
+
+// isSlow reports whether a result's last attempt took longer than the slow
+// threshold. Failed requests are reported as failures instead.
+func (cw *CacheWarmer) isSlow(result *Result) bool {
+	return cw.config.SlowThreshold > 0 && result.Success && result.latency > cw.config.SlowThreshold
+}
+
+// logSlowRequest warns about a warmed request that exceeded the slow
+// threshold, with its timing breakdown
+func (cw *CacheWarmer) logSlowRequest(workerID int, label string, result *Result) {
+	t := result.timing
+	cw.logger.Warn("Worker %d: slow request to %s took %v (DNS %v, connect %v, TLS %v, first byte %v, body %v; status %d, cache %s, attempt %d)",
+		workerID, label, result.latency.Round(time.Millisecond),
+		t.DNS.Round(time.Millisecond), t.Connect.Round(time.Millisecond), t.TLS.Round(time.Millisecond),
+		result.TTFB.Round(time.Millisecond), (result.latency - result.TTFB).Round(time.Millisecond),
+		result.StatusCode, cacheStatusLabel(result.CacheStatus), result.Attempts)
+}
+
+// cacheStatusLabel returns a cache status for display
+func cacheStatusLabel(status string) string {
+	if status == CacheStatusUnknown {
+		return "unknown"
+	}
+	return status
+}
+
+// SlowSummary describes the requests of the last run over slow_threshold
+type SlowSummary struct {
+	ThresholdMs float64      `json:"threshold_ms"`
+	Requests    int          `json:"requests"`
+	Slowest     []SlowRecord `json:"slowest"`
+}
+
+// SlowRecord is one slow request and its timing breakdown
+type SlowRecord struct {
+	URL         string  `json:"url"`
+	Region      string  `json:"region,omitempty"`
+	Device      string  `json:"device,omitempty"`
+	StatusCode  int     `json:"status_code"`
+	CacheStatus string  `json:"cache_status,omitempty"`
+	DurationMs  float64 `json:"duration_ms"`
+	DNSMs       float64 `json:"dns_ms"`
+	ConnectMs   float64 `json:"connect_ms"`
+	TLSMs       float64 `json:"tls_ms"`
+	FirstByteMs float64 `json:"first_byte_ms"`
+	BodyMs      float64 `json:"body_ms"`
+}
+
+// GetSlowSummary returns the slowest of results over the slow threshold,
+// slowest first
+func (cw *CacheWarmer) GetSlowSummary(results []Result) SlowSummary {
+	var slow []Result
+	for _, result := range results {
+		if cw.isSlow(&result) && !result.Coalesced {
+			slow = append(slow, result)
+		}
+	}
+
+	sort.SliceStable(slow, func(i, j int) bool { return slow[i].latency > slow[j].latency })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	summary := SlowSummary{ThresholdMs: ms(cw.config.SlowThreshold), Requests: len(slow)}
+	for _, result := range slow[:min(len(slow), slowestListed)] {
+		t := result.timing
+		summary.Slowest = append(summary.Slowest, SlowRecord{
+			URL:         result.URL,
+			Region:      result.Region,
+			Device:      result.Device,
+			StatusCode:  result.StatusCode,
+			CacheStatus: result.CacheStatus,
+			DurationMs:  ms(result.latency),
+			DNSMs:       ms(t.DNS),
+			ConnectMs:   ms(t.Connect),
+			TLSMs:       ms(t.TLS),
+			FirstByteMs: ms(result.TTFB),
+			BodyMs:      ms(result.latency - result.TTFB),
+		})
+	}
+	return summary
+}
+
+// printSlowest logs the slowest URLs section of the summary
+func (cw *CacheWarmer) printSlowest(results []Result) {
+	s := cw.GetSlowSummary(results)
+	if s.Requests == 0 {
+		return
+	}
+	cw.logger.Warn("  Slowest URLs (%d requests over %v):", s.Requests, cw.config.SlowThreshold)
+	for _, record := range s.Slowest {
+		via := ""
+		if record.Region != "" {
+			via = " via " + record.Region
+		}
+		cw.logger.Warn("    %8.0fms  %s%s (first byte %.0fms, cache %s)",
+			record.DurationMs, record.URL, via, record.FirstByteMs, cacheStatusLabel(record.CacheStatus))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// cycleStagger spreads a scheduled cycle's requests over a time window
+type cycleStagger struct {
+	window time.Duration
+
+	// bucket paces the dispatch of each URL and region once the cycle
+	// knows how many it has
+	bucket *tokenBucket
+}
+
+// staggerContextKey carries the stagger of a scheduled cycle in its context
+type staggerContextKey struct{}
+
+// staggerOf returns the stagger of the run ctx belongs to, or nil if its
+// requests aren't spread
+func staggerOf(ctx context.Context) *cycleStagger {
+	s, _ := ctx.Value(staggerContextKey{}).(*cycleStagger)
+	return s
+}
+
+// beginScheduledCycle waits out a random part of the jitter for a cycle
+// started by a tick every interval, then returns the context to run it in,
+// which spreads its requests over what is left of the interval if stagger is
+// set. It returns the context error if ctx ends while waiting.
+func (cw *CacheWarmer) beginScheduledCycle(ctx context.Context, interval time.Duration) (context.Context, error) {
+	var jitter time.Duration
+	if cw.config.Jitter > 0 {
+		jitter = time.Duration(rand.Int63n(int64(cw.config.Jitter)))
+		cw.logger.Debug("Delaying the cycle by %v of jitter", jitter.Round(time.Millisecond))
+		timer := time.NewTimer(jitter)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx, ctx.Err()
+		}
+	}
+
+	if !cw.config.Stagger {
+		return ctx, nil
+	}
+	window := interval - jitter
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < window {
+		// Leave the cycle timeout a chance to pass
+		window = time.Until(deadline)
+	}
+	return context.WithValue(ctx, staggerContextKey{}, &cycleStagger{window: window}), nil
+}
+
+// spread paces units dispatches of URLs to regions evenly over the window
+func (s *cycleStagger) spread(units int) {
+	if units < 1 || s.window <= 0 {
+		return
+	}
+	s.bucket = newTokenBucket(&RateLimitConfig{
+		RequestsPerSecond: float64(units) / s.window.Seconds(),
+		Burst:             1,
+	})
+}
+
+// Wait blocks until the next URL and region is due. It returns early with
+// the context error if ctx is cancelled.
+func (s *cycleStagger) Wait(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	return s.bucket.Wait(ctx)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdPacketSize caps a batch of metric lines so it fits in one UDP
+// datagram on common networks
+const statsdPacketSize = 1432
+
+// statsdClient pushes metrics to a StatsD or DogStatsD agent over UDP,
+// batching lines into datagrams
+type statsdClient struct {
+	config *StatsDConfig
+	logger *Logger
+	conn   net.Conn
+
+	// tags is the rendered tag list added to every line
+	tags string
+
+	mutex sync.Mutex
+	batch []byte
+	done  chan struct{}
+}
+
+// newStatsDClient connects to the configured agent, or returns nil if the
+// StatsD export is disabled. Sending is best effort: a missing agent only
+// loses metrics.
+func newStatsDClient(config *StatsDConfig, logger *Logger) (*statsdClient, error) {
+	if config.Address == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to StatsD agent %s: %v", config.Address, err)
+	}
+
+	keys := make([]string, 0, len(config.Tags))
+	for key := range config.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tags := make([]string, len(keys))
+	for i, key := range keys {
+		tags[i] = key + ":" + config.Tags[key]
+	}
+
+	s := &statsdClient{
+		config: config,
+		logger: logger,
+		conn:   conn,
+		tags:   strings.Join(tags, ","),
+		done:   make(chan struct{}),
+	}
+	go s.flushEvery(config.FlushInterval)
+	return s, nil
+}
+
+// send queues one metric line of the given type (c, g or ms); tags
+// alternate names and values and are dropped for plain StatsD
+func (s *statsdClient) send(name string, value float64, kind string, tags ...string) {
+	line := s.config.Prefix + name + ":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + kind
+	if s.config.DogStatsD {
+		all := s.tags
+		for i := 0; i+1 < len(tags); i += 2 {
+			if all != "" {
+				all += ","
+			}
+			all += tags[i] + ":" + statsdTagValue(tags[i+1])
+		}
+		if all != "" {
+			line += "|#" + all
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.batch) > 0 && len(s.batch)+1+len(line) > statsdPacketSize {
+		s.flushLocked()
+	}
+	if len(s.batch) > 0 {
+		s.batch = append(s.batch, '\n')
+	}
+	s.batch = append(s.batch, line...)
+}
+
+// statsdTagEscaper replaces the characters that delimit DogStatsD tags
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+// statsdTagValue makes value safe to use as a DogStatsD tag value
+func statsdTagValue(value string) string {
+	return statsdTagEscaper.Replace(value)
+}
+
+// Count adds to a counter
+func (s *statsdClient) Count(name string, value int64, tags ...string) {
+	s.send(name, float64(value), "c", tags...)
+}
+
+// Gauge sets a gauge
+func (s *statsdClient) Gauge(name string, value float64, tags ...string) {
+	s.send(name, value, "g", tags...)
+}
+
+// Timing records a duration in milliseconds
+func (s *statsdClient) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, msOf(d), "ms", tags...)
+}
+
+// msOf returns d in milliseconds, to the microsecond
+func msOf(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// RecordResult sends the metrics of one warmed URL. Results shared from an
+// in-flight request made no request of their own and are left out.
+func (s *statsdClient) RecordResult(result Result) {
+	if result.Coalesced {
+		return
+	}
+	labels := newRequestLabels(result.URL, result.StatusCode, result.CacheStatus)
+	tags := []string{"host", labels.host, "status", labels.status, "cache_state", labels.cacheState}
+	if result.Region != "" {
+		tags = append(tags, "region", result.Region)
+	}
+	s.Count("requests", 1, tags...)
+	s.Timing("request.duration", result.Duration, tags...)
+	if result.TTFB > 0 {
+		s.Timing("request.ttfb", result.TTFB, tags...)
+	}
+	s.Count("response.bytes", result.Bytes, tags...)
+	if !result.Success {
+		s.Count("failures", 1, "host", labels.host, "class", ErrorClass(result.Err))
+	}
+}
+
+// RecordRun sends the summary of a finished run and flushes, so a single
+// run's metrics are out before the process exits
+func (s *statsdClient) RecordRun(summary RunSummary) {
+	result := "success"
+	switch {
+	case summary.Cancelled:
+		result = "cancelled"
+	case summary.FailedRequests > 0:
+		result = "failed"
+	}
+	s.Count("runs", 1, "result", result)
+	s.Timing("run.duration", summary.Duration)
+	s.Gauge("run.requests", float64(summary.TotalRequests))
+	s.Gauge("run.successes", float64(summary.SuccessRequests))
+	s.Gauge("run.failures", float64(summary.FailedRequests))
+	s.Gauge("run.critical_failures", float64(summary.CriticalFailures))
+	s.Gauge("run.duplicates_skipped", float64(summary.DuplicatesSkipped))
+	s.Gauge("run.latency.p50", msOf(summary.Latency.P50))
+	s.Gauge("run.latency.p90", msOf(summary.Latency.P90))
+	s.Gauge("run.latency.p95", msOf(summary.Latency.P95))
+	s.Gauge("run.latency.p99", msOf(summary.Latency.P99))
+	s.Gauge("run.ttfb.p50", msOf(summary.TTFB.P50))
+	s.Gauge("run.ttfb.p90", msOf(summary.TTFB.P90))
+	s.Gauge("run.ttfb.p95", msOf(summary.TTFB.P95))
+	s.Gauge("run.ttfb.p99", msOf(summary.TTFB.P99))
+	s.Gauge("run.bytes", float64(summary.BytesTransferred))
+	s.Flush()
+}
+
+// Flush sends the queued lines
+func (s *statsdClient) Flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flushLocked()
+}
+
+// flushLocked sends the batch; the caller holds the mutex
+func (s *statsdClient) flushLocked() {
+	if len(s.batch) == 0 {
+		return
+	}
+	if _, err := s.conn.Write(s.batch); err != nil {
+		s.logger.Debug("Failed to send metrics to StatsD agent %s: %v", s.config.Address, err)
+	}
+	s.batch = s.batch[:0]
+}
+
+// flushEvery flushes the batch every interval until Close
+func (s *statsdClient) flushEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close flushes the queued lines and closes the connection
+func (s *statsdClient) Close() {
+	close(s.done)
+	s.Flush()
+	s.conn.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// urlPattern is a compiled -only pattern. Patterns starting with a scheme
+// match the whole URL, anything else matches the URL path; * matches any run
+// of characters, including slashes.
+type urlPattern struct {
+	full bool
+	re   *regexp.Regexp
+}
+
+// compileURLPattern compiles a -only pattern such as /checkout/*
+func compileURLPattern(pattern string) urlPattern {
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	return urlPattern{
+		full: strings.Contains(pattern, "://"),
+		re:   regexp.MustCompile("^" + quoted + "$"),
+	}
+}
+
+// Match reports whether rawURL matches the pattern
+func (p urlPattern) Match(rawURL string) bool {
+	if p.full {
+		return p.re.MatchString(rawURL)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	return p.re.MatchString(path)
+}
+
+// selectURLs applies the shard, -only, sampling and -limit to a cycle's
+// URLs, keeping their order. The limit keeps the highest-priority distinct
+// URLs, and repeats of them for duplicate stats.
+func (cw *CacheWarmer) selectURLs(ctx context.Context, urls []string) []string {
+	urls = cw.shardURLs(urls)
+	if len(cw.config.Only) == 0 && cw.config.Limit == 0 {
+		return cw.sampleURLs(ctx, urls)
+	}
+
+	patterns := make([]urlPattern, len(cw.config.Only))
+	for i, pattern := range cw.config.Only {
+		patterns[i] = compileURLPattern(pattern)
+	}
+	matched := urls
+	if len(patterns) > 0 {
+		matched = make([]string, 0, len(urls))
+		for _, u := range urls {
+			if matchesAny(patterns, u) {
+				matched = append(matched, u)
+			}
+		}
+	}
+	matched = cw.sampleURLs(ctx, matched)
+
+	kept, _ := dedupeURLs(matched)
+	if cw.config.Limit > 0 && len(kept) > cw.config.Limit {
+		kept = cw.prioritize(ctx, kept)[:cw.config.Limit]
+	}
+
+	cw.logger.Info("Selected %d of %d URLs for this run", len(kept), len(urls))
+	return keepURLs(matched, kept)
+}
+
+// matchesAny reports whether rawURL matches one of the patterns
+func matchesAny(patterns []urlPattern, rawURL string) bool {
+	for _, p := range patterns {
+		if p.Match(rawURL) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxTemplateURLs caps how many URLs one template may expand to
+const maxTemplateURLs = 1000000
+
+// templatePlaceholder matches a {name} placeholder in a URL template
+var templatePlaceholder = regexp.MustCompile(`\{([_A-Za-z][_0-9A-Za-z-]*)\}`)
+
+// templateRange matches a numeric range value such as 1..500 or 001..120
+var templateRange = regexp.MustCompile(`^(-?\d+)\.\.(-?\d+)$`)
+
+// TemplateValues are the values of a template parameter, written in config
+// as a list or as a range like "1..500"
+type TemplateValues []string
+
+// UnmarshalYAML accepts either a list of values or a single value, which is
+// expanded if it is a range. Zero-padded ranges keep their width.
+func (v *TemplateValues) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		*v = list
+		return nil
+	}
+
+	var single string
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+	m := templateRange.FindStringSubmatch(single)
+	if m == nil {
+		*v = TemplateValues{single}
+		return nil
+	}
+
+	from, err := strconv.Atoi(m[1])
+	if err != nil {
+		return fmt.Errorf("invalid range %q: %v", single, err)
+	}
+	to, err := strconv.Atoi(m[2])
+	if err != nil {
+		return fmt.Errorf("invalid range %q: %v", single, err)
+	}
+	if to < from {
+		return fmt.Errorf("invalid range %q: end is before start", single)
+	}
+	if to-from >= maxTemplateURLs {
+		return fmt.Errorf("range %q has more than %d values", single, maxTemplateURLs)
+	}
+
+	width := 0
+	if len(m[1]) > 1 && m[1][0] == '0' {
+		width = len(m[1])
+	}
+	values := make(TemplateValues, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	*v = values
+	return nil
+}
+
+// Expand returns the template's URLs for every combination of its parameter
+// values, varying the last placeholder fastest and the query parameters
+// faster still. A placeholder used twice takes the same value in both
+// places. Values are escaped for the part of the URL they appear in.
+func (t *URLTemplate) Expand() ([]string, error) {
+	if t.MaxURLs < 0 || t.MaxURLs > maxTemplateURLs {
+		return nil, fmt.Errorf("URL template %s max_urls must be between 0 and %d", t.URL, maxTemplateURLs)
+	}
+
+	// Split the template into literal text and placeholders, each referring
+	// to one of the distinct parameters
+	type placeholder struct {
+		param   int
+		inQuery bool
+	}
+	var literals []string
+	var placeholders []placeholder
+	var params []TemplateValues
+	seen := make(map[string]int)
+	inQuery := false
+	rest := t.URL
+	for {
+		loc := templatePlaceholder.FindStringSubmatchIndex(rest)
+		if loc == nil {
+			break
+		}
+		literals = append(literals, rest[:loc[0]])
+		inQuery = inQuery || strings.Contains(rest[:loc[0]], "?")
+
+		name := rest[loc[2]:loc[3]]
+		i, ok := seen[name]
+		if !ok {
+			values := t.Params[name]
+			if len(values) == 0 {
+				return nil, fmt.Errorf("URL template %s has no values for {%s}", t.URL, name)
+			}
+			i = len(params)
+			seen[name] = i
+			params = append(params, values)
+		}
+		placeholders = append(placeholders, placeholder{param: i, inQuery: inQuery})
+		rest = rest[loc[1]:]
+	}
+	literals = append(literals, rest)
+
+	// Query parameters follow as parameters of their own
+	queryStart := len(params)
+	queryNames := make([]string, 0, len(t.Query))
+	for name, values := range t.Query {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("URL template %s has no values for query parameter %s", t.URL, name)
+		}
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		params = append(params, t.Query[name])
+	}
+	querySep := "?"
+	if strings.Contains(t.URL, "?") {
+		querySep = "&"
+	}
+
+	if len(params) == 0 {
+		return nil, fmt.Errorf("URL template %s has no placeholders or query parameters", t.URL)
+	}
+	for name := range t.Params {
+		if _, ok := seen[name]; !ok {
+			return nil, fmt.Errorf("URL template %s has no placeholder {%s}", t.URL, name)
+		}
+	}
+
+	// Past max_urls the remaining combinations are never generated
+	total := 1
+	for _, values := range params {
+		total *= len(values)
+		if total <= maxTemplateURLs {
+			continue
+		}
+		if t.MaxURLs == 0 {
+			return nil, fmt.Errorf("URL template %s expands to more than %d URLs", t.URL, maxTemplateURLs)
+		}
+		total = maxTemplateURLs
+		break
+	}
+	if t.MaxURLs > 0 && total > t.MaxURLs {
+		total = t.MaxURLs
+	}
+
+	urls := make([]string, 0, total)
+	index := make([]int, len(params))
+	var b strings.Builder
+	for {
+		b.Reset()
+		for i, p := range placeholders {
+			b.WriteString(literals[i])
+			value := params[p.param][index[p.param]]
+			if p.inQuery {
+				b.WriteString(url.QueryEscape(value))
+			} else {
+				b.WriteString(url.PathEscape(value))
+			}
+		}
+		b.WriteString(literals[len(placeholders)])
+		sep := querySep
+		for i, name := range queryNames {
+			value := params[queryStart+i][index[queryStart+i]]
+			if value == "" {
+				continue
+			}
+			b.WriteString(sep)
+			b.WriteString(url.QueryEscape(name))
+			b.WriteByte('=')
+			b.WriteString(url.QueryEscape(value))
+			sep = "&"
+		}
+		urls = append(urls, b.String())
+		if len(urls) == total {
+			return urls, nil
+		}
+
+		// Advance to the next combination like an odometer
+		i := len(index) - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < len(params[i]) {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			return urls, nil
+		}
+	}
+}
+
+// TemplateURLs returns the expansion of every URL template, skipping
+// templates that fail to expand, which Validate reports
+func (c *Config) TemplateURLs() []string {
+	var urls []string
+	for i := range c.Templates {
+		expanded, err := c.Templates[i].Expand()
+		if err != nil {
+			continue
+		}
+		urls = append(urls, expanded...)
+	}
+	return urls
+}
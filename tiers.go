@@ -0,0 +1,129 @@
+package main
+
+import (
+	"time"
+)
+
+// label returns the tier's name, or its match pattern if unnamed
+func (t *TierConfig) label() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Match
+}
+
+// tier is a group of URLs warmed less often than every cycle
+type tier struct {
+	name     string
+	match    urlPattern
+	every    int
+	interval time.Duration
+}
+
+// tierSchedule decides which tiers are due each cycle
+type tierSchedule struct {
+	tiers []tier
+
+	// cycle counts the completed cycles
+	cycle int
+
+	// lastWarmed is when each interval tier was last warmed, by name
+	lastWarmed map[string]time.Time
+}
+
+// newTierSchedule compiles the configured tiers, resuming the cycle count
+// and last warm times from the state file if there is one
+func newTierSchedule(config *Config, logger *Logger) *tierSchedule {
+	schedule := &tierSchedule{lastWarmed: make(map[string]time.Time)}
+	for i := range config.Tiers {
+		t := &config.Tiers[i]
+		schedule.tiers = append(schedule.tiers, tier{
+			name:     t.label(),
+			match:    compileURLPattern(t.Match),
+			every:    t.Every,
+			interval: t.Interval,
+		})
+	}
+
+	if config.StateFile != "" {
+		state, err := loadRunState(config.StateFile)
+		if err != nil {
+			logger.Warn("Warming all tiers on the first cycle: %v", err)
+			return schedule
+		}
+		schedule.cycle = state.Cycle
+		for name, at := range state.Tiers {
+			schedule.lastWarmed[name] = at
+		}
+	}
+	return schedule
+}
+
+// due reports whether t is due this cycle. Interval tiers are also due if
+// their interval ends within half a cycle, so a 1h tier on a 5m schedule
+// isn't pushed back a full cycle by a few milliseconds of drift.
+func (s *tierSchedule) due(t *tier, now time.Time, cycleInterval time.Duration) bool {
+	if t.every > 0 {
+		return s.cycle%t.every == 0
+	}
+	last, ok := s.lastWarmed[t.name]
+	return !ok || now.Add(cycleInterval/2).Sub(last) >= t.interval
+}
+
+// scheduleTiers filters urls down to those outside any tier and those of
+// tiers due this cycle, and returns the due interval tiers
+func (cw *CacheWarmer) scheduleTiers(urls []string) ([]string, []string) {
+	now := time.Now()
+	interval := cw.CurrentConfig().Interval
+	due := make([]bool, len(cw.tiers.tiers))
+	for i := range cw.tiers.tiers {
+		due[i] = cw.tiers.due(&cw.tiers.tiers[i], now, interval)
+	}
+
+	counts := make([]int, len(cw.tiers.tiers))
+	selected := make([]string, 0, len(urls))
+	for _, u := range urls {
+		matched := -1
+		for i := range cw.tiers.tiers {
+			if cw.tiers.tiers[i].match.Match(u) {
+				matched = i
+				break
+			}
+		}
+		if matched >= 0 {
+			counts[matched]++
+			if !due[matched] {
+				continue
+			}
+		}
+		selected = append(selected, u)
+	}
+
+	var warmed []string
+	for i, t := range cw.tiers.tiers {
+		switch {
+		case due[i]:
+			cw.logger.Info("Tier %s is due, warming %d URLs", t.name, counts[i])
+			if t.interval > 0 {
+				warmed = append(warmed, t.name)
+			}
+		case t.every > 0:
+			cw.logger.Info("Tier %s not due, skipping %d URLs (due in %d cycles)",
+				t.name, counts[i], t.every-cw.tiers.cycle%t.every)
+		default:
+			next := cw.tiers.lastWarmed[t.name].Add(t.interval)
+			cw.logger.Info("Tier %s not due, skipping %d URLs (due at %s)",
+				t.name, counts[i], next.Format(time.RFC3339))
+		}
+	}
+	return selected, warmed
+}
+
+// finish records a completed cycle in which the warmed interval tiers were
+// warmed, starting at started
+func (s *tierSchedule) finish(warmed []string, started time.Time) {
+	s.cycle++
+	for _, name := range warmed {
+		s.lastWarmed[name] = started
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// loadCAPool returns the system roots with the PEM certificates in path added
+func loadCAPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// newTLSConfig returns the client TLS config for the configured TLS options,
+// or nil if none are set
+func newTLSConfig(config *Config) *tls.Config {
+	if config.TLS.CAFile == "" && !config.TLS.InsecureSkipVerify {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.TLS.InsecureSkipVerify}
+	if config.TLS.CAFile != "" {
+		// Validate guarantees the bundle loads
+		if pool, err := loadCAPool(config.TLS.CAFile); err == nil {
+			tlsConfig.RootCAs = pool
+		}
+	}
+	return tlsConfig
+}
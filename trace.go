@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer logs the same breakdown as probe for every request to URLs
+// selected with -trace-url, for debugging one page during a normal run
+// without debug logging for every other URL
+type tracer struct {
+	patterns []urlPattern
+	logger   *Logger
+
+	// until ends tracing after -trace-for, if set
+	until time.Time
+	ended sync.Once
+}
+
+// newTracer creates a tracer, or returns nil if no URLs are traced
+func newTracer(config *Config, logger *Logger) *tracer {
+	if len(config.Trace) == 0 {
+		return nil
+	}
+
+	t := &tracer{logger: logger}
+	for _, pattern := range config.Trace {
+		t.patterns = append(t.patterns, compileURLPattern(pattern))
+	}
+	if config.TraceFor > 0 {
+		t.until = time.Now().Add(config.TraceFor)
+	}
+	return t
+}
+
+// requestTrace collects the breakdown of one traced request
+type requestTrace struct {
+	tracer  *tracer
+	report  *ProbeReport
+	attempt int
+	start   time.Time
+	excerpt bytes.Buffer
+}
+
+// Start returns req instrumented for tracing and its trace if the URL is
+// traced, or req and a nil trace otherwise
+func (t *tracer) Start(req *http.Request, result *Result) (*http.Request, *requestTrace) {
+	if t == nil || !matchesAny(t.patterns, req.URL.String()) {
+		return req, nil
+	}
+	if !t.until.IsZero() && time.Now().After(t.until) {
+		t.ended.Do(func() {
+			t.logger.Info("Tracing window for -trace-url has ended")
+		})
+		return req, nil
+	}
+
+	rt := &requestTrace{
+		tracer:  t,
+		attempt: result.Attempts,
+		start:   time.Now(),
+		report: &ProbeReport{
+			URL:            req.URL.String(),
+			Region:         result.Region,
+			RequestHeaders: req.Header.Clone(),
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), probeClientTrace(rt.report, rt.start))), rt
+}
+
+// CaptureBody makes resp keep an excerpt of its body for the trace as it is
+// read
+func (rt *requestTrace) CaptureBody(resp *http.Response) {
+	if rt == nil {
+		return
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, trace: rt}
+}
+
+// tracedBody copies the start of a response body into its trace
+type tracedBody struct {
+	io.ReadCloser
+	trace *requestTrace
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.trace.report.BodyBytes += int64(n)
+	if room := probeBodyExcerpt - b.trace.excerpt.Len(); room > 0 {
+		b.trace.excerpt.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// Finish logs the breakdown of the traced request given its outcome
+func (rt *requestTrace) Finish(resp *http.Response, err error) {
+	if rt == nil {
+		return
+	}
+
+	report := rt.report
+	report.Total = time.Since(rt.start)
+	report.Success = err == nil
+	report.Err = err
+
+	if resp != nil {
+		// Walk back from the final request to list the redirect hops
+		for req := resp.Request; req.Response != nil; req = req.Response.Request {
+			report.Redirects = append([]string{req.URL.String()}, report.Redirects...)
+		}
+		report.Proto = resp.Proto
+		report.StatusCode = resp.StatusCode
+		report.ResponseHeaders = resp.Header
+		report.CacheStatus = DetectCacheStatus(resp.Header)
+		report.BodyExcerpt = rt.excerpt.String()
+	}
+
+	var b strings.Builder
+	report.Print(&b)
+	rt.tracer.logger.Info("Trace of %s (attempt %d):\n%s", report.URL, rt.attempt, strings.TrimRight(b.String(), "\n"))
+}
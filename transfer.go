@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// observeResponse adds the response size and time to first byte of a
+// result's last attempt to the run's statistics. Attempts that failed
+// before a response arrived have no time to first byte.
+func (r *warmRun) observeResponse(result *Result) {
+	atomic.AddInt64(&r.stats.BytesTransferred, result.Bytes)
+	if result.TTFB > 0 {
+		r.ttfb.Observe(result.TTFB)
+	}
+}
+
+// formatBytes returns n bytes in binary units for display
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, prefix := float64(n)/unit, 0
+	for value >= unit && prefix < len("KMGTPE")-1 {
+		value /= unit
+		prefix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[prefix])
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newHTTPClient creates an HTTP client that applies the configured timeout and
+// redirect policy on top of the given transport (nil uses the default)
+func newHTTPClient(config *Config, transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// Control redirect behavior
+			if !config.FollowRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= config.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", config.MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// newBaseTransport returns the transport used for direct warming: the one
+// supplied by embedding code, or one with the configured origin options that
+// connects through the Unix socket if set
+func newBaseTransport(config *Config) http.RoundTripper {
+	if config.Transport != nil {
+		return config.Transport
+	}
+
+	transport := newOriginTransport(config)
+	if config.UnixSocket != "" {
+		transport.Proxy = nil
+		transport.DialContext = unixSocketDialer(config.UnixSocket)
+	}
+	return transport
+}
+
+// newOriginTransport creates a transport with the configured TLS, HTTP/2,
+// connection pool, forward proxy, DNS, connect_to and address family options. Without proxy_url, HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY are honored.
+func newOriginTransport(config *Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = newTLSConfig(config)
+	setProtocols(transport, config.HTTP2)
+	setConnectionPool(transport, config)
+
+	if config.ProxyURL != "" {
+		// Validate guarantees the proxy URL parses
+		if proxyURL, err := url.Parse(config.ProxyURL); err == nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	if len(config.ProxyPool.Proxies) > 0 {
+		transport.Proxy = newProxyPool(&config.ProxyPool).Proxy
+	}
+	if config.DNS.configured() {
+		transport.DialContext = newHostResolver(&config.DNS).DialContext(&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		})
+	}
+	if len(config.ConnectTo) > 0 {
+		transport.DialContext = resolvingDialer(config.ConnectTo, transport.DialContext)
+	}
+	transport.DialContext = familyDialer(config.IPFamily, transport.DialContext)
+	transport.OnProxyConnectResponse = checkProxyConnect
+	return transport
+}
+
+// checkProxyConnect turns a proxy's refusal to CONNECT into an error
+// classified as a proxy failure
+func checkProxyConnect(ctx context.Context, proxyURL *url.URL, req *http.Request, resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		return &proxyConnectError{Proxy: proxyURL.Redacted(), Status: resp.Status}
+	}
+	return nil
+}
+
+// setProtocols restricts transport to the protocols of an http2 mode; auto
+// leaves the default negotiation
+func setProtocols(transport *http.Transport, mode string) {
+	var protocols http.Protocols
+	switch mode {
+	case HTTP2Force:
+		protocols.SetHTTP2(true)
+	case HTTP2Disable:
+		protocols.SetHTTP1(true)
+	case HTTP2H2C:
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return
+	}
+	transport.Protocols = &protocols
+}
+
+// setConnectionPool applies the connection_pool settings to transport. Idle
+// connections per host default to the number of workers rather than 2, so
+// workers warming one host keep reusing their connections.
+func setConnectionPool(transport *http.Transport, config *Config) {
+	pool := &config.ConnectionPool
+	if pool.MaxIdleConns > 0 {
+		transport.MaxIdleConns = pool.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = config.Workers
+	if pool.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	if pool.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = pool.IdleConnTimeout
+	}
+	transport.DisableKeepAlives = pool.DisableKeepAlives
+}
+
+// unixSocketDialer returns a DialContext that connects every request to the
+// given Unix domain socket, regardless of the URL's host
+func unixSocketDialer(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}
+}
+
+// newRegionTransport creates a transport that egresses through a region's
+// proxy (or the global one) and resolves hosts using its static overrides,
+// over its own address family if set
+func newRegionTransport(config *Config, rc *RegionConfig) *http.Transport {
+	if rc.IPFamily != "" && rc.IPFamily != config.IPFamily {
+		regionConfig := *config
+		regionConfig.IPFamily = rc.IPFamily
+		config = &regionConfig
+	}
+	transport := newOriginTransport(config)
+
+	if rc.Proxy != "" {
+		// Validate guarantees the proxy URL parses
+		if proxyURL, err := url.Parse(rc.Proxy); err == nil {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+
+	// A region with an address connects to it directly, without any proxy
+	overrides := rc.Resolve
+	if rc.Address != "" {
+		transport.Proxy = nil
+		overrides = map[string]string{"*": rc.Address}
+		for from, to := range rc.Resolve {
+			overrides[from] = to
+		}
+	}
+	if len(overrides) > 0 {
+		transport.DialContext = resolvingDialer(overrides, transport.DialContext)
+	}
+
+	return transport
+}
+
+// resolvingDialer returns a DialContext that connects to a static address for
+// overridden hosts while leaving Host and SNI untouched, and dials everything
+// else with dial. A host:port override wins over one for the host, which
+// wins over a "*" override for every host; an override without a port keeps
+// the URL's.
+func resolvingDialer(overrides map[string]string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	lookup := make(map[string]string, len(overrides))
+	for from, to := range overrides {
+		lookup[strings.ToLower(from)] = to
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		to, ok := lookup[strings.ToLower(addr)]
+		if !ok {
+			to, ok = lookup[strings.ToLower(host)]
+		}
+		if !ok {
+			to, ok = lookup["*"]
+		}
+		if ok {
+			addr = to
+			if _, _, err := net.SplitHostPort(to); err != nil {
+				addr = net.JoinHostPort(to, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// familyDialer returns a DialContext that dials with dial over the address
+// family of an ip_family mode only; dual returns dial unchanged
+func familyDialer(family string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var suffix string
+	switch family {
+	case IPFamilyIPv4:
+		suffix = "4"
+	case IPFamilyIPv6:
+		suffix = "6"
+	default:
+		return dial
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network += suffix
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// addressFamily returns the ip_family of a connection's address, empty if it
+// is not an IP address
+func addressFamily(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	switch {
+	case !ok:
+		return ""
+	case tcpAddr.IP.To4() != nil:
+		return IPFamilyIPv4
+	default:
+		return IPFamilyIPv6
+	}
+}
+
+// overrideHost returns the host of a connection override key
+func overrideHost(from string) string {
+	if host, _, err := net.SplitHostPort(from); err == nil {
+		from = host
+	}
+	return strings.ToLower(from)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// effectiveTTL returns how much longer a shared cache may serve the response
+// without revalidating, from Cache-Control (s-maxage over max-age) or
+// Expires, minus Age. ok is false if the response declares no lifetime.
+func effectiveTTL(header http.Header) (ttl time.Duration, ok bool) {
+	maxAge, sMaxAge := -1, -1
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch name {
+		case "no-store", "no-cache", "private":
+			return 0, true
+		case "max-age":
+			maxAge = parseSeconds(value)
+		case "s-maxage":
+			sMaxAge = parseSeconds(value)
+		}
+	}
+
+	switch {
+	case sMaxAge >= 0:
+		ttl = time.Duration(sMaxAge) * time.Second
+	case maxAge >= 0:
+		ttl = time.Duration(maxAge) * time.Second
+	case header.Get("Expires") != "":
+		expires, err := http.ParseTime(header.Get("Expires"))
+		if err != nil {
+			// Invalid dates such as "0" mean already expired
+			return 0, true
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		ttl = expires.Sub(date)
+	default:
+		return 0, false
+	}
+
+	if age := parseSeconds(header.Get("Age")); age > 0 {
+		ttl -= time.Duration(age) * time.Second
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
+}
+
+// parseSeconds parses a delta-seconds value, returning -1 if invalid
+func parseSeconds(value string) int {
+	seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || seconds < 0 {
+		return -1
+	}
+	return seconds
+}
+
+// TTLPrefixSummary aggregates the TTLs of the URLs under one path prefix
+type TTLPrefixSummary struct {
+	Prefix        string `json:"prefix"`
+	URLs          int    `json:"urls"`
+	MinTTLSeconds int64  `json:"min_ttl_seconds"`
+	MaxTTLSeconds int64  `json:"max_ttl_seconds"`
+	UnknownTTL    int    `json:"unknown_ttl"`
+	ShortTTL      int    `json:"short_ttl"`
+}
+
+// ShortTTLURL is a URL whose cached copy expires before the next cycle
+type ShortTTLURL struct {
+	URL        string `json:"url"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+}
+
+// TTLInventory is the per-cycle report of effective TTLs
+type TTLInventory struct {
+	ThresholdSeconds int64              `json:"threshold_seconds,omitempty"`
+	Prefixes         []TTLPrefixSummary `json:"prefixes"`
+	ShortTTL         []ShortTTLURL      `json:"short_ttl_urls,omitempty"`
+}
+
+// ttlThreshold returns the TTL below which URLs are flagged: min_ttl if set,
+// otherwise the interval between cycles (0 = don't flag)
+func (cw *CacheWarmer) ttlThreshold() time.Duration {
+	if cw.config.TTLReport.MinTTL > 0 {
+		return cw.config.TTLReport.MinTTL
+	}
+	return cw.CurrentConfig().Interval
+}
+
+// GetTTLInventory groups the effective TTLs of the successful responses
+// among results by path prefix. A URL warmed through several regions counts
+// once, with its shortest TTL.
+func (cw *CacheWarmer) GetTTLInventory(results []Result) TTLInventory {
+	type urlTTL struct {
+		ttl   time.Duration
+		known bool
+	}
+
+	ttls := make(map[string]urlTTL)
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		current, seen := ttls[result.URL]
+		if !seen || !current.known || (result.TTLKnown && result.TTL < current.ttl) {
+			ttls[result.URL] = urlTTL{result.TTL, result.TTLKnown}
+		}
+	}
+
+	threshold := cw.ttlThreshold()
+	inventory := TTLInventory{ThresholdSeconds: int64(threshold / time.Second)}
+	index := make(map[string]int)
+	for rawURL, t := range ttls {
+		prefix := pathPrefix(rawURL, cw.config.TTLReport.PrefixDepth)
+		i, ok := index[prefix]
+		if !ok {
+			i = len(inventory.Prefixes)
+			index[prefix] = i
+			inventory.Prefixes = append(inventory.Prefixes, TTLPrefixSummary{Prefix: prefix, MinTTLSeconds: -1})
+		}
+		s := &inventory.Prefixes[i]
+		s.URLs++
+
+		if !t.known {
+			s.UnknownTTL++
+			continue
+		}
+		seconds := int64(t.ttl / time.Second)
+		if s.MinTTLSeconds < 0 || seconds < s.MinTTLSeconds {
+			s.MinTTLSeconds = seconds
+		}
+		if seconds > s.MaxTTLSeconds {
+			s.MaxTTLSeconds = seconds
+		}
+		if threshold > 0 && t.ttl < threshold {
+			s.ShortTTL++
+			inventory.ShortTTL = append(inventory.ShortTTL, ShortTTLURL{URL: rawURL, TTLSeconds: seconds})
+		}
+	}
+
+	for i := range inventory.Prefixes {
+		if inventory.Prefixes[i].MinTTLSeconds < 0 {
+			inventory.Prefixes[i].MinTTLSeconds = 0
+		}
+	}
+	sort.Slice(inventory.Prefixes, func(i, j int) bool {
+		return inventory.Prefixes[i].Prefix < inventory.Prefixes[j].Prefix
+	})
+	sort.Slice(inventory.ShortTTL, func(i, j int) bool {
+		if inventory.ShortTTL[i].TTLSeconds != inventory.ShortTTL[j].TTLSeconds {
+			return inventory.ShortTTL[i].TTLSeconds < inventory.ShortTTL[j].TTLSeconds
+		}
+		return inventory.ShortTTL[i].URL < inventory.ShortTTL[j].URL
+	})
+	return inventory
+}
+
+// pathPrefix returns the first depth directories of a URL's path, e.g.
+// /blog/ for /blog/2024/post at depth 1. Pages directly under a shorter
+// prefix are grouped with it.
+func pathPrefix(rawURL string, depth int) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "/"
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	dirs := segments[:len(segments)-1]
+	if strings.HasSuffix(u.Path, "/") && u.Path != "/" {
+		dirs = segments
+	}
+	if len(dirs) > depth {
+		dirs = dirs[:depth]
+	}
+	if len(dirs) == 0 {
+		return "/"
+	}
+	return "/" + strings.Join(dirs, "/") + "/"
+}
+
+// printTTLInventory prints TTLs per path prefix and warns about URLs that
+// expire before the next cycle
+func (cw *CacheWarmer) printTTLInventory(results []Result) {
+	inventory := cw.GetTTLInventory(results)
+
+	cw.logger.Info("  TTL inventory:")
+	cw.logger.Info("    %-32s %6s %10s %10s %8s %6s", "PREFIX", "URLS", "MIN TTL", "MAX TTL", "UNKNOWN", "SHORT")
+	for _, s := range inventory.Prefixes {
+		cw.logger.Info("    %-32s %6d %10v %10v %8d %6d", s.Prefix, s.URLs,
+			time.Duration(s.MinTTLSeconds)*time.Second, time.Duration(s.MaxTTLSeconds)*time.Second, s.UnknownTTL, s.ShortTTL)
+	}
+
+	if len(inventory.ShortTTL) > 0 {
+		cw.logger.Warn("%d URLs have a TTL shorter than %v and will be cold between cycles",
+			len(inventory.ShortTTL), cw.ttlThreshold())
+		for _, u := range inventory.ShortTTL {
+			cw.logger.Debug("    %s: %v", u.URL, time.Duration(u.TTLSeconds)*time.Second)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlSchedule tracks when the cached copy of each URL expires, so URLs are
+// only warmed in the last cycle before they go stale
+type ttlSchedule struct {
+	lead        time.Duration
+	maxInterval time.Duration
+
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+// newTTLSchedule creates the schedule, resuming the expiry times from the
+// state file if there is one
+func newTTLSchedule(config *Config, logger *Logger) *ttlSchedule {
+	schedule := &ttlSchedule{
+		lead:        config.TTLSchedule.Lead,
+		maxInterval: config.TTLSchedule.MaxInterval,
+		expires:     make(map[string]time.Time),
+	}
+
+	if config.StateFile != "" {
+		state, err := loadRunState(config.StateFile)
+		if err != nil {
+			logger.Warn("Warming all URLs on the first cycle: %v", err)
+			return schedule
+		}
+		for url, at := range state.Expires {
+			schedule.expires[url] = at
+		}
+	}
+	return schedule
+}
+
+// due reports whether url must be warmed this cycle: it has no known expiry,
+// or will be within lead of it by the next cycle
+func (s *ttlSchedule) due(url string, now time.Time, cycleInterval time.Duration) bool {
+	expires, ok := s.expires[url]
+	return !ok || !now.Add(cycleInterval).Before(expires.Add(-s.lead))
+}
+
+// scheduleTTLs filters urls down to those due before their cached copy
+// expires
+func (cw *CacheWarmer) scheduleTTLs(urls []string) []string {
+	s := cw.ttlSchedule
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	interval := cw.CurrentConfig().Interval
+	var next time.Time
+	selected := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if s.due(url, now, interval) {
+			selected = append(selected, url)
+			continue
+		}
+		if at := s.expires[url].Add(-s.lead); next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+
+	if skipped := len(urls) - len(selected); skipped > 0 {
+		cw.logger.Info("Skipping %d URLs still cached past the next cycle (next due at %s)",
+			skipped, next.Format(time.RFC3339))
+	}
+	return selected
+}
+
+// update records when the URLs warmed in results expire, counting from
+// started, and forgets expiry times that have passed. A URL warmed through
+// several regions or devices expires with its shortest TTL; one that failed
+// or had no TTL anywhere is warmed again next cycle.
+func (s *ttlSchedule) update(results []Result, started time.Time) {
+	ttls := make(map[string]time.Duration)
+	unknown := make(map[string]bool)
+	for _, result := range results {
+		if !result.Success || !result.TTLKnown {
+			unknown[result.URL] = true
+			continue
+		}
+		ttl := result.TTL
+		if ttl > s.maxInterval {
+			ttl = s.maxInterval
+		}
+		if current, seen := ttls[result.URL]; !seen || ttl < current {
+			ttls[result.URL] = ttl
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for url, at := range s.expires {
+		if at.Before(started) {
+			delete(s.expires, url)
+		}
+	}
+	for url := range unknown {
+		delete(s.expires, url)
+	}
+	for url, ttl := range ttls {
+		if !unknown[url] {
+			s.expires[url] = started.Add(ttl)
+		}
+	}
+}
+
+// snapshot returns a copy of the expiry times for the state file
+func (s *ttlSchedule) snapshot() map[string]time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expires := make(map[string]time.Time, len(s.expires))
+	for url, at := range s.expires {
+		expires[url] = at
+	}
+	return expires
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxURLListSize caps the size of a remote URL list
+const maxURLListSize = 50 << 20
+
+// remoteURLList caches the last remote URL list fetched, with the validators
+// used to revalidate it
+type remoteURLList struct {
+	mutex        sync.Mutex
+	urls         []string
+	etag         string
+	lastModified string
+	fetched      bool
+}
+
+// fetchURLList returns the remote URL list, revalidating the cached copy
+// with If-None-Match/If-Modified-Since. The cached list is kept if the
+// endpoint is unavailable. The returned bool is false if it was unchanged.
+func (cw *CacheWarmer) fetchURLList(ctx context.Context) ([]string, bool, error) {
+	return cw.fetchRemoteList(ctx, &cw.CurrentConfig().RemoteList, &cw.urlList)
+}
+
+// fetchRemoteList fetches the URL list of config into its cache, list
+func (cw *CacheWarmer) fetchRemoteList(ctx context.Context, config *RemoteListConfig, list *remoteURLList) ([]string, bool, error) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", config.URL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("User-Agent", cw.config.UserAgent)
+	req.Header.Set("Accept", "application/json, text/plain;q=0.9")
+	for key, value := range config.Headers {
+		req.Header.Set(key, value)
+	}
+	if list.fetched {
+		if list.etag != "" {
+			req.Header.Set("If-None-Match", list.etag)
+		}
+		if list.lastModified != "" {
+			req.Header.Set("If-Modified-Since", list.lastModified)
+		}
+	}
+
+	resp, err := cw.client.Do(req)
+	if err != nil {
+		return list.cached(fmt.Errorf("failed to fetch URL list: %v", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && list.fetched {
+		return list.urls, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return list.cached(fmt.Errorf("failed to fetch URL list: status code %d", resp.StatusCode))
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, maxURLListSize+1))
+	if err != nil {
+		return list.cached(fmt.Errorf("failed to read URL list: %v", err))
+	}
+	if n > maxURLListSize {
+		return list.cached(fmt.Errorf("URL list exceeds %d bytes", maxURLListSize))
+	}
+
+	urls, err := parseURLList(buf.Bytes(), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return list.cached(err)
+	}
+
+	valid := urls[:0]
+	for _, u := range urls {
+		if err := ValidateURL(u); err != nil {
+			cw.logger.Warn("Ignoring invalid URL %q from URL list: %v", u, err)
+			continue
+		}
+		valid = append(valid, u)
+	}
+
+	list.urls = valid
+	list.etag = resp.Header.Get("ETag")
+	list.lastModified = resp.Header.Get("Last-Modified")
+	list.fetched = true
+	return list.urls, true, nil
+}
+
+// cached returns the last fetched list along with err, or just err if
+// nothing was fetched yet
+func (list *remoteURLList) cached(err error) ([]string, bool, error) {
+	if !list.fetched {
+		return nil, false, err
+	}
+	return list.urls, false, fmt.Errorf("%v (using %d cached URLs)", err, len(list.urls))
+}
+
+// parseURLList parses a JSON array of URLs (strings or objects with a "url"
+// field), a JSON object with a "urls" array, or newline-separated text where
+// blank lines and # comments are skipped
+func parseURLList(data []byte, contentType string) ([]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if strings.Contains(contentType, "json") || bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		var doc struct {
+			URLs []json.RawMessage `json:"urls"`
+		}
+		var err error
+		if bytes.HasPrefix(trimmed, []byte("[")) {
+			err = json.Unmarshal(trimmed, &doc.URLs)
+		} else {
+			err = json.Unmarshal(trimmed, &doc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse URL list: %v", err)
+		}
+
+		urls := make([]string, 0, len(doc.URLs))
+		for _, raw := range doc.URLs {
+			var entry struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(raw, &entry.URL); err != nil {
+				if err := json.Unmarshal(raw, &entry); err != nil {
+					return nil, fmt.Errorf("failed to parse URL list entry %s: %v", raw, err)
+				}
+			}
+			if u := strings.TrimSpace(entry.URL); u != "" {
+				urls = append(urls, u)
+			}
+		}
+		return urls, nil
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse URL list: %v", err)
+	}
+	return urls, nil
+}
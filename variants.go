@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// expandVariants returns urls, each followed by its scheme and www variants.
+// URLs with an explicit port keep their scheme, since the port belongs to
+// it, and GraphQL queries are left alone.
+func (cw *CacheWarmer) expandVariants(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	expanded := make([]string, 0, len(urls))
+	add := func(u string) {
+		if !seen[u] {
+			seen[u] = true
+			expanded = append(expanded, u)
+		}
+	}
+
+	for _, u := range urls {
+		add(u)
+		if cw.graphQL[u] != nil {
+			continue
+		}
+		for _, variant := range urlVariants(&cw.config.Variants, u) {
+			add(variant)
+		}
+	}
+	return expanded
+}
+
+// urlVariants returns the other scheme and host forms of rawURL
+func urlVariants(config *VariantsConfig, rawURL string) []string {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return nil
+	}
+	host := strings.ToLower(parsedURL.Hostname())
+	if len(config.Hosts) > 0 && !containsFold(config.Hosts, host) && !containsFold(config.Hosts, wwwVariant(host, true)) {
+		return nil
+	}
+
+	hosts := []string{parsedURL.Host}
+	if config.WWW {
+		if other := wwwVariant(host, len(config.Hosts) > 0); other != "" {
+			if port := parsedURL.Port(); port != "" {
+				other = net.JoinHostPort(other, port)
+			}
+			hosts = append(hosts, other)
+		}
+	}
+
+	schemes := []string{parsedURL.Scheme}
+	if parsedURL.Port() == "" {
+		schemes = append(schemes, config.Schemes...)
+	}
+
+	var variants []string
+	for _, h := range hosts {
+		for _, scheme := range schemes {
+			variant := *parsedURL
+			variant.Scheme = scheme
+			variant.Host = h
+			if s := variant.String(); s != rawURL {
+				variants = append(variants, s)
+			}
+		}
+	}
+	return variants
+}
+
+// wwwVariant returns the bare domain of a www host, or the www host of a
+// bare domain. Unless the host is listed, only names with a single dot
+// count as bare domains, so subdomains such as api.example.com aren't given
+// a www form.
+func wwwVariant(host string, listed bool) string {
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return ""
+	}
+	if bare := strings.TrimPrefix(host, "www."); bare != host {
+		return bare
+	}
+	if !listed && strings.Count(host, ".") != 1 {
+		return ""
+	}
+	return "www." + host
+}
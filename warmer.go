@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptrace"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,14 +19,219 @@ type CacheWarmer struct {
 	logger  *Logger
 	client  *http.Client
 	metrics *Metrics
+	statsd  *statsdClient
+	webhook *WebhookServer
+	grpc    *GRPCServer
+	queue   *RedisQueue
+	elector *leaderElector
+	budget  *RateLimitBudget
+	history *History
+
+	// Validators of the last full responses, if conditional requests are on
+	validators *ValidatorStore
+
+	// Persistently failing URLs that are not warmed every cycle
+	skipList *SkipList
+
+	// Object store run artifacts are uploaded to
+	objectStore ObjectStore
+
+	// Merges the cycle summaries pushed by the fleet, on the aggregator
+	fleet *fleetAggregator
+
+	// Success expressions for groups of URLs, tried in order
+	successRules []successRule
+
+	// Expected language and charset of locale variants, tried in order
+	localeChecks []localeCheck
+
+	// Byte ranges large files are warmed by, tried in order
+	byteRanges []byteRangeRule
+
+	// Access tokens for the GA4 Data API, if analytics is a URL source
+	analyticsTokens *googleTokenSource
+
+	// Credentials from the authentication flow, if configured
+	auth *authSession
+
+	// Frequency tiers of URLs not warmed every cycle
+	tiers *tierSchedule
+
+	// Expiry times of cached URLs, if URLs are warmed by their TTL
+	ttlSchedule *ttlSchedule
+
+	// Last remote URL list fetched, revalidated every cycle
+	urlList remoteURLList
+
+	// URL groups warmed on their own schedules
+	groups []*urlGroup
+
+	// Sitemap <priority> of the URLs, if ordered by it
+	sitemapPriorities sitemapPriorities
 
 	// Shutdown coordination
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 
-	// Statistics
-	stats Statistics
+	// Egress regions each URL is warmed through
+	regions []*region
+
+	// Origin shield URLs are warmed through before the regions, if any
+	shield *region
+
+	// The most recently started run, whose statistics and results the
+	// control APIs report
+	lastRun atomic.Pointer[warmRun]
+
+	// Set to 1, and readyChan closed, once the initial warm has completed
+	ready     int32
+	readyChan chan struct{}
+
+	// Runs in progress, and the URL changes and cycle runner of the
+	// control APIs
+	activeRuns   int64
+	runtime      runtimeURLs
+	runner       *cycleRunner
+	controlMutex sync.Mutex
+
+	// Holds the workers while warming is paused through the admin API
+	pause pauseGate
+
+	// Reloaded configuration waiting for the next cycle boundary, and the
+	// settings of the one last applied
+	reload  configReload
+	current atomic.Pointer[reloadable]
+
+	// URLs that failed in the last completed cycle, as served on the
+	// failures endpoint
+	failures      []byte
+	failuresMutex sync.Mutex
+
+	// Requests currently in flight, keyed by region and URL
+	inflight      map[string]*inflightCall
+	inflightMutex sync.Mutex
+
+	// Callbacks registered by embedding code
+	hooks hooks
+
+	// Worker pool and queue gauges
+	scheduler scheduler
+
+	// In-flight and heap limits on new requests
+	admission *admission
+
+	// Global request rate limit, if configured
+	throttle *tokenBucket
+
+	// Serializes requests for URL variants of the same origin resource
+	coalescer *coalescer
+
+	// Device profiles every URL is warmed as, by name
+	devices map[string]*DeviceConfig
+
+	// Resolves hosts for all_addresses with the dns options
+	resolver *hostResolver
+
+	// Hosts the probe endpoint accepts targets on
+	probeHosts map[string]bool
+
+	// Dumps requests for URLs selected with -trace-url
+	tracer *tracer
+
+	// GraphQL queries to warm, and the same keyed by their warm URL
+	graphQLQueries []*graphQLQuery
+	graphQL        map[string]*graphQLQuery
+}
+
+// inflightCall is a warm request other workers can wait on instead of
+// issuing an identical one
+type inflightCall struct {
+	done   chan struct{}
+	result Result
+	ok     bool
+}
+
+// Result is the outcome of warming one URL through one region
+type Result struct {
+	URL         string
+	Region      string
+	Address     string
+	Family      string
+	Device      string
+	Range       string
+	StatusCode  int
+	CacheStatus string
+	POP         string
+	Attempts    int
+	Duration    time.Duration
+	Success     bool
+	Err         error
+
+	// Bytes is the size of the response body read in the last attempt, and
+	// TTFB the time from sending it to the first response byte
+	Bytes int64
+	TTFB  time.Duration
+
+	// Coalesced is true if the outcome was shared from an identical
+	// in-flight request instead of being requested again
+	Coalesced bool
+
+	// Verified is true if a shield result that was not a HIT was confirmed
+	// cached by a re-request before the edge stage
+	Verified bool
+
+	// Critical is true if the URL is marked critical in the config, and
+	// Priority is its priority in the run
+	Critical bool
+	Priority int
+
+	// TTL is the response's remaining cache lifetime; TTLKnown is false if
+	// it declared none
+	TTL      time.Duration
+	TTLKnown bool
+
+	// Headers holds the response headers listed in capture_headers
+	Headers map[string]string
+
+	// Shadow is the outcome of mirroring the request to the shadow host
+	Shadow *ShadowResult
+
+	// latency is the response time of the last attempt, and contentHash
+	// its body hash if shadow content is compared
+	latency     time.Duration
+	contentHash string
+
+	// timing breaks the connection setup down if slow requests are reported
+	timing requestTiming
+}
+
+// warmJob is a single unit of work handed to a worker
+type warmJob struct {
+	url    string
+	region *region
+
+	// addr pins the request to one resolved address of the host
+	addr string
+
+	// device is the device profile the URL is warmed as, if any
+	device string
+
+	// byteRange is the byte range requested, if not the whole response
+	byteRange string
+
+	// group is the URL group the job belongs to, if any
+	group string
+}
+
+// key identifies the request a job makes, for coalescing and checkpoints
+func (j warmJob) key() string {
+	key := j.region.name + "|" + j.addr + "|" + j.device + "|" + j.byteRange + "|" + j.url
+	if j.group != "" {
+		// Groups send their own headers
+		key = j.group + "|" + key
+	}
+	return key
 }
 
 // Statistics holds runtime statistics for the cache warmer
@@ -32,6 +241,50 @@ type Statistics struct {
 	FailedRequests  int64
 	TotalDuration   int64 // in nanoseconds
 	StartTime       time.Time
+	RunID           string
+
+	// DuplicatesSkipped counts repeated URLs dropped by the scheduler
+	DuplicatesSkipped int64
+
+	// CoalescedRequests counts URLs that shared an identical in-flight request
+	CoalescedRequests int64
+
+	// SkippedURLs counts URLs left out because they are on the skip list
+	SkippedURLs int64
+
+	// CrawledURLs counts pages discovered by crawling and warmed
+	CrawledURLs int64
+
+	// AssetURLs counts page assets discovered and warmed
+	AssetURLs int64
+
+	// HaltedWave is the wave whose checks failed, stopping the run (0 = none)
+	HaltedWave int64
+
+	// ProxyErrors counts failed URLs the forward proxy couldn't be reached
+	// for or refused
+	ProxyErrors int64
+
+	// IPv4Requests and IPv6Requests count URLs warmed over each address
+	// family
+	IPv4Requests int64
+	IPv6Requests int64
+
+	// NotModified counts URLs revalidated with a 304 Not Modified
+	NotModified int64
+
+	// Retries counts retried attempts, and RetriesDenied the retries the
+	// retry budget didn't allow
+	Retries       int64
+	RetriesDenied int64
+
+	// Latency holds the request duration percentiles of the run
+	Latency LatencyPercentiles
+
+	// TTFB holds the time to first byte percentiles of the run, and
+	// BytesTransferred the response body bytes read
+	TTFB             LatencyPercentiles
+	BytesTransferred int64
 }
 
 // NewCacheWarmer creates a new cache warmer instance
@@ -40,18 +293,42 @@ func NewCacheWarmer(config *Config, logger *Logger) *CacheWarmer {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Configure HTTP client
-	client := &http.Client{
-		Timeout: config.Timeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Control redirect behavior
-			if !config.FollowRedirects {
-				return http.ErrUseLastResponse
-			}
-			if len(via) >= config.MaxRedirects {
-				return fmt.Errorf("stopped after %d redirects", config.MaxRedirects)
-			}
-			return nil
-		},
+	if config.TLS.InsecureSkipVerify {
+		logger.Warn("TLS certificate verification is disabled (insecure_skip_verify)")
+	}
+	base := newBaseTransport(config)
+	client := newHTTPClient(config, base)
+
+	// Build one client per egress region, or warm directly
+	regions := []*region{{
+		client:    client,
+		transport: pinnableTransport(base),
+		overrides: overriddenHosts(config.ConnectTo),
+		family:    config.IPFamily,
+	}}
+	if len(config.Regions) > 0 {
+		regions = make([]*region, 0, len(config.Regions))
+		for i := range config.Regions {
+			regions = append(regions, newRegion(config, &config.Regions[i]))
+		}
+	}
+
+	// Warm through the origin shield before the edge if configured
+	var shield *region
+	if config.Shield.Enabled {
+		shield = newRegion(config, &config.Shield.RegionConfig)
+	}
+
+	// Replay cookies set by responses on later requests if configured
+	if config.Cookies.Enabled {
+		jar := newCookieJar(&config.Cookies)
+		client.Jar = jar
+		for _, r := range regions {
+			r.client.Jar = jar
+		}
+		if shield != nil {
+			shield.client.Jar = jar
+		}
 	}
 
 	// Initialize metrics if enabled
@@ -60,49 +337,633 @@ func NewCacheWarmer(config *Config, logger *Logger) *CacheWarmer {
 		metrics = NewMetrics(config.Metrics.Port, config.Metrics.Path, logger)
 	}
 
-	return &CacheWarmer{
-		config:  config,
-		logger:  logger,
-		client:  client,
-		metrics: metrics,
-		ctx:     ctx,
-		cancel:  cancel,
-		stats: Statistics{
-			StartTime: time.Now(),
-		},
+	// Push metrics to a StatsD agent if configured
+	statsd, err := newStatsDClient(&config.StatsD, logger)
+	if err != nil {
+		logger.Error("StatsD export disabled: %v", err)
+	}
+
+	// Track API rate-limit budgets if enabled
+	var budget *RateLimitBudget
+	if config.RateLimitBudget.Enabled {
+		budget = NewRateLimitBudget(&config.RateLimitBudget, logger)
+	}
+
+	// Load per-URL history if configured
+	var history *History
+	if config.HistoryFile != "" {
+		var err error
+		history, err = LoadHistory(config.HistoryFile)
+		if err != nil {
+			logger.Warn("Starting with empty history: %v", err)
+			history = newHistory(config.HistoryFile)
+		}
+	}
+
+	// Load the validators of earlier responses for conditional requests
+	var validators *ValidatorStore
+	if config.Conditional.Enabled {
+		validators = newValidatorStore(config.Conditional.File)
+		if config.Conditional.File != "" {
+			var err error
+			validators, err = LoadValidatorStore(config.Conditional.File)
+			if err != nil {
+				logger.Warn("Starting without stored validators: %v", err)
+				validators = newValidatorStore(config.Conditional.File)
+			}
+		}
+	}
+
+	// Load the skip list if configured
+	var skipList *SkipList
+	if config.SkipList.File != "" {
+		var err error
+		skipList, err = LoadSkipList(&config.SkipList)
+		if err != nil {
+			logger.Warn("Starting with empty skip list: %v", err)
+			skipList = newSkipList(&config.SkipList)
+		}
+	}
+
+	cw := &CacheWarmer{
+		config:     config,
+		logger:     logger,
+		client:     client,
+		metrics:    metrics,
+		statsd:     statsd,
+		budget:     budget,
+		history:    history,
+		validators: validators,
+		skipList:   skipList,
+		readyChan:  make(chan struct{}),
+		regions:    regions,
+		shield:     shield,
+		inflight:   make(map[string]*inflightCall),
+		admission:  newAdmission(&config.Admission, logger),
+		throttle:   newTokenBucket(&config.RateLimit),
+		coalescer:  newCoalescer(&config.Coalescing),
+		groups:     newURLGroups(config),
+		devices:    deviceSet(config),
+		resolver:   newHostResolver(&config.DNS),
+		tracer:     newTracer(config, logger),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
+	cw.lastRun.Store(&warmRun{stats: Statistics{StartTime: time.Now()}})
+	cw.current.Store(newReloadable(config))
+
+	// Set up artifact uploads if configured
+	if config.Artifacts.Upload != "" {
+		store, err := NewObjectStore(config.Artifacts.Upload, config.Artifacts.Endpoint, config.Artifacts.Region)
+		if err != nil {
+			logger.Error("Artifact uploads disabled: %v", err)
+		}
+		cw.objectStore = store
+	}
+
+	// Compile success expressions if configured
+	if len(config.SuccessRules) > 0 {
+		rules, err := compileSuccessRules(config.SuccessRules)
+		if err != nil {
+			logger.Error("Success rules disabled: %v", err)
+		}
+		cw.successRules = rules
+	}
+
+	// Check the language and charset of locale variants if configured
+	if len(config.LocaleChecks) > 0 {
+		cw.localeChecks = compileLocaleChecks(config.LocaleChecks)
+	}
+
+	// Warm large files by byte range if configured
+	if len(config.ByteRanges) > 0 {
+		cw.byteRanges = compileByteRanges(config.ByteRanges)
+	}
+
+	// Log in before the first request if configured
+	if config.Auth.Type != "" {
+		cw.auth = newAuthSession(config, logger)
+	}
+
+	// Schedule frequency tiers if configured
+	if len(config.Tiers) > 0 {
+		cw.tiers = newTierSchedule(config, logger)
+	}
+
+	// Warm URLs by their TTL if configured
+	if config.TTLSchedule.Enabled {
+		cw.ttlSchedule = newTTLSchedule(config, logger)
+	}
+
+	// Load GraphQL queries if configured
+	if config.GraphQL.Endpoint != "" {
+		queries, err := loadGraphQLQueries(&config.GraphQL)
+		if err != nil {
+			logger.Error("GraphQL warming disabled: %v", err)
+		}
+		cw.graphQLQueries = queries
+		cw.graphQL = make(map[string]*graphQLQuery, len(queries))
+		for _, q := range queries {
+			cw.graphQL[q.url] = q
+		}
+	}
+
+	// Pull top pages from Google Analytics if configured
+	if config.GoogleAnalytics.PropertyID != "" {
+		cw.analyticsTokens = newGoogleTokenSource(analyticsScope)
+	}
+
+	// Send every result and run summary to the StatsD agent
+	if statsd != nil {
+		cw.OnResult(statsd.RecordResult)
+		cw.OnComplete(statsd.RecordRun)
+	}
+
+	// Report scheduler gauges on the metrics endpoint
+	if metrics != nil {
+		metrics.SetSchedulerSource(cw.SchedulerStats)
+	}
+
+	// Serve the last cycle's failed URLs for re-running with -urls-file
+	if metrics != nil {
+		metrics.HandleFunc(FailuresPath, cw.failuresHandler)
+	}
+
+	// Answer blackbox probes on the metrics server if enabled
+	if config.ProbeEndpoint.Enabled && metrics != nil {
+		cw.probeHosts = probeHosts(config)
+		metrics.HandleFunc(ProbePath, cw.probeHandler)
+	}
+
+	// Serve the admin API on the metrics server if enabled
+	if config.Admin.Enabled && metrics != nil {
+		cw.registerAdminHandlers(metrics)
+	}
+
+	// Serve the fleet endpoint on the metrics server if this is the aggregator
+	if config.Fleet.Aggregate && metrics != nil {
+		cw.fleet = newFleetAggregator(&config.Fleet, logger)
+		metrics.HandleFunc(FleetPath, cw.fleet.handler)
+		metrics.SetFleetSource(cw.fleet.Report)
+	}
+
+	// Start webhook server if enabled
+	if config.Webhook.Enabled {
+		cw.webhook = NewWebhookServer(&config.Webhook, cw, logger)
+	}
+
+	// Start gRPC server if enabled
+	if config.GRPC.Enabled {
+		cw.grpc = NewGRPCServer(&config.GRPC, cw, logger)
+	}
+
+	// Drain the Redis queue if configured
+	if config.RedisQueue.URL != "" {
+		cw.queue = NewRedisQueue(&config.RedisQueue, cw, logger)
+	}
+
+	// Take part in leader election in continuous mode if configured
+	if config.LeaderElection.Redis != "" && config.Interval > 0 {
+		cw.elector = newLeaderElector(&config.LeaderElection, logger)
+	}
+
+	return cw
+}
+
+// WarmCache performs the cache warming operation. Cancelling ctx or reaching
+// its deadline stops the cycle early; the summary then covers the partial
+// run and the context error is returned.
+func (cw *CacheWarmer) WarmCache(ctx context.Context) (summary RunSummary, err error) {
+	urls := cw.collectURLs(ctx)
+
+	// Leave out tiers that aren't due; ad-hoc -only runs warm every tier
+	tiered := cw.tiers != nil && len(cw.config.Only) == 0
+	var warmedTiers []string
+	if tiered {
+		urls, warmedTiers = cw.scheduleTiers(urls)
+	}
+
+	// Leave out URLs whose cached copy outlives the next cycle
+	scheduled := cw.ttlSchedule != nil && len(cw.config.Only) == 0
+	if scheduled {
+		urls = cw.scheduleTTLs(urls)
+	}
+	started := time.Now()
+	urls = cw.selectURLs(ctx, urls)
+
+	// A coordinator hands the cycle to the worker instances instead, high
+	// priorities first as warmInto would dispatch them
+	if cw.config.RedisQueue.Coordinator {
+		err = cw.queue.Enqueue(ctx, cw.prioritize(ctx, urls))
+		if err != nil {
+			cw.logger.Error("Failed to enqueue URLs for the workers: %v", err)
+		} else {
+			cw.logger.Info("Enqueued %d URLs for the workers", len(urls))
+			if tiered {
+				cw.tiers.finish(warmedTiers, started)
+			}
+		}
+		cw.recordCycle()
+		return RunSummary{StartedAt: started, Duration: time.Since(started), Cancelled: ctx.Err() != nil}, err
+	}
+
+	// Pick up where an interrupted cycle over the same URLs left off
+	run := newWarmRun()
+	if cw.config.Checkpoint.File != "" {
+		finish := cw.startCheckpoint(run, urls)
+		defer func() { finish(err == nil && !summary.Cancelled) }()
+	}
+
+	summary, err = cw.warmInto(ctx, run, urls)
+	if tiered && err == nil && !summary.Cancelled {
+		cw.tiers.finish(warmedTiers, started)
+	}
+	if scheduled {
+		cw.ttlSchedule.update(run.Results(0), started)
+	}
+	cw.recordCycle()
+	return summary, err
+}
+
+// collectURLs returns the configured URLs and URL template expansions
+// followed by those from the remote URL list, sitemaps, OpenAPI spec, access
+// logs and analytics, which are re-read every cycle to pick up new pages
+func (cw *CacheWarmer) collectURLs(ctx context.Context) []string {
+	config := cw.CurrentConfig()
+	urls := config.URLList()
+
+	if len(config.Templates) > 0 {
+		templateURLs := config.TemplateURLs()
+		cw.logger.Info("Expanded %d URL templates to %d URLs", len(config.Templates), len(templateURLs))
+		urls = append(urls, templateURLs...)
+	}
+
+	if config.RemoteList.URL != "" {
+		listURLs, changed, err := cw.fetchURLList(ctx)
+		if err != nil {
+			cw.logger.Error("Failed to load URL list: %v", err)
+		} else if changed {
+			cw.logger.Info("Loaded %d URLs from URL list %s", len(listURLs), config.RemoteList.URL)
+		} else {
+			cw.logger.Info("URL list %s not modified, using %d cached URLs", config.RemoteList.URL, len(listURLs))
+		}
+		urls = append(urls, listURLs...)
+	}
+
+	if config.Sitemap != "" {
+		sitemapURLs, err := cw.fetchSitemap(ctx, config.Sitemap)
+		if err != nil {
+			cw.logger.Error("Failed to load sitemap: %v", err)
+		} else {
+			cw.logger.Info("Loaded %d URLs from sitemap %s", len(sitemapURLs), config.Sitemap)
+			urls = append(urls, sitemapURLs...)
+		}
+	}
+
+	if config.Robots.Sitemaps {
+		urls = append(urls, cw.robotsSitemapURLs(ctx, urls)...)
+	}
+
+	if config.OpenAPI.Spec != "" {
+		apiURLs, err := cw.fetchOpenAPIURLs(ctx)
+		if err != nil {
+			cw.logger.Error("Failed to load OpenAPI spec: %v", err)
+		} else {
+			cw.logger.Info("Generated %d URLs from OpenAPI spec %s", len(apiURLs), config.OpenAPI.Spec)
+			urls = append(urls, apiURLs...)
+		}
+	}
+
+	for _, q := range cw.graphQLQueries {
+		urls = append(urls, q.url)
+	}
+
+	if len(config.AccessLog.Files) > 0 {
+		logURLs, err := topAccessLogURLs(&config.AccessLog, config.UserAgent)
+		if err != nil {
+			cw.logger.Error("Failed to load access logs: %v", err)
+		} else {
+			cw.logger.Info("Loaded %d most requested URLs from access logs", len(logURLs))
+			urls = append(urls, logURLs...)
+		}
+	}
+
+	if config.S3Logs.Source != "" {
+		logURLs, err := topS3LogURLs(ctx, &config.S3Logs, config.UserAgent)
+		if err != nil {
+			cw.logger.Error("Failed to load %s logs from S3: %v", config.S3Logs.Format, err)
+		} else {
+			cw.logger.Info("Loaded %d most requested URLs from %s logs in %s", len(logURLs), config.S3Logs.Format, config.S3Logs.Source)
+			urls = append(urls, logURLs...)
+		}
+	}
+
+	if cw.analyticsTokens != nil {
+		gaURLs, err := topAnalyticsURLs(ctx, &config.GoogleAnalytics, cw.analyticsTokens)
+		if err != nil {
+			cw.logger.Error("Failed to load top pages from Google Analytics: %v", err)
+		} else {
+			cw.logger.Info("Loaded %d most viewed URLs from Google Analytics (last %d days)", len(gaURLs), config.GoogleAnalytics.Days)
+			urls = append(urls, gaURLs...)
+		}
+	}
+
+	urls = cw.addRuntimeURLs(urls)
+
+	if len(config.Variants.Schemes) > 0 || config.Variants.WWW {
+		expanded := cw.expandVariants(urls)
+		cw.logger.Info("Expanded %d URLs to %d protocol and host variants", len(urls), len(expanded))
+		urls = expanded
+	}
+
+	return cw.dropRemovedURLs(urls)
+}
+
+// WarmOnStart performs the initial warm selected by warm_on_start, or by
+// backfill after missed cycles, in continuous mode, then reports the warmer
+// as ready
+func (cw *CacheWarmer) WarmOnStart(ctx context.Context) {
+	// Standbys leave the initial warm to the leader
+	if !cw.IsLeader() {
+		cw.logger.Info("Skipping initial warm, standing by for the leader")
+	} else {
+		switch cw.startScope() {
+		case WarmOnStartCritical:
+			urls := cw.selectURLs(ctx, cw.CurrentConfig().CriticalURLList())
+			if len(urls) == 0 {
+				cw.logger.Warn("Initial warm is %s but no URLs are marked critical", WarmOnStartCritical)
+				break
+			}
+			cw.logger.Info("Warming %d critical URLs before the regular schedule", len(urls))
+			cw.warm(ctx, urls)
+		case WarmOnStartNone:
+			cw.logger.Info("Skipping initial warm, waiting for the first scheduled cycle")
+		default:
+			cw.WarmCache(ctx)
+		}
+	}
+
+	// Report ready unless the initial warm was cut short by shutdown
+	if cw.ctx.Err() != nil {
+		return
+	}
+	atomic.StoreInt32(&cw.ready, 1)
+	close(cw.readyChan)
+	if cw.metrics != nil {
+		cw.metrics.SetReady(true)
+	}
+	cw.logger.Info("Cache warmer is ready")
+}
+
+// Ready reports whether the initial warm has completed
+func (cw *CacheWarmer) Ready() bool {
+	return atomic.LoadInt32(&cw.ready) == 1
+}
+
+// ReadyChan returns a channel that is closed once the initial warm has
+// completed
+func (cw *CacheWarmer) ReadyChan() <-chan struct{} {
+	return cw.readyChan
+}
+
+// WarmURLs performs an immediate warming run over the given URLs, used by
+// event-driven triggers such as the inbound webhook endpoint
+func (cw *CacheWarmer) WarmURLs(ctx context.Context, urls []string) (RunSummary, error) {
+	return cw.warm(ctx, urls)
 }
 
-// WarmCache performs the cache warming operation
-func (cw *CacheWarmer) WarmCache() {
+// warm runs the worker pool over the given URLs and prints statistics
+func (cw *CacheWarmer) warm(parent context.Context, urls []string) (RunSummary, error) {
+	return cw.warmInto(parent, newWarmRun(), urls)
+}
+
+// warmInto is warm recording into r, for callers that need its results
+func (cw *CacheWarmer) warmInto(parent context.Context, r *warmRun, urls []string) (RunSummary, error) {
+	// Track the run itself so Shutdown waits for it to finish
+	cw.wg.Add(1)
+	defer cw.wg.Done()
+	atomic.AddInt64(&cw.activeRuns, 1)
+	defer atomic.AddInt64(&cw.activeRuns, -1)
+
+	// Stop the run when either the caller's context or Shutdown ends it
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	stop := context.AfterFunc(cw.ctx, cancel)
+	defer stop()
+
+	// Record into the run's own statistics and results, which other runs
+	// started meanwhile leave alone, with the settings of the configuration
+	// as of now
+	r.settings = cw.current.Load()
+	ctx = withRun(ctx, r)
+	cw.lastRun.Store(r)
+
 	cw.logger.Info("Starting cache warming with %d URLs and %d workers",
-		len(cw.config.URLs), cw.config.Workers)
+		len(urls), cw.runWorkers(ctx))
+
+	// Drop URLs yielded more than once (e.g. by several sources), once they
+	// are normalized if configured
+	urls, normalized := normalizeURLs(&cw.config.Normalize, urls)
+	if normalized > 0 {
+		cw.logger.Debug("Normalized %d URLs", normalized)
+	}
+	urls, duplicates := dedupeURLs(urls)
+	atomic.StoreInt64(&r.stats.DuplicatesSkipped, int64(duplicates))
+	if duplicates > 0 {
+		cw.logger.Info("Skipped %d duplicate URLs", duplicates)
+	}
+
+	// Leave out URLs that keep failing until they are due for a re-check
+	if cw.skipList != nil {
+		var skipped int
+		urls, skipped = cw.skipList.Filter(urls)
+		atomic.StoreInt64(&r.stats.SkippedURLs, int64(skipped))
+		if skipped > 0 {
+			cw.logger.Info("Skipping %d persistently failing URLs", skipped)
+		}
+	}
+
+	// Order the URLs as configured, e.g. historically slow ones first
+	urls = cw.orderURLs(urls)
+
+	// Put high-priority URLs first, ahead of any deadline
+	urls = cw.prioritize(ctx, urls)
+
+	// Collect links from warmed pages if crawling or warming assets
+	if cw.followsLinks() {
+		crawler := newCrawler(urls, cw.config.Assets.Hosts)
+		if cw.config.Crawl.Enabled && cw.config.Robots.Respect {
+			crawler.robots = cw.loadRobots(ctx, urls)
+			crawler.nextRequest = make(map[string]time.Time)
+		}
+		r.crawler = crawler
+	}
+
+	// Spread the requests of a staggered cycle over its interval
+	if s := staggerOf(ctx); s != nil {
+		regions := len(cw.regions)
+		if cw.shield != nil {
+			regions++
+		}
+		s.spread(len(urls) * regions)
+		cw.logger.Info("Staggering %d URLs over %v", len(urls), s.window.Round(time.Second))
+	}
+
+	// Warm the URLs, wave by wave if configured, then any pages and assets
+	// discovered from them
+	completed := cw.dispatchWaves(ctx, urls) && (!cw.followsLinks() || cw.followLinks(ctx))
+	if !completed && cw.ctx.Err() != nil {
+		cw.logger.Info("Cache warming cancelled")
+		return cw.finishRun(r, true), cw.ctx.Err()
+	}
+	if parent.Err() != nil {
+		cw.logger.Warn("Cache warming cycle stopped early: %v", parent.Err())
+	}
+
+	// Persist history for future ordering decisions
+	if cw.history != nil {
+		if err := cw.history.Save(); err != nil {
+			cw.logger.Error("Failed to save history: %v", err)
+		}
+	}
 
-	// Reset statistics for this run
-	atomic.StoreInt64(&cw.stats.TotalRequests, 0)
-	atomic.StoreInt64(&cw.stats.SuccessRequests, 0)
-	atomic.StoreInt64(&cw.stats.FailedRequests, 0)
-	atomic.StoreInt64(&cw.stats.TotalDuration, 0)
-	cw.stats.StartTime = time.Now()
+	// Persist validators for the next run's conditional requests
+	if cw.validators != nil {
+		if err := cw.validators.Save(); err != nil {
+			cw.logger.Error("Failed to save validators: %v", err)
+		}
+	}
+
+	// Move URLs that failed too many cycles in a row to the skip list
+	results := r.Results(0)
+	if cw.skipList != nil {
+		added, recovered := cw.skipList.Update(results)
+		for _, url := range added {
+			cw.logger.Warn("Skipping %s for %v after %d consecutive failed cycles",
+				url, cw.config.SkipList.RetryAfter, cw.config.SkipList.After)
+		}
+		for _, url := range recovered {
+			cw.logger.Info("Removed %s from the skip list: its re-check succeeded", url)
+		}
+		if err := cw.skipList.Save(); err != nil {
+			cw.logger.Error("Failed to save skip list: %v", err)
+		}
+	}
+
+	// Keep the failed URLs for a quick re-run
+	cw.recordFailures(r.stats.RunID, results)
+	if cw.config.ResultsFile != "" {
+		cw.writeResultsFile(results)
+	}
+
+	// Print final statistics
+	cw.printStatistics(r)
+	if r.settings.critical != nil {
+		cw.reportCritical(results)
+	}
+	if cw.config.SlowThreshold > 0 {
+		cw.printSlowest(results)
+	}
+	if len(cw.config.Regions) > 0 || cw.shield != nil {
+		cw.printRegionComparison(results)
+		cw.printPOPComparison(results)
+	}
+	if len(cw.config.Devices) > 0 {
+		cw.printDeviceComparison(results)
+	}
+	if cw.shield != nil {
+		cw.printShieldSummary(results)
+	}
+	if cw.config.Shadow.BaseURL != "" {
+		cw.printShadowSummary(results)
+	}
+	if cw.config.TTLReport.Enabled {
+		cw.printTTLInventory(results)
+	}
 
-	// Create work channel
-	workChan := make(chan string, len(cw.config.URLs))
+	// Write and upload run artifacts
+	if cw.config.Artifacts.Dir != "" || cw.objectStore != nil {
+		cw.publishArtifacts(r)
+	}
+
+	// Share the cycle summary with the fleet aggregator
+	if cw.fleet != nil || cw.config.Fleet.Push != "" {
+		cw.publishFleetSummary(r)
+	}
+
+	return cw.finishRun(r, ctx.Err() != nil), parent.Err()
+}
+
+// dispatchRegions runs the worker pool over the given URLs in each of the
+// regions and waits for it to finish. It returns false if the run was cancelled.
+func (cw *CacheWarmer) dispatchRegions(ctx context.Context, urls []string, regions []*region) bool {
+	// Jobs are generated as workers take them, so a list of millions of
+	// URLs doesn't also need millions of jobs buffered in the channel
+	numWorkers := cw.runWorkers(ctx)
+	workChan := make(chan warmJob, numWorkers)
 
 	// Start worker goroutines
-	for i := 0; i < cw.config.Workers; i++ {
-		cw.wg.Add(1)
-		go cw.worker(i, workChan)
+	var workers sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		workers.Add(1)
+		go cw.worker(ctx, i, workChan, &workers)
 	}
 
-	// Send URLs to workers
-	for _, url := range cw.config.URLs {
-		select {
-		case workChan <- url:
-		case <-cw.ctx.Done():
-			cw.logger.Info("Cache warming cancelled")
-			close(workChan)
-			cw.wg.Wait()
-			return
+	// Count every URL and region as queued up front so the queue depth
+	// gauge shows the work left, not just the channel's few buffered jobs
+	units := int64(len(urls) * len(regions))
+	atomic.AddInt64(&cw.scheduler.queued, units)
+
+	// Send URLs to workers, at each address of the host with all_addresses,
+	// as each device profile and by each byte range
+	resolved := make(map[string][]string)
+	devices := cw.jobDevices()
+	progress := runOf(ctx).checkpoint
+	stagger := staggerOf(ctx)
+	var group string
+	if g := groupOf(ctx); g != nil {
+		group = g.config.Name
+	}
+	for _, url := range urls {
+		ranges := cw.jobRanges(url)
+		for _, region := range regions {
+			// Hold the URL back until its turn in a staggered cycle
+			if stagger.Wait(ctx) != nil {
+				close(workChan)
+				workers.Wait()
+				atomic.AddInt64(&cw.scheduler.queued, -(int64(len(workChan)) + units))
+				return false
+			}
+
+			addrs := cw.jobAddresses(ctx, url, region, resolved)
+			jobs := int64(len(addrs) * len(devices) * len(ranges))
+			units--
+			atomic.AddInt64(&cw.scheduler.queued, jobs-1)
+
+			for _, addr := range addrs {
+				for _, device := range devices {
+					for _, byteRange := range ranges {
+						job := warmJob{url: url, region: region, addr: addr, device: device, byteRange: byteRange, group: group}
+						// Skip what the interrupted cycle already warmed
+						if progress.Completed(job.key()) {
+							jobs--
+							atomic.AddInt64(&cw.scheduler.queued, -1)
+							continue
+						}
+						select {
+						case workChan <- job:
+							jobs--
+						case <-ctx.Done():
+							close(workChan)
+							workers.Wait()
+							// Drop the jobs no worker picked up and those
+							// never generated
+							atomic.AddInt64(&cw.scheduler.queued, -(int64(len(workChan)) + jobs + units))
+							return false
+						}
+					}
+				}
+			}
 		}
 	}
 
@@ -110,96 +971,300 @@ func (cw *CacheWarmer) WarmCache() {
 	close(workChan)
 
 	// Wait for all workers to complete
-	cw.wg.Wait()
+	workers.Wait()
+	atomic.AddInt64(&cw.scheduler.queued, -int64(len(workChan)))
+	return true
+}
 
-	// Print final statistics
-	cw.printStatistics()
+// dedupeURLs removes repeated URLs, keeping the first occurrence, and
+// returns how many were removed
+func dedupeURLs(urls []string) ([]string, int) {
+	seen := make(map[string]bool, len(urls))
+	unique := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		unique = append(unique, url)
+	}
+	return unique, len(urls) - len(unique)
 }
 
 // worker processes URLs from the work channel
-func (cw *CacheWarmer) worker(id int, workChan <-chan string) {
-	defer cw.wg.Done()
+func (cw *CacheWarmer) worker(ctx context.Context, id int, workChan <-chan warmJob, wg *sync.WaitGroup) {
+	defer wg.Done()
 
 	cw.logger.Debug("Worker %d started", id)
 
+	gauge := cw.scheduler.addWorker(id)
+	defer cw.scheduler.removeWorker(gauge)
+
+	// Per-worker, per-host politeness interval
+	pacer := newPolitenessPacer(&cw.config.Politeness)
+
 	for {
 		select {
-		case url, ok := <-workChan:
+		case job, ok := <-workChan:
 			if !ok {
 				cw.logger.Debug("Worker %d finished", id)
 				return
 			}
-			cw.processURL(id, url)
-		case <-cw.ctx.Done():
+			atomic.AddInt64(&cw.scheduler.queued, -1)
+			// Both cases may be ready; don't start new work once cancelled
+			if ctx.Err() != nil {
+				cw.logger.Debug("Worker %d cancelled", id)
+				return
+			}
+			// Hold the job while warming is paused
+			if err := cw.pause.Wait(ctx); err != nil {
+				cw.logger.Debug("Worker %d cancelled", id)
+				return
+			}
+			cw.scheduler.begin(gauge)
+			cw.processURL(ctx, id, job, pacer)
+			cw.scheduler.end(gauge)
+		case <-ctx.Done():
 			cw.logger.Debug("Worker %d cancelled", id)
 			return
 		}
 	}
 }
 
-// processURL makes an HTTP request to the specified URL with retry logic
-func (cw *CacheWarmer) processURL(workerID int, url string) {
+// processURL warms a URL, sharing the outcome of an identical request that
+// is already in flight (e.g. from a concurrent webhook-triggered run)
+func (cw *CacheWarmer) processURL(ctx context.Context, workerID int, job warmJob, pacer *politenessPacer) {
+	key := job.key()
+
+	cw.inflightMutex.Lock()
+	if call, ok := cw.inflight[key]; ok {
+		cw.inflightMutex.Unlock()
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return
+		}
+		if !call.ok {
+			return
+		}
+
+		run := runOf(ctx)
+		atomic.AddInt64(&run.stats.CoalescedRequests, 1)
+		cw.logger.Debug("Worker %d coalesced %s%s with an in-flight request", workerID, job.url, job.label())
+
+		result := call.result
+		result.Coalesced = true
+		cw.recordResult(run, result)
+		run.checkpoint.Complete(key)
+		return
+	}
+	call := &inflightCall{done: make(chan struct{})}
+	cw.inflight[key] = call
+	cw.inflightMutex.Unlock()
+
+	call.result, call.ok = cw.warmURL(ctx, workerID, job, pacer)
+
+	// Compare the response with the shadow host's
+	if call.ok && cw.config.Shadow.BaseURL != "" {
+		cw.mirror(ctx, &call.result)
+	}
+
+	cw.inflightMutex.Lock()
+	delete(cw.inflight, key)
+	cw.inflightMutex.Unlock()
+	close(call.done)
+
+	if call.ok {
+		run := runOf(ctx)
+		cw.recordResult(run, call.result)
+		run.checkpoint.Complete(key)
+	}
+}
+
+// warmURL makes an HTTP request to the specified URL with retry logic. It
+// returns false if the run was cancelled before an outcome was reached.
+func (cw *CacheWarmer) warmURL(ctx context.Context, workerID int, job warmJob, pacer *politenessPacer) (Result, bool) {
+	url := job.url
 	startTime := time.Now()
 	var lastErr error
 
+	result := Result{URL: url, Region: job.region.name, Address: job.addr, Device: job.device, Range: job.byteRange,
+		Critical: cw.settingsOf(ctx).critical[url], Priority: cw.urlPriority(ctx, url)}
+	run := runOf(ctx)
+
+	// Variants are only serialized within a region, address, device and
+	// byte range; each has its own cache
+	var coalesceKey string
+	if cw.coalescer != nil {
+		coalesceKey = job.region.name + "|" + job.addr + "|" + job.device + "|" + job.byteRange + "|" + cw.coalescer.Key(url)
+	}
+
+	client := job.region.client
+	if job.addr != "" {
+		client = job.region.addressClient(cw.config, job.addr)
+	}
+
 	// Increment total requests counter
-	atomic.AddInt64(&cw.stats.TotalRequests, 1)
+	atomic.AddInt64(&run.stats.TotalRequests, 1)
+
+	// Bound the time spent on this URL, retries included; ctx still tells
+	// whether the run itself was cancelled
+	urlCtx := ctx
+	if cw.config.URLBudget > 0 {
+		var cancel context.CancelFunc
+		urlCtx, cancel = context.WithTimeout(ctx, cw.config.URLBudget)
+		defer cancel()
+	}
 
-	// Retry logic
-	for attempt := 0; attempt <= cw.config.RetryCount; attempt++ {
+	// Retry logic; how often depends on the class of the last failure
+	for attempt := 0; attempt <= cw.config.RetriesFor(lastErr); attempt++ {
 		if attempt > 0 {
+			if cw.skipList != nil && cw.skipList.Rechecking(url) {
+				cw.logger.Debug("Worker %d not retrying %s: re-checks of skipped URLs get a single attempt", workerID, url)
+				break
+			}
+			if !cw.takeRetry(run) {
+				atomic.AddInt64(&run.stats.RetriesDenied, 1)
+				cw.logger.Debug("Worker %d not retrying %s: the cycle's retry budget is spent", workerID, url)
+				break
+			}
 			cw.logger.Debug("Worker %d retrying URL %s (attempt %d/%d)",
-				workerID, url, attempt+1, cw.config.RetryCount+1)
+				workerID, url, attempt+1, cw.config.RetriesFor(lastErr)+1)
 
 			// Wait before retry
 			select {
 			case <-time.After(cw.config.RetryDelay):
-			case <-cw.ctx.Done():
-				return
+			case <-urlCtx.Done():
 			}
 		}
 
-		// Make the HTTP request
-		success, err := cw.makeRequest(url)
+		// Keep this worker's requests to the host politely spaced, and all
+		// requests under the global rate limit
+		err := pacer.Wait(urlCtx, url)
+		if err == nil {
+			err = cw.throttle.Wait(urlCtx)
+		}
+
+		// Honor the host's robots.txt Crawl-delay when crawling
+		if err == nil {
+			err = cw.crawlDelay(urlCtx, url)
+		}
+
+		// Wait for requests to other variants of the same resource
+		var done func()
+		if err == nil {
+			done, err = cw.coalescer.Acquire(urlCtx, coalesceKey)
+		}
+
+		var success bool
+		if err == nil {
+			// Make the HTTP request
+			result.Attempts = attempt + 1
+			attemptCtx, cancel := urlCtx, context.CancelFunc(func() {})
+			if cw.config.RequestTimeout > 0 {
+				attemptCtx, cancel = context.WithTimeout(urlCtx, cw.config.RequestTimeout)
+			}
+			success, err = cw.makeRequest(attemptCtx, client, url, &result)
+			cancel()
+			done()
+		}
+		if !success && ctx.Err() != nil {
+			// Interrupted rather than failed; don't count it against the URL
+			return result, false
+		}
+		if !success && urlCtx.Err() != nil {
+			// Report the last attempt's failure if the budget ran out
+			// while waiting to make the next one
+			if err == urlCtx.Err() && lastErr != nil {
+				err = lastErr
+			}
+			lastErr = &TimeoutError{transportError{
+				Op:  fmt.Sprintf("url budget of %v exhausted", cw.config.URLBudget),
+				Err: err,
+			}}
+			break
+		}
 		if success {
 			duration := time.Since(startTime)
-			atomic.AddInt64(&cw.stats.SuccessRequests, 1)
-			atomic.AddInt64(&cw.stats.TotalDuration, int64(duration))
+			atomic.AddInt64(&run.stats.SuccessRequests, 1)
+			atomic.AddInt64(&run.stats.TotalDuration, int64(duration))
+			run.latency.Observe(duration)
+			run.observeResponse(&result)
+			switch result.Family {
+			case IPFamilyIPv4:
+				atomic.AddInt64(&run.stats.IPv4Requests, 1)
+			case IPFamilyIPv6:
+				atomic.AddInt64(&run.stats.IPv6Requests, 1)
+			}
+			if result.StatusCode == http.StatusNotModified {
+				atomic.AddInt64(&run.stats.NotModified, 1)
+			}
 
-			cw.logger.Debug("Worker %d successfully warmed %s in %v",
-				workerID, url, duration)
+			cw.logger.Debug("Worker %d successfully warmed %s%s in %v",
+				workerID, url, job.label(), duration)
 
 			// Update metrics if enabled
 			if cw.metrics != nil {
-				cw.metrics.RecordRequest(url, "success", duration)
+				cw.metrics.RecordRequest(&result, "success", duration)
 			}
-			return
+
+			result.Success = true
+			result.Duration = duration
+
+			// Point out pages that stay slow even when warmed
+			if cw.isSlow(&result) {
+				cw.logSlowRequest(workerID, url+job.label(), &result)
+			}
+			return result, true
 		}
 
 		lastErr = err
-		cw.logger.Debug("Worker %d failed to warm %s: %v", workerID, url, err)
+		cw.logger.Debug("Worker %d failed to warm %s%s: %v", workerID, url, job.label(), err)
 	}
 
 	// All retries failed
 	duration := time.Since(startTime)
-	atomic.AddInt64(&cw.stats.FailedRequests, 1)
-	atomic.AddInt64(&cw.stats.TotalDuration, int64(duration))
+	atomic.AddInt64(&run.stats.FailedRequests, 1)
+	atomic.AddInt64(&run.stats.TotalDuration, int64(duration))
+	run.latency.Observe(duration)
+	run.observeResponse(&result)
+	if ErrorClass(lastErr) == ErrorClassProxy {
+		atomic.AddInt64(&run.stats.ProxyErrors, 1)
+	}
 
-	cw.logger.Warn("Worker %d failed to warm %s after %d attempts: %v",
-		workerID, url, cw.config.RetryCount+1, lastErr)
+	cw.logger.Warn("Worker %d failed to warm %s%s after %d attempts: %v",
+		workerID, url, job.label(), result.Attempts, lastErr)
 
 	// Update metrics if enabled
 	if cw.metrics != nil {
-		cw.metrics.RecordRequest(url, "failure", duration)
+		cw.metrics.RecordRequest(&result, "failure", duration)
+		cw.metrics.RecordFailureClass(ErrorClass(lastErr))
+	}
+
+	result.Duration = duration
+	result.Err = lastErr
+	return result, true
+}
+
+// recordResult stores the outcome of a URL in r for end-of-run reporting
+func (cw *CacheWarmer) recordResult(r *warmRun, result Result) {
+	r.record(result)
+
+	if cw.history != nil {
+		cw.history.Record(result)
 	}
+
+	cw.emitResult(result)
 }
 
-// makeRequest performs a single HTTP request to the specified URL
-func (cw *CacheWarmer) makeRequest(url string) (bool, error) {
+// newRequest builds a warm request with the configured headers, waiting for
+// the host's rate-limit budget if needed
+func (cw *CacheWarmer) newRequest(ctx context.Context, url string) (*http.Request, error) {
 	// Create request with context for cancellation
-	req, err := http.NewRequestWithContext(cw.ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return false, fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	// Set User-Agent header
@@ -209,39 +1274,204 @@ func (cw *CacheWarmer) makeRequest(url string) (bool, error) {
 	for key, value := range cw.config.Headers {
 		req.Header.Set(key, value)
 	}
+	if g := groupOf(ctx); g != nil {
+		for key, value := range g.config.Headers {
+			req.Header.Set(key, value)
+		}
+	}
+
+	// Set basic auth credentials
+	cw.applyBasicAuth(req, url)
+
+	// Attach the login session or access token, logging in first if needed
+	if cw.auth != nil {
+		if err := cw.auth.Apply(req, cw.config.UserAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	// Send the query for GraphQL warm URLs
+	if q := cw.graphQL[url]; q != nil {
+		if err := cw.graphQLRequest(req, q); err != nil {
+			return nil, err
+		}
+	}
+
+	// Tell collapsing proxies which resource the request is for
+	if cw.coalescer != nil && cw.config.Coalescing.Header != "" {
+		req.Header.Set(cw.config.Coalescing.Header, cw.coalescer.Key(url))
+	}
+
+	// Respect the host's remaining rate-limit budget
+	if cw.budget != nil {
+		if err := cw.budget.Wait(ctx, req.URL.Host); err != nil {
+			return nil, fmt.Errorf("request cancelled: %v", err)
+		}
+	}
+
+	return req, nil
+}
+
+// makeRequest performs a single HTTP request to the specified URL
+func (cw *CacheWarmer) makeRequest(ctx context.Context, client *http.Client, url string, result *Result) (ok bool, err error) {
+	req, err := cw.newRequest(ctx, url)
+	if err != nil {
+		return false, err
+	}
+	cw.applyDevice(req, result.Device)
+	applyRange(req, result.Range)
 
-	// Make the request
-	resp, err := cw.client.Do(req)
+	// GraphQL queries keep their own method
+	if cw.config.Method == http.MethodHead && cw.graphQL[url] == nil {
+		req.Method = http.MethodHead
+	}
+
+	// Revalidate with the validators of the last full response; probes
+	// always ask for the full response and don't store its validators
+	key := validatorKey(url, result.Device)
+	validators := cw.validators != nil && cw.graphQL[url] == nil && !isProbe(ctx)
+	conditional := validators && cw.validators.Apply(req, key)
+
+	// Wait for an in-flight slot and for the heap to be below its watermark
+	if err := cw.admission.Acquire(ctx); err != nil {
+		return false, err
+	}
+	defer cw.admission.Release()
+
+	atomic.AddInt64(&cw.scheduler.inFlight, 1)
+	defer atomic.AddInt64(&cw.scheduler.inFlight, -1)
+
+	// Note the address family of the connection the request is sent on
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			result.Family = addressFamily(info.Conn.RemoteAddr())
+		},
+	}))
+
+	// Dump the whole exchange if the URL is selected with -trace-url
+	req, trace := cw.tracer.Start(req, result)
+
+	// Make the request, timing the first byte and, if it may turn out slow,
+	// the connection setup
+	start := time.Now()
+	result.TTFB, result.Bytes = 0, 0
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { result.TTFB = time.Since(start) },
+	}))
+	if cw.config.SlowThreshold > 0 {
+		result.timing = requestTiming{}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timingTrace(&result.timing)))
+	}
+	resp, err := client.Do(req)
+	defer func() { trace.Finish(resp, err) }()
 	if err != nil {
-		return false, fmt.Errorf("request failed: %v", err)
+		return false, classifyTransportError("request failed", err)
 	}
 	defer resp.Body.Close()
+	trace.CaptureBody(resp)
+
+	result.StatusCode = resp.StatusCode
+	result.CacheStatus = DetectCacheStatus(resp.Header)
+	result.POP = DetectPOP(resp.Header)
+	result.TTL, result.TTLKnown = effectiveTTL(resp.Header)
+	result.Headers = captureHeaders(resp.Header, cw.config.CaptureHeaders)
+
+	if cw.budget != nil {
+		cw.budget.Observe(req.URL.Host, resp.StatusCode, resp.Header)
+	}
 
-	// Check if status code is considered successful
-	if !cw.config.IsSuccessCode(resp.StatusCode) {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	// Rejected credentials are renewed before the retry
+	if cw.auth != nil && resp.StatusCode == http.StatusUnauthorized {
+		cw.auth.Invalidate()
+	}
+
+	// A proxy carrying plain HTTP answers 407 itself, for any origin
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return false, &ProxyError{transportError{Op: "proxy refused request", Err: errors.New(resp.Status)}}
+	}
+
+	// A 304 to a conditional request is a successful revalidation; it has
+	// no body for the other checks to look at
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		result.latency = time.Since(start)
+		cw.validators.Observe(key, resp)
+		return true, nil
+	}
+
+	// Check if status code is considered successful, unless a success rule
+	// decides once the body is read. A ranged request is answered with 206
+	// Partial Content.
+	rule := cw.successRuleFor(url)
+	partial := result.Range != "" && resp.StatusCode == http.StatusPartialContent
+	if rule == nil && !partial && !cw.isSuccessCode(ctx, resp.StatusCode) {
+		return false, &StatusCodeError{StatusCode: resp.StatusCode}
+	}
+
+	// Catch the wrong language variant served from cache
+	if err := cw.checkLocale(url, resp.Header); err != nil {
+		return false, err
+	}
+
+	// GraphQL servers report errors in the body of successful responses
+	if q := cw.graphQL[url]; q != nil {
+		if err := cw.checkGraphQLResponse(ctx, client, q, resp.Body); err != nil {
+			return false, err
+		}
+	}
+
+	// Keep the start of HTML pages to look for links when crawling
+	var page *bytes.Buffer
+	if cw.parsesHTML() && isHTML(resp.Header.Get("Content-Type")) {
+		page = &bytes.Buffer{}
+		if _, err := io.CopyN(page, resp.Body, maxHTMLParseSize); err != nil && err != io.EOF {
+			return false, classifyTransportError("incomplete response body", err)
+		}
 	}
 
 	// Read and discard response body to ensure complete request processing
 	// This is important for cache warming as it ensures the full response is processed
-	buffer := make([]byte, 4096)
-	for {
-		_, err := resp.Body.Read(buffer)
-		if err != nil {
-			break // EOF or other error, both are fine
+	body, contentHash := cw.hashingBody()
+	var read int64
+	if page != nil {
+		body.Write(page.Bytes())
+		read = int64(page.Len())
+	}
+	n, err := io.Copy(body, resp.Body)
+	result.Bytes = read + n
+	if err != nil {
+		return false, classifyTransportError("incomplete response body", err)
+	}
+	result.latency = time.Since(start)
+	result.contentHash = contentHash()
+
+	if rule != nil {
+		if err := rule.check(resp, result.CacheStatus, time.Since(start)); err != nil {
+			return false, err
 		}
 	}
 
+	if page != nil {
+		cw.discoverLinks(runOf(ctx), extractLinks(page, resp.Request.URL))
+	}
+	if cw.config.Assets.Preload {
+		cw.discoverPreloads(runOf(ctx), preloadLinks(resp.Header, resp.Request.URL))
+	}
+
+	if validators {
+		cw.validators.Observe(key, resp)
+	}
+
 	return true, nil
 }
 
-// printStatistics prints the current statistics
-func (cw *CacheWarmer) printStatistics() {
-	total := atomic.LoadInt64(&cw.stats.TotalRequests)
-	success := atomic.LoadInt64(&cw.stats.SuccessRequests)
-	failed := atomic.LoadInt64(&cw.stats.FailedRequests)
-	totalDuration := time.Duration(atomic.LoadInt64(&cw.stats.TotalDuration))
-	elapsed := time.Since(cw.stats.StartTime)
+// printStatistics prints the statistics of run r
+func (cw *CacheWarmer) printStatistics(r *warmRun) {
+	total := atomic.LoadInt64(&r.stats.TotalRequests)
+	success := atomic.LoadInt64(&r.stats.SuccessRequests)
+	failed := atomic.LoadInt64(&r.stats.FailedRequests)
+	totalDuration := time.Duration(atomic.LoadInt64(&r.stats.TotalDuration))
+	elapsed := time.Since(r.stats.StartTime)
 
 	successRate := float64(0)
 	if total > 0 {
@@ -253,44 +1483,115 @@ func (cw *CacheWarmer) printStatistics() {
 		avgDuration = totalDuration / time.Duration(total)
 	}
 
-	cw.logger.Info("Cache warming completed:")
+	cw.logger.Info("Cache warming completed (run %s):", r.stats.RunID)
 	cw.logger.Info("  Total requests: %d", total)
 	cw.logger.Info("  Successful: %d (%.1f%%)", success, successRate)
 	cw.logger.Info("  Failed: %d", failed)
 	cw.logger.Info("  Total time: %v", elapsed)
 	cw.logger.Info("  Average request time: %v", avgDuration)
+	if total > 0 {
+		p := r.latency.Percentiles()
+		cw.logger.Info("  Request time p50: %v, p90: %v, p95: %v, p99: %v",
+			p.P50.Round(time.Microsecond), p.P90.Round(time.Microsecond),
+			p.P95.Round(time.Microsecond), p.P99.Round(time.Microsecond))
+	}
+	if r.ttfb.total > 0 {
+		p := r.ttfb.Percentiles()
+		cw.logger.Info("  Time to first byte p50: %v, p90: %v, p95: %v, p99: %v",
+			p.P50.Round(time.Microsecond), p.P90.Round(time.Microsecond),
+			p.P95.Round(time.Microsecond), p.P99.Round(time.Microsecond))
+	}
+	if transferred := atomic.LoadInt64(&r.stats.BytesTransferred); transferred > 0 {
+		cw.logger.Info("  Transferred: %s", formatBytes(transferred))
+	}
 
 	if total > 0 {
 		requestsPerSecond := float64(total) / elapsed.Seconds()
 		cw.logger.Info("  Requests per second: %.2f", requestsPerSecond)
 	}
+
+	if duplicates := atomic.LoadInt64(&r.stats.DuplicatesSkipped); duplicates > 0 {
+		cw.logger.Info("  Duplicates skipped: %d", duplicates)
+	}
+	if coalesced := atomic.LoadInt64(&r.stats.CoalescedRequests); coalesced > 0 {
+		cw.logger.Info("  Coalesced with in-flight requests: %d", coalesced)
+	}
+	if skipped := atomic.LoadInt64(&r.stats.SkippedURLs); skipped > 0 {
+		cw.logger.Info("  Skipped (persistently failing): %d", skipped)
+	}
+	if crawled := atomic.LoadInt64(&r.stats.CrawledURLs); crawled > 0 {
+		cw.logger.Info("  Discovered by crawling: %d", crawled)
+	}
+	if assets := atomic.LoadInt64(&r.stats.AssetURLs); assets > 0 {
+		cw.logger.Info("  Page assets: %d", assets)
+	}
+	if wave := atomic.LoadInt64(&r.stats.HaltedWave); wave > 0 {
+		cw.logger.Info("  Stopped after wave %d failed its checks", wave)
+	}
+	if proxyErrors := atomic.LoadInt64(&r.stats.ProxyErrors); proxyErrors > 0 {
+		cw.logger.Info("  Failed at the forward proxy: %d", proxyErrors)
+	}
+	ipv4 := atomic.LoadInt64(&r.stats.IPv4Requests)
+	ipv6 := atomic.LoadInt64(&r.stats.IPv6Requests)
+	if ipv6 > 0 || cw.config.IPFamily != IPFamilyDual {
+		cw.logger.Info("  Warmed over IPv4 / IPv6: %d / %d", ipv4, ipv6)
+	}
+	if cw.validators != nil {
+		cw.logger.Info("  Not modified (304): %d", atomic.LoadInt64(&r.stats.NotModified))
+	}
+	if cw.config.RetryBudget.Ratio > 0 {
+		cw.logger.Info("  Retries: %d (%d denied by the retry budget)",
+			atomic.LoadInt64(&r.stats.Retries), atomic.LoadInt64(&r.stats.RetriesDenied))
+	}
 }
 
-// GetStatistics returns the current statistics
+// GetStatistics returns the statistics of the most recently started run
 func (cw *CacheWarmer) GetStatistics() Statistics {
-	return Statistics{
-		TotalRequests:   atomic.LoadInt64(&cw.stats.TotalRequests),
-		SuccessRequests: atomic.LoadInt64(&cw.stats.SuccessRequests),
-		FailedRequests:  atomic.LoadInt64(&cw.stats.FailedRequests),
-		TotalDuration:   atomic.LoadInt64(&cw.stats.TotalDuration),
-		StartTime:       cw.stats.StartTime,
-	}
+	return cw.lastRun.Load().Statistics()
+}
+
+// GetResults returns the per-URL results of the most recently started run
+func (cw *CacheWarmer) GetResults() []Result {
+	return cw.lastRun.Load().Results(0)
 }
 
 // Shutdown gracefully shuts down the cache warmer
 func (cw *CacheWarmer) Shutdown() {
 	cw.logger.Info("Shutting down cache warmer...")
 
+	// Stop accepting new webhook-triggered runs
+	if cw.webhook != nil {
+		cw.webhook.Shutdown()
+	}
+
+	// End the gRPC streams and stop accepting calls
+	if cw.grpc != nil {
+		cw.grpc.Shutdown()
+	}
+
+	// Stop dequeuing URLs from Redis
+	if cw.queue != nil {
+		cw.queue.Shutdown()
+	}
+
 	// Cancel context to stop all workers
 	cw.cancel()
 
 	// Wait for all workers to finish
 	cw.wg.Wait()
 
+	// Hand the leader lock to a standby
+	if cw.elector != nil {
+		cw.elector.Shutdown()
+	}
+
 	// Shutdown metrics server if enabled
 	if cw.metrics != nil {
 		cw.metrics.Shutdown()
 	}
+	if cw.statsd != nil {
+		cw.statsd.Close()
+	}
 
 	cw.logger.Info("Cache warmer shutdown complete")
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestWarmer returns a warmer for the origin with everything that listens
+// or persists turned off
+func newTestWarmer(t *testing.T, origin *httptest.Server, configure func(*Config)) *CacheWarmer {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.URLs = []URLEntry{{URL: origin.URL + "/"}}
+	config.Workers = 4
+	config.RetryCount = 0
+	config.Timeout = 5 * time.Second
+	if configure != nil {
+		configure(config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("invalid configuration: %v", err)
+	}
+
+	cw := NewCacheWarmer(config, NewLogger(false))
+	t.Cleanup(cw.Shutdown)
+	return cw
+}
+
+// testURLs returns n URLs under the origin's path prefix
+func testURLs(origin *httptest.Server, prefix string, n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/%s/%d", origin.URL, prefix, i)
+	}
+	return urls
+}
+
+func TestWarmOverlappingRunsKeepTheirOwnState(t *testing.T) {
+	// Responses are slow enough for the two runs to overlap, and fail under
+	// /missing so each run has its own mix of outcomes
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		if strings.HasPrefix(r.URL.Path, "/missing/") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	cw := newTestWarmer(t, origin, nil)
+
+	runs := []struct {
+		urls   []string
+		failed int64
+	}{
+		{urls: testURLs(origin, "ok", 20)},
+		{urls: append(testURLs(origin, "ok-too", 5), testURLs(origin, "missing", 7)...), failed: 7},
+	}
+
+	var wg sync.WaitGroup
+	summaries := make([]RunSummary, len(runs))
+	for i, run := range runs {
+		wg.Add(1)
+		go func(i int, urls []string) {
+			defer wg.Done()
+			summary, err := cw.warm(context.Background(), urls)
+			if err != nil {
+				t.Errorf("run %d: %v", i, err)
+			}
+			summaries[i] = summary
+		}(i, run.urls)
+	}
+
+	// Read the statistics the control APIs serve while the runs are going
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				cw.GetStatistics()
+				cw.GetResults()
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-done
+
+	if summaries[0].RunID == summaries[1].RunID {
+		t.Errorf("runs share the run ID %s", summaries[0].RunID)
+	}
+	for i, run := range runs {
+		s := summaries[i]
+		if s.TotalRequests != int64(len(run.urls)) {
+			t.Errorf("run %d: %d requests, want %d", i, s.TotalRequests, len(run.urls))
+		}
+		if s.FailedRequests != run.failed {
+			t.Errorf("run %d: %d failed, want %d", i, s.FailedRequests, run.failed)
+		}
+		if s.SuccessRequests != int64(len(run.urls))-run.failed {
+			t.Errorf("run %d: %d succeeded, want %d", i, s.SuccessRequests, int64(len(run.urls))-run.failed)
+		}
+	}
+}
+
+func TestWarmIntoRecordsOnlyItsOwnResults(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	cw := newTestWarmer(t, origin, nil)
+
+	prefixes := []string{"a", "b"}
+	runs := make([]*warmRun, len(prefixes))
+	var wg sync.WaitGroup
+	for i, prefix := range prefixes {
+		runs[i] = newWarmRun()
+		wg.Add(1)
+		go func(r *warmRun, urls []string) {
+			defer wg.Done()
+			cw.warmInto(context.Background(), r, urls)
+		}(runs[i], testURLs(origin, prefix, 10))
+	}
+	wg.Wait()
+
+	for i, prefix := range prefixes {
+		results := runs[i].Results(0)
+		if len(results) != 10 {
+			t.Errorf("run %s: %d results, want 10", prefix, len(results))
+		}
+		for _, result := range results {
+			if !strings.HasPrefix(result.URL, origin.URL+"/"+prefix+"/") {
+				t.Errorf("run %s recorded %s", prefix, result.URL)
+			}
+		}
+	}
+}
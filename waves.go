@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// parseWaveSize parses a wave size: a percentage of the cycle's URLs ("10%")
+// or a number of URLs ("500")
+func parseWaveSize(size string) (percent float64, count int, err error) {
+	size = strings.TrimSpace(size)
+	if strings.HasSuffix(size, "%") {
+		percent, err = strconv.ParseFloat(strings.TrimSuffix(size, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return 0, 0, fmt.Errorf("invalid wave size %q, expected a percentage between 0%% and 100%%", size)
+		}
+		return percent, 0, nil
+	}
+	count, err = strconv.Atoi(size)
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid wave size %q, expected a percentage such as 10%% or a URL count", size)
+	}
+	return 0, count, nil
+}
+
+// split cuts urls into the configured waves followed by a last wave of the
+// remaining URLs. Waves have at least one URL; waves left empty are dropped.
+func (c *WavesConfig) split(urls []string) [][]string {
+	var waves [][]string
+	total := len(urls)
+	for _, size := range c.Sizes {
+		if len(urls) == 0 {
+			break
+		}
+		percent, count, _ := parseWaveSize(size)
+		if percent > 0 {
+			count = int(math.Ceil(float64(total) * percent / 100))
+		}
+		if count > len(urls) {
+			count = len(urls)
+		}
+		waves = append(waves, urls[:count])
+		urls = urls[count:]
+	}
+	if len(urls) > 0 {
+		waves = append(waves, urls)
+	}
+	return waves
+}
+
+// dispatchWaves warms urls in the configured waves, checking the results of
+// each wave before the next is started. It returns false if the run was
+// cancelled or a wave failed its checks.
+func (cw *CacheWarmer) dispatchWaves(ctx context.Context, urls []string) bool {
+	waves := cw.config.Waves.split(urls)
+	if len(waves) <= 1 {
+		return cw.dispatch(ctx, urls)
+	}
+
+	run := runOf(ctx)
+	remaining := len(urls)
+	for i, wave := range waves {
+		cw.logger.Info("Wave %d/%d: warming %d URLs", i+1, len(waves), len(wave))
+		first := run.resultCount()
+
+		if !cw.dispatch(ctx, wave) {
+			return false
+		}
+		remaining -= len(wave)
+		if remaining == 0 {
+			break
+		}
+
+		if err := cw.checkWave(run.Results(first)); err != nil {
+			cw.logger.Error("Wave %d failed its checks, leaving the remaining %d URLs unwarmed: %v", i+1, remaining, err)
+			atomic.StoreInt64(&run.stats.HaltedWave, int64(i+1))
+			return false
+		}
+	}
+	return true
+}
+
+// checkWave compares the results of a wave with the wave thresholds
+func (cw *CacheWarmer) checkWave(results []Result) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	var succeeded int
+	durations := make([]time.Duration, len(results))
+	for i, result := range results {
+		if result.Success {
+			succeeded++
+		}
+		durations[i] = result.Duration
+	}
+	rate := float64(succeeded) / float64(len(results)) * 100
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	p95 := durations[int(math.Ceil(float64(len(durations))*0.95))-1]
+
+	cw.logger.Info("Wave results: %.1f%% succeeded, 95th percentile request time %v", rate, p95)
+
+	waves := cw.config.Waves
+	if waves.MinSuccessRate > 0 && rate < waves.MinSuccessRate {
+		return fmt.Errorf("success rate %.1f%% is below %.1f%%", rate, waves.MinSuccessRate)
+	}
+	if waves.MaxLatency > 0 && p95 > waves.MaxLatency {
+		return fmt.Errorf("95th percentile request time %v exceeds %v", p95, waves.MaxLatency)
+	}
+	return nil
+}
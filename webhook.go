@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWebhookBody limits the size of accepted webhook payloads
+const maxWebhookBody = 1 << 20
+
+// maxWebhookRuns limits how many webhook-triggered runs go at once
+const maxWebhookRuns = 2
+
+// CMSEvent is a publish/update event normalized from a CMS webhook payload
+type CMSEvent struct {
+	// ContentType is the CMS content type (post, page, blogPost...)
+	ContentType string
+
+	// ID is the CMS identifier of the document
+	ID string
+
+	// Slug is the URL slug of the document, if known
+	Slug string
+
+	// URL is the absolute permalink, if the CMS sent one
+	URL string
+
+	// Removed is true for unpublish/delete events, where only listings are warmed
+	Removed bool
+}
+
+// cmsParser extracts publish events from a CMS webhook request. A nil event
+// with a nil error means the payload is not a publish/update event.
+type cmsParser func(r *http.Request, body []byte) (*CMSEvent, error)
+
+// WebhookServer receives inbound webhooks and triggers immediate warming
+type WebhookServer struct {
+	config *WebhookConfig
+	warmer *CacheWarmer
+	logger *Logger
+	server *http.Server
+
+	// runs holds a slot per webhook-triggered run in progress
+	runs chan struct{}
+}
+
+// NewWebhookServer creates a new webhook server and starts listening
+func NewWebhookServer(config *WebhookConfig, warmer *CacheWarmer, logger *Logger) *WebhookServer {
+	ws := &WebhookServer{
+		config: config,
+		warmer: warmer,
+		logger: logger,
+		runs:   make(chan struct{}, maxWebhookRuns),
+	}
+
+	base := strings.TrimSuffix(config.Path, "/")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(base+"/wordpress", ws.cmsHandler("wordpress", parseWordPressEvent))
+	mux.HandleFunc(base+"/contentful", ws.cmsHandler("contentful", parseContentfulEvent))
+	mux.HandleFunc(base+"/sanity", ws.cmsHandler("sanity", parseSanityEvent))
+	mux.HandleFunc(base+"/warm", ws.warmHandler)
+	if config.Fastly.ServiceID != "" {
+		mux.HandleFunc(base+"/fastly/purge", ws.fastlyHandler)
+	}
+	if config.Deploy.GitHubSecret != "" {
+		mux.HandleFunc(base+"/github", ws.deployHandler("GitHub", verifyGitHubSignature, parseGitHubDeploy))
+	}
+	if config.Deploy.GitLabToken != "" {
+		mux.HandleFunc(base+"/gitlab", ws.deployHandler("GitLab", verifyGitLabToken, parseGitLabDeploy))
+	}
+
+	ws.server = &http.Server{
+		Addr:         fmt.Sprintf(":%d", config.Port),
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	// Start server in background
+	go func() {
+		logger.Info("Starting webhook server on port %d", config.Port)
+		if err := ws.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Webhook server error: %v", err)
+		}
+	}()
+
+	return ws
+}
+
+// cmsHandler returns a handler that parses a CMS payload and warms affected URLs
+func (ws *WebhookServer) cmsHandler(source string, parse cmsParser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !ws.authorized(r) {
+			ws.logger.Warn("Rejected %s webhook from %s: invalid secret", source, r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		event, err := parse(r, body)
+		if err != nil {
+			ws.logger.Warn("Invalid %s webhook payload: %v", source, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var urls []string
+		if event != nil {
+			urls = ws.affectedURLs(event)
+		}
+
+		if len(urls) == 0 {
+			ws.logger.Debug("Ignoring %s webhook: no affected URLs", source)
+			writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ignored", "urls": []string{}})
+			return
+		}
+
+		ws.logger.Info("Received %s publish event for %s %q, warming %d URLs",
+			source, event.ContentType, event.ID, len(urls))
+
+		if !ws.startWarm(urls) {
+			ws.logger.Warn("Rejected %s webhook: %d webhook runs already in progress", source, maxWebhookRuns)
+			http.Error(w, "too many warms in progress", http.StatusTooManyRequests)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "accepted", "urls": urls})
+	}
+}
+
+// startWarm warms urls in the background, unless maxWebhookRuns runs are
+// already going
+func (ws *WebhookServer) startWarm(urls []string) bool {
+	select {
+	case ws.runs <- struct{}{}:
+	default:
+		return false
+	}
+
+	go func() {
+		defer func() { <-ws.runs }()
+		ws.warmer.WarmURLs(context.Background(), urls)
+	}()
+	return true
+}
+
+// warmRequest is the optional body of the warm endpoint
+type warmRequest struct {
+	URLs []string `json:"urls"`
+	Tag  string   `json:"tag"`
+}
+
+// warmHandler warms the posted URLs and those with the posted tag, or
+// starts a full cycle if the request names none
+func (ws *WebhookServer) warmHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !ws.authorized(r) {
+		ws.logger.Warn("Rejected warm webhook from %s: invalid secret", r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	var request warmRequest
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if tag := r.URL.Query().Get("tag"); tag != "" && request.Tag == "" {
+		request.Tag = tag
+	}
+
+	if len(request.URLs) == 0 && request.Tag == "" {
+		status, err := ws.warmer.StartCycle()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": status})
+		return
+	}
+
+	config := ws.warmer.CurrentConfig()
+	hosts := configuredHosts(config)
+	for _, u := range request.URLs {
+		if err := ValidateURL(u); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if parsed, _ := url.Parse(u); !hosts[strings.ToLower(parsed.Hostname())] {
+			http.Error(w, fmt.Sprintf("%s is not on a configured host", u), http.StatusBadRequest)
+			return
+		}
+	}
+	urls := request.URLs
+	if request.Tag != "" {
+		tagged := config.TaggedURLList(request.Tag)
+		if len(tagged) == 0 {
+			http.Error(w, fmt.Sprintf("no URLs are tagged %q", request.Tag), http.StatusNotFound)
+			return
+		}
+		urls = append(urls, tagged...)
+	}
+	urls, _ = dedupeURLs(urls)
+
+	ws.logger.Info("Received warm webhook, warming %d URLs", len(urls))
+
+	if !ws.startWarm(urls) {
+		http.Error(w, "too many warms in progress", http.StatusTooManyRequests)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"status": "accepted", "urls": urls})
+}
+
+// authorized checks the shared secret, if one is configured
+func (ws *WebhookServer) authorized(r *http.Request) bool {
+	if ws.config.Secret == "" {
+		return true
+	}
+
+	provided := r.Header.Get("X-Webhook-Secret")
+	if provided == "" {
+		provided = r.URL.Query().Get("secret")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(ws.config.Secret)) == 1
+}
+
+// affectedURLs maps a publish event to its permalink and listing pages
+func (ws *WebhookServer) affectedURLs(event *CMSEvent) []string {
+	cms := ws.config.CMS
+	base := strings.TrimSuffix(cms.BaseURL, "/")
+	ct, known := cms.ContentTypes[event.ContentType]
+
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(u string) {
+		if u != "" && !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+
+	// The document itself, unless it was removed
+	if !event.Removed {
+		if event.URL != "" {
+			if sameSite(event.URL, cms.BaseURL) {
+				add(event.URL)
+			} else {
+				ws.logger.Warn("Dropping CMS permalink %s: not under cms.base_url %q", event.URL, cms.BaseURL)
+			}
+		} else if known && ct.Path != "" && (event.Slug != "" || !strings.Contains(ct.Path, "{slug}")) {
+			path := strings.NewReplacer("{slug}", event.Slug, "{id}", event.ID).Replace(ct.Path)
+			add(base + path)
+		}
+	}
+
+	if !known {
+		return urls
+	}
+
+	// Listing pages and their pagination
+	pattern := ct.PagePattern
+	if pattern == "" {
+		pattern = "{listing}/page/{n}"
+	}
+	for _, listing := range ct.Listings {
+		add(base + listing)
+		for n := 2; n <= ct.Pages; n++ {
+			page := strings.NewReplacer("{listing}", strings.TrimSuffix(listing, "/"), "{n}", strconv.Itoa(n)).Replace(pattern)
+			add(base + page)
+		}
+	}
+
+	return urls
+}
+
+// sameSite reports whether raw has the scheme and host of base
+func sameSite(raw, base string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(base)
+	if err != nil || b.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Scheme, b.Scheme) && strings.EqualFold(u.Host, b.Host)
+}
+
+// parseWordPressEvent handles WordPress webhook payloads, both the REST API
+// post shape ({"id","type","slug","link","status"}) and the WP Webhooks
+// plugin shape ({"post": {...}, "post_permalink": "..."})
+func parseWordPressEvent(r *http.Request, body []byte) (*CMSEvent, error) {
+	var payload struct {
+		ID        json.Number `json:"id"`
+		Type      string      `json:"type"`
+		Slug      string      `json:"slug"`
+		Link      string      `json:"link"`
+		Status    string      `json:"status"`
+		Permalink string      `json:"post_permalink"`
+		Post      struct {
+			ID     json.Number `json:"ID"`
+			Type   string      `json:"post_type"`
+			Name   string      `json:"post_name"`
+			Status string      `json:"post_status"`
+		} `json:"post"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse WordPress payload: %v", err)
+	}
+
+	event := &CMSEvent{
+		ContentType: firstNonEmpty(payload.Type, payload.Post.Type),
+		ID:          firstNonEmpty(payload.ID.String(), payload.Post.ID.String()),
+		Slug:        firstNonEmpty(payload.Slug, payload.Post.Name),
+		URL:         firstNonEmpty(payload.Link, payload.Permalink),
+	}
+
+	switch firstNonEmpty(payload.Status, payload.Post.Status) {
+	case "publish", "":
+	case "trash", "draft", "private":
+		event.Removed = true
+	default:
+		// Pending, scheduled and auto-draft posts are not visible yet
+		return nil, nil
+	}
+
+	if event.ContentType == "" {
+		return nil, fmt.Errorf("WordPress payload has no post type")
+	}
+	return event, nil
+}
+
+// parseContentfulEvent handles Contentful entry webhooks, using the
+// X-Contentful-Topic header to distinguish publish from unpublish
+func parseContentfulEvent(r *http.Request, body []byte) (*CMSEvent, error) {
+	var payload struct {
+		Sys struct {
+			ID          string `json:"id"`
+			ContentType struct {
+				Sys struct {
+					ID string `json:"id"`
+				} `json:"sys"`
+			} `json:"contentType"`
+		} `json:"sys"`
+		Fields struct {
+			Slug map[string]string `json:"slug"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse Contentful payload: %v", err)
+	}
+
+	event := &CMSEvent{
+		ContentType: payload.Sys.ContentType.Sys.ID,
+		ID:          payload.Sys.ID,
+	}
+
+	topic := r.Header.Get("X-Contentful-Topic")
+	switch {
+	case strings.HasSuffix(topic, "Entry.publish"), topic == "":
+	case strings.HasSuffix(topic, "Entry.unpublish"), strings.HasSuffix(topic, "Entry.delete"), strings.HasSuffix(topic, "Entry.archive"):
+		event.Removed = true
+	default:
+		// Saves, auto-saves and asset events don't change the public site
+		return nil, nil
+	}
+
+	// Slugs are localized; prefer the first non-empty value in stable order
+	if slug, ok := payload.Fields.Slug["en-US"]; ok {
+		event.Slug = slug
+	} else {
+		for _, slug := range payload.Fields.Slug {
+			if event.Slug == "" || slug < event.Slug {
+				event.Slug = slug
+			}
+		}
+	}
+
+	if event.ContentType == "" {
+		return nil, fmt.Errorf("Contentful payload has no content type")
+	}
+	return event, nil
+}
+
+// parseSanityEvent handles Sanity GROQ webhooks carrying the full document,
+// using the sanity-operation header to detect deletes
+func parseSanityEvent(r *http.Request, body []byte) (*CMSEvent, error) {
+	var payload struct {
+		ID   string `json:"_id"`
+		Type string `json:"_type"`
+		Slug struct {
+			Current string `json:"current"`
+		} `json:"slug"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse Sanity payload: %v", err)
+	}
+
+	// Drafts are never served publicly
+	if strings.HasPrefix(payload.ID, "drafts.") {
+		return nil, nil
+	}
+
+	event := &CMSEvent{
+		ContentType: payload.Type,
+		ID:          payload.ID,
+		Slug:        payload.Slug.Current,
+		Removed:     r.Header.Get("sanity-operation") == "delete",
+	}
+
+	if event.ContentType == "" {
+		return nil, fmt.Errorf("Sanity payload has no _type")
+	}
+	return event, nil
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Shutdown gracefully shuts down the webhook server
+func (ws *WebhookServer) Shutdown() {
+	ws.logger.Info("Shutting down webhook server...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := ws.server.Shutdown(ctx); err != nil {
+		ws.logger.Error("Error shutting down webhook server: %v", err)
+	} else {
+		ws.logger.Info("Webhook server shutdown complete")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestWebhookServer returns a webhook server for cw that doesn't listen
+func newTestWebhookServer(cw *CacheWarmer) *WebhookServer {
+	return &WebhookServer{
+		config: &cw.config.Webhook,
+		warmer: cw,
+		logger: cw.logger,
+		runs:   make(chan struct{}, maxWebhookRuns),
+	}
+}
+
+func TestWarmHandlerOnlyWarmsConfiguredHosts(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	ws := newTestWebhookServer(newTestWarmer(t, origin, nil))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"configured host", `{"urls": ["` + origin.URL + `/pricing"]}`, http.StatusAccepted},
+		{"off-site", `{"urls": ["http://169.254.169.254/latest/meta-data/"]}`, http.StatusBadRequest},
+		{"off-site among configured", `{"urls": ["` + origin.URL + `/", "https://example.org/"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ws.warmHandler(rec, httptest.NewRequest(http.MethodPost, "/webhooks/warm", strings.NewReader(tt.body)))
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWarmHandlerCapsRuns(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	ws := newTestWebhookServer(newTestWarmer(t, origin, nil))
+	for range maxWebhookRuns {
+		ws.runs <- struct{}{}
+	}
+
+	rec := httptest.NewRecorder()
+	body := `{"urls": ["` + origin.URL + `/pricing"]}`
+	ws.warmHandler(rec, httptest.NewRequest(http.MethodPost, "/webhooks/warm", strings.NewReader(body)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d with every run slot taken, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestAffectedURLsDropsOffSitePermalinks(t *testing.T) {
+	ws := &WebhookServer{
+		config: &WebhookConfig{CMS: CMSConfig{
+			BaseURL:      "https://example.com",
+			ContentTypes: map[string]CMSContentType{"post": {Listings: []string{"/blog"}}},
+		}},
+		logger: NewLogger(false),
+	}
+
+	tests := []struct {
+		permalink string
+		want      []string
+	}{
+		{"https://example.com/blog/hello", []string{"https://example.com/blog/hello", "https://example.com/blog"}},
+		{"https://EXAMPLE.com/blog/hello", []string{"https://EXAMPLE.com/blog/hello", "https://example.com/blog"}},
+		{"http://example.com/blog/hello", []string{"https://example.com/blog"}},
+		{"https://attacker.example/blog/hello", []string{"https://example.com/blog"}},
+		{"https://example.com.attacker.example/", []string{"https://example.com/blog"}},
+	}
+	for _, tt := range tests {
+		got := ws.affectedURLs(&CMSEvent{ContentType: "post", URL: tt.permalink})
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: warmed %v, want %v", tt.permalink, got, tt.want)
+		}
+	}
+}